@@ -0,0 +1,360 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ternarybob/gitsync/internal/common"
+)
+
+// exitUsage is the exit code of a command line that cannot be parsed, the one
+// the flag package uses
+const exitUsage = 2
+
+// command is a subcommand of gitsync. name may be two words for commands
+// grouped under another, such as "config print".
+type command struct {
+	name    string
+	args    string // Positional arguments in the usage line
+	summary string
+	details string // Printed by the command's help after the summary
+	run     func(args []string) int
+}
+
+// commands are listed by the help in this order, set in init because
+// cmdHelp refers to them
+var commands []command
+
+func init() {
+	commands = []command{
+		{"serve", "", "Run the scheduler, the initial sync and the HTTP server until stopped",
+			"Without a command gitsync serves. A SIGHUP, or a change of the file with\nwatch_config, reloads the configuration.", cmdServe},
+		{"run", "[job...]", "Run jobs once and exit",
+			"Jobs may be given as separate arguments or comma separated. Exits 1 when any\nbranch or target of a job failed. With -all every enabled job runs and a\nstartup error exits 2, telling it apart from a failed job.\n\n" + runReportSchema, cmdRun},
+		{"validate", "", "Validate the configuration and exit", "", cmdValidate},
+		{"status", "[job]", "Show the next and previous run, last result and running state of jobs",
+			"Asks a running daemon through its HTTP server, and falls back to the\nconfiguration and history when none answers.", cmdStatus},
+		{"history", "<job>", "List recent sync transactions of a job", "", cmdHistory},
+		{"export", "", "Export the sync history as CSV or JSON", "", cmdExport},
+		{"jobs", "", "List jobs with their resolved sources, targets and credentials", "", cmdJobs},
+		{"config print", "", "Print the effective configuration, secrets redacted",
+			"Environment variables, *_env settings and [jobs] defaults are applied.", cmdConfigPrint},
+		{"init", "", "Write a commented starter configuration",
+			"Writes to the -config path, by default gitsync.toml next to the executable.", cmdInit},
+		{"doctor", "", "Check git, credentials, directories and schedules",
+			"Exits 1 when a check failed. Nothing is contacted without -remote.", cmdDoctor},
+		{"service install", "", "Register gitsync as a Windows service",
+			"The service starts with Windows and serves with the -config path, made\nabsolute. Stopping it shuts down like SIGTERM. Needs an elevated prompt.", cmdServiceInstall},
+		{"service uninstall", "", "Stop and remove the Windows service", "", cmdServiceUninstall},
+		{"version", "", "Show the version", "", cmdVersion},
+		{"help", "[command]", "Show the help of a command", "", cmdHelp},
+	}
+}
+
+// findCommand returns the command args start with and the arguments after
+// its name, nil when args name none
+func findCommand(args []string) (*command, []string) {
+	for i := range commands {
+		words := strings.Fields(commands[i].name)
+		if len(args) >= len(words) && strings.Join(args[:len(words)], " ") == commands[i].name {
+			return &commands[i], args[len(words):]
+		}
+	}
+	return nil, nil
+}
+
+// subcommands returns the commands grouped under group, such as print for
+// config
+func subcommands(group string) []string {
+	var names []string
+	for _, c := range commands {
+		if words := strings.Fields(c.name); len(words) > 1 && words[0] == group {
+			names = append(names, words[1])
+		}
+	}
+	return names
+}
+
+// usage prints the commands of gitsync
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: gitsync <command> [flags] [arguments]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-18s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(w, "\nRun 'gitsync help <command>' for the flags of a command. Without a command\n")
+	fmt.Fprintf(w, "gitsync serves. The flags of earlier releases, such as -run-job, still work\n")
+	fmt.Fprintf(w, "but are deprecated.\n")
+}
+
+// newFlagSet returns the flag set of a command, whose -h prints its help
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet("gitsync "+name, flag.ExitOnError)
+	fs.Usage = func() {
+		c, _ := findCommand(strings.Fields(name))
+		w := fs.Output()
+		fmt.Fprintf(w, "Usage: gitsync %s [flags] %s\n\n%s\n", c.name, c.args, c.summary)
+		if c.details != "" {
+			fmt.Fprintf(w, "\n%s\n", c.details)
+		}
+		fmt.Fprintf(w, "\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// configFlag registers the -config flag every command that reads the
+// configuration takes
+func configFlag(fs *flag.FlagSet) *string {
+	return fs.String("config", "", "Path to configuration file (defaults to GITSYNC_CONFIG, then gitsync.toml in executable directory)")
+}
+
+// parseArgs parses flags that may come before or after the positional
+// arguments, as in "gitsync run main-sync -json", and returns the positional
+// ones
+func parseArgs(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		fs.Parse(args)
+		if fs.NArg() == 0 {
+			return positional
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// usageError explains a command line a command cannot run, with its help
+func usageError(fs *flag.FlagSet, format string, args ...interface{}) int {
+	fmt.Fprintf(fs.Output(), "%s\n\n", fmt.Sprintf(format, args...))
+	fs.Usage()
+	return exitUsage
+}
+
+// withConfig loads the configuration and runs the action of a command on it.
+// A failed action is reported as "Failed to <what>" and exits 1.
+func withConfig(configFlag, what string, action func(cfg *common.Config) error) int {
+	cfg, _ := loadConfig(configFlag, 1)
+	if err := action(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to %s: %v\n", what, err)
+		return 1
+	}
+	return 0
+}
+
+func cmdServe(args []string) int {
+	fs := newFlagSet("serve")
+	configPath := configFlag(fs)
+	skipInitial := fs.Bool("skip-initial-sync", false, "Start the scheduler without syncing the enabled jobs first")
+	logOpts := logFlags(fs)
+	if positional := parseArgs(fs, args); len(positional) > 0 {
+		return usageError(fs, "serve takes no arguments")
+	}
+	if err := logOpts.check(); err != nil {
+		return usageError(fs, "%v", err)
+	}
+	return serve(*configPath, *skipInitial, logOpts)
+}
+
+func cmdRun(args []string) int {
+	fs := newFlagSet("run")
+	configPath := configFlag(fs)
+	opts := runOptions{}
+	fs.BoolVar(&opts.all, "all", false, "Run every enabled job")
+	fs.BoolVar(&opts.force, "force", false, "Run jobs that are disabled in the configuration, or a -branch their patterns do not select")
+	fs.BoolVar(&opts.withDeps, "with-deps", false, "Run the jobs the jobs depend on first, skipping jobs whose dependencies failed")
+	fs.StringVar(&opts.filter.Branch, "branch", "", "Only sync this branch, which has to match the job's branches unless -force is given")
+	fs.StringVar(&opts.filter.Target, "target", "", "Only push to the targets with this URL or host")
+	output := fs.String("output", "text", "Print the results as text, or as one JSON document once all jobs ran: text or json")
+	asJSON := fs.Bool("json", false, "Same as -output json")
+	opts.log = logFlags(fs)
+
+	for _, arg := range parseArgs(fs, args) {
+		for _, jobName := range strings.Split(arg, ",") {
+			if jobName = strings.TrimSpace(jobName); jobName != "" {
+				opts.jobs = append(opts.jobs, jobName)
+			}
+		}
+	}
+	if err := opts.log.check(); err != nil {
+		return usageError(fs, "%v", err)
+	}
+	var err error
+	if opts.json, err = runOutput(*output, *asJSON); err != nil {
+		return usageError(fs, "%v", err)
+	}
+	switch {
+	case opts.all && len(opts.jobs) > 0:
+		return usageError(fs, "run takes either jobs or -all")
+	case !opts.all && len(opts.jobs) == 0:
+		return usageError(fs, "run needs the jobs to run, or -all")
+	case opts.all && (opts.filter.Branch != "" || opts.filter.Target != "" || opts.withDeps || opts.force):
+		return usageError(fs, "-branch, -target, -with-deps and -force apply to named jobs, not -all")
+	}
+	opts.configPath = *configPath
+	opts.filter.AnyBranch = opts.force
+	return runCommand(opts)
+}
+
+func cmdValidate(args []string) int {
+	fs := newFlagSet("validate")
+	configPath := configFlag(fs)
+	strict := fs.Bool("strict", false, "Fail when the configuration has unknown keys")
+	deep := fs.Bool("deep", false, "Also read the tokens jobs take from Vault, Secrets Manager or Parameter Store")
+	if positional := parseArgs(fs, args); len(positional) > 0 {
+		return usageError(fs, "validate takes no arguments")
+	}
+	return validate(*configPath, *strict, *deep)
+}
+
+func cmdStatus(args []string) int {
+	fs := newFlagSet("status")
+	configPath := configFlag(fs)
+	asJSON := fs.Bool("json", false, "Print the status as JSON")
+	positional := parseArgs(fs, args)
+	if len(positional) > 1 {
+		return usageError(fs, "status takes at most one job")
+	}
+
+	var jobName string
+	if len(positional) == 1 {
+		jobName = positional[0]
+	}
+	return withConfig(*configPath, "show job status", func(cfg *common.Config) error {
+		return runJobStatus(cfg, jobName, *asJSON)
+	})
+}
+
+func cmdHistory(args []string) int {
+	fs := newFlagSet("history")
+	configPath := configFlag(fs)
+	opts := historyOptions{}
+	fs.IntVar(&opts.limit, "limit", 20, "Maximum number of entries, 0 for all")
+	fs.StringVar(&opts.target, "target", "", "Only list entries for this target URL")
+	fs.StringVar(&opts.status, "status", "", "Only list entries with this status (running, success, failed, skipped, diverged, blocked)")
+	fs.BoolVar(&opts.json, "json", false, "Print the entries as JSON")
+	positional := parseArgs(fs, args)
+	if len(positional) != 1 {
+		return usageError(fs, "history needs one job")
+	}
+
+	return withConfig(*configPath, "read history", func(cfg *common.Config) error {
+		return runHistory(cfg, positional[0], opts)
+	})
+}
+
+func cmdExport(args []string) int {
+	fs := newFlagSet("export")
+	configPath := configFlag(fs)
+	opts := exportOptions{}
+	fs.StringVar(&opts.from, "from", "", "Only export transactions started on or after this date (YYYY-MM-DD or RFC3339)")
+	fs.StringVar(&opts.to, "to", "", "Only export transactions started up to this date (YYYY-MM-DD inclusive, or RFC3339)")
+	fs.StringVar(&opts.format, "format", "csv", "Export format: csv or json")
+	fs.StringVar(&opts.output, "output", "", "Destination file (defaults to stdout)")
+	if positional := parseArgs(fs, args); len(positional) > 0 {
+		return usageError(fs, "export takes no arguments")
+	}
+
+	return withConfig(*configPath, "export history", func(cfg *common.Config) error {
+		return runExport(cfg, opts)
+	})
+}
+
+func cmdJobs(args []string) int {
+	fs := newFlagSet("jobs")
+	configPath := configFlag(fs)
+	asJSON := fs.Bool("json", false, "Print the jobs as JSON")
+	if positional := parseArgs(fs, args); len(positional) > 0 {
+		return usageError(fs, "jobs takes no arguments")
+	}
+
+	return withConfig(*configPath, "list jobs", func(cfg *common.Config) error {
+		return runListJobs(cfg, *asJSON)
+	})
+}
+
+func cmdConfigPrint(args []string) int {
+	fs := newFlagSet("config print")
+	configPath := configFlag(fs)
+	asJSON := fs.Bool("json", false, "Print JSON instead of TOML")
+	if positional := parseArgs(fs, args); len(positional) > 0 {
+		return usageError(fs, "config print takes no arguments")
+	}
+
+	return withConfig(*configPath, "print configuration", func(cfg *common.Config) error {
+		return runPrintConfig(cfg, *asJSON)
+	})
+}
+
+func cmdInit(args []string) int {
+	fs := newFlagSet("init")
+	configPath := configFlag(fs)
+	force := fs.Bool("force", false, "Overwrite an existing file")
+	if positional := parseArgs(fs, args); len(positional) > 0 {
+		return usageError(fs, "init takes no arguments")
+	}
+	return initConfig(*configPath, *force)
+}
+
+func cmdDoctor(args []string) int {
+	fs := newFlagSet("doctor")
+	configPath := configFlag(fs)
+	remote := fs.Bool("remote", false, "Also reach the source and targets of every enabled job")
+	if positional := parseArgs(fs, args); len(positional) > 0 {
+		return usageError(fs, "doctor takes no arguments")
+	}
+	return doctor(*configPath, *remote)
+}
+
+func cmdServiceInstall(args []string) int {
+	fs := newFlagSet("service install")
+	configPath := configFlag(fs)
+	name := serviceNameFlag(fs)
+	if positional := parseArgs(fs, args); len(positional) > 0 {
+		return usageError(fs, "service install takes no arguments")
+	}
+	return serviceInstall(*configPath, *name)
+}
+
+func cmdServiceUninstall(args []string) int {
+	fs := newFlagSet("service uninstall")
+	name := serviceNameFlag(fs)
+	if positional := parseArgs(fs, args); len(positional) > 0 {
+		return usageError(fs, "service uninstall takes no arguments")
+	}
+	return serviceUninstall(*name)
+}
+
+func serviceNameFlag(fs *flag.FlagSet) *string {
+	return fs.String("name", defaultServiceName, "Name of the Windows service")
+}
+
+func cmdVersion(args []string) int {
+	fs := newFlagSet("version")
+	if positional := parseArgs(fs, args); len(positional) > 0 {
+		return usageError(fs, "version takes no arguments")
+	}
+	fmt.Printf("GitSync v%s (build: %s)\n", common.GetVersion(), common.GetBuild())
+	return 0
+}
+
+func cmdHelp(args []string) int {
+	if len(args) == 0 {
+		usage(os.Stdout)
+		return 0
+	}
+	c, rest := findCommand(args)
+	switch {
+	case c == nil || len(rest) > 0:
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n", strings.Join(args, " "))
+		usage(os.Stderr)
+		return exitUsage
+	case c.name == "help":
+		usage(os.Stdout)
+		return 0
+	}
+	// -h prints the help of the command and exits
+	return c.run([]string{"-h"})
+}
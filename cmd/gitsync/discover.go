@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/ternarybob/gitsync/internal/common"
+	"github.com/ternarybob/gitsync/internal/services"
+)
+
+// discoverJobs lists the repositories of every discovery job of cfg and
+// returns cfg with a job for each. A listing that fails is logged and keeps
+// the jobs of the listing before it, if any.
+func discoverJobs(discovery *services.Discovery, cfg *common.Config) *common.Config {
+	_, errs := discovery.Refresh(context.Background(), cfg, true)
+	logDiscoveryErrors(errs)
+	return discoveredConfig(discovery, cfg)
+}
+
+// refreshDiscovery lists the repositories of the discovery jobs that are due
+// and reloads the scheduler with the jobs of repositories that appeared or
+// disappeared. cfg is the configuration as loaded, without discovered jobs.
+func refreshDiscovery(sched *services.Scheduler, discovery *services.Discovery, cfg *common.Config) {
+	changed, errs := discovery.Refresh(context.Background(), cfg, false)
+	logDiscoveryErrors(errs)
+	if !changed {
+		return
+	}
+
+	logger := common.GetLogger()
+	result, err := sched.Reload(discoveredConfig(discovery, cfg))
+	if err != nil {
+		logger.Error().Err(err).Msg("Discovered jobs rejected, keeping the running jobs")
+		return
+	}
+	logger.Info().Str("added", strings.Join(result.Added, ", ")).Str("removed", strings.Join(result.Removed, ", ")).
+		Str("changed", strings.Join(result.Changed, ", ")).Msg("Discovered jobs updated")
+}
+
+// discoveredConfig returns cfg with the discovered jobs, logging why any
+// discovered repository was left out
+func discoveredConfig(discovery *services.Discovery, cfg *common.Config) *common.Config {
+	discovered := discovery.Config(cfg)
+	logger := common.GetLogger()
+	for _, warning := range discovered.Warnings[len(cfg.Warnings):] {
+		logger.Warn().Msg(warning)
+	}
+	return discovered
+}
+
+func logDiscoveryErrors(errs map[string]error) {
+	logger := common.GetLogger()
+	for jobName, err := range errs {
+		logger.Error().Str("job", jobName).Err(err).Msg("Failed to discover repositories, keeping the jobs discovered before")
+	}
+}
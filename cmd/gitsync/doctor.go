@@ -0,0 +1,420 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ternarybob/gitsync/internal/common"
+	"github.com/ternarybob/gitsync/internal/services"
+)
+
+const (
+	// minGitVersion is the oldest git with every command gitsync runs,
+	// git branch --format and refname:strip came with 2.13
+	minGitMajor, minGitMinor = 2, 13
+
+	mib = 1 << 20
+	gib = 1 << 30
+
+	// doctorRemoteTimeout bounds reaching the repositories of one job
+	doctorRemoteTimeout = time.Minute
+)
+
+// doctorReport prints the outcome of each check and counts the problems
+type doctorReport struct {
+	failures int
+	warnings int
+}
+
+func (r *doctorReport) section(title string) {
+	fmt.Printf("\n%s\n", title)
+}
+
+func (r *doctorReport) pass(format string, args ...interface{}) {
+	fmt.Printf("  [PASS] %s\n", fmt.Sprintf(format, args...))
+}
+
+func (r *doctorReport) warn(format string, args ...interface{}) {
+	r.warnings++
+	fmt.Printf("  [WARN] %s\n", fmt.Sprintf(format, args...))
+}
+
+func (r *doctorReport) fail(format string, args ...interface{}) {
+	r.failures++
+	fmt.Printf("  [FAIL] %s\n", fmt.Sprintf(format, args...))
+}
+
+// doctor resolves the configuration path and runs the checks
+func doctor(configFlag string, remote bool) int {
+	path, err := resolveConfigPath(configFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to run checks: %v\n", err)
+		return 1
+	}
+	return runDoctor(path, remote)
+}
+
+// runDoctor checks that the machine can run the configured jobs: git and the
+// tools jobs need, credentials, directories and schedules. Remotes are only
+// contacted with remote. Disabled jobs are left out. It returns the exit
+// code, 1 when any check failed.
+func runDoctor(configPath string, remote bool) int {
+	report := &doctorReport{}
+
+	report.section("Git")
+	checkGit(report)
+
+	report.section("Configuration")
+	var cfg *common.Config
+	if _, err := os.Stat(configPath); os.IsNotExist(err) && !common.EnvConfigured() {
+		report.fail("Configuration file not found: %s", configPath)
+	} else if cfg, err = common.Load(configPath); err != nil {
+		report.fail("%v", err)
+	} else {
+		checkConfig(report, cfg, configPath)
+		checkGitTools(report, cfg)
+		checkSignatureTools(report, cfg)
+
+		report.section("Credentials")
+		checkCredentials(report, cfg)
+
+		report.section("Schedules")
+		checkSchedules(report, cfg)
+	}
+
+	report.section("Directories")
+	checkDirectories(report, cfg)
+
+	if remote && cfg != nil {
+		report.section("Secrets")
+		checkSecrets(report, cfg)
+
+		report.section("Remotes")
+		checkRemotes(report, cfg)
+	}
+
+	fmt.Printf("\n%d failures, %d warnings\n", report.failures, report.warnings)
+	if report.failures > 0 {
+		return 1
+	}
+	return 0
+}
+
+func checkGit(report *doctorReport) {
+	output, err := exec.Command("git", "--version").Output()
+	if err != nil {
+		report.fail("git not found or not executable: %v", err)
+		return
+	}
+	version := strings.TrimSpace(string(output))
+
+	major, minor, ok := parseGitVersion(version)
+	switch {
+	case !ok:
+		report.warn("%s, the version could not be read", version)
+	case major < minGitMajor || major == minGitMajor && minor < minGitMinor:
+		report.warn("%s, gitsync needs git %d.%d or later", version, minGitMajor, minGitMinor)
+	default:
+		report.pass("%s", version)
+	}
+}
+
+// parseGitVersion reads the major and minor version of git --version output
+// such as "git version 2.39.3 (Apple Git-146)" or "git version 2.41.0.windows.1"
+func parseGitVersion(output string) (major, minor int, ok bool) {
+	fields := strings.Fields(output)
+	if len(fields) < 3 {
+		return 0, 0, false
+	}
+	parts := strings.Split(fields[2], ".")
+	if len(parts) < 2 {
+		return 0, 0, false
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, false
+	}
+	minor, err = strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, false
+	}
+	return major, minor, true
+}
+
+// checkGitTools checks the git commands only some jobs need. History rewriting
+// runs git filter-branch, which some distributions package separately.
+func checkGitTools(report *doctorReport, cfg *common.Config) {
+	var rewriting []string
+	for _, jobName := range cfg.GetEnabledJobs() {
+		jobConfig, _ := cfg.GetJobConfig(jobName)
+		if jobConfig.RewritesHistory() {
+			rewriting = append(rewriting, jobName)
+		}
+	}
+	if len(rewriting) == 0 {
+		return
+	}
+
+	// -h prints the usage and exits with 129 when the command exists
+	output, _ := exec.Command("git", "filter-branch", "-h").CombinedOutput()
+	if strings.Contains(string(output), "usage: git filter-branch") {
+		report.pass("git filter-branch is available for rewriting %s", strings.Join(rewriting, ", "))
+		return
+	}
+	report.fail("git filter-branch is not available, %s rewrite history with it", strings.Join(rewriting, ", "))
+}
+
+// checkSignatureTools checks the programs git verifies signatures with, gpg
+// for GPG signatures and ssh-keygen for SSH ones. Jobs usually see only one
+// kind, so a missing program is a warning.
+func checkSignatureTools(report *doctorReport, cfg *common.Config) {
+	var verifying []string
+	for _, jobName := range cfg.GetEnabledJobs() {
+		jobConfig, _ := cfg.GetJobConfig(jobName)
+		if jobConfig.RequireSignatures {
+			verifying = append(verifying, jobName)
+		}
+	}
+	if len(verifying) == 0 {
+		return
+	}
+
+	for _, program := range []string{"gpg", "ssh-keygen"} {
+		if path, err := exec.LookPath(program); err == nil {
+			report.pass("%s is available for verifying signatures of %s", path, strings.Join(verifying, ", "))
+		} else {
+			report.warn("%s not found, %s cannot verify commits signed with it", program, strings.Join(verifying, ", "))
+		}
+	}
+}
+
+func checkConfig(report *doctorReport, cfg *common.Config, configPath string) {
+	enabled := len(cfg.GetEnabledJobs())
+	if cfg.EnvOnly {
+		report.pass("No configuration file, job %s configured by GITSYNC_ environment variables", cfg.Jobs.Names[0])
+	} else {
+		report.pass("%s loaded, %d jobs, %d enabled", configPath, len(cfg.Jobs.Names), enabled)
+	}
+	for _, file := range cfg.IncludedFiles() {
+		report.pass("Included %s", file)
+	}
+	for _, warning := range cfg.Warnings {
+		report.warn("%s", warning)
+	}
+	if enabled == 0 {
+		report.warn("No job is enabled")
+	}
+}
+
+func checkCredentials(report *doctorReport, cfg *common.Config) {
+	for _, jobName := range cfg.GetEnabledJobs() {
+		jobConfig, _ := cfg.GetJobConfig(jobName)
+		label := "job " + jobName
+
+		switch {
+		case jobConfig.GitTokenEnv != "" && jobConfig.GitToken == "":
+			report.fail("%s: $%s is not set", label, jobConfig.GitTokenEnv)
+		case jobConfig.GitTokenEnv != "":
+			report.pass("%s: token $%s is set", label, jobConfig.GitTokenEnv)
+		}
+		if (jobConfig.GitToken != "" || jobConfig.TokenSecret() != "") && jobConfig.GitUsername == "" {
+			report.warn("%s: git_username is not set, the token is not used", label)
+		}
+
+		checkSSHKey(report, label, jobConfig.SSHKeyEnv, jobConfig.SSHKeyPath)
+		for _, target := range jobConfig.Targets {
+			checkSSHKey(report, label+" target "+common.RepositoryHost(target.URL), target.SSHKeyEnv, target.SSHKeyPath)
+		}
+	}
+}
+
+// checkSSHKey checks that a key exists and is readable, and that only its
+// owner can read it since ssh ignores keys others can read
+func checkSSHKey(report *doctorReport, label, env, path string) {
+	switch {
+	case env != "" && path == "":
+		report.fail("%s: ssh key $%s is not set", label, env)
+		return
+	case path == "":
+		return
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		report.fail("%s: ssh key %v", label, err)
+		return
+	}
+	if info.IsDir() {
+		report.fail("%s: ssh key %s is a directory", label, path)
+		return
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		report.fail("%s: ssh key %v", label, err)
+		return
+	}
+	file.Close()
+
+	if perm := info.Mode().Perm(); runtime.GOOS != "windows" && perm&0077 != 0 {
+		report.fail("%s: ssh key %s has permissions %04o, ssh ignores it unless only the owner can read it (chmod 600)", label, path, perm)
+		return
+	}
+	report.pass("%s: ssh key %s", label, path)
+}
+
+func checkSchedules(report *doctorReport, cfg *common.Config) {
+	now := time.Now()
+	for _, jobName := range cfg.GetEnabledJobs() {
+		schedule := cfg.JobSchedule(jobName)
+		next, err := cfg.JobNextRun(jobName, now)
+		if err != nil {
+			report.fail("job %s: schedule '%s': %v", jobName, schedule, err)
+			continue
+		}
+		report.pass("job %s: '%s', next run %s", jobName, schedule, next.Format(time.RFC3339))
+	}
+}
+
+// checkDirectories checks that gitsync can write its work directory, and the
+// logs and store when the configuration loaded, and that they have room
+func checkDirectories(report *doctorReport, cfg *common.Config) {
+	checkDirectory(report, "Work directory", services.CacheRoot(), 100*mib, gib)
+	if cfg == nil {
+		return
+	}
+
+	if cfg.Logging.WritesFile() {
+		if dir, err := cfg.Logging.LogDirectory(); err != nil {
+			report.fail("Log directory: %v", err)
+		} else {
+			checkDirectory(report, "Log directory", dir, 0, 100*mib)
+		}
+	}
+	if cfg.Store.Path != "" {
+		checkDirectory(report, "Store directory", filepath.Dir(cfg.Store.Path), 0, 100*mib)
+	}
+}
+
+// checkDirectory fails a directory that cannot be written or has less than
+// failBelow bytes free, and warns below warnBelow
+func checkDirectory(report *doctorReport, label, dir string, failBelow, warnBelow uint64) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		report.fail("%s %s cannot be created: %v", label, dir, err)
+		return
+	}
+	probe, err := os.CreateTemp(dir, ".gitsync-write-check-*")
+	if err != nil {
+		report.fail("%s %s is not writable: %v", label, dir, err)
+		return
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+
+	free, err := common.FreeSpace(dir)
+	switch {
+	case err != nil:
+		report.warn("%s %s is writable, free space unknown: %v", label, dir, err)
+	case free < failBelow:
+		report.fail("%s %s has only %s free", label, dir, formatBytes(free))
+	case free < warnBelow:
+		report.warn("%s %s has only %s free", label, dir, formatBytes(free))
+	default:
+		report.pass("%s %s is writable, %s free", label, dir, formatBytes(free))
+	}
+}
+
+// checkSecrets reads the token of every job that takes it from a secrets
+// backend, reporting where it was read from but never the value
+func checkSecrets(report *doctorReport, cfg *common.Config) {
+	ctx, cancel := context.WithTimeout(context.Background(), doctorRemoteTimeout)
+	defer cancel()
+	errs := services.ResolveTokenSecrets(ctx, cfg)
+
+	checked := 0
+	for _, jobName := range cfg.GetEnabledJobs() {
+		jobConfig, _ := cfg.GetJobConfig(jobName)
+		if jobConfig.TokenSecret() == "" {
+			continue
+		}
+		checked++
+		if err := errs[jobName]; err != nil {
+			report.fail("%v", err)
+		} else {
+			report.pass("job %s: token read from %s", jobName, jobConfig.TokenSecret())
+		}
+	}
+	if checked == 0 {
+		report.pass("No job reads its token from a secrets backend")
+	}
+}
+
+func checkRemotes(report *doctorReport, cfg *common.Config) {
+	// Syncers log, keep the report free of everything but errors
+	logging := cfg.Logging
+	logging.Level = "error"
+	common.InitLogger(&logging)
+
+	// Discovery jobs have to reach their provider API, the jobs they find are checked like the others
+	ctx, cancel := context.WithTimeout(context.Background(), doctorRemoteTimeout)
+	discovery := services.NewDiscovery()
+	_, errs := discovery.Refresh(ctx, cfg, true)
+	cancel()
+	discovered := discovery.Config(cfg)
+	for _, jobName := range cfg.Jobs.Names {
+		jobConfig, _ := cfg.GetJobConfig(jobName)
+		if !jobConfig.Enabled || !jobConfig.Discovers() {
+			continue
+		}
+		if err := errs[jobName]; err != nil {
+			report.fail("job %s: %v", jobName, err)
+			continue
+		}
+		count := 0
+		for _, discoveredJob := range discovered.JobDefs {
+			if discoveredJob.DiscoveredBy == jobName {
+				count++
+			}
+		}
+		report.pass("job %s: discovers %d repositories in %s", jobName, count, jobConfig.SourceLabel())
+	}
+	cfg = discovered
+
+	for _, jobName := range cfg.GetEnabledJobs() {
+		jobConfig, _ := cfg.GetJobConfig(jobName)
+		syncer, err := services.NewSyncer(jobName, jobConfig, nil)
+		if err != nil {
+			report.fail("job %s: %v", jobName, err)
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), doctorRemoteTimeout)
+		checks, err := syncer.CheckRemotes(ctx)
+		cancel()
+		if err != nil {
+			report.fail("job %s: %v", jobName, err)
+			continue
+		}
+		for _, check := range checks {
+			url := common.RedactURLCredentials(check.URL)
+			if check.Err != nil {
+				report.fail("job %s: %s: %v", jobName, url, check.Err)
+			} else {
+				report.pass("job %s: %s is reachable", jobName, url)
+			}
+		}
+	}
+}
+
+func formatBytes(bytes uint64) string {
+	if bytes >= gib {
+		return fmt.Sprintf("%.1f GiB", float64(bytes)/gib)
+	}
+	return fmt.Sprintf("%d MiB", bytes/mib)
+}
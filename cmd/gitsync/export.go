@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ternarybob/gitsync/internal/common"
+	"github.com/ternarybob/gitsync/internal/store"
+)
+
+// exportOptions selects the transactions and format written by gitsync export
+type exportOptions struct {
+	from   string
+	to     string
+	format string
+	output string
+}
+
+var exportColumns = []string{"id", "job", "branch", "ref", "target", "status", "commit", "old_commit", "start_time", "end_time", "duration_seconds", "error", "run_id"}
+
+// runExport streams every transaction in the requested range to stdout or a file
+func runExport(cfg *common.Config, opts exportOptions) error {
+	if opts.format != "csv" && opts.format != "json" {
+		return fmt.Errorf("unknown export format %q, use csv or json", opts.format)
+	}
+	from, err := parseExportDate(opts.from, false)
+	if err != nil {
+		return fmt.Errorf("invalid -from: %w", err)
+	}
+	to, err := parseExportDate(opts.to, true)
+	if err != nil {
+		return fmt.Errorf("invalid -to: %w", err)
+	}
+	if cfg.Store.Path == "" {
+		return fmt.Errorf("transaction store is disabled, set [store] path to record history")
+	}
+
+	st, err := store.Open(cfg.Store.Path, cfg.Store.BucketName)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	var out io.Writer = os.Stdout
+	if opts.output != "" {
+		file, err := os.Create(opts.output)
+		if err != nil {
+			return fmt.Errorf("failed to create export file: %w", err)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	if opts.format == "json" {
+		return exportJSON(st, from, to, out)
+	}
+	return exportCSV(st, from, to, out)
+}
+
+func exportCSV(st *store.Store, from, to time.Time, out io.Writer) error {
+	w := csv.NewWriter(out)
+	if err := w.Write(exportColumns); err != nil {
+		return err
+	}
+
+	err := st.ForEachTransaction(from, to, func(t *store.Transaction) error {
+		var endTime string
+		if !t.EndTime.IsZero() {
+			endTime = t.EndTime.UTC().Format(time.RFC3339)
+		}
+		return w.Write([]string{
+			t.ID, t.JobName, t.Branch, t.Ref, t.Target, t.Status, t.CommitHash, t.OldCommit,
+			t.StartTime.UTC().Format(time.RFC3339), endTime,
+			strconv.FormatFloat(t.Duration.Seconds(), 'f', 3, 64), t.Error, t.RunID,
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	w.Flush()
+	return w.Error()
+}
+
+// exportJSON writes a JSON array one element at a time, never holding the full history in memory
+func exportJSON(st *store.Store, from, to time.Time, out io.Writer) error {
+	if _, err := io.WriteString(out, "["); err != nil {
+		return err
+	}
+
+	first := true
+	err := st.ForEachTransaction(from, to, func(t *store.Transaction) error {
+		data, err := json.Marshal(t)
+		if err != nil {
+			return err
+		}
+		separator := ",\n"
+		if first {
+			separator = "\n"
+			first = false
+		}
+		if _, err := io.WriteString(out, separator); err != nil {
+			return err
+		}
+		_, err = out.Write(data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(out, "\n]\n")
+	return err
+}
+
+// parseExportDate accepts YYYY-MM-DD or RFC3339. A bare date used as the end
+// of the range includes that whole day.
+func parseExportDate(value string, end bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not YYYY-MM-DD or RFC3339", value)
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
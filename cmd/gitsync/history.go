@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ternarybob/gitsync/internal/common"
+	"github.com/ternarybob/gitsync/internal/store"
+)
+
+// historyOptions filters the transactions printed by gitsync history
+type historyOptions struct {
+	limit  int
+	target string
+	status string
+	json   bool
+}
+
+// runHistory prints the most recent transactions of a job, newest first
+func runHistory(cfg *common.Config, jobName string, opts historyOptions) error {
+	if _, exists := cfg.GetJobConfig(jobName); !exists {
+		return fmt.Errorf("job not found: %s", jobName)
+	}
+	switch opts.status {
+	case "", store.StatusRunning, store.StatusSuccess, store.StatusFailed, store.StatusSkipped, store.StatusDiverged, store.StatusBlocked:
+	default:
+		return fmt.Errorf("unknown status %q, use running, success, failed, skipped, diverged or blocked", opts.status)
+	}
+	if cfg.Store.Path == "" {
+		return fmt.Errorf("transaction store is disabled, set [store] path to record history")
+	}
+
+	st, err := store.Open(cfg.Store.Path, cfg.Store.BucketName)
+	if err != nil {
+		return fmt.Errorf("%w (is another gitsync process holding the database?)", err)
+	}
+	defer st.Close()
+
+	all, err := st.GetTransactionsByJob(jobName, 0)
+	if err != nil {
+		return err
+	}
+
+	var transactions []*store.Transaction
+	for _, t := range all {
+		if opts.target != "" && t.Target != opts.target {
+			continue
+		}
+		if opts.status != "" && t.Status != opts.status {
+			continue
+		}
+		transactions = append(transactions, t)
+		if opts.limit > 0 && len(transactions) >= opts.limit {
+			break
+		}
+	}
+
+	if opts.json {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if transactions == nil {
+			transactions = []*store.Transaction{}
+		}
+		return encoder.Encode(transactions)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tBRANCH\tTARGET\tSTATUS\tCOMMIT\tDURATION\tERROR")
+	for _, t := range transactions {
+		name := t.Branch
+		if name == "" {
+			name = t.Ref
+		}
+		if name == "" {
+			name = "-"
+		}
+		commit := t.CommitHash
+		if len(commit) > 7 {
+			commit = commit[:7]
+		}
+		if commit == "" {
+			commit = "-"
+		}
+		errText := strings.SplitN(t.Error, "\n", 2)[0]
+		if errText == "" {
+			errText = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			t.StartTime.Local().Format(time.RFC3339), name, t.Target, t.Status, commit, t.Duration.Round(time.Millisecond), errText)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ternarybob/gitsync/internal/common"
+	"github.com/ternarybob/gitsync/internal/services"
+)
+
+// jobListRow is one job printed by gitsync jobs, with the environment variables
+// of the configuration already applied. Secrets are only reported as set or
+// unset.
+type jobListRow struct {
+	Name           string          `json:"name"`
+	Enabled        bool            `json:"enabled"`
+	Source         string          `json:"source"`
+	TargetCount    int             `json:"target_count"`
+	TargetHosts    []string        `json:"target_hosts"`
+	Branches       []string        `json:"branches,omitempty"`
+	Refspecs       []string        `json:"refspecs,omitempty"`
+	Override       bool            `json:"override"`
+	RewriteHistory bool            `json:"rewrite_history"`
+	Discovers      bool            `json:"discovers,omitempty"`     // A discovery job, which creates the jobs discovered_by it
+	DiscoveredBy   string          `json:"discovered_by,omitempty"` // Discovery job that created this job
+	Credentials    jobCredentials  `json:"credentials"`
+	TargetKeys     []targetKeyInfo `json:"target_ssh_keys,omitempty"`
+}
+
+// jobCredentials tells how a job authenticates. Token is "set" or "unset"
+// when the job configures a token, empty otherwise.
+type jobCredentials struct {
+	GitUsername string `json:"git_username,omitempty"`
+	TokenEnv    string `json:"token_env,omitempty"`
+	TokenSecret string `json:"token_secret,omitempty"` // Secrets backend entry the token is read from
+	Token       string `json:"token,omitempty"`
+	SSHKeyEnv   string `json:"ssh_key_env,omitempty"`
+	SSHKeyPath  string `json:"ssh_key_path,omitempty"`
+}
+
+// targetKeyInfo is the SSH key of a target that has its own
+type targetKeyInfo struct {
+	Host       string `json:"host"`
+	SSHKeyEnv  string `json:"ssh_key_env,omitempty"`
+	SSHKeyPath string `json:"ssh_key_path,omitempty"`
+}
+
+// runListJobs prints every configured job as gitsync would run it, with the
+// jobs discovery jobs currently find
+func runListJobs(cfg *common.Config, asJSON bool) error {
+	discovery := services.NewDiscovery()
+	_, errs := discovery.Refresh(context.Background(), cfg, true)
+	for jobName, err := range errs {
+		fmt.Fprintf(os.Stderr, "Warning: failed to discover repositories of job %s: %v\n", jobName, err)
+	}
+	discovered := discovery.Config(cfg)
+	for _, warning := range discovered.Warnings[len(cfg.Warnings):] {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+	}
+	cfg = discovered
+
+	rows := make([]jobListRow, 0, len(cfg.Jobs.Names))
+	for _, jobName := range cfg.Jobs.Names {
+		jobConfig, _ := cfg.GetJobConfig(jobName)
+		rows = append(rows, listJob(jobName, jobConfig))
+	}
+
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(rows)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "JOB\tENABLED\tSOURCE\tTARGETS\tBRANCHES\tOVERRIDE\tREWRITE\tCREDENTIALS")
+	for _, row := range rows {
+		branches := "default branch"
+		switch {
+		case len(row.Refspecs) > 0:
+			branches = "refspecs " + strings.Join(row.Refspecs, ", ")
+		case len(row.Branches) > 0:
+			branches = strings.Join(row.Branches, ", ")
+		}
+		fmt.Fprintf(w, "%s\t%t\t%s\t%d (%s)\t%s\t%t\t%t\t%s\n", row.Name, row.Enabled, row.Source,
+			row.TargetCount, strings.Join(row.TargetHosts, ", "), branches, row.Override, row.RewriteHistory,
+			describeCredentials(row))
+	}
+	return w.Flush()
+}
+
+func listJob(jobName string, jobConfig *common.JobConfig) jobListRow {
+	row := jobListRow{
+		Name:           jobName,
+		Enabled:        jobConfig.Enabled,
+		Source:         common.RedactURLCredentials(jobConfig.SourceLabel()),
+		TargetCount:    len(jobConfig.Targets),
+		TargetHosts:    []string{},
+		Branches:       jobConfig.Branches,
+		Refspecs:       jobConfig.Refspecs,
+		Override:       jobConfig.Override,
+		RewriteHistory: jobConfig.RewriteHistory,
+		Discovers:      jobConfig.Discovers(),
+		DiscoveredBy:   jobConfig.DiscoveredBy,
+		Credentials: jobCredentials{
+			GitUsername: jobConfig.GitUsername,
+			TokenEnv:    jobConfig.GitTokenEnv,
+			TokenSecret: jobConfig.TokenSecret(),
+			SSHKeyEnv:   jobConfig.SSHKeyEnv,
+			SSHKeyPath:  jobConfig.SSHKeyPath,
+		},
+	}
+
+	if jobConfig.GitTokenEnv != "" || jobConfig.GitToken != "" {
+		row.Credentials.Token = setOrUnset(jobConfig.GitToken)
+	}
+
+	seen := make(map[string]bool)
+	for _, target := range jobConfig.Targets {
+		host := common.RepositoryHost(target.URL)
+		if !seen[host] {
+			seen[host] = true
+			row.TargetHosts = append(row.TargetHosts, host)
+		}
+		if target.SSHKeyEnv != "" || target.SSHKeyPath != "" {
+			row.TargetKeys = append(row.TargetKeys, targetKeyInfo{Host: host, SSHKeyEnv: target.SSHKeyEnv, SSHKeyPath: target.SSHKeyPath})
+		}
+	}
+	return row
+}
+
+// describeCredentials is the CREDENTIALS column of gitsync jobs
+func describeCredentials(row jobListRow) string {
+	var parts []string
+	creds := row.Credentials
+	switch {
+	case creds.TokenEnv != "":
+		parts = append(parts, fmt.Sprintf("token $%s (%s)", creds.TokenEnv, creds.Token))
+	case creds.TokenSecret != "":
+		parts = append(parts, "token from "+creds.TokenSecret)
+	case creds.Token != "":
+		parts = append(parts, "token ("+creds.Token+")")
+	}
+	if (creds.Token == "set" || creds.TokenSecret != "") && creds.GitUsername == "" {
+		parts = append(parts, "no git_username, token unused")
+	} else if creds.GitUsername != "" {
+		parts = append(parts, "user "+creds.GitUsername)
+	}
+	if key := describeSSHKey(creds.SSHKeyEnv, creds.SSHKeyPath); key != "" {
+		parts = append(parts, key)
+	}
+	for _, target := range row.TargetKeys {
+		parts = append(parts, target.Host+" "+describeSSHKey(target.SSHKeyEnv, target.SSHKeyPath))
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, ", ")
+}
+
+func describeSSHKey(env, path string) string {
+	switch {
+	case env != "" && path == "":
+		return fmt.Sprintf("ssh key $%s (unset)", env)
+	case env != "":
+		return fmt.Sprintf("ssh key $%s (%s)", env, path)
+	case path != "":
+		return "ssh key " + path
+	}
+	return ""
+}
+
+func setOrUnset(value string) string {
+	if value == "" {
+		return "unset"
+	}
+	return "set"
+}
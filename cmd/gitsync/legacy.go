@@ -0,0 +1,162 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ternarybob/gitsync/internal/common"
+	"github.com/ternarybob/gitsync/internal/services"
+)
+
+// legacyMain runs the flag-only command line of earlier releases. An action
+// flag runs the command that replaces it after a deprecation warning on
+// stderr, and without one gitsync serves as it always did.
+func legacyMain(args []string) int {
+	fs := flag.NewFlagSet("gitsync", flag.ExitOnError)
+	var (
+		configPath     = fs.String("config", "", "Path to configuration file (defaults to GITSYNC_CONFIG, then gitsync.toml in executable directory)")
+		validateConfig = fs.Bool("validate", false, "Deprecated, use 'gitsync validate'")
+		strictConfig   = fs.Bool("strict", false, "Let -validate fail when the configuration has unknown keys")
+		showVersion    = fs.Bool("version", false, "Deprecated, use 'gitsync version'")
+		runJob         = fs.String("run-job", "", "Deprecated, use 'gitsync run <job...>'")
+		runAllOnce     = fs.Bool("once", false, "Deprecated, use 'gitsync run -all'")
+		runAll         = fs.Bool("run-all", false, "Deprecated, use 'gitsync run -all'")
+		skipInitial    = fs.Bool("skip-initial-sync", false, "Start the scheduler without syncing the enabled jobs first")
+		forceRun       = fs.Bool("force", false, "Let -run-job run a disabled job or a -branch its patterns do not select, or -init overwrite an existing file")
+		initFile       = fs.Bool("init", false, "Deprecated, use 'gitsync init'")
+		withDeps       = fs.Bool("with-deps", false, "Let -run-job run the jobs the jobs depend on first")
+		showStats      = fs.Bool("stats", false, "Deprecated, use 'gitsync status'")
+		jsonOutput     = fs.Bool("json", false, "Print the -run-job result, -history, -job-status, -list-jobs or -print-config as JSON")
+		printConfig    = fs.Bool("print-config", false, "Deprecated, use 'gitsync config print'")
+		runChecks      = fs.Bool("doctor", false, "Deprecated, use 'gitsync doctor'")
+		doctorRemote   = fs.Bool("remote", false, "Let -doctor also reach the source and targets of every enabled job")
+		listJobs       = fs.Bool("list-jobs", false, "Deprecated, use 'gitsync jobs'")
+		installSvc     = fs.Bool("install-service", false, "Deprecated, use 'gitsync service install'")
+		uninstallSvc   = fs.Bool("uninstall-service", false, "Deprecated, use 'gitsync service uninstall'")
+		jobStatus      = fs.Bool("job-status", false, "Deprecated, use 'gitsync status [job]'")
+		historyJob     = fs.String("history", "", "Deprecated, use 'gitsync history <job>'")
+		historyLimit   = fs.Int("limit", 20, "Maximum number of -history entries, 0 for all")
+		targetFilter   = fs.String("target", "", "Only list -history entries for this target URL, or only push to the targets with this URL or host in -run-job")
+		runBranch      = fs.String("branch", "", "Only sync this branch in -run-job")
+		historyStatus  = fs.String("status", "", "Only list -history entries with this status (running, success, failed, skipped, diverged, blocked)")
+		exportHistory  = fs.Bool("export", false, "Deprecated, use 'gitsync export'")
+		exportFrom     = fs.String("from", "", "Only -export transactions started on or after this date (YYYY-MM-DD or RFC3339)")
+		exportTo       = fs.String("to", "", "Only -export transactions started up to this date (YYYY-MM-DD inclusive, or RFC3339)")
+		exportFormat   = fs.String("format", "csv", "-export format: csv or json")
+		exportOutput   = fs.String("output", "", "-export destination file (defaults to stdout), or text or json for -run-job, -once and -run-all")
+	)
+	logOpts := logFlags(fs)
+	fs.Usage = func() {
+		usage(fs.Output())
+		fmt.Fprintf(fs.Output(), "\nFlags of earlier releases:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if err := logOpts.check(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return exitUsage
+	}
+
+	// -job-status takes an optional job name, flags may follow it
+	var statusJob string
+	if *jobStatus && fs.NArg() > 0 {
+		statusJob = fs.Arg(0)
+		fs.Parse(fs.Args()[1:])
+	}
+
+	// -output is the destination file of -export and the format of the run flags
+	runJSON := *jsonOutput
+	if *runAllOnce || *runAll || *runJob != "" {
+		var err error
+		if runJSON, err = runOutput(*exportOutput, *jsonOutput); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return exitUsage
+		}
+	}
+
+	switch {
+	case *showVersion:
+		deprecated("-version", "gitsync version")
+		return cmdVersion(nil)
+	case *initFile:
+		deprecated("-init", "gitsync init")
+		return initConfig(*configPath, *forceRun)
+	case *runChecks:
+		deprecated("-doctor", "gitsync doctor")
+		return doctor(*configPath, *doctorRemote)
+	case *installSvc:
+		deprecated("-install-service", "gitsync service install")
+		return serviceInstall(*configPath, defaultServiceName)
+	case *uninstallSvc:
+		deprecated("-uninstall-service", "gitsync service uninstall")
+		return serviceUninstall(defaultServiceName)
+	case *validateConfig:
+		deprecated("-validate", "gitsync validate")
+		return validate(*configPath, *strictConfig, false)
+	case *printConfig:
+		deprecated("-print-config", "gitsync config print")
+		return withConfig(*configPath, "print configuration", func(cfg *common.Config) error {
+			return runPrintConfig(cfg, *jsonOutput)
+		})
+	case *listJobs:
+		deprecated("-list-jobs", "gitsync jobs")
+		return withConfig(*configPath, "list jobs", func(cfg *common.Config) error {
+			return runListJobs(cfg, *jsonOutput)
+		})
+	case *historyJob != "":
+		deprecated("-history", "gitsync history")
+		opts := historyOptions{limit: *historyLimit, target: *targetFilter, status: *historyStatus, json: *jsonOutput}
+		return withConfig(*configPath, "read history", func(cfg *common.Config) error {
+			return runHistory(cfg, *historyJob, opts)
+		})
+	case *jobStatus:
+		deprecated("-job-status", "gitsync status")
+		return withConfig(*configPath, "show job status", func(cfg *common.Config) error {
+			return runJobStatus(cfg, statusJob, *jsonOutput)
+		})
+	case *exportHistory:
+		deprecated("-export", "gitsync export")
+		opts := exportOptions{from: *exportFrom, to: *exportTo, format: *exportFormat, output: *exportOutput}
+		return withConfig(*configPath, "export history", func(cfg *common.Config) error {
+			return runExport(cfg, opts)
+		})
+	case *showStats:
+		deprecated("-stats", "gitsync status")
+		cfg, configPath := loadConfig(*configPath, 1)
+		startLogging(cfg, configPath, logOpts, !*jsonOutput, 1)
+		fmt.Println("Statistics are now tracked via logging.")
+		logsDir, _ := cfg.Logging.LogDirectory()
+		fmt.Printf("Check the log files in %s for sync history and performance data.\n", logsDir)
+		return 0
+	case *runAllOnce:
+		deprecated("-once", "gitsync run -all")
+		return runCommand(runOptions{configPath: *configPath, all: true, json: runJSON, log: logOpts})
+	case *runAll:
+		deprecated("-run-all", "gitsync run -all")
+		return runCommand(runOptions{configPath: *configPath, all: true, json: runJSON, log: logOpts})
+	case *runJob != "":
+		deprecated("-run-job", "gitsync run")
+		opts := runOptions{
+			configPath: *configPath,
+			force:      *forceRun,
+			withDeps:   *withDeps,
+			json:       runJSON,
+			log:        logOpts,
+			filter:     services.RunFilter{Branch: *runBranch, Target: *targetFilter, AnyBranch: *forceRun},
+		}
+		for _, jobName := range strings.Split(*runJob, ",") {
+			if jobName = strings.TrimSpace(jobName); jobName != "" {
+				opts.jobs = append(opts.jobs, jobName)
+			}
+		}
+		return runCommand(opts)
+	}
+	return serve(*configPath, *skipInitial, logOpts)
+}
+
+// deprecated warns that a flag of earlier releases is replaced by a command
+func deprecated(flagName, replacement string) {
+	fmt.Fprintf(os.Stderr, "Warning: %s is deprecated and will be removed, use '%s' instead\n", flagName, replacement)
+}
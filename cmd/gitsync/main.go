@@ -1,121 +1,280 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
 	"os/exec"
-	"os/signal"
 	"path/filepath"
-	"syscall"
+	"strings"
+	"sync"
+	"time"
+	_ "time/tzdata" // Job timezones work on hosts without a zoneinfo database
+
+	"github.com/ternarybob/arbor"
 
 	"github.com/ternarybob/gitsync/internal/common"
 	"github.com/ternarybob/gitsync/internal/services"
+	"github.com/ternarybob/gitsync/internal/store"
 )
 
 func main() {
-	var (
-		configPath     = flag.String("config", "", "Path to configuration file (defaults to gitsync.toml in executable directory)")
-		validateConfig = flag.Bool("validate", false, "Validate configuration file and exit")
-		showVersion    = flag.Bool("version", false, "Show version and exit")
-		runJob         = flag.String("run-job", "", "Run a specific job immediately and exit")
-		showStats      = flag.Bool("stats", false, "Show sync statistics and exit")
-	)
-	flag.Parse()
-
-	if *showVersion {
-		fmt.Printf("GitSync v%s (build: %s)\n", common.GetVersion(), common.GetBuild())
-		os.Exit(0)
-	}
-
-	// Determine config file path
-	finalConfigPath := *configPath
-	if finalConfigPath == "" {
-		// Default to gitsync.toml in the same directory as the executable
-		execPath, err := os.Executable()
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to get executable path: %v\n", err)
-			os.Exit(1)
+	args := os.Args[1:]
+
+	// Flags first is the command line of earlier releases
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		os.Exit(legacyMain(args))
+	}
+
+	c, rest := findCommand(args)
+	if c == nil {
+		if names := subcommands(args[0]); len(names) > 0 {
+			fmt.Fprintf(os.Stderr, "gitsync %s needs a command: %s\n", args[0], strings.Join(names, ", "))
+			os.Exit(exitUsage)
 		}
-		execDir := filepath.Dir(execPath)
-		finalConfigPath = filepath.Join(execDir, "gitsync.toml")
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n", args[0])
+		usage(os.Stderr)
+		os.Exit(exitUsage)
+	}
+	os.Exit(c.run(rest))
+}
+
+// resolveConfigPath returns the configuration file of a command: -config,
+// then GITSYNC_CONFIG, then gitsync.toml next to the executable
+func resolveConfigPath(flagValue string) (string, error) {
+	if flagValue != "" {
+		return flagValue, nil
+	}
+	if path := os.Getenv("GITSYNC_CONFIG"); path != "" {
+		return path, nil
 	}
 
-	// Check if config file exists
-	if _, err := os.Stat(finalConfigPath); os.IsNotExist(err) {
-		fmt.Fprintf(os.Stderr, "Configuration file not found: %s\n", finalConfigPath)
-		fmt.Fprintf(os.Stderr, "Create a gitsync.toml file in the same directory as the executable, or specify one with -config\n")
-		os.Exit(1)
+	execPath, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to get executable path: %w", err)
 	}
+	return filepath.Join(filepath.Dir(execPath), "gitsync.toml"), nil
+}
 
-	cfg, err := common.Load(finalConfigPath)
+// loadConfig loads the configuration of a command and returns it with its
+// path. When it cannot, it prints why to stderr and exits with exitCode.
+func loadConfig(flagValue string, exitCode int) (*common.Config, string) {
+	path, err := resolveConfigPath(flagValue)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
-		os.Exit(1)
+		os.Exit(exitCode)
 	}
 
-	if *validateConfig {
-		fmt.Println("Configuration is valid")
-		os.Exit(0)
+	// Without a file GITSYNC_SOURCE and friends can describe a job
+	if _, err := os.Stat(path); os.IsNotExist(err) && !common.EnvConfigured() {
+		fmt.Fprintf(os.Stderr, "Configuration file not found: %s\n", path)
+		fmt.Fprintf(os.Stderr, "Create a gitsync.toml file in the same directory as the executable with 'gitsync init', or specify one with -config or GITSYNC_CONFIG\n")
+		fmt.Fprintf(os.Stderr, "To run a single job without a file, set GITSYNC_SOURCE and GITSYNC_TARGETS\n")
+		os.Exit(exitCode)
 	}
 
-	// Calculate enabled jobs
-	enabledJobs := cfg.GetEnabledJobs()
+	cfg, err := common.Load(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		os.Exit(exitCode)
+	}
+	return cfg, path
+}
 
-	// Initialize logger with config before any logging operations
+// logOptions override [logging] for the life of the process, so a single run
+// can log at debug or a cron job stay silent without editing the file
+type logOptions struct {
+	level    string
+	quiet    bool // No banner and only warnings and errors on the console
+	noBanner bool
+}
+
+// logFlags registers the logging overrides of the commands that sync
+func logFlags(fs *flag.FlagSet) *logOptions {
+	opts := &logOptions{}
+	fs.StringVar(&opts.level, "log-level", "", "Log at this level instead of the configured one: trace, debug, info, warn or error")
+	fs.BoolVar(&opts.quiet, "quiet", false, "Leave out the banner and console log lines below warn, for cron")
+	fs.BoolVar(&opts.noBanner, "no-banner", false, "Leave out the banner")
+	return opts
+}
+
+func (o *logOptions) check() error {
+	if o.level == "" {
+		return nil
+	}
+	return common.CheckLogLevel(o.level)
+}
+
+// apply sets the overrides on the logging configuration before the logger is
+// initialized
+func (o *logOptions) apply(logging *common.LoggingConfig) {
+	if o.level != "" {
+		logging.Level = o.level
+	}
+	if o.quiet {
+		logging.ConsoleLevel = "warn"
+	}
+}
+
+// startLogging initializes the logger of the commands that sync with the
+// overrides of opts, logs the startup and checks that git is available,
+// exiting with exitCode when either fails. The banner is left out when
+// stdout has to stay machine readable.
+func startLogging(cfg *common.Config, configPath string, opts *logOptions, banner bool, exitCode int) arbor.ILogger {
+	opts.apply(&cfg.Logging)
 	if err := common.InitLogger(&cfg.Logging); err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to initialize logger: %v\n", err)
-		os.Exit(1)
+		os.Exit(exitCode)
 	}
-
-	// Now get the configured logger
 	logger := common.GetLogger()
 
-	// Show banner after logger is initialized
-	common.PrintBanner(cfg.Service.Name, cfg.Service.Environment, len(cfg.Jobs.Names), len(enabledJobs))
+	// JSON console logs keep stdout machine readable as well
+	if banner && !opts.quiet && !opts.noBanner && cfg.Logging.ConsoleLogFormat() != "json" {
+		common.PrintBanner(cfg.Service.Name, cfg.Service.Environment, len(cfg.Jobs.Names), len(cfg.GetEnabledJobs()))
+	}
 
 	logger.Info().Str("version", common.GetVersion()).Str("build", common.GetBuild()).Msg("Starting GitSync")
 
-	// Test git availability and version at startup
+	if cfg.EnvOnly {
+		logger.Info().Str("config", configPath).Str("job", cfg.Jobs.Names[0]).Msg("No configuration file, running the job configured by GITSYNC_ environment variables")
+	}
+	if files := cfg.IncludedFiles(); len(files) > 0 {
+		logger.Info().Strs("files", files).Msg("Included job files")
+	}
+	for _, warning := range cfg.Warnings {
+		logger.Warn().Msg(warning)
+	}
+
 	gitVersion, err := testGitAvailability()
 	if err != nil {
-		logger.Fatal().Err(err).Msg("Git is not available")
+		logger.Error().Err(err).Msg("Git is not available")
+		os.Exit(exitCode)
 	}
 	logger.Info().Str("git_version", gitVersion).Msg("Git availability verified")
+	return logger
+}
 
-	if *showStats {
-		fmt.Println("Statistics are now tracked via logging.")
-		fmt.Println("Check the log files in ./logs/ for sync history and performance data.")
-		os.Exit(0)
+// syncResources are what syncing needs besides the configuration and the
+// logger: tracing and the transaction store, nil when history is disabled
+type syncResources struct {
+	store           *store.Store
+	shutdownTracing func(context.Context) error
+}
+
+// openSyncResources starts tracing and opens the transaction store, exiting
+// with exitCode when tracing cannot start
+func openSyncResources(cfg *common.Config, exitCode int) *syncResources {
+	logger := common.GetLogger()
+
+	shutdownTracing, err := services.InitTracing(context.Background(), cfg)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to initialize tracing")
+		os.Exit(exitCode)
 	}
+	if cfg.Tracing.Endpoint != "" {
+		logger.Info().Str("endpoint", cfg.Tracing.Endpoint).Msg("Tracing enabled")
+	}
+
+	return &syncResources{store: openStore(cfg), shutdownTracing: shutdownTracing}
+}
+
+// close closes the store and flushes the spans not exported yet
+func (r *syncResources) close() {
+	closeStore(r.store)
+	flushTracing(r.shutdownTracing)
+}
 
-	if *runJob != "" {
-		logger.Info().Str("job", *runJob).Msg("Running job immediately")
-		s := services.NewScheduler(cfg)
-		if err := s.RunJobNow(*runJob); err != nil {
-			logger.Fatal().Err(err).Msg("Failed to run job")
+// validate loads the configuration and reports its warnings, failing on
+// unknown keys when strict and on tokens that cannot be read from their
+// secrets backend when deep
+func validate(configFlag string, strict, deep bool) int {
+	cfg, _ := loadConfig(configFlag, 1)
+
+	for _, warning := range cfg.Warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+	}
+	if strict && len(cfg.UnknownKeys) > 0 {
+		fmt.Fprintf(os.Stderr, "Configuration has %d unknown keys\n", len(cfg.UnknownKeys))
+		return 1
+	}
+	for _, file := range cfg.IncludedFiles() {
+		fmt.Printf("Included %s\n", file)
+	}
+	if cfg.EnvOnly {
+		fmt.Println("No configuration file, using the GITSYNC_ environment variables")
+	}
+	if deep {
+		// The secrets are read to know they resolve, their values are not printed
+		errs := services.ResolveTokenSecrets(context.Background(), cfg)
+		for _, jobName := range cfg.GetEnabledJobs() {
+			if err := errs[jobName]; err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+			} else if jobConfig, _ := cfg.GetJobConfig(jobName); jobConfig.TokenSecret() != "" {
+				fmt.Printf("Job %s reads its token from %s\n", jobName, jobConfig.TokenSecret())
+			}
+		}
+		if len(errs) > 0 {
+			return 1
 		}
-		logger.Info().Msg("Job completed")
-		os.Exit(0)
 	}
+	fmt.Println("Configuration is valid")
+	return 0
+}
 
-	sched := services.NewScheduler(cfg)
+// initConfig writes the starter configuration, refusing to replace an
+// existing file unless forced
+func initConfig(configFlag string, force bool) int {
+	path, err := resolveConfigPath(configFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write configuration: %v\n", err)
+		return 1
+	}
+	if err := common.WriteStarterConfig(path, force); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write configuration: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Configuration written to %s\n", path)
+	fmt.Printf("Edit the example job, set GITHUB_TOKEN and check it with: gitsync validate -config %s\n", path)
+	return 0
+}
+
+// openStore opens the transaction history, running without history when it is
+// disabled or cannot be opened
+func openStore(cfg *common.Config) *store.Store {
+	logger := common.GetLogger()
 
-	// Run all enabled jobs once at startup
-	logger.Info().Msg("Running initial sync for all enabled jobs...")
-	runInitialJobs(sched, cfg)
+	if cfg.Store.Path == "" {
+		logger.Info().Msg("Transaction store disabled")
+		return nil
+	}
 
-	if err := sched.Start(); err != nil {
-		logger.Fatal().Err(err).Msg("Failed to start scheduler")
+	st, err := store.Open(cfg.Store.Path, cfg.Store.BucketName)
+	if err != nil {
+		logger.Warn().Err(err).Msg("Failed to open transaction store, sync history will not be recorded")
+		return nil
 	}
 
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
-	<-quit
+	logger.Info().Str("path", cfg.Store.Path).Msg("Transaction store opened")
+	return st
+}
 
-	logger.Info().Msg("Shutting down GitSync...")
-	sched.Stop()
-	logger.Info().Msg("Shutdown complete")
+func closeStore(st *store.Store) {
+	if st == nil {
+		return
+	}
+	if err := st.Close(); err != nil {
+		common.GetLogger().Warn().Err(err).Msg("Failed to close transaction store")
+	}
+}
+
+// flushTracing exports spans still buffered, giving up after a few seconds
+func flushTracing(shutdown func(context.Context) error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdown(ctx); err != nil {
+		common.GetLogger().Warn().Err(err).Msg("Failed to flush traces")
+	}
 }
 
 func testGitAvailability() (string, error) {
@@ -128,34 +287,68 @@ func testGitAvailability() (string, error) {
 	return string(output), nil
 }
 
-func runInitialJobs(sched *services.Scheduler, cfg *common.Config) {
-	logger := common.GetLogger()
-	enabledJobs := cfg.GetEnabledJobs()
-	if len(enabledJobs) == 0 {
-		logger.Info().Msg("No enabled jobs found, skipping initial sync")
-		return
+// printResult writes a job result to stdout as a summary line and a table
+func printResult(result *services.SyncResult) {
+	job := result.Job
+	if result.RunID != "" {
+		job += " (run " + result.RunID + ")"
 	}
+	fmt.Printf("\nJob %s: %s\n", job, result.Summary())
+	result.WriteTable(os.Stdout)
+}
 
-	var successCount, errorCount int
-	logger.Info().Int("job_count", len(enabledJobs)).Msg("Starting initial sync for enabled jobs")
-
-	for _, jobName := range enabledJobs {
-		logger.Info().Str("job", jobName).Msg("🔄 Running initial sync for job")
+// parallelJobs returns how many jobs run at once outside the schedule:
+// max_concurrent_jobs, or one at a time without a limit
+func parallelJobs(cfg *common.Config) int {
+	if cfg.Jobs.MaxConcurrentJobs > 0 {
+		return cfg.Jobs.MaxConcurrentJobs
+	}
+	return 1
+}
 
-		if err := sched.RunJobNow(jobName); err != nil {
-			errorCount++
-			logger.Error().Str("job", jobName).Err(err).Msg("❌ INITIAL SYNC FAILED for job")
-		} else {
-			successCount++
-			logger.Info().Str("job", jobName).Msg("✅ Initial sync completed successfully for job")
-		}
+// forEachJob calls fn for every job, parallelJobs at a time, and returns once
+// all calls have. A job is called after the jobs of jobNames it depends on;
+// when one of them failed fn gets the reason to skip it.
+func forEachJob(cfg *common.Config, jobNames []string, fn func(jobName string, dependencyErr error) error) {
+	type outcome struct {
+		done chan struct{}
+		err  error
+	}
+	outcomes := make(map[string]*outcome, len(jobNames))
+	for _, jobName := range jobNames {
+		outcomes[jobName] = &outcome{done: make(chan struct{})}
 	}
 
-	logger.Info().Int("successful", successCount).Int("failed", errorCount).Int("total", len(enabledJobs)).Msg("Initial sync summary")
+	var wg sync.WaitGroup
+	jobs := make(chan string)
+	for i := 0; i < parallelJobs(cfg); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for jobName := range jobs {
+				// Dependencies were handed out before, so they finish
+				var dependencyErr error
+				var dependencies []string
+				if jobConfig, exists := cfg.GetJobConfig(jobName); exists {
+					dependencies = jobConfig.DependsOn
+				}
+				for _, dependency := range dependencies {
+					if dep, listed := outcomes[dependency]; listed {
+						<-dep.done
+						if dep.err != nil && dependencyErr == nil {
+							dependencyErr = fmt.Errorf("dependency %s failed: %w", dependency, dep.err)
+						}
+					}
+				}
 
-	if errorCount > 0 {
-		logger.Error().Int("failed_count", errorCount).Msg("⚠️  WARNING: Jobs failed during initial sync - check configuration and connectivity")
-	} else {
-		logger.Info().Msg("🎉 All initial sync jobs completed successfully")
+				outcomes[jobName].err = fn(jobName, dependencyErr)
+				close(outcomes[jobName].done)
+			}
+		}()
+	}
+	for _, jobName := range cfg.DependencyOrder(jobNames, false) {
+		jobs <- jobName
 	}
+	close(jobs)
+	wg.Wait()
 }
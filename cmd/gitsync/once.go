@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ternarybob/gitsync/internal/common"
+	"github.com/ternarybob/gitsync/internal/services"
+	"github.com/ternarybob/gitsync/internal/store"
+)
+
+// Exit codes of run
+const (
+	exitSuccess      = 0
+	exitJobFailed    = 1 // A job or one of its branch/target syncs failed
+	exitStartupError = 2 // The configuration or environment kept gitsync from starting
+)
+
+// runOptions are the jobs and flags of run
+type runOptions struct {
+	configPath string
+	jobs       []string
+	all        bool // Every enabled job instead of jobs
+	force      bool
+	withDeps   bool
+	json       bool // A runReport on stdout instead of tables, console logs on stderr
+	filter     services.RunFilter
+	log        *logOptions
+}
+
+// runReportVersion is the version of the runReport schema. Fields may be
+// added without changing it, it only changes when one is renamed or removed.
+const runReportVersion = 1
+
+// runReport is the single JSON document run prints with -output json, once
+// every job has run. Scripts parse it, keep the schema in the help of run in
+// step with it.
+type runReport struct {
+	Version   int                    `json:"version"`
+	Status    string                 `json:"status"` // success, or failed when any job failed
+	StartTime time.Time              `json:"start_time"`
+	Duration  time.Duration          `json:"duration_ns"`
+	Succeeded int                    `json:"succeeded"`
+	Failed    int                    `json:"failed"`
+	Jobs      []*services.SyncResult `json:"jobs"`
+}
+
+// runReportSchema documents runReport in the help of run
+const runReportSchema = `With -output json the banner is left out, console logs go to stderr and
+stdout carries one JSON document once all jobs ran. Its schema is stable:
+fields may be added, none are renamed or removed without a new version.
+
+  version      1
+  status       "success", or "failed" when any job failed
+  start_time   RFC 3339 time the run started
+  duration_ns  run duration in nanoseconds
+  succeeded    number of jobs without a failed branch or target
+  failed       number of jobs with one
+  jobs         one object per job, in the order given:
+    job, run_id, source, start_time, duration_ns
+    matched    branches or refs selected for the run
+    error      why the job failed, left out when it did not
+    entries    one object per branch or ref and target:
+      branch, ref, target, duration_ns
+      status               "pushed", "skipped", "failed" or "blocked"
+      old_commit, new_commit
+      source_commit        the source commit new_commit was rewritten from
+      source               the [[job.source]] of an aggregation job it came from
+      wiki                 true for a branch of the wiki repository
+      commits_pushed, commits_overwritten, objects, bytes
+      error                left out unless the push failed
+
+Counts that are zero and fields that do not apply, such as branch for a
+bundle, are left out.`
+
+// runOutput reads -output, with -json as a shorter way to ask for JSON, and
+// reports whether run prints JSON
+func runOutput(output string, asJSON bool) (bool, error) {
+	switch output {
+	case "", "text":
+		return asJSON, nil
+	case "json":
+		return true, nil
+	}
+	return false, fmt.Errorf("unknown output '%s', use text or json", output)
+}
+
+// runCommand runs the jobs of opts once and returns the exit code
+func runCommand(opts runOptions) int {
+	// Running every job reports startup errors apart from job failures
+	startupExit := 1
+	if opts.all {
+		startupExit = exitStartupError
+	}
+
+	cfg, configPath := loadConfig(opts.configPath, startupExit)
+	if opts.json {
+		cfg.Logging.ConsoleToStderr = true
+	}
+	logger := startLogging(cfg, configPath, opts.log, !opts.json, startupExit)
+	resources := openSyncResources(cfg, startupExit)
+	defer resources.close()
+
+	cfg = discoverJobs(services.NewDiscovery(), cfg)
+
+	jobNames := opts.jobs
+	if opts.all {
+		jobNames = cfg.GetEnabledJobs()
+		logger.Info().Int("job_count", len(jobNames)).Int("parallel", parallelJobs(cfg)).Msg("Running all enabled jobs once")
+	} else {
+		for _, jobName := range opts.jobs {
+			if _, exists := cfg.GetJobConfig(jobName); !exists {
+				fmt.Fprintf(os.Stderr, "Job not found: %s\n", jobName)
+				return 1
+			}
+		}
+		if opts.withDeps {
+			jobNames = cfg.DependencyOrder(jobNames, true)
+		}
+	}
+
+	startTime := time.Now()
+	results, failed := runJobs(cfg, resources.store, jobNames, opts.force, opts.filter)
+	if opts.json {
+		printRunReport(results, failed, startTime)
+	} else {
+		for _, result := range results {
+			printResult(result)
+		}
+		if opts.all || len(results) > 1 {
+			printJobsSummary(len(results), failed, startTime)
+		}
+	}
+	// Partial failures count as failures so CI and monitoring see a non-zero exit
+	if failed > 0 {
+		return exitJobFailed
+	}
+	if !opts.all {
+		logger.Info().Msg("Job completed")
+	}
+	return exitSuccess
+}
+
+// runJobs runs the given jobs, parallelJobs at a time and each after the
+// listed jobs it depends on, and returns their results in the order given
+// with the number of failed jobs. A job fails when any of its branch or
+// target syncs within filter failed. SIGINT and SIGTERM start no further jobs and cancel
+// the running ones after shutdown_grace_period.
+func runJobs(cfg *common.Config, st *store.Store, jobNames []string, force bool, filter services.RunFilter) ([]*services.SyncResult, int) {
+	logger := common.GetLogger()
+	sched := services.NewScheduler(cfg, st)
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(quit)
+	go func() {
+		if _, ok := <-quit; ok {
+			logger.Warn().Dur("grace_period", cfg.Service.ShutdownGracePeriod).Msg("Interrupted, letting running jobs finish")
+			sched.Stop(cfg.Service.ShutdownGracePeriod)
+		}
+	}()
+
+	results := make(map[string]*services.SyncResult)
+	var failed int
+	var mu sync.Mutex
+
+	forEachJob(cfg, jobNames, func(jobName string, dependencyErr error) error {
+		var result *services.SyncResult
+		err := dependencyErr
+		if err == nil {
+			logger.Info().Str("job", jobName).Msg("Running job immediately")
+			result, err = sched.RunJobFiltered(jobName, force, filter)
+		}
+		if result == nil {
+			result = &services.SyncResult{Job: jobName, StartTime: time.Now()}
+		}
+		if err != nil && result.Error == "" {
+			result.Error = err.Error()
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		results[jobName] = result
+		if err != nil {
+			failed++
+			logger.Error().Str("job", jobName).Err(err).Msg("Job failed")
+		}
+		return err
+	})
+
+	// Results in the order given, whichever job finished first
+	ordered := make([]*services.SyncResult, 0, len(jobNames))
+	for _, jobName := range jobNames {
+		ordered = append(ordered, results[jobName])
+	}
+	return ordered, failed
+}
+
+func printJobsSummary(jobs, failed int, startTime time.Time) {
+	fmt.Printf("\n%d jobs in %s: %d succeeded, %d failed\n",
+		jobs, time.Since(startTime).Round(time.Millisecond), jobs-failed, failed)
+}
+
+func printRunReport(results []*services.SyncResult, failed int, startTime time.Time) {
+	report := runReport{
+		Version:   runReportVersion,
+		Status:    store.StatusSuccess,
+		StartTime: startTime,
+		Duration:  time.Since(startTime),
+		Succeeded: len(results) - failed,
+		Failed:    failed,
+		Jobs:      results,
+	}
+	if failed > 0 {
+		report.Status = store.StatusFailed
+	}
+	// Lists are empty rather than null, so they can be iterated unchecked
+	for _, result := range results {
+		if result.Entries == nil {
+			result.Entries = []services.SyncEntry{}
+		}
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to encode results: %v\n", err)
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/pelletier/go-toml/v2"
+
+	"github.com/ternarybob/gitsync/internal/common"
+)
+
+// runPrintConfig prints the effective configuration with secrets redacted
+func runPrintConfig(cfg *common.Config, asJSON bool) error {
+	effective := cfg.Effective()
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.SetEscapeHTML(false)
+		return encoder.Encode(effective)
+	}
+
+	encoder := toml.NewEncoder(os.Stdout)
+	encoder.SetIndentTables(true)
+	return encoder.Encode(effective)
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ternarybob/gitsync/internal/common"
+	"github.com/ternarybob/gitsync/internal/services"
+)
+
+// exitForcedShutdown reports that shutdown_grace_period ran out and running
+// jobs were cancelled
+const exitForcedShutdown = 3
+
+// serve runs the daemon: the initial sync, the scheduler and the HTTP server,
+// until SIGINT or SIGTERM, or until Windows stops the service it runs as. It
+// returns the exit code.
+func serve(configFlag string, skipInitial bool, logOpts *logOptions) int {
+	if runningAsService() {
+		return runService(defaultServiceName, func(stop <-chan struct{}) int {
+			return serveUntil(configFlag, skipInitial, logOpts, stop)
+		})
+	}
+	return serveUntil(configFlag, skipInitial, logOpts, nil)
+}
+
+// serveUntil serves until a signal or until stop is closed, which shuts down
+// the same way as SIGTERM
+func serveUntil(configFlag string, skipInitial bool, logOpts *logOptions, stop <-chan struct{}) int {
+	cfg, configPath := loadConfig(configFlag, 1)
+	logger := startLogging(cfg, configPath, logOpts, true, 1)
+	resources := openSyncResources(cfg, 1)
+
+	// The configuration as loaded is kept, discovered jobs are added to it again
+	// on every refresh and reload
+	loaded := cfg
+	discovery := services.NewDiscovery()
+	cfg = discoverJobs(discovery, loaded)
+	resolveTokenSecrets(cfg)
+
+	sched := services.NewScheduler(cfg, resources.store)
+
+	// The server comes up before the initial sync so liveness probes pass while
+	// it runs; readiness follows once the scheduler has started
+	var server *services.Server
+	if cfg.Server.Enabled {
+		server = services.NewServer(cfg, sched, resources.store)
+		if err := server.Start(); err != nil {
+			logger.Error().Err(err).Msg("Failed to start HTTP server")
+			resources.close()
+			return 1
+		}
+	}
+
+	// Registered before the initial sync, so an early SIGHUP waits for the
+	// scheduler instead of terminating the process
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	if stop != nil {
+		go func() {
+			<-stop
+			quit <- syscall.SIGTERM
+		}()
+	}
+
+	// Run enabled jobs once at startup, unless run_on_startup opts them out.
+	// A signal meanwhile shuts down like it does once the scheduler runs.
+	interrupted := false
+	if skipInitial {
+		logger.Info().Msg("Skipping initial sync, jobs first run on their schedule")
+	} else {
+		logger.Info().Msg("Running initial sync for all enabled jobs...")
+		initialDone := make(chan struct{})
+		go func() {
+			runInitialJobs(sched, cfg)
+			close(initialDone)
+		}()
+		select {
+		case <-initialDone:
+		case <-quit:
+			interrupted = true
+		}
+	}
+
+	if !interrupted {
+		if err := sched.Start(); err != nil {
+			logger.Fatal().Err(err).Msg("Failed to start scheduler")
+		}
+
+		// A nil channel never fires, leaving the loop to signals
+		var configChanged <-chan struct{}
+		watchCtx, stopWatch := context.WithCancel(context.Background())
+		if cfg.Service.WatchConfig {
+			var err error
+			configChanged, err = common.WatchConfig(watchCtx, configPath)
+			if err != nil {
+				logger.Error().Str("config", configPath).Err(err).Msg("Failed to watch configuration file, reload with SIGHUP instead")
+			} else {
+				logger.Info().Str("config", configPath).Msg("Watching configuration file for changes")
+			}
+		}
+
+		// Each discovery job lists its repositories again once its refresh passed
+		discoveryTicker := time.NewTicker(time.Minute)
+
+		for running := true; running; {
+			select {
+			case <-reload:
+				loaded = reloadConfig(sched, discovery, loaded, configPath, logOpts)
+			case <-configChanged:
+				logger.Info().Str("config", configPath).Msg("Configuration file changed")
+				loaded = reloadConfig(sched, discovery, loaded, configPath, logOpts)
+			case <-discoveryTicker.C:
+				refreshDiscovery(sched, discovery, loaded)
+			case <-quit:
+				running = false
+			}
+		}
+		discoveryTicker.Stop()
+		stopWatch()
+	}
+
+	logger.Info().Msg("Shutting down GitSync...")
+	if server != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		server.Stop(ctx)
+		cancel()
+	}
+	// Running jobs get the grace period to finish, new runs no longer start
+	clean := sched.Stop(sched.Config().Service.ShutdownGracePeriod)
+	resources.close()
+	if !clean {
+		logger.Warn().Msg("Shutdown forced, running jobs were cancelled")
+		return exitForcedShutdown
+	}
+	logger.Info().Msg("Shutdown complete")
+	return 0
+}
+
+// reloadConfig loads the configuration file again and applies its jobs, and
+// those its discovery jobs find, to the scheduler. It returns the configuration
+// as loaded, or loaded when the file is invalid and the running configuration
+// is kept. The logging overrides apply to the reloaded file as they did at
+// startup.
+func reloadConfig(sched *services.Scheduler, discovery *services.Discovery, loaded *common.Config, path string, logOpts *logOptions) *common.Config {
+	logger := common.GetLogger()
+	logger.Info().Str("config", path).Msg("Reloading configuration")
+
+	cfg, err := common.Load(path)
+	if err != nil {
+		logger.Error().Str("config", path).Err(err).Msg("Configuration reload rejected, keeping the running configuration")
+		return loaded
+	}
+	logOpts.apply(&cfg.Logging)
+
+	// Discovery jobs that are new or whose discover settings changed list their repositories now
+	_, errs := discovery.Refresh(context.Background(), cfg, false)
+	logDiscoveryErrors(errs)
+
+	discovered := discoveredConfig(discovery, cfg)
+	result, err := sched.Reload(discovered)
+	if err != nil {
+		logger.Error().Str("config", path).Err(err).Msg("Configuration reload rejected, keeping the running configuration")
+		return loaded
+	}
+	resolveTokenSecrets(discovered)
+
+	for _, warning := range cfg.Warnings {
+		logger.Warn().Msg(warning)
+	}
+	if len(result.RestartRequired) > 0 {
+		logger.Warn().Str("sections", strings.Join(result.RestartRequired, ", ")).Msg("Configuration changes that need a restart were not applied")
+	}
+
+	logger.Info().Str("added", strings.Join(result.Added, ", ")).Str("removed", strings.Join(result.Removed, ", ")).
+		Str("changed", strings.Join(result.Changed, ", ")).Msg("Configuration reloaded")
+	return cfg
+}
+
+// resolveTokenSecrets reads the tokens jobs take from a secrets backend when
+// the configuration is loaded, so one that cannot be read is reported before
+// the job runs. Runs read them again once the backend's cache expires.
+func resolveTokenSecrets(cfg *common.Config) {
+	logger := common.GetLogger()
+	for jobName, err := range services.ResolveTokenSecrets(context.Background(), cfg) {
+		logger.Error().Str("job", jobName).Err(err).Msg("Failed to read git token, the job fails until it can be read")
+	}
+}
+
+func runInitialJobs(sched *services.Scheduler, cfg *common.Config) {
+	logger := common.GetLogger()
+
+	var enabledJobs []string
+	for _, jobName := range cfg.GetEnabledJobs() {
+		if cfg.JobRunsOnStartup(jobName) {
+			enabledJobs = append(enabledJobs, jobName)
+		} else {
+			logger.Info().Str("job", jobName).Msg("run_on_startup is off, skipping initial sync for job")
+		}
+	}
+	if len(enabledJobs) == 0 {
+		logger.Info().Msg("No enabled jobs run on startup, skipping initial sync")
+		return
+	}
+
+	sched.SetInitialSync(true)
+	defer sched.SetInitialSync(false)
+
+	var successCount, errorCount int
+	var mu sync.Mutex
+	logger.Info().Int("job_count", len(enabledJobs)).Int("parallel", parallelJobs(cfg)).Msg("Starting initial sync for enabled jobs")
+
+	forEachJob(cfg, enabledJobs, func(jobName string, dependencyErr error) error {
+		err := dependencyErr
+		if err == nil {
+			logger.Info().Str("job", jobName).Msg(common.Emoji("🔄") + "Running initial sync for job")
+			_, err = sched.RunJobNow(jobName, false)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			errorCount++
+			logger.Error().Str("job", jobName).Err(err).Msg(common.Emoji("❌") + "INITIAL SYNC FAILED for job")
+		} else {
+			successCount++
+			logger.Info().Str("job", jobName).Msg(common.Emoji("✅") + "Initial sync completed successfully for job")
+		}
+		return err
+	})
+
+	logger.Info().Int("successful", successCount).Int("failed", errorCount).Int("total", len(enabledJobs)).Msg("Initial sync summary")
+
+	if errorCount > 0 {
+		logger.Error().Int("failed_count", errorCount).Msg(common.Emoji("⚠️ ") + "WARNING: Jobs failed during initial sync - check configuration and connectivity")
+	} else {
+		logger.Info().Msg(common.Emoji("🎉") + "All initial sync jobs completed successfully")
+	}
+}
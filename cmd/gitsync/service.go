@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// defaultServiceName is the name gitsync is registered and runs under as a
+// Windows service
+const defaultServiceName = "gitsync"
+
+// serviceInstall registers the Windows service after checking the
+// configuration it will serve with loads
+func serviceInstall(configFlag, name string) int {
+	_, configPath := loadConfig(configFlag, 1)
+	// The service starts in the system directory, a relative path would not resolve
+	absPath, err := filepath.Abs(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to install service: %v\n", err)
+		return 1
+	}
+	if err := installService(name, absPath); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to install service: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Service %s installed with configuration %s\n", name, absPath)
+	fmt.Printf("Start it with: sc.exe start %s\n", name)
+	return 0
+}
+
+func serviceUninstall(name string) int {
+	if err := uninstallService(name); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to uninstall service: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Service %s removed\n", name)
+	return 0
+}
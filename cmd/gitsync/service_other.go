@@ -0,0 +1,25 @@
+//go:build !windows
+
+package main
+
+import "errors"
+
+var errServiceUnsupported = errors.New("services can only be installed on Windows, use systemd or launchd on this platform")
+
+// runningAsService is always false outside Windows
+func runningAsService() bool {
+	return false
+}
+
+// runService serves until a signal, there is no service control manager
+func runService(_ string, serve func(stop <-chan struct{}) int) int {
+	return serve(nil)
+}
+
+func installService(string, string) error {
+	return errServiceUnsupported
+}
+
+func uninstallService(string) error {
+	return errServiceUnsupported
+}
@@ -0,0 +1,143 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// serviceStopTimeout bounds waiting for a running service to stop before it
+// is removed
+const serviceStopTimeout = 2 * time.Minute
+
+// runningAsService reports whether the service control manager started
+// gitsync, rather than a console
+func runningAsService() bool {
+	isService, err := svc.IsWindowsService()
+	return err == nil && isService
+}
+
+// runService serves as the Windows service name until the service control
+// manager stops it. Stop and Shutdown requests close stop, which shuts down
+// like SIGTERM does.
+func runService(name string, serve func(stop <-chan struct{}) int) int {
+	handler := &serviceHandler{serve: serve}
+	if err := svc.Run(name, handler); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to run as service %s: %v\n", name, err)
+		return 1
+	}
+	return handler.exitCode
+}
+
+type serviceHandler struct {
+	serve    func(stop <-chan struct{}) int
+	exitCode int
+}
+
+func (h *serviceHandler) Execute(_ []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+
+	stop := make(chan struct{})
+	var stopOnce sync.Once
+	done := make(chan int, 1)
+	go func() {
+		done <- h.serve(stop)
+	}()
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for {
+		select {
+		case h.exitCode = <-done:
+			status <- svc.Status{State: svc.Stopped}
+			// A code of its own, such as a forced shutdown, is reported as service specific
+			return h.exitCode != 0, uint32(h.exitCode)
+		case request := <-requests:
+			switch request.Cmd {
+			case svc.Interrogate:
+				status <- request.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				stopOnce.Do(func() { close(stop) })
+			}
+		}
+	}
+}
+
+// installService registers gitsync as an automatically started service that
+// serves with configPath, restarted by Windows when it crashes
+func installService(name, configPath string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to get executable path: %w", err)
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service control manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	if s, err := m.OpenService(name); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s already exists", name)
+	}
+	s, err := m.CreateService(name, exe, mgr.Config{
+		DisplayName: "GitSync (" + name + ")",
+		Description: "Synchronizes git repositories on a schedule",
+		StartType:   mgr.StartAutomatic,
+	}, "serve", "-config", configPath)
+	if err != nil {
+		return fmt.Errorf("failed to create service %s: %w", name, err)
+	}
+	defer s.Close()
+
+	recovery := []mgr.RecoveryAction{
+		{Type: mgr.ServiceRestart, Delay: 10 * time.Second},
+		{Type: mgr.ServiceRestart, Delay: time.Minute},
+		{Type: mgr.NoAction},
+	}
+	if err := s.SetRecoveryActions(recovery, uint32((24 * time.Hour).Seconds())); err != nil {
+		return fmt.Errorf("failed to set recovery actions of service %s: %w", name, err)
+	}
+	return nil
+}
+
+// uninstallService stops the service when it runs and removes it
+func uninstallService(name string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service control manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed", name)
+	}
+	defer s.Close()
+
+	if status, err := s.Query(); err == nil && status.State != svc.Stopped {
+		if _, err := s.Control(svc.Stop); err != nil {
+			return fmt.Errorf("failed to stop service %s: %w", name, err)
+		}
+		deadline := time.Now().Add(serviceStopTimeout)
+		for status.State != svc.Stopped && time.Now().Before(deadline) {
+			time.Sleep(time.Second)
+			if status, err = s.Query(); err != nil {
+				return fmt.Errorf("failed to query service %s: %w", name, err)
+			}
+		}
+		if status.State != svc.Stopped {
+			return fmt.Errorf("service %s did not stop within %s", name, serviceStopTimeout)
+		}
+	}
+	if err := s.Delete(); err != nil {
+		return fmt.Errorf("failed to remove service %s: %w", name, err)
+	}
+	return nil
+}
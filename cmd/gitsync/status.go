@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ternarybob/gitsync/internal/common"
+	"github.com/ternarybob/gitsync/internal/services"
+	"github.com/ternarybob/gitsync/internal/store"
+)
+
+// daemonTimeout bounds the request asking a running gitsync for job status
+const daemonTimeout = 3 * time.Second
+
+// jobStatusRow is one job printed by gitsync status. Running, its progress and
+// the previous run are only known when a running gitsync answered.
+type jobStatusRow struct {
+	Name       string                `json:"name"`
+	Enabled    bool                  `json:"enabled"`
+	Schedule   string                `json:"schedule"`
+	Timezone   string                `json:"timezone,omitempty"`
+	NextRun    *time.Time            `json:"next_run,omitempty"`
+	PrevRun    *time.Time            `json:"prev_run,omitempty"`
+	LastResult string                `json:"last_result,omitempty"` // Status of the latest transaction
+	LastRun    *time.Time            `json:"last_run,omitempty"`
+	Running    *bool                 `json:"running,omitempty"`
+	Progress   *services.GitProgress `json:"progress,omitempty"` // Of a running clone, fetch or push with git_progress
+}
+
+// jobStatusReport is the gitsync status output, From tells whether the daemon
+// answered ("daemon") or the schedules were computed from the configuration
+type jobStatusReport struct {
+	From string         `json:"from"`
+	Jobs []jobStatusRow `json:"jobs"`
+}
+
+// runJobStatus prints the schedule state of all jobs, or of jobName when set.
+// It asks the running gitsync through its job API and falls back to computing
+// the next runs from the configuration.
+func runJobStatus(cfg *common.Config, jobName string, asJSON bool) error {
+	jobNames := cfg.Jobs.Names
+	if jobName != "" {
+		if _, exists := cfg.GetJobConfig(jobName); !exists {
+			return fmt.Errorf("job not found: %s", jobName)
+		}
+		jobNames = []string{jobName}
+	}
+
+	report := jobStatusReport{From: "daemon"}
+	rows, err := daemonJobStatus(cfg, jobNames)
+	if err != nil {
+		if cfg.Server.Enabled {
+			fmt.Fprintf(os.Stderr, "No answer from gitsync at %s: %v\n", cfg.Server.Listen, err)
+		}
+		report.From = "config"
+		rows = configJobStatus(cfg, jobNames)
+	}
+	addLastResults(cfg, rows)
+	report.Jobs = rows
+
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "JOB\tENABLED\tSCHEDULE\tNEXT RUN\tPREVIOUS RUN\tLAST RESULT\tRUNNING")
+	for _, row := range rows {
+		running := "-"
+		if row.Running != nil {
+			running = "no"
+			if *row.Running {
+				running = "yes"
+			}
+			if progress := row.Progress; progress != nil {
+				running = fmt.Sprintf("yes, %s %s %d%%", progress.Operation, strings.ToLower(progress.Phase), progress.Percent)
+			}
+		}
+		lastResult := "-"
+		if row.LastResult != "" {
+			lastResult = row.LastResult + " " + formatStatusTime(row.LastRun)
+		}
+		fmt.Fprintf(w, "%s\t%t\t%s\t%s\t%s\t%s\t%s\n", row.Name, row.Enabled, row.Schedule,
+			formatStatusTime(row.NextRun), formatStatusTime(row.PrevRun), lastResult, running)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if report.From == "config" {
+		fmt.Println("\nNext runs computed from the configuration, gitsync is not running or its server is disabled")
+	}
+	return nil
+}
+
+// daemonJobStatus reads the jobs from the job API of a running gitsync
+func daemonJobStatus(cfg *common.Config, jobNames []string) ([]jobStatusRow, error) {
+	if !cfg.Server.Enabled {
+		return nil, fmt.Errorf("server is disabled")
+	}
+
+	host, port, err := net.SplitHostPort(cfg.Server.Listen)
+	if err != nil {
+		return nil, err
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "http://"+net.JoinHostPort(host, port)+"/api/jobs", nil)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Server.APIToken != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.Server.APIToken)
+	}
+
+	client := &http.Client{Timeout: daemonTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("job API answered %s", resp.Status)
+	}
+
+	var jobs []struct {
+		jobStatusRow
+		Running bool `json:"running"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&jobs); err != nil {
+		return nil, fmt.Errorf("failed to decode job API response: %w", err)
+	}
+
+	byName := make(map[string]jobStatusRow, len(jobs))
+	for _, job := range jobs {
+		row := job.jobStatusRow
+		running := job.Running
+		row.Running = &running
+		byName[row.Name] = row
+	}
+
+	rows := make([]jobStatusRow, 0, len(jobNames))
+	for _, jobName := range jobNames {
+		// Jobs the daemon does not know were added to the file since it loaded it
+		row, exists := byName[jobName]
+		if !exists {
+			row = configJobStatus(cfg, []string{jobName})[0]
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// configJobStatus computes the next run of each enabled job from its schedule
+func configJobStatus(cfg *common.Config, jobNames []string) []jobStatusRow {
+	now := time.Now()
+	rows := make([]jobStatusRow, 0, len(jobNames))
+	for _, jobName := range jobNames {
+		jobConfig, _ := cfg.GetJobConfig(jobName)
+		row := jobStatusRow{
+			Name:     jobName,
+			Enabled:  jobConfig.Enabled,
+			Schedule: cfg.JobSchedule(jobName),
+			Timezone: cfg.JobTimezone(jobName),
+		}
+		if jobConfig.Enabled {
+			if next, err := cfg.JobNextRun(jobName, now); err == nil {
+				row.NextRun = &next
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// addLastResults fills in the latest transaction of each job from the store,
+// leaving the rows as they are when there is no store yet
+func addLastResults(cfg *common.Config, rows []jobStatusRow) {
+	if cfg.Store.Path == "" {
+		return
+	}
+	if _, err := os.Stat(cfg.Store.Path); err != nil {
+		return
+	}
+
+	st, err := store.Open(cfg.Store.Path, cfg.Store.BucketName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read last results: %v\n", err)
+		return
+	}
+	defer st.Close()
+
+	for i := range rows {
+		transactions, err := st.GetTransactionsByJob(rows[i].Name, 1)
+		if err != nil || len(transactions) == 0 {
+			continue
+		}
+		last := transactions[0]
+		rows[i].LastResult = last.Status
+		rows[i].LastRun = &last.StartTime
+	}
+}
+
+func formatStatusTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return "-"
+	}
+	return t.Format("2006-01-02 15:04:05 MST")
+}
@@ -0,0 +1,72 @@
+package common
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultAWSRefreshInterval is how long a secret read from Secrets Manager or
+// Parameter Store is used before it is read again
+const DefaultAWSRefreshInterval = 15 * time.Minute
+
+// AWSConfig is the [aws] table, how jobs read git_token_secret_arn and
+// git_token_ssm_param. Credentials come from the default AWS chain:
+// environment, web identity, shared credentials file, ECS task role and EC2
+// instance role.
+type AWSConfig struct {
+	Region          string        `toml:"region"`           // AWS_REGION or AWS_DEFAULT_REGION when empty, ARNs name their own
+	RefreshInterval time.Duration `toml:"refresh_interval"` // How long a secret is used before it is read again
+}
+
+// newAWSConfig returns the settings of a missing [aws] table
+func newAWSConfig() *AWSConfig {
+	return &AWSConfig{RefreshInterval: DefaultAWSRefreshInterval}
+}
+
+func parseAWSConfig(awsMap map[string]interface{}) *AWSConfig {
+	return &AWSConfig{
+		Region:          getString(awsMap, "region", ""),
+		RefreshInterval: getDuration(awsMap, "refresh_interval", DefaultAWSRefreshInterval),
+	}
+}
+
+// applyEnvOverrides fills the region from the environment the way the AWS
+// CLI does
+func (a *AWSConfig) applyEnvOverrides() {
+	if a.Region == "" {
+		a.Region = os.Getenv("AWS_REGION")
+	}
+	if a.Region == "" {
+		a.Region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+}
+
+func (a *AWSConfig) validate() error {
+	if a == nil {
+		return nil
+	}
+	if a.RefreshInterval <= 0 {
+		return fmt.Errorf("refresh_interval must be a positive duration such as \"15m\"")
+	}
+	return nil
+}
+
+// RegionOf returns the region a secret or parameter is read from, the one in
+// its ARN or else the configured one
+func (a *AWSConfig) RegionOf(id string) string {
+	// arn:partition:service:region:account:resource
+	if fields := strings.SplitN(id, ":", 6); len(fields) == 6 && fields[0] == "arn" && fields[3] != "" {
+		return fields[3]
+	}
+	return a.Region
+}
+
+// AWSSecretRef splits a git_token_secret_arn such as
+// arn:aws:secretsmanager:eu-west-1:123456789012:secret:gitsync#token into the
+// secret and the key of a JSON secret, empty when the whole value is the token
+func AWSSecretRef(ref string) (secretID, key string) {
+	secretID, key, _ = strings.Cut(ref, "#")
+	return secretID, key
+}
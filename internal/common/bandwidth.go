@@ -0,0 +1,75 @@
+package common
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Bandwidth is a transfer rate in bytes per second, 0 is unlimited
+type Bandwidth int64
+
+// bandwidthUnits are the units a max_bandwidth value may carry, bits are
+// accepted because uplinks are sold in them
+var bandwidthUnits = map[string]float64{
+	"":     1,
+	"b":    1,
+	"kb":   1000,
+	"mb":   1000 * 1000,
+	"gb":   1000 * 1000 * 1000,
+	"kib":  1024,
+	"mib":  1024 * 1024,
+	"gib":  1024 * 1024 * 1024,
+	"kbit": 1000 / 8,
+	"mbit": 1000 * 1000 / 8,
+	"gbit": 1000 * 1000 * 1000 / 8,
+}
+
+// ParseBandwidth reads a rate such as "10MB", "512KiB/s" or "20Mbit", a
+// number without unit being bytes per second
+func ParseBandwidth(value string) (Bandwidth, error) {
+	text := strings.ToLower(strings.TrimSpace(value))
+	text = strings.TrimSpace(strings.TrimSuffix(text, "/s"))
+	split := strings.IndexFunc(text, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if split < 0 {
+		split = len(text)
+	}
+	number, err := strconv.ParseFloat(text[:split], 64)
+	unit, ok := bandwidthUnits[strings.TrimSpace(text[split:])]
+	if err != nil || !ok || number <= 0 {
+		return 0, fmt.Errorf("invalid bandwidth %q, expected a rate such as \"10MB\" or \"20Mbit\"", value)
+	}
+	return Bandwidth(number * unit), nil
+}
+
+// getBandwidth reads a rate given as a string with a unit or as a plain
+// number of bytes per second. Invalid values are kept as -1 for validation
+// to report.
+func getBandwidth(m map[string]interface{}, key string, defaultValue Bandwidth) Bandwidth {
+	switch v := m[key].(type) {
+	case string:
+		bandwidth, err := ParseBandwidth(v)
+		if err != nil {
+			return -1
+		}
+		return bandwidth
+	case int64:
+		return Bandwidth(v)
+	case float64:
+		return Bandwidth(v)
+	}
+	return defaultValue
+}
+
+// String formats the rate with the largest decimal unit below it
+func (b Bandwidth) String() string {
+	switch {
+	case b >= 1000*1000*1000:
+		return fmt.Sprintf("%.1f GB/s", float64(b)/(1000*1000*1000))
+	case b >= 1000*1000:
+		return fmt.Sprintf("%.1f MB/s", float64(b)/(1000*1000))
+	case b >= 1000:
+		return fmt.Sprintf("%.1f KB/s", float64(b)/1000)
+	}
+	return fmt.Sprintf("%d B/s", int64(b))
+}
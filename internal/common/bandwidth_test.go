@@ -0,0 +1,59 @@
+package common
+
+import "testing"
+
+func TestParseBandwidth(t *testing.T) {
+	tests := []struct {
+		value string
+		want  Bandwidth
+	}{
+		{"1000", 1000},
+		{"10MB", 10 * 1000 * 1000},
+		{"512 KiB/s", 512 * 1024},
+		{"1.5mb", 1500 * 1000},
+		{"20Mbit", 20 * 1000 * 1000 / 8},
+		{"1GiB/s", 1024 * 1024 * 1024},
+	}
+	for _, test := range tests {
+		got, err := ParseBandwidth(test.value)
+		if err != nil || got != test.want {
+			t.Errorf("ParseBandwidth(%q) = %d, %v, want %d", test.value, got, err, test.want)
+		}
+	}
+
+	for _, value := range []string{"", "fast", "10 parsecs", "0MB", "-5MB", "MB"} {
+		if got, err := ParseBandwidth(value); err == nil {
+			t.Errorf("ParseBandwidth(%q) = %d, want an error", value, got)
+		}
+	}
+}
+
+func TestGetBandwidth(t *testing.T) {
+	m := map[string]interface{}{"text": "2MB", "bytes": int64(4096), "broken": "2 megs"}
+	if got := getBandwidth(m, "text", 0); got != 2*1000*1000 {
+		t.Errorf("string = %d, want 2MB", got)
+	}
+	if got := getBandwidth(m, "bytes", 0); got != 4096 {
+		t.Errorf("int = %d, want 4096", got)
+	}
+	if got := getBandwidth(m, "broken", 0); got != -1 {
+		t.Errorf("unparsable = %d, want -1 for validation to report", got)
+	}
+	if got := getBandwidth(m, "missing", 7); got != 7 {
+		t.Errorf("missing = %d, want the default", got)
+	}
+}
+
+func TestBandwidthString(t *testing.T) {
+	tests := map[Bandwidth]string{
+		500:              "500 B/s",
+		1500:             "1.5 KB/s",
+		10 * 1000 * 1000: "10.0 MB/s",
+		2500000000:       "2.5 GB/s",
+	}
+	for bandwidth, want := range tests {
+		if got := bandwidth.String(); got != want {
+			t.Errorf("%d.String() = %q, want %q", int64(bandwidth), got, want)
+		}
+	}
+}
@@ -15,28 +15,64 @@ const (
 	Reset  = "\033[0m"
 )
 
-// PrintBanner displays the GitSync banner with clean purple box style
+// bannerStyle holds the box characters and colors of the banner. The plain
+// style is ASCII only, for output that is not a terminal.
+type bannerStyle struct {
+	color, text, reset                 string
+	top, divider, bottom, side, bullet string
+}
+
+var (
+	styledBanner = bannerStyle{
+		color: Purple, text: White, reset: Reset,
+		top:     "┌" + strings.Repeat("─", 80) + "┐",
+		divider: "├" + strings.Repeat("─", 80) + "┤",
+		bottom:  "└" + strings.Repeat("─", 80) + "┘",
+		side:    "│",
+		bullet:  "•",
+	}
+	plainBanner = bannerStyle{
+		top:     "+" + strings.Repeat("-", 80) + "+",
+		divider: "+" + strings.Repeat("-", 80) + "+",
+		bottom:  "+" + strings.Repeat("-", 80) + "+",
+		side:    "|",
+		bullet:  "-",
+	}
+)
+
+// PrintBanner displays the GitSync banner with clean purple box style, or in
+// plain ASCII when output is not styled
 func PrintBanner(serviceName, environment string, jobCount, enabledCount int) {
+	style := plainBanner
+	if Styled() {
+		style = styledBanner
+	}
+
+	line := func(text string) {
+		fmt.Printf("%s%s%s %-78s %s%s%s\n", style.color, style.side, style.text, text, style.color, style.side, style.reset)
+	}
+	rule := func(rule string) {
+		fmt.Printf("%s%s%s\n", style.color, rule, style.reset)
+	}
+
 	fmt.Printf("\n")
-	// Clean banner in purple box style
-	fmt.Printf("%s┌────────────────────────────────────────────────────────────────────────────────┐%s\n", Purple, Reset)
-	fmt.Printf("%s│%s %-78s %s│%s\n", Purple, White, centerText("GITSYNC SERVICE", 78), Purple, Reset)
-	fmt.Printf("%s│%s %-78s %s│%s\n", Purple, White, centerText("Intelligent Git Repository Synchronization Engine", 78), Purple, Reset)
-	fmt.Printf("%s├────────────────────────────────────────────────────────────────────────────────┤%s\n", Purple, Reset)
-	fmt.Printf("%s│%s %-78s %s│%s\n", Purple, White, "Configuration loaded from gitsync.toml", Purple, Reset)
-	fmt.Printf("%s│%s %-78s %s│%s\n", Purple, White, "Git availability verified, scheduler initialized", Purple, Reset)
-	fmt.Printf("%s├────────────────────────────────────────────────────────────────────────────────┤%s\n", Purple, Reset)
-	fmt.Printf("%s│%s %-78s %s│%s\n", Purple, White, fmt.Sprintf("Service: %s", serviceName), Purple, Reset)
-	fmt.Printf("%s│%s %-78s %s│%s\n", Purple, White, fmt.Sprintf("Version: %s", GetVersion()), Purple, Reset)
-	fmt.Printf("%s│%s %-78s %s│%s\n", Purple, White, fmt.Sprintf("Build: %s", GetBuild()), Purple, Reset)
-	fmt.Printf("%s│%s %-78s %s│%s\n", Purple, White, fmt.Sprintf("Environment: %s", environment), Purple, Reset)
-	fmt.Printf("%s│%s %-78s %s│%s\n", Purple, White, fmt.Sprintf("Jobs: %d configured, %d enabled", jobCount, enabledCount), Purple, Reset)
-	fmt.Printf("%s│%s %-78s %s│%s\n", Purple, White, "Mode: Repository Synchronization", Purple, Reset)
-	fmt.Printf("%s└────────────────────────────────────────────────────────────────────────────────┘%s\n", Purple, Reset)
+	rule(style.top)
+	line(centerText("GITSYNC SERVICE", 78))
+	line(centerText("Intelligent Git Repository Synchronization Engine", 78))
+	rule(style.divider)
+	line("Configuration loaded from gitsync.toml")
+	line("Git availability verified, scheduler initialized")
+	rule(style.divider)
+	line(fmt.Sprintf("Service: %s", serviceName))
+	line(fmt.Sprintf("Version: %s", GetVersion()))
+	line(fmt.Sprintf("Build: %s", GetBuild()))
+	line(fmt.Sprintf("Environment: %s", environment))
+	line(fmt.Sprintf("Jobs: %d configured, %d enabled", jobCount, enabledCount))
+	line("Mode: Repository Synchronization")
+	rule(style.bottom)
 	fmt.Printf("\n")
 
-	// Print sync capabilities with emojis
-	printSyncCapabilities()
+	printSyncCapabilities(style.bullet)
 	fmt.Printf("\n")
 }
 
@@ -53,45 +89,51 @@ func centerText(text string, width int) string {
 	return strings.Repeat(" ", leftPad) + text + strings.Repeat(" ", rightPad)
 }
 
-// printSyncCapabilities displays the sync features with emojis
-func printSyncCapabilities() {
+// printSyncCapabilities displays the sync features, with emojis when styled
+func printSyncCapabilities(bullet string) {
 	// Core Features
-	fmt.Printf("🚀 Core Synchronization Features:\n")
-	fmt.Printf("   • Multi-platform repository mirroring (GitHub, GitLab, Bitbucket)\n")
-	fmt.Printf("   • Branch pattern filtering with wildcards (main, feature-*, *-sync)\n")
-	fmt.Printf("   • Bidirectional sync support with separate job configurations\n")
-	fmt.Printf("   • Safe vs force push modes per job (override control)\n")
+	fmt.Printf("%sCore Synchronization Features:\n", Emoji("🚀"))
+	fmt.Printf("   %s Multi-platform repository mirroring (GitHub, GitLab, Bitbucket)\n", bullet)
+	fmt.Printf("   %s Branch pattern filtering with wildcards (main, feature-*, *-sync)\n", bullet)
+	fmt.Printf("   %s Bidirectional sync support with separate job configurations\n", bullet)
+	fmt.Printf("   %s Safe vs force push modes per job (override control)\n", bullet)
 	fmt.Printf("\n")
 
 	// Scheduling & Automation
-	fmt.Printf("⏰ Scheduling & Automation:\n")
-	fmt.Printf("   • Cron-based scheduling with seconds precision\n")
-	fmt.Printf("   • Concurrent job execution with timeout control\n")
-	fmt.Printf("   • Automatic retry on transient failures\n")
-	fmt.Printf("   • Real-time job status tracking\n")
+	fmt.Printf("%sScheduling & Automation:\n", Emoji("⏰"))
+	fmt.Printf("   %s Cron-based scheduling with seconds precision\n", bullet)
+	fmt.Printf("   %s Concurrent job execution with timeout control\n", bullet)
+	fmt.Printf("   %s Automatic retry on transient failures\n", bullet)
+	fmt.Printf("   %s Real-time job status tracking\n", bullet)
 	fmt.Printf("\n")
 
 	// Security & Authentication
-	fmt.Printf("🔐 Security & Authentication:\n")
-	fmt.Printf("   • Token-based authentication (GitHub, GitLab, Bitbucket)\n")
-	fmt.Printf("   • SSH key authentication support\n")
-	fmt.Printf("   • Environment variable substitution for secrets\n")
-	fmt.Printf("   • Per-job authentication configuration\n")
+	fmt.Printf("%sSecurity & Authentication:\n", Emoji("🔐"))
+	fmt.Printf("   %s Token-based authentication (GitHub, GitLab, Bitbucket)\n", bullet)
+	fmt.Printf("   %s SSH key authentication support\n", bullet)
+	fmt.Printf("   %s Environment variable substitution for secrets\n", bullet)
+	fmt.Printf("   %s Per-job authentication configuration\n", bullet)
 	fmt.Printf("\n")
 
 	// Monitoring & Logging
-	fmt.Printf("📊 Monitoring & Logging:\n")
-	fmt.Printf("   • Structured logging with arbor logger\n")
-	fmt.Printf("   • Dual console and file output\n")
-	fmt.Printf("   • Performance metrics and timing data\n")
-	fmt.Printf("   • Comprehensive error tracking\n")
+	fmt.Printf("%sMonitoring & Logging:\n", Emoji("📊"))
+	fmt.Printf("   %s Structured logging with arbor logger\n", bullet)
+	fmt.Printf("   %s Dual console and file output\n", bullet)
+	fmt.Printf("   %s Performance metrics and timing data\n", bullet)
+	fmt.Printf("   %s Comprehensive error tracking\n", bullet)
 	fmt.Printf("\n")
 
-	// Command Line Options
-	fmt.Printf("⚡ Command Line Options:\n")
-	fmt.Printf("   • -config <file>    : Specify configuration file\n")
-	fmt.Printf("   • -validate        : Validate configuration and exit\n")
-	fmt.Printf("   • -run-job <name>  : Run specific job immediately\n")
-	fmt.Printf("   • -version         : Show version information\n")
-	fmt.Printf("   • -stats           : Display sync statistics\n")
+	// Commands
+	fmt.Printf("%sCommands:\n", Emoji("⚡"))
+	fmt.Printf("   %s serve             : Run the scheduler and initial sync (the default)\n", bullet)
+	fmt.Printf("   %s run <jobs>        : Run jobs once and exit (-all, -branch, -target, -json)\n", bullet)
+	fmt.Printf("   %s validate          : Validate configuration and exit (-strict)\n", bullet)
+	fmt.Printf("   %s status [job]      : Show next/previous runs, last result and running state\n", bullet)
+	fmt.Printf("   %s history <job>     : List recent sync transactions of a job\n", bullet)
+	fmt.Printf("   %s export            : Export sync history as CSV or JSON\n", bullet)
+	fmt.Printf("   %s jobs              : List jobs with their resolved sources, targets and credentials\n", bullet)
+	fmt.Printf("   %s config print      : Print the effective configuration, secrets redacted\n", bullet)
+	fmt.Printf("   %s init              : Write a starter configuration\n", bullet)
+	fmt.Printf("   %s doctor            : Check git, credentials, directories and schedules\n", bullet)
+	fmt.Printf("   %s help [command]    : Show the flags of a command\n", bullet)
 }
@@ -2,11 +2,18 @@ package common
 
 import (
 	"fmt"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
 	"strings"
 	"time"
+	"unicode"
 
 	"github.com/pelletier/go-toml/v2"
+	"github.com/robfig/cron/v3"
 )
 
 type Config struct {
@@ -14,17 +21,102 @@ type Config struct {
 	Jobs    JobsConfig    `toml:"jobs"`
 	JobDefs map[string]*JobConfig
 	Logging LoggingConfig `toml:"logging"`
+	Store   StoreConfig   `toml:"store"`
+	Server  ServerConfig  `toml:"server"`
+
+	Notifications NotificationsConfig `toml:"notifications"`
+	Tracing       TracingConfig       `toml:"tracing"`
+	Vault         *VaultConfig        `toml:"vault"`      // Secrets backend of git_token_vault_path, nil when not configured
+	AWS           *AWSConfig          `toml:"aws"`        // Region and refresh of git_token_secret_arn and git_token_ssm_param, nil when not configured
+	RateLimit     RateLimitConfig     `toml:"rate_limit"` // Minimum interval between git operations by host, shared by all jobs
+
+	// Warnings collected during validation, logged once the logger is initialized
+	Warnings []string `toml:"-"`
+	// Keys of the file no setting reads, also listed in Warnings
+	UnknownKeys []string `toml:"-"`
+	// File of the include directory each included job was last defined in
+	JobFiles map[string]string `toml:"-"`
+	// Built from GITSYNC_ variables because there is no configuration file
+	EnvOnly bool `toml:"-"`
 }
 
 type ServiceConfig struct {
-	Name        string `toml:"name"`
-	Environment string `toml:"environment"`
+	Name         string `toml:"name"`
+	Environment  string `toml:"environment"`
+	MaxCacheSize int    `toml:"max_cache_size"` // Cached clone limit in MB, 0 disables
+	SummaryPath  string `toml:"summary_path"`   // JSON run summary written after each run, {job} is replaced by the job name
+	WatchConfig  bool   `toml:"watch_config"`   // Reload when the configuration file changes
+	// How long shutdown waits for running jobs before cancelling them
+	ShutdownGracePeriod time.Duration `toml:"shutdown_grace_period"`
+}
+
+// StoreConfig controls the transaction history database, an empty path disables it
+type StoreConfig struct {
+	Path            string `toml:"path"`
+	BucketName      string `toml:"bucket_name"`
+	MaxTransactions int    `toml:"max_transactions"`
+	RetentionDays   int    `toml:"retention_days"`
+}
+
+// ServerConfig controls the embedded HTTP server for health probes, metrics,
+// the job API and webhooks. It only runs when a [server] section is present and
+// not disabled.
+type ServerConfig struct {
+	Enabled         bool          `toml:"enabled"`
+	Listen          string        `toml:"listen"`
+	APIToken        string        `toml:"api_token"` // Bearer token required by /api, empty leaves it open
+	APITokenEnv     string        `toml:"api_token_env"`
+	GitHubSecret    string        `toml:"github_secret"` // Enables /webhooks/github when set
+	GitHubSecretEnv string        `toml:"github_secret_env"`
+	GitLabSecret    string        `toml:"gitlab_secret"` // Enables /webhooks/gitlab when set
+	GitLabSecretEnv string        `toml:"gitlab_secret_env"`
+	WebhookDebounce time.Duration `toml:"webhook_debounce"` // Wait for further pushes before running a triggered job
+}
+
+// TracingConfig enables OpenTelemetry traces of job runs, exported over
+// OTLP/HTTP. Tracing is off while the endpoint is empty.
+type TracingConfig struct {
+	Endpoint    string  `toml:"endpoint"` // host:port or URL of the OTLP/HTTP collector
+	Insecure    bool    `toml:"insecure"` // Use plain HTTP
+	SampleRatio float64 `toml:"sample_ratio"`
+}
+
+// NotificationsConfig lists where job failures and recoveries are announced
+type NotificationsConfig struct {
+	SlackURLs   []string      `toml:"slack_urls"`   // Slack incoming-webhook URLs
+	WebhookURLs []string      `toml:"webhook_urls"` // Receive a JSON document per notification
+	MinInterval time.Duration `toml:"min_interval"` // Minimum time between failure notifications of a job
+	Email       *EmailConfig  `toml:"email"`        // SMTP delivery, nil when not configured
+}
+
+// EmailConfig describes the SMTP server notifications are mailed through
+type EmailConfig struct {
+	Host        string   `toml:"host"`
+	Port        int      `toml:"port"`
+	TLS         string   `toml:"tls"` // starttls (default), tls or none
+	Username    string   `toml:"username"`
+	Password    string   `toml:"password"`
+	PasswordEnv string   `toml:"password_env"`
+	From        string   `toml:"from"`
+	To          []string `toml:"to"`
 }
 
 type JobsConfig struct {
-	Names    []string      `toml:"names"`
-	Schedule string        `toml:"schedule"`
-	Timeout  time.Duration `toml:"timeout"`
+	Names                  []string      `toml:"names"`
+	Schedule               string        `toml:"schedule"`
+	Every                  time.Duration `toml:"every"`    // Interval alternative to schedule
+	Timezone               string        `toml:"timezone"` // IANA zone of schedules, the local zone when empty
+	Timeout                time.Duration `toml:"timeout"`
+	CloneTimeout           time.Duration `toml:"clone_timeout"`            // Defaults to timeout
+	FetchTimeout           time.Duration `toml:"fetch_timeout"`            // Defaults to a third of timeout
+	PushTimeout            time.Duration `toml:"push_timeout"`             // Defaults to a third of timeout
+	MaxBandwidth           Bandwidth     `toml:"max_bandwidth"`            // Transfer cap of each job, 0 is unlimited
+	MaxConcurrentJobs      int           `toml:"max_concurrent_jobs"`      // Runs executing at once, 0 is unlimited
+	ScheduleJitter         time.Duration `toml:"schedule_jitter"`          // Random delay of scheduled runs, up to this
+	MaxConsecutiveFailures int           `toml:"max_consecutive_failures"` // Pause scheduled runs after this many failures, 0 never pauses
+	FailureCooldown        time.Duration `toml:"failure_cooldown"`         // How long a job stays paused
+	RunOnStartup           bool          `toml:"run_on_startup"`           // Sync enabled jobs once before the scheduler starts
+	IncludeDir             string        `toml:"include_dir"`              // Directory of *.toml job files, <config>.d when empty
 }
 
 type AuthorReplacement struct {
@@ -35,42 +127,218 @@ type AuthorReplacement struct {
 }
 
 type JobConfig struct {
-	Description    string              `toml:"description"`
-	Enabled        bool                `toml:"enabled"`
-	Source         string              `toml:"source"`
-	Targets        []string            `toml:"targets"`
-	Branches       []string            `toml:"branches"`
-	Override       bool                `toml:"override"`
-	GitUsername    string              `toml:"git_username"`
-	GitToken       string              `toml:"git_token"`
-	GitTokenEnv    string              `toml:"git_token_env"`
-	SSHKeyPath     string              `toml:"ssh_key_path"`
-	SSHKeyEnv      string              `toml:"ssh_key_env"`
-	AuthorReplace  []AuthorReplacement `toml:"author_replace"`  // Replace existing commit authors
-	RewriteHistory bool                `toml:"rewrite_history"` // Enable commit rewriting
+	Description       string              `toml:"description"`
+	Enabled           bool                `toml:"enabled"`
+	Schedule          string              `toml:"schedule"`        // Overrides the jobs schedule
+	Every             time.Duration       `toml:"every"`           // Interval alternative to schedule
+	Timezone          string              `toml:"timezone"`        // Overrides the jobs timezone
+	ScheduleJitter    time.Duration       `toml:"schedule_jitter"` // Overrides the jobs schedule_jitter
+	Source            string              `toml:"source"`
+	Sources           []SourceConfig      `toml:"source"` // [[job.source]] tables of an aggregation job, which has no source URL
+	Targets           []TargetConfig      `toml:"targets"`
+	Branches          []string            `toml:"branches"`
+	Override          bool                `toml:"override"`
+	Bidirectional     bool                `toml:"bidirectional"` // Sync the source and its one target both ways, fast-forward only
+	GitUsername       string              `toml:"git_username"`
+	GitToken          string              `toml:"git_token"`
+	GitTokenEnv       string              `toml:"git_token_env"`
+	GitTokenVaultPath string              `toml:"git_token_vault_path"` // Vault secret holding git_token, read when a run starts, such as secret/data/gitsync#token
+	GitTokenSecretARN string              `toml:"git_token_secret_arn"` // AWS Secrets Manager secret holding git_token, #key reads a key of a JSON secret
+	GitTokenSSMParam  string              `toml:"git_token_ssm_param"`  // AWS Systems Manager parameter holding git_token, decrypted
+	SSHKeyPath        string              `toml:"ssh_key_path"`
+	SSHKeyEnv         string              `toml:"ssh_key_env"`
+	AuthorReplace     []AuthorReplacement `toml:"author_replace"`       // Replace existing commit authors
+	RewriteHistory    bool                `toml:"rewrite_history"`      // Enable commit rewriting
+	AddOriginTrailer  bool                `toml:"add_origin_trailer"`   // Append a Mirrored-From trailer to rewritten commits
+	BranchPriority    []string            `toml:"branch_priority"`      // Patterns synced first, in order
+	MaxBranchAge      time.Duration       `toml:"max_branch_age"`       // Skip wildcard-matched branches older than this
+	SyncDelay         time.Duration       `toml:"sync_delay"`           // Only sync commits committed at least this long ago
+	SyncToTagPattern  map[string]string   `toml:"sync_to_tag_pattern"`  // Branch pattern to the tag pattern whose newest tag on the branch is synced
+	StampTagFormat    string              `toml:"stamp_tag_format"`     // Tag pushed to targets after a successful run, such as "sync/{job}/{date}"
+	StampTagAnnotated bool                `toml:"stamp_tag_annotated"`  // Push stamp tags as annotated tags instead of lightweight ones
+	StampTagBranch    string              `toml:"stamp_tag_branch"`     // Branch stamp tags point at, the source default branch when empty
+	StampTagKeep      int                 `toml:"stamp_tag_keep"`       // Stamp tags kept on each target, 0 keeps all
+	RequireSignatures bool                `toml:"require_signatures"`   // Only push commits signed by a trusted key
+	TrustedKeys       []string            `toml:"trusted_keys"`         // GPG or SSH key fingerprints require_signatures trusts
+	AllowedSigners    string              `toml:"allowed_signers_file"` // ssh-keygen allowed signers file verifying SSH signatures
+	Refspecs          []string            `toml:"refspecs"`             // Sync these refspecs instead of branch patterns
+	SyncTags          bool                `toml:"sync_tags"`            // Also push tags to targets
+	SyncWiki          bool                `toml:"sync_wiki"`            // Also sync the <repo>.wiki.git repository of the source to those of the targets
+	IncrementalBundle bool                `toml:"incremental_bundle"`   // Bundle only commits since the previous bundle
+	SharedCache       bool                `toml:"shared_cache"`         // Fetch through one bare mirror of the source, shared with the other jobs setting it
+	AtomicPush        bool                `toml:"atomic_push"`          // Push the branches of a target with --atomic, all or none of them
+	HTTPProxy         string              `toml:"http_proxy"`
+	HTTPSProxy        string              `toml:"https_proxy"`
+	NoProxy           string              `toml:"no_proxy"`
+	CABundlePath      string              `toml:"ca_bundle_path"`
+	TLSSkipVerify     bool                `toml:"tls_skip_verify"`
+	CloneTimeout      time.Duration       `toml:"clone_timeout"`
+	FetchTimeout      time.Duration       `toml:"fetch_timeout"`
+	PushTimeout       time.Duration       `toml:"push_timeout"`
+	MaxBandwidth      Bandwidth           `toml:"max_bandwidth"`     // Cap of the job's clones, fetches and pushes over http(s), defaults to the jobs one
+	VerifyPush        bool                `toml:"verify_push"`       // Always compare with the target instead of trusting the history
+	PreSyncCommand    string              `toml:"pre_sync_command"`  // Runs before any push, a non-zero exit aborts the job
+	PrePushCommand    string              `toml:"pre_push_command"`  // Runs for each branch and range about to be pushed, a non-zero exit blocks the branch
+	PostSyncCommand   string              `toml:"post_sync_command"` // Runs after all pushes
+	HeartbeatURL      string              `toml:"heartbeat_url"`     // Pinged after every run, <url>/fail on failure
+	QueueMissedRun    bool                `toml:"queue_missed_run"`  // Run once after a run that made the schedule skip
+	RunOnStartup      *bool               `toml:"run_on_startup"`    // Overrides the jobs run_on_startup, nil when unset
+	Window            *SyncWindow         `toml:"window"`            // Hours scheduled runs are limited to, nil for any time
+	DependsOn         []string            `toml:"depends_on"`        // Jobs whose run on the same tick has to succeed first
+	GitProgress       bool                `toml:"git_progress"`      // Ask clone and fetch for progress lines, logged at debug level and reported
+	ProgressInterval  time.Duration       `toml:"progress_interval"` // Reported progress is logged at info level at most this often
+	Discover          *DiscoverConfig     `toml:"discover"`          // Makes this a discovery job, nil for a job that syncs
+	Vars              map[string]string   `toml:"vars"`              // Values of {key} placeholders in the source and target URLs
+	Vault             *VaultConfig        `toml:"-"`                 // The [vault] table, handed down to jobs with git_token_vault_path
+	AWS               *AWSConfig          `toml:"-"`                 // The [aws] table or its defaults, handed down to jobs with an AWS secret
+	RateLimit         RateLimitConfig     `toml:"-"`                 // The [rate_limit] table, handed down to every job
+	DiscoveredBy      string              `toml:"-"`                 // Discovery job this job was created for
+}
+
+// SourceConfig is one repository of an aggregation job, given as a
+// [[job.source]] table. Its branches are pushed into the targets under Prefix,
+// its credentials default to the job-level ones.
+type SourceConfig struct {
+	Name        string   `toml:"name"`
+	URL         string   `toml:"url"`
+	Branches    []string `toml:"branches"` // The default branch of the source when empty
+	Prefix      string   `toml:"prefix"`   // Prepended to branch names on targets, "<name>/" when unset
+	GitUsername string   `toml:"git_username"`
+	GitToken    string   `toml:"git_token"`
+	GitTokenEnv string   `toml:"git_token_env"`
+	SSHKeyPath  string   `toml:"ssh_key_path"`
+	SSHKeyEnv   string   `toml:"ssh_key_env"`
+}
+
+// TargetConfig is a push destination. In TOML a target is either a plain URL
+// string or a table carrying per-target options.
+type TargetConfig struct {
+	URL           string `toml:"url"`
+	SSHKeyPath    string `toml:"ssh_key_path"`
+	SSHKeyEnv     string `toml:"ssh_key_env"`
+	HTTPProxy     string `toml:"http_proxy"`
+	HTTPSProxy    string `toml:"https_proxy"`
+	NoProxy       string `toml:"no_proxy"`
+	CABundlePath  string `toml:"ca_bundle_path"`
+	TLSSkipVerify *bool  `toml:"tls_skip_verify"` // Overrides the job's tls_skip_verify, nil when unset
+	Provider      string `toml:"provider"`        // github, gitlab, gitea or forgejo, detected from public hosts when empty
+	APIURL        string `toml:"api_url"`         // Provider API root, derived from an http or https URL when empty
+	CreateRepo    bool   `toml:"create_repo"`     // Create the repository through the provider API when missing
+	DefaultBranch string `toml:"default_branch"`  // Made the repository's default branch after each sync
+	MarkMirror    bool   `toml:"mark_mirror"`     // Describe the repository as a mirror of the source
 }
 
 type LoggingConfig struct {
-	Level      string `toml:"level"`
-	Format     string `toml:"format"`
-	Output     string `toml:"output"`
-	MaxSize    int    `toml:"max_size"`
-	MaxBackups int    `toml:"max_backups"`
+	Level          string `toml:"level"`
+	Format         string `toml:"format"`         // text or json
+	ConsoleFormat  string `toml:"console_format"` // Overrides format for the console, empty follows format
+	Output         string `toml:"output"`         // Comma separated console, file and syslog; both is console and file
+	MaxSize        int    `toml:"max_size"`
+	MaxBackups     int    `toml:"max_backups"`
+	MaxAge         int    `toml:"max_age"`   // Days to keep rotated log files, 0 keeps them regardless of age
+	Directory      string `toml:"directory"` // Log file directory, logs next to the executable when empty
+	NoColor        bool   `toml:"no_color"`  // Plain console output without colors and emoji, like NO_COLOR
+	SyslogFacility string `toml:"syslog_facility"`
+	SyslogTag      string `toml:"syslog_tag"`
+
+	// Raises the level of console lines above Level, set by -quiet rather
+	// than the file
+	ConsoleLevel string `toml:"-"`
+
+	// Sends console lines to stderr, set when stdout carries JSON output
+	ConsoleToStderr bool `toml:"-"`
+}
+
+// outputs returns the destinations listed in Output. stdout is another name
+// for console.
+func (l *LoggingConfig) outputs() map[string]bool {
+	outputs := make(map[string]bool)
+	for _, output := range strings.Split(l.Output, ",") {
+		switch output = strings.TrimSpace(output); output {
+		case "", "both":
+			outputs["console"] = true
+			outputs["file"] = true
+		case "stdout":
+			outputs["console"] = true
+		default:
+			outputs[output] = true
+		}
+	}
+	return outputs
+}
+
+// WritesFile reports whether log lines go to a log file
+func (l *LoggingConfig) WritesFile() bool {
+	return l.outputs()["file"]
+}
+
+// WritesConsole reports whether log lines go to stdout
+func (l *LoggingConfig) WritesConsole() bool {
+	return l.outputs()["console"]
+}
+
+// WritesSyslog reports whether log lines go to the local syslog daemon
+func (l *LoggingConfig) WritesSyslog() bool {
+	return l.outputs()["syslog"]
+}
+
+// LogDirectory returns the directory of the log files
+func (l *LoggingConfig) LogDirectory() (string, error) {
+	if l.Directory != "" {
+		return l.Directory, nil
+	}
+	execPath, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to get executable path: %w", err)
+	}
+	return filepath.Join(filepath.Dir(execPath), "logs"), nil
+}
+
+// ConsoleLogFormat returns the format of console log lines
+func (l *LoggingConfig) ConsoleLogFormat() string {
+	if l.ConsoleFormat != "" {
+		return l.ConsoleFormat
+	}
+	return l.Format
 }
 
+// cronParser accepts the same expressions as the scheduler, which runs cron
+// with a seconds field
+var cronParser = cron.NewParser(cron.Second | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
 func DefaultConfig() *Config {
 	return &Config{
 		Service: ServiceConfig{
-			Name:        "gitsync",
-			Environment: "development",
+			Name:                "gitsync",
+			Environment:         "development",
+			ShutdownGracePeriod: 30 * time.Second,
 		},
 		Jobs: JobsConfig{
-			Names:    []string{},
-			Schedule: "",
-			Timeout:  5 * time.Minute,
+			Names:           []string{},
+			Schedule:        "",
+			Timeout:         5 * time.Minute,
+			FailureCooldown: time.Hour,
+			RunOnStartup:    true,
+		},
+		JobDefs:  make(map[string]*JobConfig),
+		JobFiles: make(map[string]string),
+		Logging:  *DefaultLoggingConfig(),
+		Store: StoreConfig{
+			Path:            "./data/gitsync.db",
+			BucketName:      "sync_transactions",
+			MaxTransactions: 10000,
+			RetentionDays:   30,
+		},
+		Server: ServerConfig{
+			Listen:          ":8080",
+			WebhookDebounce: 10 * time.Second,
+		},
+		Notifications: NotificationsConfig{
+			MinInterval: time.Hour,
+		},
+		Tracing: TracingConfig{
+			SampleRatio: 1,
 		},
-		JobDefs: make(map[string]*JobConfig),
-		Logging: *DefaultLoggingConfig(),
 	}
 }
 
@@ -84,26 +352,58 @@ func Load(filename string) (*Config, error) {
 				return nil, fmt.Errorf("failed to read config file %s: %w", filename, err)
 			}
 
-			content := os.ExpandEnv(string(data))
+			content := os.Expand(string(data), expandConfigVar)
 
 			var rawConfig map[string]interface{}
 			if err := toml.Unmarshal([]byte(content), &rawConfig); err != nil {
 				return nil, fmt.Errorf("failed to parse config file %s: %w", filename, err)
 			}
 
+			if err := mergeIncludes(rawConfig, filename, config); err != nil {
+				return nil, err
+			}
+
 			if err := parseConfig(rawConfig, config); err != nil {
 				return nil, fmt.Errorf("failed to process config: %w", err)
 			}
+			config.UnknownKeys = unknownKeys(rawConfig, config.Jobs.Names)
+			config.Warnings = append(config.Warnings, config.UnknownKeys...)
+		} else if EnvConfigured() {
+			rawConfig, err := envRawConfig()
+			if err != nil {
+				return nil, fmt.Errorf("invalid environment configuration: %w", err)
+			}
+			if err := parseConfig(rawConfig, config); err != nil {
+				return nil, fmt.Errorf("failed to process environment configuration: %w", err)
+			}
+			config.EnvOnly = true
 		}
 	}
 
 	applyEnvOverrides(config)
 
-	// Apply environment variables to credentials
+	// Apply environment variables to credentials, secrets backends are read when a job runs
+	awsConfig := config.AWS
+	if awsConfig == nil {
+		awsConfig = newAWSConfig()
+		awsConfig.applyEnvOverrides()
+	}
 	for _, jobConfig := range config.JobDefs {
 		applyJobEnvOverrides(jobConfig)
+		if jobConfig.GitTokenVaultPath != "" {
+			jobConfig.Vault = config.Vault
+		}
+		if jobConfig.GitTokenSecretARN != "" || jobConfig.GitTokenSSMParam != "" {
+			jobConfig.AWS = awsConfig
+		}
+		jobConfig.RateLimit = config.RateLimit
+		if jobConfig.MaxBandwidth == 0 {
+			jobConfig.MaxBandwidth = config.Jobs.MaxBandwidth
+		}
 	}
 
+	applyPhaseTimeouts(config)
+
 	if err := config.Validate(); err != nil {
 		return nil, fmt.Errorf("invalid configuration: %w", err)
 	}
@@ -111,6 +411,18 @@ func Load(filename string) (*Config, error) {
 	return config, nil
 }
 
+// expandConfigVar resolves a variable referenced in the config file. Unset
+// GITSYNC_ variables are kept, hooks receive them when they run.
+func expandConfigVar(name string) string {
+	if value, ok := os.LookupEnv(name); ok {
+		return value
+	}
+	if strings.HasPrefix(name, "GITSYNC_") {
+		return "${" + name + "}"
+	}
+	return ""
+}
+
 func applyJobEnvOverrides(jobConfig *JobConfig) {
 	if jobConfig.GitTokenEnv != "" {
 		jobConfig.GitToken = os.Getenv(jobConfig.GitTokenEnv)
@@ -118,15 +430,97 @@ func applyJobEnvOverrides(jobConfig *JobConfig) {
 	if jobConfig.SSHKeyEnv != "" {
 		jobConfig.SSHKeyPath = os.Getenv(jobConfig.SSHKeyEnv)
 	}
+	for i := range jobConfig.Targets {
+		if jobConfig.Targets[i].SSHKeyEnv != "" {
+			jobConfig.Targets[i].SSHKeyPath = os.Getenv(jobConfig.Targets[i].SSHKeyEnv)
+		}
+	}
+	if jobConfig.Discover != nil && jobConfig.Discover.TokenEnv != "" {
+		jobConfig.Discover.Token = os.Getenv(jobConfig.Discover.TokenEnv)
+	}
+	for i := range jobConfig.Sources {
+		if jobConfig.Sources[i].GitTokenEnv != "" {
+			jobConfig.Sources[i].GitToken = os.Getenv(jobConfig.Sources[i].GitTokenEnv)
+		}
+		if jobConfig.Sources[i].SSHKeyEnv != "" {
+			jobConfig.Sources[i].SSHKeyPath = os.Getenv(jobConfig.Sources[i].SSHKeyEnv)
+		}
+	}
+}
+
+// applyPhaseTimeouts derives unset clone, fetch and push timeouts from the job
+// timeout and hands the global values down to jobs without their own
+func applyPhaseTimeouts(config *Config) {
+	if config.Jobs.CloneTimeout == 0 {
+		config.Jobs.CloneTimeout = config.Jobs.Timeout
+	}
+	if config.Jobs.FetchTimeout == 0 {
+		config.Jobs.FetchTimeout = config.Jobs.Timeout / 3
+	}
+	if config.Jobs.PushTimeout == 0 {
+		config.Jobs.PushTimeout = config.Jobs.Timeout / 3
+	}
+
+	for _, jobConfig := range config.JobDefs {
+		if jobConfig.CloneTimeout == 0 {
+			jobConfig.CloneTimeout = config.Jobs.CloneTimeout
+		}
+		if jobConfig.FetchTimeout == 0 {
+			jobConfig.FetchTimeout = config.Jobs.FetchTimeout
+		}
+		if jobConfig.PushTimeout == 0 {
+			jobConfig.PushTimeout = config.Jobs.PushTimeout
+		}
+	}
+}
+
+// migrateJobsArray rewrites jobs of the old [[jobs]] layout, an array of
+// tables each naming its job, into [jobs] names and a [<name>] table per job,
+// and warns that the layout is deprecated
+func migrateJobsArray(rawConfig map[string]interface{}, config *Config) error {
+	jobsArray, ok := rawConfig["jobs"].([]interface{})
+	if !ok {
+		return nil
+	}
+
+	var names []interface{}
+	for i, entry := range jobsArray {
+		jobMap, ok := entry.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("jobs entry %d is not a table", i+1)
+		}
+		name, _ := jobMap["name"].(string)
+		if name == "" {
+			return fmt.Errorf("jobs entry %d has no name", i+1)
+		}
+		if _, exists := rawConfig[name]; exists {
+			return fmt.Errorf("job '%s' of the [[jobs]] array clashes with the [%s] table", name, name)
+		}
+		delete(jobMap, "name")
+		rawConfig[name] = jobMap
+		names = append(names, name)
+	}
+	rawConfig["jobs"] = map[string]interface{}{"names": names}
+
+	config.Warnings = append(config.Warnings, "jobs are configured as a [[jobs]] array, which is deprecated: list the job names in [jobs] names and move each job into a table of its name, such as [main-sync]")
+	return nil
 }
 
 func parseConfig(rawConfig map[string]interface{}, config *Config) error {
+	if err := migrateJobsArray(rawConfig, config); err != nil {
+		return err
+	}
+
 	for key, value := range rawConfig {
 		switch key {
 		case "service":
 			if serviceMap, ok := value.(map[string]interface{}); ok {
 				config.Service.Name = getString(serviceMap, "name", "gitsync")
 				config.Service.Environment = getString(serviceMap, "environment", "development")
+				config.Service.MaxCacheSize = getInt(serviceMap, "max_cache_size", 0)
+				config.Service.SummaryPath = getString(serviceMap, "summary_path", "")
+				config.Service.WatchConfig = getBool(serviceMap, "watch_config", false)
+				config.Service.ShutdownGracePeriod = getDuration(serviceMap, "shutdown_grace_period", config.Service.ShutdownGracePeriod)
 			}
 		case "jobs":
 			if jobsMap, ok := value.(map[string]interface{}); ok {
@@ -138,30 +532,157 @@ func parseConfig(rawConfig map[string]interface{}, config *Config) error {
 					}
 				}
 				config.Jobs.Schedule = getString(jobsMap, "schedule", "")
+				config.Jobs.Every = getDuration(jobsMap, "every", 0)
+				config.Jobs.Timezone = getString(jobsMap, "timezone", "")
 				config.Jobs.Timeout = getDuration(jobsMap, "timeout", 5*time.Minute)
+				config.Jobs.CloneTimeout = getDuration(jobsMap, "clone_timeout", 0)
+				config.Jobs.FetchTimeout = getDuration(jobsMap, "fetch_timeout", 0)
+				config.Jobs.PushTimeout = getDuration(jobsMap, "push_timeout", 0)
+				config.Jobs.MaxBandwidth = getBandwidth(jobsMap, "max_bandwidth", 0)
+				config.Jobs.MaxConcurrentJobs = getInt(jobsMap, "max_concurrent_jobs", 0)
+				config.Jobs.ScheduleJitter = getDuration(jobsMap, "schedule_jitter", 0)
+				config.Jobs.MaxConsecutiveFailures = getInt(jobsMap, "max_consecutive_failures", 0)
+				config.Jobs.FailureCooldown = getDuration(jobsMap, "failure_cooldown", config.Jobs.FailureCooldown)
+				config.Jobs.RunOnStartup = getBool(jobsMap, "run_on_startup", config.Jobs.RunOnStartup)
+				config.Jobs.IncludeDir = getString(jobsMap, "include_dir", "")
 			}
 		case "logging":
 			if loggingMap, ok := value.(map[string]interface{}); ok {
 				config.Logging.Level = getString(loggingMap, "level", "info")
 				config.Logging.Format = getString(loggingMap, "format", "text")
+				config.Logging.ConsoleFormat = getString(loggingMap, "console_format", "")
 				config.Logging.Output = getString(loggingMap, "output", "both")
 				config.Logging.MaxSize = getInt(loggingMap, "max_size", 100)
 				config.Logging.MaxBackups = getInt(loggingMap, "max_backups", 3)
+				config.Logging.MaxAge = getInt(loggingMap, "max_age", 30)
+				config.Logging.Directory = getString(loggingMap, "directory", "")
+				config.Logging.NoColor = getBool(loggingMap, "no_color", false)
+				config.Logging.SyslogFacility = getString(loggingMap, "syslog_facility", "daemon")
+				config.Logging.SyslogTag = getString(loggingMap, "syslog_tag", "gitsync")
+			}
+		case "store":
+			if storeMap, ok := value.(map[string]interface{}); ok {
+				config.Store.Path = getString(storeMap, "path", config.Store.Path)
+				config.Store.BucketName = getString(storeMap, "bucket_name", config.Store.BucketName)
+				config.Store.MaxTransactions = getInt(storeMap, "max_transactions", config.Store.MaxTransactions)
+				config.Store.RetentionDays = getInt(storeMap, "retention_days", config.Store.RetentionDays)
+			}
+		case "server":
+			if serverMap, ok := value.(map[string]interface{}); ok {
+				config.Server.Enabled = getBool(serverMap, "enabled", true)
+				config.Server.Listen = getString(serverMap, "listen", config.Server.Listen)
+				config.Server.APIToken = getString(serverMap, "api_token", "")
+				config.Server.APITokenEnv = getString(serverMap, "api_token_env", "")
+				config.Server.GitHubSecret = getString(serverMap, "github_secret", "")
+				config.Server.GitHubSecretEnv = getString(serverMap, "github_secret_env", "")
+				config.Server.GitLabSecret = getString(serverMap, "gitlab_secret", "")
+				config.Server.GitLabSecretEnv = getString(serverMap, "gitlab_secret_env", "")
+				config.Server.WebhookDebounce = getDuration(serverMap, "webhook_debounce", config.Server.WebhookDebounce)
+			}
+		case "tracing":
+			if tracingMap, ok := value.(map[string]interface{}); ok {
+				config.Tracing.Endpoint = getString(tracingMap, "endpoint", "")
+				config.Tracing.Insecure = getBool(tracingMap, "insecure", false)
+				config.Tracing.SampleRatio = getFloat(tracingMap, "sample_ratio", config.Tracing.SampleRatio)
+			}
+		case "vault":
+			if vaultMap, ok := value.(map[string]interface{}); ok {
+				config.Vault = parseVaultConfig(vaultMap)
+			}
+		case "aws":
+			if awsMap, ok := value.(map[string]interface{}); ok {
+				config.AWS = parseAWSConfig(awsMap)
+			}
+		case "rate_limit":
+			if rateMap, ok := value.(map[string]interface{}); ok {
+				config.RateLimit = parseRateLimitConfig(rateMap)
+			}
+		case "notifications":
+			if notificationsMap, ok := value.(map[string]interface{}); ok {
+				config.Notifications.SlackURLs = getStringSlice(notificationsMap, "slack_urls")
+				config.Notifications.WebhookURLs = getStringSlice(notificationsMap, "webhook_urls")
+				config.Notifications.MinInterval = getDuration(notificationsMap, "min_interval", config.Notifications.MinInterval)
+				if emailMap, ok := notificationsMap["email"].(map[string]interface{}); ok {
+					config.Notifications.Email = parseEmailConfig(emailMap)
+				}
 			}
 		default:
 			// Job definition
 			if jobMap, ok := value.(map[string]interface{}); ok {
 				jobConfig := &JobConfig{
-					Description:    getString(jobMap, "description", ""),
-					Enabled:        getBool(jobMap, "enabled", true),
-					Source:         getString(jobMap, "source", ""),
-					Override:       getBool(jobMap, "override", false),
-					GitUsername:    getString(jobMap, "git_username", ""),
-					GitToken:       getString(jobMap, "git_token", ""),
-					GitTokenEnv:    getString(jobMap, "git_token_env", ""),
-					SSHKeyPath:     getString(jobMap, "ssh_key_path", ""),
-					SSHKeyEnv:      getString(jobMap, "ssh_key_env", ""),
-					RewriteHistory: getBool(jobMap, "rewrite_history", false),
+					Description:       getString(jobMap, "description", ""),
+					Enabled:           getBool(jobMap, "enabled", true),
+					Schedule:          getString(jobMap, "schedule", ""),
+					Every:             getDuration(jobMap, "every", 0),
+					Timezone:          getString(jobMap, "timezone", ""),
+					ScheduleJitter:    getDuration(jobMap, "schedule_jitter", 0),
+					Source:            getString(jobMap, "source", ""),
+					Override:          getBool(jobMap, "override", false),
+					Bidirectional:     getBool(jobMap, "bidirectional", false),
+					GitUsername:       getString(jobMap, "git_username", ""),
+					GitToken:          getString(jobMap, "git_token", ""),
+					GitTokenEnv:       getString(jobMap, "git_token_env", ""),
+					GitTokenVaultPath: getString(jobMap, "git_token_vault_path", ""),
+					GitTokenSecretARN: getString(jobMap, "git_token_secret_arn", ""),
+					GitTokenSSMParam:  getString(jobMap, "git_token_ssm_param", ""),
+					SSHKeyPath:        getString(jobMap, "ssh_key_path", ""),
+					SSHKeyEnv:         getString(jobMap, "ssh_key_env", ""),
+					RewriteHistory:    getBool(jobMap, "rewrite_history", false),
+					AddOriginTrailer:  getBool(jobMap, "add_origin_trailer", false),
+					BranchPriority:    getStringSlice(jobMap, "branch_priority"),
+					MaxBranchAge:      getDuration(jobMap, "max_branch_age", 0),
+					SyncDelay:         getDuration(jobMap, "sync_delay", 0),
+					StampTagFormat:    getString(jobMap, "stamp_tag_format", ""),
+					StampTagAnnotated: getBool(jobMap, "stamp_tag_annotated", false),
+					StampTagBranch:    getString(jobMap, "stamp_tag_branch", ""),
+					StampTagKeep:      getInt(jobMap, "stamp_tag_keep", 10),
+					RequireSignatures: getBool(jobMap, "require_signatures", false),
+					TrustedKeys:       getStringSlice(jobMap, "trusted_keys"),
+					AllowedSigners:    getString(jobMap, "allowed_signers_file", ""),
+					Refspecs:          getStringSlice(jobMap, "refspecs"),
+					SyncTags:          getBool(jobMap, "sync_tags", false),
+					SyncWiki:          getBool(jobMap, "sync_wiki", false),
+					IncrementalBundle: getBool(jobMap, "incremental_bundle", false),
+					SharedCache:       getBool(jobMap, "shared_cache", false),
+					AtomicPush:        getBool(jobMap, "atomic_push", false),
+					HTTPProxy:         getString(jobMap, "http_proxy", ""),
+					HTTPSProxy:        getString(jobMap, "https_proxy", ""),
+					NoProxy:           getString(jobMap, "no_proxy", ""),
+					CABundlePath:      getString(jobMap, "ca_bundle_path", ""),
+					TLSSkipVerify:     getBool(jobMap, "tls_skip_verify", false),
+					CloneTimeout:      getDuration(jobMap, "clone_timeout", 0),
+					FetchTimeout:      getDuration(jobMap, "fetch_timeout", 0),
+					PushTimeout:       getDuration(jobMap, "push_timeout", 0),
+					MaxBandwidth:      getBandwidth(jobMap, "max_bandwidth", 0),
+					VerifyPush:        getBool(jobMap, "verify_push", false),
+					PreSyncCommand:    getString(jobMap, "pre_sync_command", ""),
+					PrePushCommand:    getString(jobMap, "pre_push_command", ""),
+					PostSyncCommand:   getString(jobMap, "post_sync_command", ""),
+					HeartbeatURL:      getString(jobMap, "heartbeat_url", ""),
+					QueueMissedRun:    getBool(jobMap, "queue_missed_run", false),
+					DependsOn:         getStringSlice(jobMap, "depends_on"),
+					GitProgress:       getBool(jobMap, "git_progress", false),
+					ProgressInterval:  getDuration(jobMap, "progress_interval", 30*time.Second),
+				}
+
+				if runOnStartup, ok := jobMap["run_on_startup"].(bool); ok {
+					jobConfig.RunOnStartup = &runOnStartup
+				}
+
+				if windowMap, ok := jobMap["window"].(map[string]interface{}); ok {
+					jobConfig.Window = parseSyncWindow(windowMap)
+				}
+
+				if discoverMap, ok := jobMap["discover"].(map[string]interface{}); ok {
+					jobConfig.Discover = parseDiscoverConfig(discoverMap)
+				}
+
+				// Branch patterns to tag patterns, a value that is no string fails validation as empty
+				if tagPatterns, ok := jobMap["sync_to_tag_pattern"].(map[string]interface{}); ok {
+					jobConfig.SyncToTagPattern = make(map[string]string, len(tagPatterns))
+					for branchPattern, tagPattern := range tagPatterns {
+						jobConfig.SyncToTagPattern[branchPattern], _ = tagPattern.(string)
+					}
 				}
 
 				// Parse author replacement rules
@@ -179,11 +700,23 @@ func parseConfig(rawConfig map[string]interface{}, config *Config) error {
 					}
 				}
 
-				// Parse targets array
+				// [[job.source]] tables make an aggregation job, source is then no URL
+				if sourcesArray, exists := jobMap["source"].([]interface{}); exists {
+					for _, source := range sourcesArray {
+						if sourceMap, ok := source.(map[string]interface{}); ok {
+							jobConfig.Sources = append(jobConfig.Sources, parseSourceConfig(sourceMap))
+						}
+					}
+				}
+
+				// Parse targets array, entries are URLs or tables with per-target options
 				if targetsArray, exists := jobMap["targets"].([]interface{}); exists {
 					for _, target := range targetsArray {
-						if targetStr, ok := target.(string); ok {
-							jobConfig.Targets = append(jobConfig.Targets, targetStr)
+						switch t := target.(type) {
+						case string:
+							jobConfig.Targets = append(jobConfig.Targets, TargetConfig{URL: t})
+						case map[string]interface{}:
+							jobConfig.Targets = append(jobConfig.Targets, parseTargetConfig(t))
 						}
 					}
 				}
@@ -197,6 +730,15 @@ func parseConfig(rawConfig map[string]interface{}, config *Config) error {
 					}
 				}
 
+				// Placeholder values, a number such as a port is taken as written
+				if varsMap, ok := jobMap["vars"].(map[string]interface{}); ok {
+					jobConfig.Vars = make(map[string]string, len(varsMap))
+					for name, value := range varsMap {
+						jobConfig.Vars[name] = fmt.Sprint(value)
+					}
+				}
+				jobConfig.expandURLs(key)
+
 				config.JobDefs[key] = jobConfig
 			}
 		}
@@ -204,6 +746,64 @@ func parseConfig(rawConfig map[string]interface{}, config *Config) error {
 	return nil
 }
 
+func parseEmailConfig(emailMap map[string]interface{}) *EmailConfig {
+	email := &EmailConfig{
+		Host:        getString(emailMap, "host", ""),
+		Port:        getInt(emailMap, "port", 0),
+		TLS:         getString(emailMap, "tls", "starttls"),
+		Username:    getString(emailMap, "username", ""),
+		Password:    getString(emailMap, "password", ""),
+		PasswordEnv: getString(emailMap, "password_env", ""),
+		From:        getString(emailMap, "from", ""),
+		To:          getStringSlice(emailMap, "to"),
+	}
+	if email.Port == 0 {
+		email.Port = 587
+		if email.TLS == "tls" {
+			email.Port = 465
+		} else if email.TLS == "none" {
+			email.Port = 25
+		}
+	}
+	return email
+}
+
+func parseSourceConfig(sourceMap map[string]interface{}) SourceConfig {
+	name := getString(sourceMap, "name", "")
+	return SourceConfig{
+		Name:        name,
+		URL:         getString(sourceMap, "url", ""),
+		Branches:    getStringSlice(sourceMap, "branches"),
+		Prefix:      getString(sourceMap, "prefix", name+"/"),
+		GitUsername: getString(sourceMap, "git_username", ""),
+		GitToken:    getString(sourceMap, "git_token", ""),
+		GitTokenEnv: getString(sourceMap, "git_token_env", ""),
+		SSHKeyPath:  getString(sourceMap, "ssh_key_path", ""),
+		SSHKeyEnv:   getString(sourceMap, "ssh_key_env", ""),
+	}
+}
+
+func parseTargetConfig(targetMap map[string]interface{}) TargetConfig {
+	target := TargetConfig{
+		URL:           getString(targetMap, "url", ""),
+		SSHKeyPath:    getString(targetMap, "ssh_key_path", ""),
+		SSHKeyEnv:     getString(targetMap, "ssh_key_env", ""),
+		HTTPProxy:     getString(targetMap, "http_proxy", ""),
+		HTTPSProxy:    getString(targetMap, "https_proxy", ""),
+		NoProxy:       getString(targetMap, "no_proxy", ""),
+		CABundlePath:  getString(targetMap, "ca_bundle_path", ""),
+		Provider:      getString(targetMap, "provider", ""),
+		APIURL:        getString(targetMap, "api_url", ""),
+		CreateRepo:    getBool(targetMap, "create_repo", false),
+		DefaultBranch: getString(targetMap, "default_branch", ""),
+		MarkMirror:    getBool(targetMap, "mark_mirror", false),
+	}
+	if skipVerify, ok := targetMap["tls_skip_verify"].(bool); ok {
+		target.TLSSkipVerify = &skipVerify
+	}
+	return target
+}
+
 func getString(m map[string]interface{}, key, defaultValue string) string {
 	if v, ok := m[key].(string); ok {
 		return v
@@ -211,6 +811,18 @@ func getString(m map[string]interface{}, key, defaultValue string) string {
 	return defaultValue
 }
 
+func getStringSlice(m map[string]interface{}, key string) []string {
+	var values []string
+	if array, ok := m[key].([]interface{}); ok {
+		for _, item := range array {
+			if str, ok := item.(string); ok {
+				values = append(values, str)
+			}
+		}
+	}
+	return values
+}
+
 func getInt(m map[string]interface{}, key string, defaultValue int) int {
 	if v, ok := m[key].(int64); ok {
 		return int(v)
@@ -221,6 +833,16 @@ func getInt(m map[string]interface{}, key string, defaultValue int) int {
 	return defaultValue
 }
 
+func getFloat(m map[string]interface{}, key string, defaultValue float64) float64 {
+	if v, ok := m[key].(float64); ok {
+		return v
+	}
+	if v, ok := m[key].(int64); ok {
+		return float64(v)
+	}
+	return defaultValue
+}
+
 func getBool(m map[string]interface{}, key string, defaultValue bool) bool {
 	if v, ok := m[key].(bool); ok {
 		return v
@@ -228,14 +850,21 @@ func getBool(m map[string]interface{}, key string, defaultValue bool) bool {
 	return defaultValue
 }
 
+// getDuration reads a duration given as a string with a unit ("5m") or as a
+// plain number of seconds (300). A string that is not a duration reads as -1,
+// for validation to report instead of falling back to the default.
 func getDuration(m map[string]interface{}, key string, defaultValue time.Duration) time.Duration {
-	if v, ok := m[key].(string); ok {
-		if d, err := time.ParseDuration(v); err == nil {
-			return d
+	switch v := m[key].(type) {
+	case string:
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return -1
 		}
-	}
-	if v, ok := m[key].(int64); ok {
-		return time.Duration(v)
+		return d
+	case int64:
+		return time.Duration(v) * time.Second
+	case float64:
+		return time.Duration(v * float64(time.Second))
 	}
 	return defaultValue
 }
@@ -253,6 +882,27 @@ func applyEnvOverrides(config *Config) {
 	if logFormat := os.Getenv("LOG_FORMAT"); logFormat != "" {
 		config.Logging.Format = logFormat
 	}
+	if logDir := os.Getenv("LOG_DIR"); logDir != "" {
+		config.Logging.Directory = logDir
+	}
+	if config.Server.APITokenEnv != "" {
+		config.Server.APIToken = os.Getenv(config.Server.APITokenEnv)
+	}
+	if config.Server.GitHubSecretEnv != "" {
+		config.Server.GitHubSecret = os.Getenv(config.Server.GitHubSecretEnv)
+	}
+	if config.Server.GitLabSecretEnv != "" {
+		config.Server.GitLabSecret = os.Getenv(config.Server.GitLabSecretEnv)
+	}
+	if email := config.Notifications.Email; email != nil && email.PasswordEnv != "" {
+		email.Password = os.Getenv(email.PasswordEnv)
+	}
+	if config.Vault != nil {
+		config.Vault.applyEnvOverrides()
+	}
+	if config.AWS != nil {
+		config.AWS.applyEnvOverrides()
+	}
 }
 
 func (c *Config) Validate() error {
@@ -264,30 +914,620 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("at least one job must be configured")
 	}
 
-	if c.Jobs.Schedule == "" {
-		return fmt.Errorf("jobs schedule cannot be empty")
+	if c.Store.Path != "" && c.Store.BucketName == "" {
+		return fmt.Errorf("store bucket_name cannot be empty")
+	}
+
+	if c.Store.MaxTransactions < 0 || c.Store.RetentionDays < 0 {
+		return fmt.Errorf("store max_transactions and retention_days cannot be negative")
+	}
+
+	if c.Server.Enabled && c.Server.Listen == "" {
+		return fmt.Errorf("server listen address cannot be empty")
+	}
+
+	if c.Server.APITokenEnv != "" && c.Server.APIToken == "" {
+		return fmt.Errorf("server api_token_env %s is not set", c.Server.APITokenEnv)
+	}
+
+	if c.Server.GitHubSecretEnv != "" && c.Server.GitHubSecret == "" {
+		return fmt.Errorf("server github_secret_env %s is not set", c.Server.GitHubSecretEnv)
+	}
+
+	if c.Server.GitLabSecretEnv != "" && c.Server.GitLabSecret == "" {
+		return fmt.Errorf("server gitlab_secret_env %s is not set", c.Server.GitLabSecretEnv)
+	}
+
+	if c.Server.WebhookDebounce < 0 {
+		return fmt.Errorf("server webhook_debounce must be a non-negative duration such as \"10s\"")
+	}
+
+	if c.Server.Enabled && c.Server.APIToken == "" && c.IsProduction() {
+		c.Warnings = append(c.Warnings, "SECURITY: the HTTP job API accepts unauthenticated requests in a production environment, set server api_token")
+	}
+
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		return fmt.Errorf("tracing sample_ratio must be between 0 and 1")
+	}
+
+	if c.Notifications.MinInterval < 0 {
+		return fmt.Errorf("notifications min_interval must be a non-negative duration such as \"1h\"")
+	}
+
+	for _, url := range append(append([]string{}, c.Notifications.SlackURLs...), c.Notifications.WebhookURLs...) {
+		if !strings.HasPrefix(url, "https://") && !strings.HasPrefix(url, "http://") {
+			return fmt.Errorf("notification url %s must be an http or https URL", url)
+		}
+	}
+
+	if err := c.Notifications.Email.validate(); err != nil {
+		return fmt.Errorf("notifications email: %w", err)
+	}
+
+	if err := c.Vault.validate(); err != nil {
+		return fmt.Errorf("vault: %w", err)
+	}
+
+	if err := c.AWS.validate(); err != nil {
+		return fmt.Errorf("aws: %w", err)
+	}
+
+	if err := c.RateLimit.validate(); err != nil {
+		return fmt.Errorf("rate_limit: %w", err)
+	}
+
+	if c.Service.SummaryPath != "" && !strings.Contains(c.Service.SummaryPath, "{job}") && len(c.Jobs.Names) > 1 {
+		c.Warnings = append(c.Warnings, fmt.Sprintf("service summary_path %s has no {job} placeholder, every job overwrites the same summary", c.Service.SummaryPath))
+	}
+
+	for _, format := range []struct{ key, value string }{
+		{"format", c.Logging.Format},
+		{"console_format", c.Logging.ConsoleFormat},
+	} {
+		if format.value != "" && format.value != "text" && format.value != "json" {
+			return fmt.Errorf("logging %s '%s' is not supported, use text or json", format.key, format.value)
+		}
+	}
+
+	for output := range c.Logging.outputs() {
+		if output != "console" && output != "file" && output != "syslog" {
+			return fmt.Errorf("logging output '%s' is not supported, use console, file, both or syslog", output)
+		}
+	}
+	if c.Logging.WritesSyslog() {
+		if err := validateSyslogFacility(c.Logging.SyslogFacility); err != nil {
+			return fmt.Errorf("logging output syslog: %w", err)
+		}
+	}
+
+	if c.Logging.MaxBackups < 0 || c.Logging.MaxAge < 0 {
+		return fmt.Errorf("logging max_backups and max_age cannot be negative")
+	}
+	if c.Logging.MaxBackups == 0 && c.Logging.MaxAge == 0 {
+		defaults := DefaultLoggingConfig()
+		c.Warnings = append(c.Warnings, fmt.Sprintf("logging max_backups and max_age are both 0, which would keep every rotated log file; keeping %d files of up to %d days instead", defaults.MaxBackups, defaults.MaxAge))
+		c.Logging.MaxBackups, c.Logging.MaxAge = defaults.MaxBackups, defaults.MaxAge
+	}
+
+	if c.Service.MaxCacheSize < 0 {
+		return fmt.Errorf("service max_cache_size cannot be negative")
+	}
+
+	if c.Service.ShutdownGracePeriod < 0 {
+		return fmt.Errorf("service shutdown_grace_period must be a non-negative duration such as \"30s\"")
+	}
+
+	if c.Jobs.Timeout < 0 || c.Jobs.CloneTimeout < 0 || c.Jobs.FetchTimeout < 0 || c.Jobs.PushTimeout < 0 {
+		return fmt.Errorf("jobs timeouts must be non-negative durations such as \"5m\"")
+	}
+
+	if c.Jobs.MaxBandwidth < 0 {
+		return fmt.Errorf("jobs max_bandwidth must be a rate such as \"10MB\" or \"20Mbit\"")
+	}
+
+	if c.Jobs.MaxConcurrentJobs < 0 {
+		return fmt.Errorf("jobs max_concurrent_jobs cannot be negative")
+	}
+
+	if c.Jobs.ScheduleJitter < 0 {
+		return fmt.Errorf("jobs schedule_jitter must be a non-negative duration such as \"30s\"")
+	}
+
+	if err := validateInterval(c.Jobs.Schedule, c.Jobs.Every); err != nil {
+		return fmt.Errorf("jobs %w", err)
+	}
+
+	if c.Jobs.MaxConsecutiveFailures < 0 {
+		return fmt.Errorf("jobs max_consecutive_failures cannot be negative")
+	}
+
+	if c.Jobs.FailureCooldown < 0 {
+		return fmt.Errorf("jobs failure_cooldown must be a non-negative duration such as \"1h\"")
+	}
+
+	if c.Jobs.MaxConsecutiveFailures > 0 && c.Jobs.FailureCooldown <= 0 {
+		return fmt.Errorf("jobs failure_cooldown must be positive when max_consecutive_failures is set")
 	}
 
 	for i, jobName := range c.Jobs.Names {
-		jobConfig, exists := c.JobDefs[jobName]
-		if !exists {
-			return fmt.Errorf("job[%d]: job definition '%s' not found", i, jobName)
+		if err := c.validateJob(i, jobName); err != nil {
+			// Jobs from the include directory name the file they came from
+			if file := c.JobFiles[jobName]; file != "" {
+				return fmt.Errorf("%s: %w", file, err)
+			}
+			return err
+		}
+	}
+
+	if err := c.validateDependencies(); err != nil {
+		return err
+	}
+
+	c.warnSharedTargets()
+
+	return nil
+}
+
+// validateJob checks the definition of the i-th job of [jobs] names
+func (c *Config) validateJob(i int, jobName string) error {
+	if err := ValidateJobName(jobName); err != nil {
+		return fmt.Errorf("job[%d]: %w", i, err)
+	}
+
+	jobConfig, exists := c.JobDefs[jobName]
+	if !exists {
+		return fmt.Errorf("job[%d]: job definition '%s' not found", i, jobName)
+	}
+
+	if jobConfig.Source == "" && !jobConfig.Aggregates() && !jobConfig.Discovers() {
+		return fmt.Errorf("job[%d]: source cannot be empty for job '%s'", i, jobName)
+	}
+
+	if err := validateInterval(jobConfig.Schedule, jobConfig.Every); err != nil {
+		return fmt.Errorf("job[%d]: %w for job '%s'", i, err, jobName)
+	}
+
+	if tz := c.JobTimezone(jobName); tz != "" {
+		if _, err := time.LoadLocation(tz); err != nil {
+			return fmt.Errorf("job[%d]: unknown timezone '%s' for job '%s': %w", i, tz, jobName, err)
+		}
+	}
+
+	schedule := c.JobSchedule(jobName)
+	if schedule == "" {
+		return fmt.Errorf("job[%d]: schedule cannot be empty for job '%s', set schedule or every on the job or in [jobs]", i, jobName)
+	}
+	if _, err := cronParser.Parse(c.JobCronSpec(jobName)); err != nil {
+		return fmt.Errorf("job[%d]: invalid schedule '%s' for job '%s': %w (use SEC MIN HOUR DAY MONTH WEEKDAY, e.g. \"0 */5 * * * *\" for every 5 minutes, or every = \"5m\")", i, schedule, jobName, err)
+	}
+
+	if jobConfig.ScheduleJitter < 0 {
+		return fmt.Errorf("job[%d]: schedule_jitter must be a non-negative duration such as \"30s\" for job '%s'", i, jobName)
+	}
+
+	if jobConfig.Window != nil {
+		if err := jobConfig.Window.validate(); err != nil {
+			return fmt.Errorf("job[%d]: invalid window for job '%s': %w", i, jobName, err)
+		}
+	}
+
+	if len(jobConfig.Targets) == 0 {
+		return fmt.Errorf("job[%d]: at least one target must be configured for job '%s'", i, jobName)
+	}
+
+	if err := validatePlaceholders(jobName, jobConfig); err != nil {
+		return fmt.Errorf("job[%d]: %w", i, err)
+	}
+
+	for j, target := range jobConfig.Targets {
+		if target.URL == "" {
+			return fmt.Errorf("job[%d]: target[%d] url cannot be empty for job '%s'", i, j, jobName)
+		}
+		for _, source := range jobConfig.SourceURLs() {
+			if SameRepository(target.URL, source) {
+				return fmt.Errorf("job[%d]: target[%d] %s is the same repository as the source for job '%s'", i, j, target.URL, jobName)
+			}
 		}
+		if err := validateProvisioning(jobConfig, &target); err != nil {
+			return fmt.Errorf("job[%d]: target[%d] %s: %v for job '%s'", i, j, target.URL, err, jobName)
+		}
+	}
+
+	if err := validateTokenSecret(jobConfig); err != nil {
+		return fmt.Errorf("job[%d]: %v for job '%s'", i, err, jobName)
+	}
+
+	if jobConfig.Aggregates() {
+		if err := validateSources(jobName, jobConfig); err != nil {
+			return fmt.Errorf("job[%d]: %w", i, err)
+		}
+	}
+
+	if jobConfig.Discovers() {
+		if err := validateDiscover(jobName, jobConfig); err != nil {
+			return fmt.Errorf("job[%d]: %w", i, err)
+		}
+	}
 
-		if jobConfig.Source == "" {
-			return fmt.Errorf("job[%d]: source cannot be empty for job '%s'", i, jobName)
+	if jobConfig.Bidirectional {
+		if err := validateBidirectional(jobName, jobConfig); err != nil {
+			return fmt.Errorf("job[%d]: %w", i, err)
 		}
+	}
+
+	if jobConfig.SyncWiki && IsBundleURL(jobConfig.Source) {
+		return fmt.Errorf("job[%d]: sync_wiki needs a repository source, a bundle has no wiki, for job '%s'", i, jobName)
+	}
 
-		if len(jobConfig.Targets) == 0 {
-			return fmt.Errorf("job[%d]: at least one target must be configured for job '%s'", i, jobName)
+	if jobConfig.SharedCache {
+		switch {
+		case IsBundleURL(jobConfig.Source):
+			return fmt.Errorf("job[%d]: shared_cache needs a repository source, a bundle is read in full each run, for job '%s'", i, jobName)
+		case jobConfig.Aggregates():
+			return fmt.Errorf("job[%d]: shared_cache cannot be combined with [[%s.source]] tables for job '%s'", i, jobName, jobName)
+		case jobConfig.Bidirectional:
+			return fmt.Errorf("job[%d]: shared_cache cannot be combined with bidirectional, which pushes back to the source, for job '%s'", i, jobName)
+		case len(jobConfig.Refspecs) > 0:
+			return fmt.Errorf("job[%d]: shared_cache cannot be combined with refspecs, the shared mirror holds branches and tags only, for job '%s'", i, jobName)
 		}
+	}
+
+	if jobConfig.AtomicPush && len(jobConfig.Refspecs) > 0 {
+		return fmt.Errorf("job[%d]: atomic_push cannot be combined with refspecs, which are pushed one by one, for job '%s'", i, jobName)
+	}
 
-		// Validate branch configuration - if no branches specified, default to main
-		if len(jobConfig.Branches) == 0 {
-			jobConfig.Branches = []string{"main"}
+	if jobConfig.HeartbeatURL != "" {
+		if u, err := url.Parse(jobConfig.HeartbeatURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("job[%d]: heartbeat_url %s must be an absolute http or https URL for job '%s'", i, jobConfig.HeartbeatURL, jobName)
 		}
 	}
 
+	if err := c.validateTLS(jobName, jobConfig); err != nil {
+		return fmt.Errorf("job[%d]: %w", i, err)
+	}
+
+	for _, keyPath := range jobConfig.SSHKeyPaths() {
+		warning, err := checkSSHKey(keyPath)
+		if err != nil {
+			return fmt.Errorf("job[%d]: %w for job '%s'", i, err, jobName)
+		}
+		if warning != "" {
+			c.Warnings = append(c.Warnings, fmt.Sprintf("job '%s': %s", jobName, warning))
+		}
+	}
+
+	for _, pattern := range jobConfig.Branches {
+		if err := ValidateBranchPattern(pattern); err != nil {
+			return fmt.Errorf("job[%d]: invalid branch pattern '%s' for job '%s': %w", i, pattern, jobName, err)
+		}
+	}
+
+	if jobConfig.MaxBranchAge < 0 {
+		return fmt.Errorf("job[%d]: max_branch_age must be a non-negative duration such as \"720h\" for job '%s'", i, jobName)
+	}
+
+	if jobConfig.SyncDelay < 0 {
+		return fmt.Errorf("job[%d]: sync_delay must be a non-negative duration such as \"5m\" for job '%s'", i, jobName)
+	}
+	if jobConfig.SyncDelay > 0 {
+		if len(jobConfig.Refspecs) > 0 {
+			return fmt.Errorf("job[%d]: sync_delay does not apply to refspecs, which are pushed as they are, for job '%s'", i, jobName)
+		}
+		if jobConfig.SyncTags {
+			c.Warnings = append(c.Warnings, fmt.Sprintf("job '%s': sync_delay does not hold back tags, a tag on a commit within the delay publishes it", jobName))
+		}
+	}
+
+	if err := validateTagPatterns(jobName, jobConfig); err != nil {
+		return fmt.Errorf("job[%d]: %w", i, err)
+	}
+
+	if err := c.validateStampTag(jobName, jobConfig); err != nil {
+		return fmt.Errorf("job[%d]: %w", i, err)
+	}
+
+	if jobConfig.CloneTimeout < 0 || jobConfig.FetchTimeout < 0 || jobConfig.PushTimeout < 0 {
+		return fmt.Errorf("job[%d]: clone, fetch and push timeouts must be non-negative durations such as \"5m\" for job '%s'", i, jobName)
+	}
+
+	if jobConfig.ProgressInterval < 0 {
+		return fmt.Errorf("job[%d]: progress_interval must be a non-negative duration such as \"30s\" for job '%s'", i, jobName)
+	}
+
+	if jobConfig.MaxBandwidth < 0 {
+		return fmt.Errorf("job[%d]: max_bandwidth must be a rate such as \"10MB\" or \"20Mbit\" for job '%s'", i, jobName)
+	}
+
+	for _, spec := range jobConfig.Refspecs {
+		if _, err := ParseRefspec(spec); err != nil {
+			return fmt.Errorf("job[%d]: invalid refspec '%s' for job '%s': %w", i, spec, jobName, err)
+		}
+	}
+
+	if jobConfig.AddOriginTrailer {
+		switch {
+		case !jobConfig.RewriteHistory:
+			return fmt.Errorf("job[%d]: add_origin_trailer needs rewrite_history = true for job '%s'", i, jobName)
+		case len(jobConfig.Refspecs) > 0:
+			return fmt.Errorf("job[%d]: add_origin_trailer does not apply to refspecs, which are pushed unchanged, for job '%s'", i, jobName)
+		case !jobConfig.Override:
+			c.Warnings = append(c.Warnings, fmt.Sprintf("job '%s': add_origin_trailer changes every commit hash, a target that already has the history without trailers needs override = true", jobName))
+		}
+	}
+
+	for _, pattern := range jobConfig.BranchPriority {
+		if err := ValidateBranchPattern(pattern); err != nil {
+			return fmt.Errorf("job[%d]: invalid branch_priority pattern '%s' for job '%s': %w", i, pattern, jobName, err)
+		}
+	}
+
+	if err := c.validateSignatures(jobName, jobConfig); err != nil {
+		return fmt.Errorf("job[%d]: %w", i, err)
+	}
+
+	if jobConfig.PrePushCommand != "" {
+		if len(jobConfig.Refspecs) > 0 {
+			return fmt.Errorf("job[%d]: pre_push_command does not apply to refspecs, use pre_sync_command, for job '%s'", i, jobName)
+		}
+		if jobConfig.SyncTags {
+			c.Warnings = append(c.Warnings, fmt.Sprintf("job '%s': pre_push_command does not check tags, a tag on a commit no branch pushed publishes it unchecked", jobName))
+		}
+	}
+	return nil
+}
+
+// sourceNamePattern keeps source names usable in git remote and ref names
+var sourceNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// validateSources checks the [[job.source]] tables of an aggregation job, and
+// that the job uses no setting that only applies to a single source
+func validateSources(jobName string, jobConfig *JobConfig) error {
+	unsupported := []struct {
+		key string
+		set bool
+	}{
+		{"branches", len(jobConfig.Branches) > 0},
+		{"refspecs", len(jobConfig.Refspecs) > 0},
+		{"rewrite_history", jobConfig.RewriteHistory},
+		{"sync_tags", jobConfig.SyncTags},
+		{"sync_wiki", jobConfig.SyncWiki},
+		{"sync_delay", jobConfig.SyncDelay > 0},
+		{"sync_to_tag_pattern", len(jobConfig.SyncToTagPattern) > 0},
+		{"max_branch_age", jobConfig.MaxBranchAge > 0},
+		{"branch_priority", len(jobConfig.BranchPriority) > 0},
+	}
+	for _, option := range unsupported {
+		if option.set {
+			return fmt.Errorf("%s does not apply to a job with [[%s.source]] tables, set branches per source, for job '%s'", option.key, jobName, jobName)
+		}
+	}
+	if jobConfig.StampTagFormat != "" && jobConfig.StampTagBranch == "" {
+		return fmt.Errorf("stamp_tag_format needs stamp_tag_branch, such as \"<prefix>main\", with several sources for job '%s'", jobName)
+	}
+
+	names := make(map[string]bool)
+	for j, source := range jobConfig.Sources {
+		switch {
+		case !sourceNamePattern.MatchString(source.Name):
+			return fmt.Errorf("source[%d] name '%s' must be letters, digits, '.', '_' or '-' for job '%s'", j, source.Name, jobName)
+		case names[source.Name]:
+			return fmt.Errorf("source[%d] name '%s' is used twice for job '%s'", j, source.Name, jobName)
+		case source.URL == "":
+			return fmt.Errorf("source '%s' url cannot be empty for job '%s'", source.Name, jobName)
+		case IsBundleURL(source.URL):
+			return fmt.Errorf("source '%s' cannot be a bundle for job '%s'", source.Name, jobName)
+		}
+		names[source.Name] = true
+
+		for _, pattern := range source.Branches {
+			if err := ValidateBranchPattern(pattern); err != nil {
+				return fmt.Errorf("invalid branch pattern '%s' of source '%s' for job '%s': %w", pattern, source.Name, jobName, err)
+			}
+		}
+		if err := validateRefName("refs/heads/" + source.Prefix + "main"); err != nil || strings.Contains(source.Prefix, "*") {
+			return fmt.Errorf("prefix '%s' of source '%s' does not make valid branch names for job '%s'", source.Prefix, source.Name, jobName)
+		}
+		// Branches of two sources may only land on the same name when neither prefix starts the other
+		for _, other := range jobConfig.Sources[:j] {
+			if strings.HasPrefix(source.Prefix, other.Prefix) || strings.HasPrefix(other.Prefix, source.Prefix) {
+				return fmt.Errorf("prefix '%s' of source '%s' overlaps prefix '%s' of source '%s', their branches could collide, for job '%s'",
+					source.Prefix, source.Name, other.Prefix, other.Name, jobName)
+			}
+		}
+	}
+	return nil
+}
+
+// validateBidirectional checks that a bidirectional job pairs its source with
+// one target, and has none of the options that only make sense one way
+func validateBidirectional(jobName string, jobConfig *JobConfig) error {
+	switch {
+	case jobConfig.Aggregates():
+		return fmt.Errorf("bidirectional cannot be combined with [[%s.source]] tables, it pairs one source with one target, for job '%s'", jobName, jobName)
+	case len(jobConfig.Targets) != 1:
+		return fmt.Errorf("bidirectional needs exactly one target, the other side of the pair, for job '%s'", jobName)
+	case IsBundleURL(jobConfig.Source) || IsBundleURL(jobConfig.Targets[0].URL):
+		return fmt.Errorf("bidirectional cannot sync with a bundle for job '%s'", jobName)
+	case jobConfig.Override:
+		return fmt.Errorf("override cannot be combined with bidirectional, a diverged branch is never forced either way, for job '%s'", jobName)
+	}
+
+	unsupported := []struct {
+		key string
+		set bool
+	}{
+		{"refspecs", len(jobConfig.Refspecs) > 0},
+		{"rewrite_history", jobConfig.RewriteHistory},
+		{"sync_tags", jobConfig.SyncTags},
+		{"sync_wiki", jobConfig.SyncWiki},
+		{"sync_delay", jobConfig.SyncDelay > 0},
+		{"sync_to_tag_pattern", len(jobConfig.SyncToTagPattern) > 0},
+		{"stamp_tag_format", jobConfig.StampTagFormat != ""},
+		{"require_signatures", jobConfig.RequireSignatures},
+		{"pre_push_command", jobConfig.PrePushCommand != ""},
+		{"atomic_push", jobConfig.AtomicPush},
+	}
+	for _, option := range unsupported {
+		if option.set {
+			return fmt.Errorf("%s does not apply to a bidirectional job, which only fast-forwards branches, for job '%s'", option.key, jobName)
+		}
+	}
+	return nil
+}
+
+// validateTagPatterns checks the sync_to_tag_pattern table of a job
+func validateTagPatterns(jobName string, jobConfig *JobConfig) error {
+	if len(jobConfig.SyncToTagPattern) == 0 {
+		return nil
+	}
+	switch {
+	case len(jobConfig.Refspecs) > 0:
+		return fmt.Errorf("sync_to_tag_pattern does not apply to refspecs, which are pushed as they are, for job '%s'", jobName)
+	case jobConfig.RewritesHistory():
+		return fmt.Errorf("sync_to_tag_pattern cannot be combined with rewrite_history, tags keep pointing at the source commits, for job '%s'", jobName)
+	}
+
+	for _, branchPattern := range sortedKeys(jobConfig.SyncToTagPattern) {
+		if err := ValidateBranchPattern(branchPattern); err != nil {
+			return fmt.Errorf("invalid sync_to_tag_pattern branch pattern '%s' for job '%s': %w", branchPattern, jobName, err)
+		}
+		tagPattern := jobConfig.SyncToTagPattern[branchPattern]
+		if tagPattern == "" {
+			return fmt.Errorf("sync_to_tag_pattern of branch '%s' must be a tag pattern such as \"v*\" for job '%s'", branchPattern, jobName)
+		}
+		if _, err := path.Match(tagPattern, ""); err != nil {
+			return fmt.Errorf("invalid sync_to_tag_pattern tag pattern '%s' for job '%s': %w", tagPattern, jobName, err)
+		}
+	}
+	return nil
+}
+
+// validateStampTag checks the stamp_tag_format settings of a job
+func (c *Config) validateStampTag(jobName string, jobConfig *JobConfig) error {
+	if jobConfig.StampTagFormat == "" {
+		if jobConfig.StampTagAnnotated || jobConfig.StampTagBranch != "" {
+			c.Warnings = append(c.Warnings, fmt.Sprintf("job '%s': stamp_tag_annotated and stamp_tag_branch have no effect without stamp_tag_format", jobName))
+		}
+		return nil
+	}
+
+	switch {
+	case len(jobConfig.Refspecs) > 0:
+		return fmt.Errorf("stamp_tag_format needs a branch to tag, refspecs have none, for job '%s'", jobName)
+	case !strings.Contains(jobConfig.StampTagFormat, "{date}"):
+		return fmt.Errorf("stamp_tag_format %s must contain {date}, which orders the stamp tags, for job '%s'", jobConfig.StampTagFormat, jobName)
+	case jobConfig.StampTagKeep < 0:
+		return fmt.Errorf("stamp_tag_keep cannot be negative for job '%s'", jobName)
+	}
+
+	if jobConfig.StampTagKeep > 0 && !strings.Contains(jobConfig.StampTagFormat, "{job}") {
+		c.Warnings = append(c.Warnings, fmt.Sprintf("job '%s': stamp_tag_format has no {job}, stamp_tag_keep also deletes the stamp tags of other jobs pushing to the same target", jobName))
+	}
+
+	sample := jobConfig.StampTag(jobName, "0123456789abcdef", time.Now())
+	if err := validateRefName("refs/tags/" + sample); err != nil || strings.Contains(sample, "*") {
+		return fmt.Errorf("stamp_tag_format %s does not make a valid tag name, such as %s, for job '%s'", jobConfig.StampTagFormat, sample, jobName)
+	}
+	if jobConfig.StampTagBranch != "" {
+		if err := validateRefName("refs/heads/" + jobConfig.StampTagBranch); err != nil || strings.Contains(jobConfig.StampTagBranch, "*") {
+			return fmt.Errorf("stamp_tag_branch %s is not a branch name for job '%s'", jobConfig.StampTagBranch, jobName)
+		}
+	}
+	return nil
+}
+
+// validateSignatures checks the require_signatures settings of a job
+func (c *Config) validateSignatures(jobName string, jobConfig *JobConfig) error {
+	if !jobConfig.RequireSignatures {
+		if len(jobConfig.TrustedKeys) > 0 || jobConfig.AllowedSigners != "" {
+			c.Warnings = append(c.Warnings, fmt.Sprintf("job '%s': trusted_keys and allowed_signers_file have no effect without require_signatures = true", jobName))
+		}
+		return nil
+	}
+
+	switch {
+	case len(jobConfig.TrustedKeys) == 0 && jobConfig.AllowedSigners == "":
+		return fmt.Errorf("require_signatures needs trusted_keys or allowed_signers_file for job '%s'", jobName)
+	case jobConfig.RewritesHistory():
+		return fmt.Errorf("require_signatures cannot be combined with rewrite_history, rewritten commits lose their signatures, for job '%s'", jobName)
+	case len(jobConfig.Refspecs) > 0:
+		return fmt.Errorf("require_signatures does not apply to refspecs, which are pushed unverified, for job '%s'", jobName)
+	}
+
+	for _, key := range jobConfig.TrustedKeys {
+		if NormalizeKeyFingerprint(key) == "" {
+			return fmt.Errorf("trusted_keys contains an empty fingerprint for job '%s'", jobName)
+		}
+	}
+	if jobConfig.AllowedSigners != "" {
+		if _, err := os.Stat(jobConfig.AllowedSigners); err != nil {
+			return fmt.Errorf("allowed_signers_file %s is not accessible for job '%s': %w", jobConfig.AllowedSigners, jobName, err)
+		}
+	}
+
+	if jobConfig.SyncTags {
+		c.Warnings = append(c.Warnings, fmt.Sprintf("job '%s': require_signatures does not verify tags, a tag pushes the commits it points at unverified", jobName))
+	}
+	for _, target := range jobConfig.Targets {
+		if IsBundleURL(target.URL) {
+			c.Warnings = append(c.Warnings, fmt.Sprintf("job '%s': require_signatures does not verify bundle target %s, bundles carry every fetched commit", jobName, target.URL))
+		}
+	}
+	return nil
+}
+
+// NormalizeKeyFingerprint returns a GPG fingerprint without spaces in upper
+// case, the way git reports it, and an SSH "SHA256:" fingerprint unchanged
+func NormalizeKeyFingerprint(key string) string {
+	key = strings.TrimSpace(key)
+	if strings.HasPrefix(key, "SHA256:") {
+		return key
+	}
+	return strings.ToUpper(strings.ReplaceAll(key, " ", ""))
+}
+
+// warnSharedTargets flags jobs pushing overlapping branches to the same target,
+// since they will overwrite each other's refs
+func (c *Config) warnSharedTargets() {
+	for i, nameA := range c.Jobs.Names {
+		jobA := c.JobDefs[nameA]
+		for _, nameB := range c.Jobs.Names[i+1:] {
+			jobB := c.JobDefs[nameB]
+			if nameA == nameB || !branchPatternsOverlap(jobA, jobB) {
+				continue
+			}
+			for _, targetA := range jobA.Targets {
+				for _, targetB := range jobB.Targets {
+					if SameRepository(targetA.URL, targetB.URL) {
+						c.Warnings = append(c.Warnings, fmt.Sprintf("jobs '%s' and '%s' both push overlapping branches to %s and will fight over its refs", nameA, nameB, targetA.URL))
+					}
+				}
+			}
+		}
+	}
+}
+
+func (e *EmailConfig) validate() error {
+	if e == nil {
+		return nil
+	}
+	if e.Host == "" {
+		return fmt.Errorf("host cannot be empty")
+	}
+	if e.Port <= 0 || e.Port > 65535 {
+		return fmt.Errorf("port %d is out of range", e.Port)
+	}
+	switch e.TLS {
+	case "starttls", "tls", "none":
+	default:
+		return fmt.Errorf("tls must be starttls, tls or none, got '%s'", e.TLS)
+	}
+	if e.PasswordEnv != "" && e.Password == "" {
+		return fmt.Errorf("password_env %s is not set", e.PasswordEnv)
+	}
+	if e.From == "" || len(e.To) == 0 {
+		return fmt.Errorf("from and to addresses are required")
+	}
 	return nil
 }
 
@@ -300,16 +1540,290 @@ func (c *Config) GetJobConfig(name string) (*JobConfig, bool) {
 	return jobConfig, exists
 }
 
+// GetEnabledJobs returns the enabled jobs that sync, discovery jobs are left
+// out as only their discovered jobs run
 func (c *Config) GetEnabledJobs() []string {
 	var enabled []string
 	for _, jobName := range c.Jobs.Names {
-		if jobConfig, exists := c.JobDefs[jobName]; exists && jobConfig.Enabled {
+		if jobConfig, exists := c.JobDefs[jobName]; exists && jobConfig.Enabled && !jobConfig.Discovers() {
 			enabled = append(enabled, jobName)
 		}
 	}
 	return enabled
 }
 
+// IsLocalRepository reports whether a source or target refers to a repository
+// on the local filesystem (an absolute path or a file:// URL)
+func IsLocalRepository(url string) bool {
+	if strings.HasPrefix(url, "file://") {
+		return true
+	}
+	return filepath.IsAbs(url) || filepath.VolumeName(url) != ""
+}
+
+// IsBundleURL reports whether a source or target is a bundle:// file rather than a git remote
+func IsBundleURL(url string) bool {
+	return strings.HasPrefix(url, "bundle://")
+}
+
+// LocalRepositoryPath returns the filesystem path of a local source or target
+func LocalRepositoryPath(url string) string {
+	return filepath.FromSlash(strings.TrimPrefix(url, "file://"))
+}
+
+// UsesRemoteAuth reports whether any of the job's repositories needs credentials
+func (jc *JobConfig) UsesRemoteAuth() bool {
+	for _, source := range jc.SourceURLs() {
+		if !IsLocalRepository(source) && !IsBundleURL(source) {
+			return true
+		}
+	}
+	for _, target := range jc.Targets {
+		if !IsLocalRepository(target.URL) && !IsBundleURL(target.URL) {
+			return true
+		}
+	}
+	return false
+}
+
+// Aggregates reports whether the job pushes several [[job.source]] repositories
+// into its targets instead of mirroring one source
+func (jc *JobConfig) Aggregates() bool {
+	return len(jc.Sources) > 0
+}
+
+// SourceURLs returns the source of the job, or every source of an aggregation job
+func (jc *JobConfig) SourceURLs() []string {
+	if !jc.Aggregates() {
+		return []string{jc.Source}
+	}
+	urls := make([]string, 0, len(jc.Sources))
+	for _, source := range jc.Sources {
+		urls = append(urls, source.URL)
+	}
+	return urls
+}
+
+// SourceLabel names the source in logs, results and listings, the source URLs
+// separated by commas for an aggregation job and the organization or group
+// for a discovery job
+func (jc *JobConfig) SourceLabel() string {
+	if jc.Discovers() {
+		return jc.Discover.Label()
+	}
+	return strings.Join(jc.SourceURLs(), ", ")
+}
+
+// SyncsTags reports whether the job pushes tags, through sync_tags or a refspec
+func (jc *JobConfig) SyncsTags() bool {
+	if jc.SyncTags {
+		return true
+	}
+	for _, spec := range jc.Refspecs {
+		if strings.HasPrefix(strings.TrimPrefix(strings.TrimSpace(spec), "+"), "refs/tags/") {
+			return true
+		}
+	}
+	return false
+}
+
+// TagPatternFor returns the sync_to_tag_pattern tag pattern of a branch, empty
+// when it syncs at its tip. A branch name given exactly wins over patterns,
+// overlapping patterns are tried in alphabetical order.
+func (jc *JobConfig) TagPatternFor(branchName string) string {
+	if tagPattern, ok := jc.SyncToTagPattern[branchName]; ok {
+		return tagPattern
+	}
+	for _, branchPattern := range sortedKeys(jc.SyncToTagPattern) {
+		if matchesBranchPattern(branchName, branchPattern) {
+			return jc.SyncToTagPattern[branchPattern]
+		}
+	}
+	return ""
+}
+
+// RewritesHistory reports whether the job rewrites the fetched branches before
+// pushing them, to replace authors or to add the origin trailer
+func (jc *JobConfig) RewritesHistory() bool {
+	return jc.RewriteHistory && (len(jc.AuthorReplace) > 0 || jc.AddOriginTrailer)
+}
+
+// SSHKeyPaths returns every SSH key referenced by the job and its targets
+func (jc *JobConfig) SSHKeyPaths() []string {
+	var paths []string
+	if jc.SSHKeyPath != "" {
+		paths = append(paths, jc.SSHKeyPath)
+	}
+	for _, source := range jc.Sources {
+		if source.SSHKeyPath != "" {
+			paths = append(paths, source.SSHKeyPath)
+		}
+	}
+	for _, target := range jc.Targets {
+		if target.SSHKeyPath != "" {
+			paths = append(paths, target.SSHKeyPath)
+		}
+	}
+	return paths
+}
+
+// JobSchedule returns the cron expression a job runs on, from its own schedule
+// or every when set and from the jobs ones otherwise. An every interval becomes
+// an @every expression.
+func (c *Config) JobSchedule(jobName string) string {
+	if jobConfig, exists := c.JobDefs[jobName]; exists {
+		if jobConfig.Schedule != "" {
+			return jobConfig.Schedule
+		}
+		if jobConfig.Every > 0 {
+			return "@every " + jobConfig.Every.String()
+		}
+	}
+	if c.Jobs.Every > 0 {
+		return "@every " + c.Jobs.Every.String()
+	}
+	return c.Jobs.Schedule
+}
+
+// JobTimezone returns the IANA timezone a job's schedule is evaluated in, its
+// own timezone when set and the jobs timezone otherwise. Empty means the local
+// timezone of the host.
+func (c *Config) JobTimezone(jobName string) string {
+	if jobConfig, exists := c.JobDefs[jobName]; exists && jobConfig.Timezone != "" {
+		return jobConfig.Timezone
+	}
+	return c.Jobs.Timezone
+}
+
+// JobCronSpec returns the expression the scheduler registers for a job: its
+// schedule, prefixed with CRON_TZ when a timezone is configured
+func (c *Config) JobCronSpec(jobName string) string {
+	schedule := c.JobSchedule(jobName)
+	if tz := c.JobTimezone(jobName); tz != "" && schedule != "" {
+		return "CRON_TZ=" + tz + " " + schedule
+	}
+	return schedule
+}
+
+// JobCronSchedule parses the schedule the scheduler registers for a job
+func (c *Config) JobCronSchedule(jobName string) (cron.Schedule, error) {
+	return cronParser.Parse(c.JobCronSpec(jobName))
+}
+
+// JobNextRun returns when the schedule of a job fires next after t, in the
+// job's timezone. Intervals count from t, while the scheduler counts them from
+// its start.
+func (c *Config) JobNextRun(jobName string, t time.Time) (time.Time, error) {
+	schedule, err := c.JobCronSchedule(jobName)
+	if err != nil {
+		return time.Time{}, err
+	}
+	next := schedule.Next(t)
+	if tz := c.JobTimezone(jobName); tz != "" {
+		if loc, err := time.LoadLocation(tz); err == nil {
+			next = next.In(loc)
+		}
+	}
+	return next, nil
+}
+
+// validateInterval checks that at most one of schedule and every is set and
+// that every is a whole number of seconds, the resolution of the scheduler
+func validateInterval(schedule string, every time.Duration) error {
+	if schedule != "" && every != 0 {
+		return fmt.Errorf("schedule and every cannot both be set")
+	}
+	if every < 0 {
+		return fmt.Errorf("every must be a non-negative duration such as \"30m\"")
+	}
+	if every != 0 && (every < time.Second || every%time.Second != 0) {
+		return fmt.Errorf("every must be a whole number of seconds, got %s", every)
+	}
+	return nil
+}
+
+// JobScheduleJitter returns the maximum random delay of a job's scheduled runs,
+// its own schedule_jitter when set and the jobs schedule_jitter otherwise
+func (c *Config) JobScheduleJitter(jobName string) time.Duration {
+	if jobConfig, exists := c.JobDefs[jobName]; exists && jobConfig.ScheduleJitter > 0 {
+		return jobConfig.ScheduleJitter
+	}
+	return c.Jobs.ScheduleJitter
+}
+
+// JobRunsOnStartup reports whether a job is synced once at startup, by its own
+// run_on_startup when set and the jobs run_on_startup otherwise
+func (c *Config) JobRunsOnStartup(jobName string) bool {
+	if jobConfig, exists := c.JobDefs[jobName]; exists && jobConfig.RunOnStartup != nil {
+		return *jobConfig.RunOnStartup
+	}
+	return c.Jobs.RunOnStartup
+}
+
+// SummaryPath returns where the run summary of a job is written, empty when disabled
+func (c *Config) SummaryPath(jobName string) string {
+	return strings.ReplaceAll(c.Service.SummaryPath, "{job}", jobName)
+}
+
+// ValidateJobName rejects job names that cannot be mapped to a cache directory
+func ValidateJobName(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return fmt.Errorf("job name cannot be empty")
+	}
+	for _, r := range name {
+		if r == '/' || r == '\\' {
+			return fmt.Errorf("job name '%s' must not contain path separators, use '-' instead (e.g. '%s')", name, strings.NewReplacer("/", "-", "\\", "-").Replace(name))
+		}
+		if unicode.IsControl(r) {
+			return fmt.Errorf("job name %q must not contain control characters", name)
+		}
+	}
+	return nil
+}
+
+// validateTLS checks CA bundles exist and flags disabled TLS verification in production
+func (c *Config) validateTLS(jobName string, jobConfig *JobConfig) error {
+	caPaths := []string{jobConfig.CABundlePath}
+	skipVerify := false
+	for _, target := range jobConfig.Targets {
+		caPaths = append(caPaths, target.CABundlePath)
+		skipVerify = skipVerify || jobConfig.SkipsTLSVerify(&target)
+	}
+	// The source has no target to override the job's setting
+	skipVerify = skipVerify || jobConfig.TLSSkipVerify
+
+	for _, path := range caPaths {
+		if path == "" {
+			continue
+		}
+		if info, err := os.Stat(path); err != nil || info.IsDir() {
+			return fmt.Errorf("ca_bundle_path %s is not a readable file for job '%s'", path, jobName)
+		}
+	}
+
+	if skipVerify && c.IsProduction() {
+		c.Warnings = append(c.Warnings, fmt.Sprintf("SECURITY: job '%s' disables TLS certificate verification (tls_skip_verify) in a production environment", jobName))
+	}
+
+	return nil
+}
+
+// checkSSHKey verifies a private key file exists, returning a warning when
+// its permissions allow other users to read it
+func checkSSHKey(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("ssh key %s is not accessible: %w", path, err)
+	}
+	if info.IsDir() {
+		return "", fmt.Errorf("ssh key %s is a directory", path)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0004 != 0 {
+		return fmt.Sprintf("ssh key %s is world-readable, restrict it with chmod 600", path), nil
+	}
+	return "", nil
+}
+
 func (jc *JobConfig) ShouldSyncBranch(branchName string) bool {
 	// Check against all patterns in branches list
 	for _, pattern := range jc.Branches {
@@ -320,11 +1834,68 @@ func (jc *JobConfig) ShouldSyncBranch(branchName string) bool {
 	return false
 }
 
+// ShouldSyncBranch reports whether a branch of an aggregation source matches
+// its branch patterns
+func (sc *SourceConfig) ShouldSyncBranch(branchName string) bool {
+	for _, pattern := range sc.Branches {
+		if matchesBranchPattern(branchName, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsExplicitBranch reports whether the branch is listed by name rather than
+// only matched through a wildcard pattern
+func (jc *JobConfig) IsExplicitBranch(branchName string) bool {
+	for _, pattern := range jc.Branches {
+		if !strings.ContainsAny(pattern, "*?[") && pattern == branchName {
+			return true
+		}
+	}
+	return false
+}
+
+// BranchPriorityRank returns the index of the first branch_priority pattern
+// matching the branch, or len(BranchPriority) when none match
+func (jc *JobConfig) BranchPriorityRank(branchName string) int {
+	for i, pattern := range jc.BranchPriority {
+		if matchesBranchPattern(branchName, pattern) {
+			return i
+		}
+	}
+	return len(jc.BranchPriority)
+}
+
+// SkipsTLSVerify reports whether TLS certificates go unverified for a target,
+// by its own tls_skip_verify when set and the job's otherwise. A nil target
+// is the source.
+func (jc *JobConfig) SkipsTLSVerify(target *TargetConfig) bool {
+	if target != nil && target.TLSSkipVerify != nil {
+		return *target.TLSSkipVerify
+	}
+	return jc.TLSSkipVerify
+}
+
 func (jc *JobConfig) GetSyncBranches() []string {
 	return jc.Branches
 }
 
 func matchesBranchPattern(branchName, pattern string) bool {
+	// Patterns with a single "*" keep their original meaning, where the
+	// wildcard also matches across slashes (e.g. "hotfix/*" matches "hotfix/a/b")
+	if isLegacyPattern(pattern) {
+		return matchesLegacyPattern(branchName, pattern)
+	}
+
+	re, err := globToRegexp(pattern)
+	if err != nil {
+		return false
+	}
+	return re.MatchString(branchName)
+}
+
+func matchesLegacyPattern(branchName, pattern string) bool {
 	// Simple wildcard matching
 	if pattern == "*" {
 		return true
@@ -346,7 +1917,8 @@ func matchesBranchPattern(branchName, pattern string) bool {
 	if strings.Contains(pattern, "*") {
 		parts := strings.Split(pattern, "*")
 		if len(parts) == 2 {
-			return strings.HasPrefix(branchName, parts[0]) && strings.HasSuffix(branchName, parts[1])
+			return len(branchName) >= len(parts[0])+len(parts[1]) &&
+				strings.HasPrefix(branchName, parts[0]) && strings.HasSuffix(branchName, parts[1])
 		}
 	}
 
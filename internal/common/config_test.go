@@ -0,0 +1,259 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadFixture(t *testing.T) {
+	t.Setenv("FIXTURE_TOKEN", "secret-token")
+	t.Setenv("LOG_LEVEL", "warn")
+
+	cfg, err := Load(filepath.Join("testdata", "jobs.toml"))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if cfg.Service.Name != "gitsync-test" || cfg.Service.Environment != "staging" {
+		t.Errorf("service = %+v, want gitsync-test in staging", cfg.Service)
+	}
+	if cfg.Store.Path != "data/gitsync.db" {
+		t.Errorf("store path = %q, want data/gitsync.db", cfg.Store.Path)
+	}
+	// LOG_LEVEL overrides the file, the format comes from the file
+	if cfg.Logging.Level != "warn" || cfg.Logging.Format != "json" {
+		t.Errorf("logging = %s/%s, want warn from the environment and json from the file", cfg.Logging.Level, cfg.Logging.Format)
+	}
+	if got := strings.Join(cfg.Jobs.Names, ","); got != "mirror,backup" {
+		t.Errorf("job names = %s, want mirror,backup", got)
+	}
+	if cfg.Jobs.Timeout != 20*time.Minute {
+		t.Errorf("jobs timeout = %s, want 20m", cfg.Jobs.Timeout)
+	}
+
+	mirror, ok := cfg.GetJobConfig("mirror")
+	if !ok {
+		t.Fatal("job mirror not loaded")
+	}
+	if !mirror.Enabled || !mirror.Override || mirror.Every != 30*time.Minute {
+		t.Errorf("mirror = enabled %v, override %v, every %s, want enabled, override, 30m", mirror.Enabled, mirror.Override, mirror.Every)
+	}
+	if got := strings.Join(mirror.Branches, ","); got != "main,release/*" {
+		t.Errorf("mirror branches = %s, want main,release/*", got)
+	}
+	if mirror.GitToken != "secret-token" {
+		t.Errorf("mirror token = %q, want the value of git_token_env", mirror.GitToken)
+	}
+	if len(mirror.Targets) != 1 || mirror.Targets[0].URL != "https://gitlab.com/example/project.git" {
+		t.Errorf("mirror targets = %+v, want the one gitlab URL", mirror.Targets)
+	}
+	if got := cfg.JobCronSpec("mirror"); got != "@every 30m0s" {
+		t.Errorf("mirror schedule = %q, want its own every", got)
+	}
+
+	backup, _ := cfg.GetJobConfig("backup")
+	if backup.Enabled {
+		t.Error("backup enabled, want disabled")
+	}
+	if got := cfg.JobCronSpec("backup"); got != "0 */5 * * * *" {
+		t.Errorf("backup schedule = %q, want the [jobs] schedule", got)
+	}
+	if len(backup.Targets) != 2 || backup.Targets[1].HTTPSProxy != "http://proxy.example.com:3128" {
+		t.Errorf("backup targets = %+v, want two with the proxy on the second", backup.Targets)
+	}
+	if got := cfg.GetEnabledJobs(); len(got) != 1 || got[0] != "mirror" {
+		t.Errorf("enabled jobs = %v, want mirror only", got)
+	}
+}
+
+func TestLoadJobsArray(t *testing.T) {
+	cfg, err := Load(filepath.Join("testdata", "jobs_array.toml"))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if got := strings.Join(cfg.Jobs.Names, ","); got != "mirror,backup" {
+		t.Errorf("job names = %s, want mirror,backup in the order of the array", got)
+	}
+	mirror, ok := cfg.GetJobConfig("mirror")
+	if !ok || mirror.Source != "https://github.com/example/project.git" || len(mirror.Targets) != 1 {
+		t.Fatalf("mirror = %+v, want the job of the first [[jobs]] table", mirror)
+	}
+	if backup, ok := cfg.GetJobConfig("backup"); !ok || backup.Enabled {
+		t.Errorf("backup = %+v, want the disabled job of the second table", backup)
+	}
+
+	var warned bool
+	for _, warning := range cfg.Warnings {
+		warned = warned || strings.Contains(warning, "[[jobs]] array, which is deprecated")
+	}
+	if !warned {
+		t.Errorf("warnings = %q, want one on the [[jobs]] layout", cfg.Warnings)
+	}
+
+	// Entries that cannot become jobs are refused
+	for name, content := range map[string]string{
+		"jobs entry 1 has no name":        "[[jobs]]\nsource = \"https://github.com/example/project.git\"\n",
+		"clashes with the [mirror] table": "[[jobs]]\nname = \"mirror\"\n\n[mirror]\nsource = \"https://github.com/example/project.git\"\n",
+	} {
+		path := filepath.Join(t.TempDir(), "gitsync.toml")
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Load(path); err == nil || !strings.Contains(err.Error(), name) {
+			t.Errorf("Load = %v, want an error containing %q", err, name)
+		}
+	}
+}
+
+func TestLoadFixtureValidationErrors(t *testing.T) {
+	tests := []struct {
+		file string
+		want string
+	}{
+		{"missing_source.toml", "source cannot be empty for job 'broken'"},
+		{"unknown_job.toml", "job definition 'missing' not found"},
+		{"bad_schedule.toml", "invalid schedule 'every now and then' for job 'mirror'"},
+		{"same_target.toml", "is the same repository as the source for job 'loop'"},
+		{"bad_duration.toml", "sync_delay must be a non-negative duration such as \"5m\" for job 'mirror'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			_, err := Load(filepath.Join("testdata", tt.file))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Load = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetDuration(t *testing.T) {
+	m := map[string]interface{}{
+		"string":   "1m30s",
+		"int":      int64(90),
+		"float":    1.5,
+		"invalid":  "5 mins",
+		"negative": "-5m",
+		"bool":     true,
+	}
+	tests := []struct {
+		key  string
+		want time.Duration
+	}{
+		{"string", 90 * time.Second},
+		{"int", 90 * time.Second},
+		{"float", 1500 * time.Millisecond},
+		{"invalid", -1},
+		{"negative", -5 * time.Minute},
+		{"bool", time.Hour},
+		{"missing", time.Hour},
+	}
+	for _, tt := range tests {
+		if got := getDuration(m, tt.key, time.Hour); got != tt.want {
+			t.Errorf("getDuration(%s) = %s, want %s", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestSkipsTLSVerify(t *testing.T) {
+	inherit := parseTargetConfig(map[string]interface{}{"url": "https://a.example.com/repo.git"})
+	verify := parseTargetConfig(map[string]interface{}{"url": "https://b.example.com/repo.git", "tls_skip_verify": false})
+	skip := parseTargetConfig(map[string]interface{}{"url": "https://c.example.com/repo.git", "tls_skip_verify": true})
+	if inherit.TLSSkipVerify != nil {
+		t.Fatalf("tls_skip_verify of a target without it = %v, want unset", *inherit.TLSSkipVerify)
+	}
+
+	tests := []struct {
+		job    bool
+		target *TargetConfig
+		want   bool
+	}{
+		{false, nil, false},
+		{true, nil, true},
+		{false, &inherit, false},
+		{true, &inherit, true},
+		{true, &verify, false},
+		{false, &verify, false},
+		{false, &skip, true},
+		{true, &skip, true},
+	}
+	for _, tt := range tests {
+		jobConfig := &JobConfig{TLSSkipVerify: tt.job}
+		if got := jobConfig.SkipsTLSVerify(tt.target); got != tt.want {
+			name := "source"
+			if tt.target != nil {
+				name = tt.target.URL
+			}
+			t.Errorf("job tls_skip_verify %v, %s: SkipsTLSVerify = %v, want %v", tt.job, name, got, tt.want)
+		}
+	}
+}
+
+func TestStarterConfigLoads(t *testing.T) {
+	content, err := StarterConfig()
+	if err != nil {
+		t.Fatalf("StarterConfig: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "gitsync.toml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load of the starter configuration: %v", err)
+	}
+	if _, ok := cfg.GetJobConfig("example-sync"); !ok {
+		t.Error("starter configuration has no example-sync job")
+	}
+	if len(cfg.UnknownKeys) > 0 {
+		t.Errorf("starter configuration has unknown keys: %v", cfg.UnknownKeys)
+	}
+}
+
+func TestWriteStarterConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config", "gitsync.toml")
+	if err := WriteStarterConfig(path, false); err != nil {
+		t.Fatalf("WriteStarterConfig: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("starter configuration not written: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 && os.PathSeparator == '/' {
+		t.Errorf("permissions = %o, want 0600", perm)
+	}
+
+	if err := os.WriteFile(path, []byte("# edited\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteStarterConfig(path, false); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("second WriteStarterConfig = %v, want a refusal to overwrite", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "# edited\n" {
+		t.Error("existing configuration replaced without force")
+	}
+
+	if err := WriteStarterConfig(path, true); err != nil {
+		t.Fatalf("WriteStarterConfig with force: %v", err)
+	}
+	if data, _ := os.ReadFile(path); !strings.Contains(string(data), "example-sync") {
+		t.Error("force did not replace the configuration")
+	}
+}
+
+func TestExampleConfigLoads(t *testing.T) {
+	cfg, err := Load(filepath.Join("..", "..", "deployments", "gitsync.example.toml"))
+	if err != nil {
+		t.Fatalf("Load of the example configuration: %v", err)
+	}
+	if len(cfg.Jobs.Names) == 0 {
+		t.Error("example configuration has no jobs")
+	}
+	if len(cfg.UnknownKeys) > 0 {
+		t.Errorf("example configuration has unknown keys: %v", cfg.UnknownKeys)
+	}
+}
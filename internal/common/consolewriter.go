@@ -0,0 +1,82 @@
+package common
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/phuslu/log"
+	"github.com/ternarybob/arbor/models"
+	"github.com/ternarybob/arbor/writers"
+)
+
+// consoleWriter prints arbor log events to the console, as JSON lines with
+// the structured fields as top-level keys or as text lines without colors.
+// arbor's console writer only prints colored text, its file writer has the
+// JSON output.
+type consoleWriter struct {
+	logger log.Logger
+	floor  log.Level // Lines below it are left out whatever the logger level
+}
+
+func newJSONConsoleWriter(out io.Writer) *consoleWriter {
+	return &consoleWriter{
+		logger: log.Logger{
+			Level:  log.InfoLevel,
+			Writer: log.IOWriter{Writer: out},
+		},
+	}
+}
+
+// newTextConsoleWriter prints the text lines of arbor's console writer, with
+// ANSI colors only when color is set
+func newTextConsoleWriter(out io.Writer, color bool) *consoleWriter {
+	return &consoleWriter{
+		logger: log.Logger{
+			Level:      log.InfoLevel,
+			TimeFormat: "15:04:05",
+			Writer: &log.ConsoleWriter{
+				Writer:         out,
+				ColorOutput:    color,
+				EndWithMessage: true,
+			},
+		},
+	}
+}
+
+func (w *consoleWriter) WithLevel(level log.Level) writers.IWriter {
+	if level < w.floor {
+		level = w.floor
+	}
+	w.logger.SetLevel(level)
+	return w
+}
+
+func (w *consoleWriter) Write(data []byte) (int, error) {
+	var event models.LogEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		w.logger.Info().Str("raw", string(data)).Msg("Undecodable log event")
+		return len(data), nil
+	}
+
+	entry := w.logger.WithLevel(event.Level)
+	if entry == nil {
+		return len(data), nil // Below the level
+	}
+	if event.Prefix != "" {
+		entry = entry.Str("prefix", event.Prefix)
+	}
+	if event.Function != "" {
+		entry = entry.Str("function", event.Function)
+	}
+	if event.CorrelationID != "" {
+		entry = entry.Str("correlationid", event.CorrelationID)
+	}
+	for key, value := range event.Fields {
+		entry = entry.Interface(key, value)
+	}
+	if event.Error != "" {
+		entry = entry.Str("error", event.Error)
+	}
+	entry.Msg(event.Message)
+	return len(data), nil
+}
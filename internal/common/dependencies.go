@@ -0,0 +1,125 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+)
+
+// validateDependencies checks depends_on of every job. Dependencies must be
+// configured jobs on the same schedule, since a dependent job runs on the
+// ticks its dependencies run on, and must not form a cycle.
+func (c *Config) validateDependencies() error {
+	configured := make(map[string]bool, len(c.Jobs.Names))
+	for _, jobName := range c.Jobs.Names {
+		configured[jobName] = true
+	}
+
+	for i, jobName := range c.Jobs.Names {
+		jobConfig := c.JobDefs[jobName]
+		for _, dependency := range jobConfig.DependsOn {
+			if !configured[dependency] {
+				return fmt.Errorf("job[%d]: depends_on names unknown job '%s' for job '%s'", i, dependency, jobName)
+			}
+			if dependency == jobName {
+				continue // Reported as a cycle
+			}
+			if spec, depSpec := c.JobCronSpec(jobName), c.JobCronSpec(dependency); spec != depSpec {
+				return fmt.Errorf("job[%d]: job '%s' depends on '%s', which runs on a different schedule ('%s' and '%s'), dependent jobs need the schedule of their dependencies", i, jobName, dependency, spec, depSpec)
+			}
+			if jobConfig.Enabled && !c.JobDefs[dependency].Enabled {
+				c.Warnings = append(c.Warnings, fmt.Sprintf("job '%s' depends on disabled job '%s', its scheduled runs are skipped", jobName, dependency))
+			}
+		}
+	}
+
+	if cycle := c.dependencyCycle(); cycle != nil {
+		return fmt.Errorf("job dependency cycle: %s", strings.Join(cycle, " -> "))
+	}
+	return nil
+}
+
+// dependencyCycle returns the jobs of a depends_on cycle, starting and ending
+// with the same job, or nil when there is none
+func (c *Config) dependencyCycle() []string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int)
+	var path []string
+
+	var visit func(jobName string) []string
+	visit = func(jobName string) []string {
+		switch state[jobName] {
+		case visiting:
+			for i, name := range path {
+				if name == jobName {
+					return append(append([]string{}, path[i:]...), jobName)
+				}
+			}
+		case visited:
+			return nil
+		}
+
+		state[jobName] = visiting
+		path = append(path, jobName)
+		if jobConfig, exists := c.JobDefs[jobName]; exists {
+			for _, dependency := range jobConfig.DependsOn {
+				if cycle := visit(dependency); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[jobName] = visited
+		return nil
+	}
+
+	for _, jobName := range c.Jobs.Names {
+		if cycle := visit(jobName); cycle != nil {
+			return cycle
+		}
+	}
+	return nil
+}
+
+// JobDependencies returns every job a job depends on, directly or through
+// other jobs, in an order that runs each dependency before its dependents
+func (c *Config) JobDependencies(jobName string) []string {
+	order := c.DependencyOrder([]string{jobName}, true)
+	return order[:len(order)-1]
+}
+
+// DependencyOrder sorts jobs so each runs after the jobs it depends on,
+// otherwise keeping their order. With transitive set, dependencies missing
+// from jobNames are added, otherwise they are left out.
+func (c *Config) DependencyOrder(jobNames []string, transitive bool) []string {
+	included := make(map[string]bool, len(jobNames))
+	for _, jobName := range jobNames {
+		included[jobName] = true
+	}
+
+	seen := make(map[string]bool)
+	var order []string
+	var visit func(jobName string)
+	visit = func(jobName string) {
+		if seen[jobName] {
+			return
+		}
+		seen[jobName] = true
+		if jobConfig, exists := c.JobDefs[jobName]; exists {
+			for _, dependency := range jobConfig.DependsOn {
+				if transitive || included[dependency] {
+					visit(dependency)
+				}
+			}
+		}
+		order = append(order, jobName)
+	}
+
+	for _, jobName := range jobNames {
+		visit(jobName)
+	}
+	return order
+}
@@ -0,0 +1,208 @@
+package common
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// DefaultDiscoverRefresh is how often a discovery job lists its repositories
+// when refresh is not set
+const DefaultDiscoverRefresh = time.Hour
+
+// DiscoverConfig turns a job into a discovery job, given as a [job.discover]
+// table. It syncs nothing itself: the repositories of a GitHub organization
+// or GitLab group are listed through the provider API and each becomes a job
+// of its own, named <job>-<repository>, with the settings of the discovery job.
+type DiscoverConfig struct {
+	Provider   string        `toml:"provider"`   // github or gitlab
+	Org        string        `toml:"org"`        // GitHub organization whose repositories are synced
+	Group      string        `toml:"group"`      // GitLab group path whose projects are synced
+	Subgroups  bool          `toml:"subgroups"`  // Include the projects of GitLab subgroups, the default
+	APIURL     string        `toml:"api_url"`    // API root, the provider's public API when empty
+	Include    []string      `toml:"include"`    // Repository path patterns, every repository when empty
+	Exclude    []string      `toml:"exclude"`    // Repository path patterns left out, over include
+	Visibility []string      `toml:"visibility"` // public, internal or private, every visibility when empty
+	Refresh    time.Duration `toml:"refresh"`    // How often the repositories are listed again
+	Protocol   string        `toml:"protocol"`   // Clone URLs used as sources: https (default) or ssh
+	Token      string        `toml:"token"`      // API token, the job's git_token when empty
+	TokenEnv   string        `toml:"token_env"`
+}
+
+// DiscoveredRepo is a repository a discovery job listed
+type DiscoveredRepo struct {
+	Path       string // Path within the organization or group, subgroup/repo for a GitLab subgroup, replaces {path} in targets
+	Name       string // Path with / replaced by -, names its job and replaces {name} in targets
+	URL        string // Clone URL, the source of its job
+	Visibility string // public, internal or private
+}
+
+// NewDiscoveredRepo creates a discovered repository from its path within the
+// organization or group
+func NewDiscoveredRepo(repoPath, url, visibility string) DiscoveredRepo {
+	return DiscoveredRepo{
+		Path:       repoPath,
+		Name:       strings.ReplaceAll(repoPath, "/", "-"),
+		URL:        url,
+		Visibility: visibility,
+	}
+}
+
+func parseDiscoverConfig(discoverMap map[string]interface{}) *DiscoverConfig {
+	return &DiscoverConfig{
+		Provider:   getString(discoverMap, "provider", ProviderGitHub),
+		Org:        getString(discoverMap, "org", ""),
+		Group:      strings.Trim(getString(discoverMap, "group", ""), "/"),
+		Subgroups:  getBool(discoverMap, "subgroups", true),
+		APIURL:     strings.TrimSuffix(getString(discoverMap, "api_url", ""), "/"),
+		Include:    getStringSlice(discoverMap, "include"),
+		Exclude:    getStringSlice(discoverMap, "exclude"),
+		Visibility: getStringSlice(discoverMap, "visibility"),
+		Refresh:    getDuration(discoverMap, "refresh", DefaultDiscoverRefresh),
+		Protocol:   getString(discoverMap, "protocol", "https"),
+		Token:      getString(discoverMap, "token", ""),
+		TokenEnv:   getString(discoverMap, "token_env", ""),
+	}
+}
+
+// Discovers reports whether the job is a discovery job, which is never run
+// itself
+func (jc *JobConfig) Discovers() bool {
+	return jc.Discover != nil
+}
+
+// APIToken returns the token a discovery job calls the provider API with
+func (jc *JobConfig) APIToken() string {
+	if jc.Discover.Token != "" {
+		return jc.Discover.Token
+	}
+	return jc.GitToken
+}
+
+// Label names what a discovery job lists, such as "github org acme"
+func (d *DiscoverConfig) Label() string {
+	if d.Provider == ProviderGitLab {
+		return "gitlab group " + d.Group
+	}
+	return fmt.Sprintf("%s org %s", d.Provider, d.Org)
+}
+
+// Selects reports whether a repository passes visibility, include and
+// exclude. Patterns match the path, a * does not cross a subgroup.
+func (d *DiscoverConfig) Selects(repo DiscoveredRepo) bool {
+	if len(d.Visibility) > 0 && !slices.Contains(d.Visibility, repo.Visibility) {
+		return false
+	}
+	for _, pattern := range d.Exclude {
+		if matched, _ := path.Match(pattern, repo.Path); matched {
+			return false
+		}
+	}
+	if len(d.Include) == 0 {
+		return true
+	}
+	for _, pattern := range d.Include {
+		if matched, _ := path.Match(pattern, repo.Path); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// validateDiscover checks the [job.discover] table of a discovery job
+func validateDiscover(jobName string, jobConfig *JobConfig) error {
+	discover := jobConfig.Discover
+	switch {
+	case jobConfig.Source != "" || jobConfig.Aggregates():
+		return fmt.Errorf("a discovery job takes no source, every discovered repository is one, for job '%s'", jobName)
+	case jobConfig.Bidirectional:
+		return fmt.Errorf("bidirectional cannot be combined with discover for job '%s'", jobName)
+	case discover.Provider != ProviderGitHub && discover.Provider != ProviderGitLab:
+		return fmt.Errorf("discover provider '%s' is not supported, use github or gitlab, for job '%s'", discover.Provider, jobName)
+	case discover.Provider == ProviderGitHub && discover.Org == "":
+		return fmt.Errorf("discover org cannot be empty for job '%s'", jobName)
+	case discover.Provider == ProviderGitHub && discover.Group != "":
+		return fmt.Errorf("discover group is for gitlab, use org for job '%s'", jobName)
+	case discover.Provider == ProviderGitLab && discover.Group == "":
+		return fmt.Errorf("discover group cannot be empty for job '%s'", jobName)
+	case discover.Provider == ProviderGitLab && discover.Org != "":
+		return fmt.Errorf("discover org is for github, use group for job '%s'", jobName)
+	case discover.Protocol != "https" && discover.Protocol != "ssh":
+		return fmt.Errorf("discover protocol '%s' is not supported, use https or ssh, for job '%s'", discover.Protocol, jobName)
+	case discover.Refresh < time.Minute:
+		return fmt.Errorf("discover refresh must be at least 1m for job '%s'", jobName)
+	case discover.TokenEnv != "" && discover.Token == "":
+		return fmt.Errorf("discover token_env %s is not set for job '%s'", discover.TokenEnv, jobName)
+	}
+
+	for _, pattern := range append(append([]string{}, discover.Include...), discover.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid discover repository pattern '%s' for job '%s': %w", pattern, jobName, err)
+		}
+	}
+	for _, visibility := range discover.Visibility {
+		if visibility != "public" && visibility != "internal" && visibility != "private" {
+			return fmt.Errorf("discover visibility '%s' is not supported, use public, internal or private, for job '%s'", visibility, jobName)
+		}
+	}
+	// Without {name} or {path} every repository would be pushed into the same target
+	for _, target := range jobConfig.Targets {
+		if !strings.Contains(target.URL, "{name}") && !strings.Contains(target.URL, "{path}") {
+			return fmt.Errorf("target %s of a discovery job needs a {name} or {path} placeholder for the repository for job '%s'", target.URL, jobName)
+		}
+	}
+	return nil
+}
+
+// WithDiscovered returns a copy of the configuration with a job for every
+// repository listed by each discovery job, keyed by the discovery job's name.
+// A discovered job is a copy of its discovery job with the repository as
+// source and {name} and {path} in the targets replaced. A job whose name is already
+// taken, or that does not validate, is left out with a warning.
+func (c *Config) WithDiscovered(repos map[string][]DiscoveredRepo) *Config {
+	discovered := *c
+	discovered.Jobs.Names = append([]string{}, c.Jobs.Names...)
+	discovered.JobDefs = make(map[string]*JobConfig, len(c.JobDefs))
+	for name, jobConfig := range c.JobDefs {
+		discovered.JobDefs[name] = jobConfig
+	}
+	discovered.Warnings = append([]string{}, c.Warnings...)
+
+	for _, discoveryName := range c.Jobs.Names {
+		discovery, exists := c.JobDefs[discoveryName]
+		if !exists || !discovery.Discovers() {
+			continue
+		}
+		for _, repo := range repos[discoveryName] {
+			jobName := discoveryName + "-" + repo.Name
+			if _, taken := discovered.JobDefs[jobName]; taken {
+				discovered.Warnings = append(discovered.Warnings, fmt.Sprintf("job '%s': discovered repository %s is left out, a job named '%s' already exists", discoveryName, repo.Path, jobName))
+				continue
+			}
+
+			jobConfig := *discovery
+			jobConfig.Discover = nil
+			jobConfig.DiscoveredBy = discoveryName
+			jobConfig.Source = repo.URL
+			if jobConfig.Description == "" {
+				jobConfig.Description = "Discovered in " + discovery.Discover.Label()
+			}
+			jobConfig.Targets = make([]TargetConfig, len(discovery.Targets))
+			for i, target := range discovery.Targets {
+				target.URL = strings.NewReplacer("{name}", repo.Name, "{path}", repo.Path).Replace(target.URL)
+				jobConfig.Targets[i] = target
+			}
+
+			discovered.JobDefs[jobName] = &jobConfig
+			discovered.Jobs.Names = append(discovered.Jobs.Names, jobName)
+			if err := discovered.validateJob(len(discovered.Jobs.Names)-1, jobName); err != nil {
+				discovered.Warnings = append(discovered.Warnings, fmt.Sprintf("job '%s': discovered repository %s is left out: %v", discoveryName, repo.Path, err))
+				delete(discovered.JobDefs, jobName)
+				discovered.Jobs.Names = discovered.Jobs.Names[:len(discovered.Jobs.Names)-1]
+			}
+		}
+	}
+	return &discovered
+}
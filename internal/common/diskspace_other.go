@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package common
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// FreeSpace is not available on this platform
+func FreeSpace(string) (uint64, error) {
+	return 0, fmt.Errorf("free space cannot be measured on %s", runtime.GOOS)
+}
@@ -0,0 +1,17 @@
+package common
+
+import "golang.org/x/sys/windows"
+
+// FreeSpace returns the bytes available to the current user on the volume
+// holding path
+func FreeSpace(path string) (uint64, error) {
+	pathPtr, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	var available uint64
+	if err := windows.GetDiskFreeSpaceEx(pathPtr, &available, nil, nil); err != nil {
+		return 0, err
+	}
+	return available, nil
+}
@@ -0,0 +1,118 @@
+package common
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// redacted replaces secrets in the effective configuration. Secrets that are
+// empty are printed as empty strings, so it still shows whether one is set.
+const redacted = "<redacted>"
+
+// secretKeys are settings whose values are never printed
+var secretKeys = map[string]bool{
+	"git_token":     true,
+	"token":         true,
+	"api_token":     true,
+	"github_secret": true,
+	"gitlab_secret": true,
+	"password":      true,
+	"slack_urls":    true, // Slack incoming-webhook URLs carry their secret
+	"webhook_urls":  true,
+}
+
+// Effective returns the configuration as gitsync runs it, keyed like the
+// configuration file: environment variables are expanded, the *_env settings
+// resolved and the [jobs] defaults applied to every job listed in names.
+// Secrets and values read from *_env variables are redacted, as are
+// credentials in URLs.
+func (c *Config) Effective() map[string]interface{} {
+	effective := effectiveStruct(reflect.ValueOf(*c))
+
+	for _, jobName := range c.Jobs.Names {
+		jobConfig, exists := c.JobDefs[jobName]
+		if !exists {
+			continue
+		}
+		job := effectiveStruct(reflect.ValueOf(*jobConfig))
+		// source and the [[job.source]] tables share their key
+		if !jobConfig.Aggregates() {
+			job["source"] = RedactURLCredentials(jobConfig.Source)
+		}
+		delete(job, "every")
+		job["schedule"] = c.JobSchedule(jobName)
+		job["timezone"] = c.JobTimezone(jobName)
+		job["schedule_jitter"] = formatDuration(c.JobScheduleJitter(jobName))
+		job["run_on_startup"] = c.JobRunsOnStartup(jobName)
+		effective[jobName] = job
+	}
+	return effective
+}
+
+func effectiveStruct(v reflect.Value) map[string]interface{} {
+	table := make(map[string]interface{})
+	for i := 0; i < v.NumField(); i++ {
+		name, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("toml"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		if value := effectiveValue(v.Field(i)); value != nil {
+			table[name] = value
+		}
+	}
+
+	for name, value := range table {
+		// Set by an environment variable, such as git_token by git_token_env
+		// or ssh_key_path by ssh_key_env
+		env, _ := table[strings.TrimSuffix(name, "_path")+"_env"].(string)
+		if secretKeys[name] || env != "" {
+			table[name] = redactValue(value)
+		}
+	}
+	return table
+}
+
+// effectiveValue converts a field to a value TOML and JSON print the way the
+// configuration file spells it, nil for unset optional tables
+func effectiveValue(v reflect.Value) interface{} {
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		return formatDuration(time.Duration(v.Int()))
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+		return effectiveValue(v.Elem())
+	case reflect.Struct:
+		return effectiveStruct(v)
+	case reflect.Slice:
+		items := make([]interface{}, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			items = append(items, effectiveValue(v.Index(i)))
+		}
+		return items
+	case reflect.String:
+		return RedactURLCredentials(v.String())
+	}
+	return v.Interface()
+}
+
+func redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		if v == "" {
+			return ""
+		}
+		return redacted
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = redactValue(item)
+		}
+		return items
+	}
+	return value
+}
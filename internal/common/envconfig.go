@@ -0,0 +1,103 @@
+package common
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envJobName is the job of an environment-only configuration unless
+// GITSYNC_JOB_NAME names it
+const envJobName = "mirror"
+
+// EnvConfigured reports whether the environment describes a job, which is
+// used when there is no configuration file
+func EnvConfigured() bool {
+	return os.Getenv("GITSYNC_SOURCE") != ""
+}
+
+// envRawConfig builds the raw configuration of a single job from GITSYNC_
+// variables, shaped like a parsed configuration file so the file defaults
+// apply. Lists are comma separated.
+func envRawConfig() (map[string]interface{}, error) {
+	jobName := envOr("GITSYNC_JOB_NAME", envJobName)
+
+	job := map[string]interface{}{
+		"source":  os.Getenv("GITSYNC_SOURCE"),
+		"targets": envList("GITSYNC_TARGETS"),
+	}
+	jobs := map[string]interface{}{
+		"names": []interface{}{jobName},
+	}
+	raw := map[string]interface{}{
+		"jobs":  jobs,
+		jobName: job,
+	}
+
+	settings := map[string]string{
+		"GITSYNC_USERNAME":     "git_username",
+		"GITSYNC_TOKEN_ENV":    "git_token_env",
+		"GITSYNC_SSH_KEY_PATH": "ssh_key_path",
+		"GITSYNC_SSH_KEY_ENV":  "ssh_key_env",
+	}
+	for env, key := range settings {
+		if value := os.Getenv(env); value != "" {
+			job[key] = value
+		}
+	}
+	if branches := envList("GITSYNC_BRANCHES"); len(branches) > 0 {
+		job["branches"] = branches
+	}
+	for env, key := range map[string]string{"GITSYNC_OVERRIDE": "override", "GITSYNC_SYNC_TAGS": "sync_tags"} {
+		if value := os.Getenv(env); value != "" {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: '%s' is not true or false", env, value)
+			}
+			job[key] = b
+		}
+	}
+
+	if schedule := os.Getenv("GITSYNC_SCHEDULE"); schedule != "" {
+		jobs["schedule"] = schedule
+	}
+	for env, key := range map[string]string{"GITSYNC_EVERY": "every", "GITSYNC_TIMEOUT": "timeout"} {
+		if value := os.Getenv(env); value != "" {
+			if _, err := time.ParseDuration(value); err != nil {
+				return nil, fmt.Errorf("%s: %w", env, err)
+			}
+			jobs[key] = value
+		}
+	}
+	if _, set := jobs["schedule"]; !set {
+		if _, set := jobs["every"]; !set {
+			jobs["every"] = "1h"
+		}
+	}
+
+	// History is kept unless GITSYNC_STORE_PATH is set empty
+	if path, set := os.LookupEnv("GITSYNC_STORE_PATH"); set {
+		raw["store"] = map[string]interface{}{"path": path}
+	}
+	return raw, nil
+}
+
+func envOr(name, defaultValue string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// envList splits a comma separated variable, dropping empty entries
+func envList(name string) []interface{} {
+	var items []interface{}
+	for _, item := range strings.Split(os.Getenv(name), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
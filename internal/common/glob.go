@@ -0,0 +1,74 @@
+package common
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ValidateBranchPattern reports whether a branch pattern is a well-formed glob
+func ValidateBranchPattern(pattern string) error {
+	if strings.TrimSpace(pattern) == "" {
+		return fmt.Errorf("pattern cannot be empty")
+	}
+	if isLegacyPattern(pattern) {
+		return nil
+	}
+	_, err := globToRegexp(pattern)
+	return err
+}
+
+// isLegacyPattern reports whether a pattern uses only the original single "*" syntax
+func isLegacyPattern(pattern string) bool {
+	return strings.Count(pattern, "*") <= 1 && !strings.ContainsAny(pattern, "?[")
+}
+
+// globToRegexp converts a branch glob into an anchored regular expression.
+// "*" and "?" never match "/", "**" matches across slashes, and "[!...]"
+// negates a character class.
+func globToRegexp(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+				// "**/" also matches zero directories, so "a/**/b" matches "a/b"
+				if i+1 < len(pattern) && pattern[i+1] == '/' {
+					i++
+					b.WriteString("(?:.*/)?")
+				} else {
+					b.WriteString(".*")
+				}
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated character class")
+			}
+			class := pattern[i+1 : i+1+end]
+			if class == "" || class == "!" {
+				return nil, fmt.Errorf("empty character class")
+			}
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case ']':
+			return nil, fmt.Errorf("unexpected ']'")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
@@ -0,0 +1,106 @@
+package common
+
+import "testing"
+
+func TestGlobToRegexp(t *testing.T) {
+	tests := []struct {
+		pattern string
+		branch  string
+		want    bool
+	}{
+		// "*" stays within one path segment
+		{"release/*/hotfix-*", "release/1.2/hotfix-login", true},
+		{"release/*/hotfix-*", "release/1.2/rc/hotfix-login", false},
+		{"*/*", "feature/login", true},
+		{"*/*", "feature/login/form", false},
+		{"*-*", "bug-fix", true},
+		{"*-*", "team/bug-fix", false},
+
+		// "**" at the start, in the middle and at the end
+		{"**/hotfix", "hotfix", true},
+		{"**/hotfix", "release/1.2/hotfix", true},
+		{"**/hotfix", "release/hotfix-2", false},
+		{"release/**/hotfix", "release/hotfix", true},
+		{"release/**/hotfix", "release/1.2/rc/hotfix", true},
+		{"release/**/hotfix", "releases/1.2/hotfix", false},
+		{"feature/**", "feature/login", true},
+		{"feature/**", "feature/login/form", true},
+		{"feature/**", "feature", false},
+		{"feature/**", "features/login", false},
+
+		// "?" matches one character other than "/"
+		{"v?.?", "v1.2", true},
+		{"v?.?", "v1.23", false},
+		{"a?b", "a/b", false},
+
+		// Character classes and their negation
+		{"release-[0-9]*", "release-2024", true},
+		{"release-[0-9]*", "release-next", false},
+		{"hotfix-[abc]", "hotfix-b", true},
+		{"hotfix-[abc]", "hotfix-d", false},
+		{"hotfix-[!abc]", "hotfix-d", true},
+		{"hotfix-[!abc]", "hotfix-a", false},
+
+		// Regular expression characters are literal
+		{"v1.0", "v1.0", true},
+		{"v1.0", "v1x0", false},
+		{"fix+?", "fix+1", true},
+	}
+
+	for _, tt := range tests {
+		re, err := globToRegexp(tt.pattern)
+		if err != nil {
+			t.Errorf("globToRegexp(%q): %v", tt.pattern, err)
+			continue
+		}
+		if got := re.MatchString(tt.branch); got != tt.want {
+			t.Errorf("%q matching %q = %v, want %v", tt.pattern, tt.branch, got, tt.want)
+		}
+	}
+}
+
+func TestGlobToRegexpInvalid(t *testing.T) {
+	for _, pattern := range []string{"release-[0-9", "hotfix-[]", "hotfix-[!]", "*/main]*"} {
+		if _, err := globToRegexp(pattern); err == nil {
+			t.Errorf("globToRegexp(%q) succeeded, want an error", pattern)
+		}
+		if err := ValidateBranchPattern(pattern); err == nil {
+			t.Errorf("ValidateBranchPattern(%q) succeeded, want an error", pattern)
+		}
+	}
+	if err := ValidateBranchPattern(" "); err == nil {
+		t.Error("ValidateBranchPattern accepted an empty pattern")
+	}
+}
+
+func TestMatchesBranchPatternLegacy(t *testing.T) {
+	tests := []struct {
+		pattern string
+		branch  string
+		want    bool
+	}{
+		// A single "*" keeps matching across slashes
+		{"*", "feature/login/form", true},
+		{"hotfix/*", "hotfix/a", true},
+		{"hotfix/*", "hotfix/a/b", true},
+		{"hotfix/*", "hotfixes/a", false},
+		{"*-sync", "team/nightly-sync", true},
+		{"*-sync", "sync", false},
+		{"feature*login", "feature/team/login", true},
+		{"feature*login", "featurelogin", true},
+		{"feature*login", "feature/logout", false},
+
+		// Exact names
+		{"main", "main", true},
+		{"main", "main2", false},
+	}
+
+	for _, tt := range tests {
+		if !isLegacyPattern(tt.pattern) {
+			t.Fatalf("%q is not a legacy pattern", tt.pattern)
+		}
+		if got := matchesBranchPattern(tt.branch, tt.pattern); got != tt.want {
+			t.Errorf("%q matching %q = %v, want %v", tt.pattern, tt.branch, got, tt.want)
+		}
+	}
+}
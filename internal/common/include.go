@@ -0,0 +1,132 @@
+package common
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// includeDir returns the directory whose *.toml files are merged into the
+// configuration file: include_dir of [jobs], relative to the configuration
+// file, or the configuration file name with .d for its extension. explicit
+// tells whether include_dir was set.
+func includeDir(raw map[string]interface{}, filename string) (dir string, explicit bool) {
+	if jobsMap, ok := raw["jobs"].(map[string]interface{}); ok {
+		if dir := getString(jobsMap, "include_dir", ""); dir != "" {
+			if !filepath.IsAbs(dir) {
+				dir = filepath.Join(filepath.Dir(filename), dir)
+			}
+			return dir, true
+		}
+	}
+	return strings.TrimSuffix(filename, filepath.Ext(filename)) + ".d", false
+}
+
+// mergeIncludes merges the job tables of the *.toml files in the include
+// directory into raw, in file name order, and appends their jobs to [jobs]
+// names. A key a job already has is overridden with a warning. The file each
+// included job was last defined in is recorded in config.JobFiles.
+func mergeIncludes(raw map[string]interface{}, filename string, config *Config) error {
+	dir, explicit := includeDir(raw, filename)
+	files, err := filepath.Glob(filepath.Join(dir, "*.toml"))
+	if err != nil {
+		return fmt.Errorf("include_dir %s: %w", dir, err)
+	}
+	if explicit {
+		if _, err := os.Stat(dir); err != nil {
+			return fmt.Errorf("include_dir %s: %w", dir, err)
+		}
+	}
+	if len(files) == 0 {
+		return nil
+	}
+	sort.Strings(files)
+
+	jobsMap, ok := raw["jobs"].(map[string]interface{})
+	if !ok {
+		jobsMap = make(map[string]interface{})
+		raw["jobs"] = jobsMap
+	}
+	names, _ := jobsMap["names"].([]interface{})
+	listed := make(map[string]bool, len(names))
+	for _, name := range names {
+		if nameStr, ok := name.(string); ok {
+			listed[nameStr] = true
+		}
+	}
+
+	sections := tomlFields(reflect.TypeOf(Config{}))
+	definedIn := make(map[string]string) // Job key to the file that set it
+	for key, value := range raw {
+		if table, ok := value.(map[string]interface{}); ok && sections[key] == nil {
+			for jobKey := range table {
+				definedIn[key+"."+jobKey] = filepath.Base(filename)
+			}
+		}
+	}
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read included file %s: %w", file, err)
+		}
+
+		var included map[string]interface{}
+		if err := toml.Unmarshal([]byte(os.Expand(string(data), expandConfigVar)), &included); err != nil {
+			return fmt.Errorf("failed to parse included file %s: %w", file, err)
+		}
+
+		base := filepath.Base(file)
+		for _, jobName := range sortedKeys(included) {
+			table, ok := included[jobName].(map[string]interface{})
+			switch {
+			case sections[jobName] != nil:
+				return fmt.Errorf("%s: [%s] belongs in the main configuration, included files only define jobs", file, jobName)
+			case !ok:
+				return fmt.Errorf("%s: '%s' is not a job table, included files only define jobs", file, jobName)
+			}
+
+			job, exists := raw[jobName].(map[string]interface{})
+			if !exists {
+				job = make(map[string]interface{})
+				raw[jobName] = job
+			}
+			for _, key := range sortedKeys(table) {
+				if previous, set := definedIn[jobName+"."+key]; set {
+					config.Warnings = append(config.Warnings, fmt.Sprintf("%s: job '%s' key '%s' overrides the value from %s", base, jobName, key, previous))
+				}
+				job[key] = table[key]
+				definedIn[jobName+"."+key] = base
+			}
+
+			if !listed[jobName] {
+				listed[jobName] = true
+				names = append(names, jobName)
+			}
+			config.JobFiles[jobName] = file
+		}
+	}
+
+	jobsMap["names"] = names
+	return nil
+}
+
+// IncludedFiles lists the files of the include directory that were merged
+// into the configuration
+func (c *Config) IncludedFiles() []string {
+	seen := make(map[string]bool)
+	var files []string
+	for _, file := range c.JobFiles {
+		if !seen[file] {
+			seen[file] = true
+			files = append(files, file)
+		}
+	}
+	sort.Strings(files)
+	return files
+}
@@ -0,0 +1,154 @@
+package common
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// unknownKeys lists every key of the raw configuration that no setting reads,
+// such as a misspelled "overide" in a job table, with where it was found and
+// the closest known key when it looks like a typo. The known keys are the toml
+// tags of the configuration structs.
+func unknownKeys(raw map[string]interface{}, jobNames []string) []string {
+	sections := tomlFields(reflect.TypeOf(Config{}))
+	jobType := reflect.TypeOf(JobConfig{})
+	jobs := make(map[string]bool, len(jobNames))
+	for _, jobName := range jobNames {
+		jobs[jobName] = true
+	}
+
+	var unknown []string
+	for _, key := range sortedKeys(raw) {
+		value := raw[key]
+		if field, ok := sections[key]; ok {
+			unknown = append(unknown, unknownKeysIn(value, field, key)...)
+			continue
+		}
+		// A misspelled section such as [loging] is not read at all
+		if suggestion := closestKey(key, sections); suggestion != "" && !jobs[key] {
+			unknown = append(unknown, fmt.Sprintf("unknown table [%s] is ignored, did you mean [%s]?", key, suggestion))
+			continue
+		}
+		// Any other table is a job definition, listed in [jobs] names or not
+		if table, ok := value.(map[string]interface{}); ok {
+			unknown = append(unknown, unknownTableKeys(table, jobType, key)...)
+			continue
+		}
+		unknown = append(unknown, unknownKey(key, "the top level", sections))
+	}
+	return unknown
+}
+
+// unknownKeysIn checks a value against the type of the field it is parsed
+// into, descending into tables and arrays of tables
+func unknownKeysIn(value interface{}, fieldType reflect.Type, location string) []string {
+	for fieldType.Kind() == reflect.Ptr {
+		fieldType = fieldType.Elem()
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if fieldType.Kind() == reflect.Struct {
+			return unknownTableKeys(v, fieldType, location)
+		}
+	case []interface{}:
+		if fieldType.Kind() != reflect.Slice {
+			return nil
+		}
+		var unknown []string
+		for i, item := range v {
+			unknown = append(unknown, unknownKeysIn(item, fieldType.Elem(), fmt.Sprintf("%s[%d]", location, i))...)
+		}
+		return unknown
+	}
+	return nil
+}
+
+func unknownTableKeys(table map[string]interface{}, structType reflect.Type, location string) []string {
+	fields := tomlFields(structType)
+
+	var unknown []string
+	for _, key := range sortedKeys(table) {
+		field, ok := fields[key]
+		if !ok {
+			unknown = append(unknown, unknownKey(key, "["+location+"]", fields))
+			continue
+		}
+		unknown = append(unknown, unknownKeysIn(table[key], field, location+"."+key)...)
+	}
+	return unknown
+}
+
+func unknownKey(key, location string, known map[string]reflect.Type) string {
+	message := fmt.Sprintf("unknown key '%s' in %s is ignored", key, location)
+	if suggestion := closestKey(key, known); suggestion != "" {
+		message += fmt.Sprintf(", did you mean '%s'?", suggestion)
+	}
+	return message
+}
+
+// tomlFields maps the toml tags of a struct to the types of their fields
+func tomlFields(structType reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("toml"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+// closestKey returns the known key within a few edits of key, empty when
+// none is close enough to be a typo
+func closestKey(key string, known map[string]reflect.Type) string {
+	maxDistance := len(key) / 3
+	if maxDistance < 1 {
+		maxDistance = 1
+	}
+	if maxDistance > 3 {
+		maxDistance = 3
+	}
+
+	best, bestDistance := "", maxDistance+1
+	for _, candidate := range sortedKeys(known) {
+		if distance := editDistance(key, candidate); distance < bestDistance {
+			best, bestDistance = candidate, distance
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance of two strings
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -6,10 +6,13 @@
 package common
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/ternarybob/arbor"
 	"github.com/ternarybob/arbor/models"
@@ -53,70 +56,178 @@ func InitLogger(config *LoggingConfig) error {
 
 func initDefaultLogger() arbor.ILogger {
 	config := DefaultLoggingConfig()
+	config.Directory = os.Getenv("LOG_DIR")
 	logger, err := createLogger(config)
 	if err != nil {
-		fmt.Printf("Warning: Failed to initialize default logger: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Warning: Failed to initialize default logger: %v\n", err)
 		return arbor.NewLogger()
 	}
 	return logger
 }
 
 func createLogger(config *LoggingConfig) (arbor.ILogger, error) {
-	// Get the directory where the executable is located
-	execPath, err := os.Executable()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get executable path: %w", err)
-	}
-	execDir := filepath.Dir(execPath)
-
-	// Create logs directory in the same directory as the executable
-	logsDir := filepath.Join(execDir, "logs")
-	if err := os.MkdirAll(logsDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create logs directory: %w", err)
+	applyNoColor(config)
+
+	var logsDir string
+	if config.WritesFile() {
+		var err error
+		if logsDir, err = config.LogDirectory(); err != nil {
+			return nil, err
+		}
+		if err := ensureWritableDir(logsDir); err != nil {
+			return nil, err
+		}
 	}
 
 	// Initialize arbor logger
 	l := arbor.NewLogger()
 
 	// Configure file logging if requested
-	if config.Output == "both" || config.Output == "file" || config.Output == "" {
-		logFile := filepath.Join(logsDir, "gitsync.log")
+	if config.WritesFile() {
+		logFile := filepath.Join(logsDir, logFileName)
+		// JSON lines carry the full RFC 3339 time for log shippers
+		timeFormat := "15:04:05"
+		if config.Format == "json" {
+			timeFormat = ""
+		}
 		l = l.WithFileWriter(models.WriterConfiguration{
 			Type:             models.LogWriterTypeFile,
 			FileName:         logFile,
-			TimeFormat:       "15:04:05",
+			TimeFormat:       timeFormat,
 			MaxSize:          int64(config.MaxSize * 1024 * 1024), // Convert MB to bytes
 			MaxBackups:       config.MaxBackups,
-			TextOutput:       true,
+			TextOutput:       config.Format != "json",
 			DisableTimestamp: false,
 		})
 	}
 
 	// Configure console logging if requested
-	if config.Output == "both" || config.Output == "console" || config.Output == "" {
-		l = l.WithConsoleWriter(models.WriterConfiguration{
-			Type:             models.LogWriterTypeConsole,
-			TimeFormat:       "15:04:05",
-			TextOutput:       true,
-			DisableTimestamp: false,
-		})
+	if config.WritesConsole() {
+		out := os.Stdout
+		if config.ConsoleToStderr {
+			out = os.Stderr
+		}
+		var writer *consoleWriter
+		switch {
+		case config.ConsoleLogFormat() == "json":
+			writer = newJSONConsoleWriter(out)
+		case !styled || config.ConsoleLevel != "" || config.ConsoleToStderr:
+			// arbor's console writer cannot have a level of its own and only
+			// writes to stdout
+			writer = newTextConsoleWriter(out, styled)
+		default:
+			l = l.WithConsoleWriter(models.WriterConfiguration{
+				Type:             models.LogWriterTypeConsole,
+				TimeFormat:       "15:04:05",
+				TextOutput:       true,
+				DisableTimestamp: false,
+			})
+		}
+		if writer != nil {
+			if config.ConsoleLevel != "" {
+				floor, err := arbor.ParseLevelString(config.ConsoleLevel)
+				if err != nil {
+					return nil, fmt.Errorf("console log level: %w", err)
+				}
+				writer.floor = floor
+			}
+			arbor.RegisterWriter(arbor.WRITER_CONSOLE, writer)
+		}
+	}
+
+	if config.WritesSyslog() {
+		writer, err := newSyslogWriter(config)
+		if err != nil {
+			return nil, err
+		}
+		arbor.RegisterWriter(writerSyslog, writer)
 	}
 
 	// Set log level
 	l = l.WithLevelFromString(config.Level)
 
 	// Test logging immediately to verify it's working
-	l.Info().Msg("GitSync logger initialized")
+	if logsDir != "" {
+		l.Info().Str("directory", logsDir).Msg("GitSync logger initialized")
+	} else {
+		l.Info().Msg("GitSync logger initialized")
+	}
 
 	return l, nil
 }
 
+// CheckLogLevel rejects a log level arbor does not know, which it would
+// replace with info
+func CheckLogLevel(level string) error {
+	if _, err := arbor.ParseLevelString(level); err != nil {
+		return fmt.Errorf("unknown log level '%s', use trace, debug, info, warn or error", level)
+	}
+	return nil
+}
+
+// ensureWritableDir creates dir when missing and checks that files can be
+// created in it
+func ensureWritableDir(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create log directory %s: %w", dir, err)
+	}
+	probe, err := os.CreateTemp(dir, ".gitsync-write-check-*")
+	if err != nil {
+		return fmt.Errorf("log directory %s is not writable: %w", dir, err)
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+	return nil
+}
+
+// CleanupLogs removes rotated log files older than maxAgeDays from logsDir
+// and returns how many were removed. The file in use is kept.
+func CleanupLogs(logsDir string, maxAgeDays int) (int, error) {
+	// The writer keeps logFileName as a link to the file it writes
+	current, _ := os.Readlink(filepath.Join(logsDir, logFileName))
+
+	ext := filepath.Ext(logFileName)
+	rotated, err := filepath.Glob(filepath.Join(logsDir, strings.TrimSuffix(logFileName, ext)+".*"+ext))
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -maxAgeDays)
+	var removed int
+	var errs []error
+	for _, path := range rotated {
+		if filepath.Base(path) == filepath.Base(current) {
+			continue
+		}
+		info, err := os.Lstat(path)
+		if err != nil || !info.Mode().IsRegular() || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		removed++
+	}
+	return removed, errors.Join(errs...)
+}
+
+// writerSyslog is the arbor registry name of the syslog writer
+const writerSyslog = "syslog"
+
+// logFileName is the log file in the logs directory, rotated files insert a
+// timestamp before the extension
+const logFileName = "gitsync.log"
+
 func DefaultLoggingConfig() *LoggingConfig {
 	return &LoggingConfig{
-		Level:      "info",
-		Format:     "text",
-		Output:     "both",
-		MaxSize:    100,
-		MaxBackups: 3,
+		Level:          "info",
+		Format:         "text",
+		Output:         "both",
+		MaxSize:        100,
+		MaxBackups:     3,
+		MaxAge:         30,
+		SyslogFacility: "daemon",
+		SyslogTag:      "gitsync",
 	}
 }
@@ -0,0 +1,95 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ternarybob/arbor"
+)
+
+// logLines decodes every line of JSON log output, failing on any that is not
+// a JSON object
+func logLines(t *testing.T, output []byte) []map[string]interface{} {
+	t.Helper()
+	var lines []map[string]interface{}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var line map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("log line is not JSON: %v\n%s", err, scanner.Text())
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// checkSyncLine finds the line of the message and checks its fields are
+// top-level keys
+func checkSyncLine(t *testing.T, lines []map[string]interface{}) {
+	t.Helper()
+	for _, line := range lines {
+		if line["message"] != "Starting sync to target" {
+			continue
+		}
+		if _, ok := line["time"]; !ok {
+			t.Errorf("no time in %v", line)
+		}
+		want := map[string]string{"level": "info", "job": "mirror", "branch": "main", "target": "https://gitlab.com/example/project.git"}
+		for key, value := range want {
+			if line[key] != value {
+				t.Errorf("%s = %v, want %q in %v", key, line[key], value, line)
+			}
+		}
+		return
+	}
+	t.Fatalf("no line for the sync message in %v", lines)
+}
+
+func TestJSONConsoleLog(t *testing.T) {
+	var out bytes.Buffer
+	arbor.RegisterWriter(arbor.WRITER_CONSOLE, newJSONConsoleWriter(&out))
+	t.Cleanup(func() { arbor.UnregisterWriter(arbor.WRITER_CONSOLE) })
+
+	l := arbor.NewLogger().WithLevelFromString("info")
+	l.Info().Str("job", "mirror").Str("branch", "main").Str("target", "https://gitlab.com/example/project.git").Msg("Starting sync to target")
+	l.Warn().Str("job", "mirror").Err(os.ErrNotExist).Msg("Quoted \"message\"\nover two lines")
+
+	lines := logLines(t, out.Bytes())
+	if len(lines) != 2 {
+		t.Fatalf("got %d log lines, want 2:\n%s", len(lines), out.String())
+	}
+	checkSyncLine(t, lines)
+	if lines[1]["error"] != os.ErrNotExist.Error() {
+		t.Errorf("error = %v, want %q", lines[1]["error"], os.ErrNotExist)
+	}
+}
+
+func TestJSONFileLog(t *testing.T) {
+	dir := t.TempDir()
+	t.Cleanup(func() { arbor.UnregisterWriter(arbor.WRITER_FILE) })
+
+	l, err := createLogger(&LoggingConfig{Level: "info", Format: "json", Output: "file", Directory: dir, MaxSize: 1})
+	if err != nil {
+		t.Fatalf("createLogger: %v", err)
+	}
+	l.Info().Str("job", "mirror").Str("branch", "main").Str("target", "https://gitlab.com/example/project.git").Msg("Starting sync to target")
+
+	// The file writer may write in the background
+	var lines []map[string]interface{}
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		output, _ := os.ReadFile(filepath.Join(dir, logFileName))
+		if lines = logLines(t, output); len(lines) >= 2 {
+			break
+		}
+	}
+	checkSyncLine(t, lines)
+}
@@ -0,0 +1,103 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Providers of hosted repositories. Forgejo serves the Gitea API.
+const (
+	ProviderGitHub  = "github"
+	ProviderGitLab  = "gitlab"
+	ProviderGitea   = "gitea"
+	ProviderForgejo = "forgejo"
+)
+
+// knownProviderHosts maps the public instances of providers to them, a
+// self-hosted instance needs provider set
+var knownProviderHosts = map[string]string{
+	"github.com":   ProviderGitHub,
+	"gitlab.com":   ProviderGitLab,
+	"codeberg.org": ProviderForgejo,
+}
+
+// ProviderName returns the provider hosting the target, provider when set and
+// otherwise detected from the host, empty when unknown
+func (t *TargetConfig) ProviderName() string {
+	if t.Provider != "" {
+		return t.Provider
+	}
+	if IsLocalRepository(t.URL) || IsBundleURL(t.URL) {
+		return ""
+	}
+	return knownProviderHosts[RepositoryHost(t.URL)]
+}
+
+// Provisions reports whether the target repository is managed through the
+// provider API: created when missing or given a default branch or description
+func (t *TargetConfig) Provisions() bool {
+	return t.CreateRepo || t.DefaultBranch != "" || t.MarkMirror
+}
+
+// ProvisionsTargets reports whether any target of the job is managed through
+// the provider API
+func (jc *JobConfig) ProvisionsTargets() bool {
+	for i := range jc.Targets {
+		if jc.Targets[i].Provisions() {
+			return true
+		}
+	}
+	return false
+}
+
+// GiteaRepository returns the API root of the Gitea or Forgejo instance
+// hosting the target, and the owner and name of the repository. The owner is
+// a user or an organization, the path segment before the repository name.
+// Without api_url the API root is derived from an http or https URL,
+// keeping a path prefix the instance is served under.
+func (t *TargetConfig) GiteaRepository() (apiURL, owner, repo string, err error) {
+	hostPath := NormalizeRepositoryURL(t.URL)
+	segments := strings.Split(hostPath, "/")
+	if len(segments) < 3 || segments[len(segments)-2] == "" || segments[len(segments)-1] == "" {
+		return "", "", "", fmt.Errorf("no owner and repository name in %s", t.URL)
+	}
+	owner, repo = segments[len(segments)-2], segments[len(segments)-1]
+
+	apiURL = strings.TrimSuffix(t.APIURL, "/")
+	if apiURL == "" {
+		scheme, _, found := strings.Cut(t.URL, "://")
+		if !found || (scheme != "http" && scheme != "https") {
+			return "", "", "", errors.New("api_url is needed, it can only be derived from an http or https URL")
+		}
+		apiURL = scheme + "://" + strings.Join(segments[:len(segments)-2], "/") + "/api/v1"
+	}
+	return apiURL, owner, repo, nil
+}
+
+// validateProvisioning checks the provider settings of a target
+func validateProvisioning(jobConfig *JobConfig, target *TargetConfig) error {
+	switch target.Provider {
+	case "", ProviderGitHub, ProviderGitLab, ProviderGitea, ProviderForgejo:
+	default:
+		return fmt.Errorf("provider '%s' is not supported, use github, gitlab, gitea or forgejo", target.Provider)
+	}
+	if !target.Provisions() {
+		return nil
+	}
+
+	switch provider := target.ProviderName(); provider {
+	case ProviderGitea, ProviderForgejo:
+	case "":
+		return fmt.Errorf("create_repo, default_branch and mark_mirror need the provider, set provider = \"gitea\" for a self-hosted instance")
+	default:
+		return fmt.Errorf("create_repo, default_branch and mark_mirror are not supported for %s targets, only gitea and forgejo", provider)
+	}
+	if _, _, _, err := target.GiteaRepository(); err != nil {
+		return err
+	}
+	if jobConfig.GitToken == "" && jobConfig.TokenSecret() == "" {
+		return fmt.Errorf("create_repo, default_branch and mark_mirror call the API with the job's git_token, which is not set")
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// RateLimitConfig is the [rate_limit] table: the minimum interval between git
+// operations talking to a host, shared by all jobs, keyed by host name
+type RateLimitConfig map[string]time.Duration
+
+// parseRateLimitConfig reads the [rate_limit] table. A value is either the
+// operations allowed per minute, such as "github.com" = 30, or the minimum
+// interval between them, such as "git.example.com" = "5s". Values that are
+// neither are kept as zero for validate to report.
+func parseRateLimitConfig(rateMap map[string]interface{}) RateLimitConfig {
+	limits := make(RateLimitConfig, len(rateMap))
+	for host, value := range rateMap {
+		var interval time.Duration
+		switch v := value.(type) {
+		case int64:
+			if v > 0 {
+				interval = time.Minute / time.Duration(v)
+			}
+		case float64:
+			if v > 0 {
+				interval = time.Duration(float64(time.Minute) / v)
+			}
+		case string:
+			interval, _ = time.ParseDuration(v)
+		}
+		limits[strings.ToLower(host)] = interval
+	}
+	return limits
+}
+
+func (r RateLimitConfig) validate() error {
+	for _, host := range sortedKeys(r) {
+		if r[host] <= 0 {
+			return fmt.Errorf("%s must be a positive number of operations per minute or an interval such as \"2s\"", host)
+		}
+	}
+	return nil
+}
+
+// Interval returns the minimum interval between git operations talking to
+// host, 0 when the host is not limited
+func (r RateLimitConfig) Interval(host string) time.Duration {
+	return r[strings.ToLower(host)]
+}
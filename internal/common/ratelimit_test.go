@@ -0,0 +1,39 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRateLimitConfig(t *testing.T) {
+	limits := parseRateLimitConfig(map[string]interface{}{
+		"GitHub.com":      int64(30),
+		"gitlab.com":      0.5,
+		"git.example.com": "5s",
+		"broken.example":  "5 secs",
+	})
+
+	tests := []struct {
+		host string
+		want time.Duration
+	}{
+		{"github.com", 2 * time.Second},
+		{"GITLAB.com", 2 * time.Minute},
+		{"git.example.com", 5 * time.Second},
+		{"broken.example", 0},
+		{"unlimited.example", 0},
+	}
+	for _, test := range tests {
+		if got := limits.Interval(test.host); got != test.want {
+			t.Errorf("Interval(%s) = %s, want %s", test.host, got, test.want)
+		}
+	}
+
+	if err := limits.validate(); err == nil {
+		t.Error("validate accepted an unparsable interval")
+	}
+	delete(limits, "broken.example")
+	if err := limits.validate(); err != nil {
+		t.Errorf("validate: %v", err)
+	}
+}
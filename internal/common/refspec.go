@@ -0,0 +1,99 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Refspec is a parsed "[+]<src>[:<dst>]" git refspec
+type Refspec struct {
+	Force bool
+	Src   string
+	Dst   string
+}
+
+// ParseRefspec parses and validates a refspec from the job configuration.
+// Both sides must be fully qualified refs, and a wildcard on one side must
+// be matched by a wildcard on the other.
+func ParseRefspec(spec string) (Refspec, error) {
+	var r Refspec
+
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "+") {
+		r.Force = true
+		spec = strings.TrimPrefix(spec, "+")
+	}
+
+	parts := strings.Split(spec, ":")
+	switch len(parts) {
+	case 1:
+		r.Src, r.Dst = parts[0], parts[0]
+	case 2:
+		r.Src, r.Dst = parts[0], parts[1]
+		if r.Dst == "" {
+			r.Dst = r.Src
+		}
+	default:
+		return r, fmt.Errorf("expected [+]<src>[:<dst>]")
+	}
+
+	for _, ref := range []string{r.Src, r.Dst} {
+		if err := validateRefName(ref); err != nil {
+			return r, err
+		}
+	}
+
+	if strings.Count(r.Src, "*") != strings.Count(r.Dst, "*") {
+		return r, fmt.Errorf("wildcards must appear on both sides")
+	}
+
+	return r, nil
+}
+
+// IsWildcard reports whether the refspec maps a whole ref namespace
+func (r Refspec) IsWildcard() bool {
+	return strings.Contains(r.Src, "*")
+}
+
+// MapRef translates a ref matched by Src into the corresponding Dst ref
+func (r Refspec) MapRef(ref string) (string, bool) {
+	if !r.IsWildcard() {
+		return r.Dst, ref == r.Src
+	}
+
+	srcPrefix, srcSuffix, _ := strings.Cut(r.Src, "*")
+	if !strings.HasPrefix(ref, srcPrefix) || !strings.HasSuffix(ref, srcSuffix) || len(ref) < len(srcPrefix)+len(srcSuffix) {
+		return "", false
+	}
+	match := ref[len(srcPrefix) : len(ref)-len(srcSuffix)]
+
+	dstPrefix, dstSuffix, _ := strings.Cut(r.Dst, "*")
+	return dstPrefix + match + dstSuffix, true
+}
+
+func (r Refspec) String() string {
+	spec := r.Src + ":" + r.Dst
+	if r.Force {
+		spec = "+" + spec
+	}
+	return spec
+}
+
+func validateRefName(ref string) error {
+	if !strings.HasPrefix(ref, "refs/") {
+		return fmt.Errorf("ref %q must start with refs/", ref)
+	}
+	if strings.Count(ref, "*") > 1 {
+		return fmt.Errorf("ref %q may contain at most one wildcard", ref)
+	}
+	if strings.Contains(ref, "..") || strings.Contains(ref, "//") || strings.Contains(ref, "@{") ||
+		strings.HasSuffix(ref, "/") || strings.HasSuffix(ref, ".lock") || strings.HasSuffix(ref, ".") {
+		return fmt.Errorf("ref %q is not a valid ref name", ref)
+	}
+	for _, c := range ref {
+		if c < 0x20 || c == 0x7f || strings.ContainsRune(" ~^:?[\\", c) {
+			return fmt.Errorf("ref %q contains invalid character %q", ref, c)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package common
+
+import "fmt"
+
+// TokenSecret describes the secrets backend entry the job's git_token is read
+// from when a run starts, empty when the token is configured directly
+func (jc *JobConfig) TokenSecret() string {
+	switch {
+	case jc.GitTokenVaultPath != "":
+		return "vault " + jc.GitTokenVaultPath
+	case jc.GitTokenSecretARN != "":
+		return "secret " + jc.GitTokenSecretARN
+	case jc.GitTokenSSMParam != "":
+		return "parameter " + jc.GitTokenSSMParam
+	}
+	return ""
+}
+
+// validateTokenSecret checks that the token comes from one place at most and
+// that the backend it is read from is configured
+func validateTokenSecret(jobConfig *JobConfig) error {
+	sources := 0
+	for _, set := range []bool{
+		jobConfig.GitToken != "" || jobConfig.GitTokenEnv != "",
+		jobConfig.GitTokenVaultPath != "",
+		jobConfig.GitTokenSecretARN != "",
+		jobConfig.GitTokenSSMParam != "",
+	} {
+		if set {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return fmt.Errorf("only one of git_token or git_token_env, git_token_vault_path, git_token_secret_arn and git_token_ssm_param can be set")
+	}
+
+	switch {
+	case jobConfig.GitTokenVaultPath != "":
+		secretPath, _ := VaultSecretRef(jobConfig.GitTokenVaultPath)
+		if jobConfig.Vault == nil {
+			return fmt.Errorf("git_token_vault_path needs a [vault] table")
+		}
+		if secretPath == "" {
+			return fmt.Errorf("git_token_vault_path has no secret path")
+		}
+	case jobConfig.GitTokenSecretARN != "":
+		secretID, _ := AWSSecretRef(jobConfig.GitTokenSecretARN)
+		if secretID == "" {
+			return fmt.Errorf("git_token_secret_arn has no secret")
+		}
+		if jobConfig.AWS.RegionOf(secretID) == "" {
+			return fmt.Errorf("git_token_secret_arn %s needs a region, set region in [aws] or AWS_REGION", secretID)
+		}
+	case jobConfig.GitTokenSSMParam != "":
+		if jobConfig.AWS.RegionOf(jobConfig.GitTokenSSMParam) == "" {
+			return fmt.Errorf("git_token_ssm_param %s needs a region, set region in [aws] or AWS_REGION", jobConfig.GitTokenSSMParam)
+		}
+	}
+	return nil
+}
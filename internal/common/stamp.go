@@ -0,0 +1,35 @@
+package common
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+// stampDateLayout formats the {date} of stamp_tag_format in UTC, the same as
+// the {date} of bundle targets, so stamp tags sort by the run that made them
+const stampDateLayout = "20060102-150405"
+
+// StampTag expands the stamp_tag_format of a job for a run started at t:
+// {job} is the job name, {date} the time as 20060102-150405 and {run_id} the
+// run ID
+func (jc *JobConfig) StampTag(jobName, runID string, t time.Time) string {
+	return strings.NewReplacer(
+		"{job}", jobName,
+		"{date}", t.UTC().Format(stampDateLayout),
+		"{run_id}", runID,
+	).Replace(jc.StampTagFormat)
+}
+
+// StampTagPattern matches the tag names StampTag makes for a job, capturing
+// the first {date} as the first group
+func (jc *JobConfig) StampTagPattern(jobName string) *regexp.Regexp {
+	pattern := regexp.QuoteMeta(jc.StampTagFormat)
+	pattern = strings.Replace(pattern, `\{date\}`, `(\d{8}-\d{6})`, 1)
+	pattern = strings.NewReplacer(
+		`\{job\}`, regexp.QuoteMeta(jobName),
+		`\{date\}`, `\d{8}-\d{6}`,
+		`\{run_id\}`, `[0-9a-f]+`,
+	).Replace(pattern)
+	return regexp.MustCompile("^" + pattern + "$")
+}
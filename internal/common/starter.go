@@ -0,0 +1,130 @@
+package common
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// starterTemplate is the configuration written by gitsync init. Values that have a
+// default are filled in from DefaultConfig, so the file shows what gitsync
+// would use anyway.
+var starterTemplate = template.Must(template.New("gitsync.toml").Funcs(template.FuncMap{
+	"duration": formatDuration,
+}).Parse(`# GitSync configuration, written by gitsync init
+#
+# Environment variables are expanded with ${VAR} or ${VAR:-default}. Check the
+# file with: gitsync validate -config <this file>
+# Schedules are cron expressions with a seconds field: "SEC MIN HOUR DAY MONTH WEEKDAY"
+
+[service]
+name = "{{.Service.Name}}"
+environment = "{{.Service.Environment}}"  # development, staging, production
+shutdown_grace_period = "{{duration .Service.ShutdownGracePeriod}}"  # How long shutdown waits for running jobs
+
+# Settings shared by all jobs, only the jobs listed in names are run
+[jobs]
+names = ["example-sync"]
+schedule = "0 */15 * * * *"   # Every 15 minutes, default for jobs without their own schedule
+timeout = "{{duration .Jobs.Timeout}}"
+run_on_startup = {{.Jobs.RunOnStartup}}  # Sync enabled jobs once before the scheduler starts
+
+# A job mirrors branches of the source repository to every target
+["example-sync"]
+description = "Mirror main and release branches to a backup"
+enabled = true
+source = "https://github.com/myorg/project.git"
+targets = [
+  "https://gitlab.com/myorg/project.git",
+]
+branches = ["main", "release/*"]   # Branch names or wildcard patterns
+override = false                   # true force pushes, needed when rewriting history
+git_username = "sync-bot"
+git_token = "${GITHUB_TOKEN}"      # Read from the environment, never stored here
+# ssh_key_path = "/home/gitsync/.ssh/id_ed25519"   # Instead of a token, for git@ URLs
+rewrite_history = false            # true applies the author_replace rules below
+# add_origin_trailer = true        # With rewrite_history, append "Mirrored-From: <source>@<commit>"
+# require_signatures = true        # Refuse to push commits not signed by one of trusted_keys
+# trusted_keys = ["SHA256:..."]    # GPG fingerprints or SSH "SHA256:" fingerprints
+
+# Author replacement rules of example-sync. Keys after a rule belong to it until
+# the next table, so job settings go above the rules.
+[["example-sync".author_replace]]
+from_email = "contractor@example.com"
+to_email = "employee@myorg.com"
+to_name = "Company Employee"
+
+# Transaction history, path = "" disables it
+[store]
+path = "{{.Store.Path}}"
+retention_days = {{.Store.RetentionDays}}
+
+[logging]
+level = "{{.Logging.Level}}"  # debug, info, warn, error
+format = "{{.Logging.Format}}"  # text, json
+output = "{{.Logging.Output}}"  # console, file, both (console + file), syslog; combine with commas
+max_size = {{.Logging.MaxSize}}  # Log file max size in MB
+max_backups = {{.Logging.MaxBackups}}
+max_age = {{.Logging.MaxAge}}  # Days to keep rotated log files
+`))
+
+// StarterConfig returns a commented configuration with one example job
+func StarterConfig() (string, error) {
+	var buf bytes.Buffer
+	if err := starterTemplate.Execute(&buf, DefaultConfig()); err != nil {
+		return "", err
+	}
+
+	// Keep the template in step with the configuration structs
+	var raw map[string]interface{}
+	if err := toml.Unmarshal(buf.Bytes(), &raw); err != nil {
+		return "", fmt.Errorf("starter configuration does not parse: %w", err)
+	}
+	if unknown := unknownKeys(raw, []string{"example-sync"}); len(unknown) > 0 {
+		return "", fmt.Errorf("starter configuration is out of date: %s", strings.Join(unknown, "; "))
+	}
+	return buf.String(), nil
+}
+
+// WriteStarterConfig writes StarterConfig to path, refusing to replace an
+// existing file unless force is set
+func WriteStarterConfig(path string, force bool) error {
+	content, err := StarterConfig()
+	if err != nil {
+		return err
+	}
+
+	if !force {
+		if _, err := os.Stat(path); err == nil {
+			return fmt.Errorf("%s already exists, use -force to overwrite it", path)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", path, err)
+	}
+	// The file may end up holding tokens, keep it private to the owner
+	return os.WriteFile(path, []byte(content), 0600)
+}
+
+// formatDuration prints whole minutes and hours without the zero units
+// time.Duration adds, 5m instead of 5m0s
+func formatDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
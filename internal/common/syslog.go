@@ -0,0 +1,120 @@
+//go:build !windows && !plan9
+
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/syslog"
+	"sort"
+	"strings"
+
+	"github.com/phuslu/log"
+	"github.com/ternarybob/arbor/models"
+	"github.com/ternarybob/arbor/writers"
+)
+
+var syslogFacilities = map[string]syslog.Priority{
+	"kern": syslog.LOG_KERN, "user": syslog.LOG_USER, "mail": syslog.LOG_MAIL,
+	"daemon": syslog.LOG_DAEMON, "auth": syslog.LOG_AUTH, "syslog": syslog.LOG_SYSLOG,
+	"lpr": syslog.LOG_LPR, "news": syslog.LOG_NEWS, "uucp": syslog.LOG_UUCP,
+	"cron": syslog.LOG_CRON, "authpriv": syslog.LOG_AUTHPRIV, "ftp": syslog.LOG_FTP,
+	"local0": syslog.LOG_LOCAL0, "local1": syslog.LOG_LOCAL1, "local2": syslog.LOG_LOCAL2,
+	"local3": syslog.LOG_LOCAL3, "local4": syslog.LOG_LOCAL4, "local5": syslog.LOG_LOCAL5,
+	"local6": syslog.LOG_LOCAL6, "local7": syslog.LOG_LOCAL7,
+}
+
+func validateSyslogFacility(facility string) error {
+	if _, ok := syslogFacilities[strings.ToLower(facility)]; !ok {
+		return fmt.Errorf("unknown syslog_facility '%s', use daemon, user or local0 to local7", facility)
+	}
+	return nil
+}
+
+// syslogWriter sends arbor log events to the local syslog daemon, or journald
+// through its syslog socket. The syslog message carries no time, syslog adds
+// its own; structured fields are appended as key=value pairs or, with the
+// json format, the message is a JSON object.
+type syslogWriter struct {
+	writer *syslog.Writer
+	json   bool
+	level  log.Level
+}
+
+func newSyslogWriter(config *LoggingConfig) (*syslogWriter, error) {
+	facility := syslogFacilities[strings.ToLower(config.SyslogFacility)]
+	writer, err := syslog.New(facility|syslog.LOG_INFO, config.SyslogTag)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to syslog: %w", err)
+	}
+	return &syslogWriter{writer: writer, json: config.Format == "json", level: log.InfoLevel}, nil
+}
+
+func (w *syslogWriter) WithLevel(level log.Level) writers.IWriter {
+	w.level = level
+	return w
+}
+
+func (w *syslogWriter) Write(data []byte) (int, error) {
+	var event models.LogEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		return len(data), w.writer.Info(string(data))
+	}
+	if event.Level < w.level {
+		return len(data), nil
+	}
+
+	message := w.format(&event)
+	switch {
+	case event.Level >= log.FatalLevel:
+		return len(data), w.writer.Crit(message)
+	case event.Level >= log.ErrorLevel:
+		return len(data), w.writer.Err(message)
+	case event.Level >= log.WarnLevel:
+		return len(data), w.writer.Warning(message)
+	case event.Level >= log.InfoLevel:
+		return len(data), w.writer.Info(message)
+	}
+	return len(data), w.writer.Debug(message)
+}
+
+func (w *syslogWriter) format(event *models.LogEvent) string {
+	fields := make(map[string]interface{}, len(event.Fields)+4)
+	for key, value := range event.Fields {
+		fields[key] = value
+	}
+	if event.Function != "" {
+		fields["function"] = event.Function
+	}
+	if event.Prefix != "" {
+		fields["prefix"] = event.Prefix
+	}
+	if event.CorrelationID != "" {
+		fields["correlationid"] = event.CorrelationID
+	}
+	if event.Error != "" {
+		fields["error"] = event.Error
+	}
+
+	if w.json {
+		fields["level"] = event.Level.String()
+		fields["message"] = event.Message
+		data, err := json.Marshal(fields)
+		if err == nil {
+			return string(data)
+		}
+	}
+
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var message strings.Builder
+	message.WriteString(event.Message)
+	for _, key := range keys {
+		fmt.Fprintf(&message, " %s=%v", key, fields[key])
+	}
+	return message.String()
+}
@@ -0,0 +1,25 @@
+//go:build windows || plan9
+
+package common
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/phuslu/log"
+	"github.com/ternarybob/arbor/writers"
+)
+
+func validateSyslogFacility(string) error {
+	return fmt.Errorf("syslog output is not available on %s, use console or file", runtime.GOOS)
+}
+
+type syslogWriter struct{}
+
+func newSyslogWriter(*LoggingConfig) (*syslogWriter, error) {
+	return nil, validateSyslogFacility("")
+}
+
+func (w *syslogWriter) WithLevel(log.Level) writers.IWriter { return w }
+
+func (w *syslogWriter) Write(data []byte) (int, error) { return len(data), nil }
@@ -0,0 +1,106 @@
+package common
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+)
+
+// placeholderPattern matches a placeholder of a source or target URL, such
+// as {name}
+var placeholderPattern = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_-]*)\}`)
+
+// builtinPlaceholders cannot be defined in vars: {name} and {repo} are
+// expanded at load, {job} and {date} when a bundle target is written and
+// {path} when a discovery job creates its jobs
+var builtinPlaceholders = []string{"name", "repo", "job", "date", "path"}
+
+// expandURLs expands the placeholders of the source and target URLs of a job
+// once it is parsed: {name} is the job name, {repo} the last path segment of
+// the source without .git, and any other name a key of the job's vars.
+// Placeholders expanded later are kept, as are unknown ones, which
+// validatePlaceholders rejects.
+func (jc *JobConfig) expandURLs(jobName string) {
+	values := map[string]string{"name": jobName}
+	for key, value := range jc.Vars {
+		if !slices.Contains(builtinPlaceholders, key) {
+			values[key] = value
+		}
+	}
+
+	jc.Source = expandPlaceholders(jc.Source, values, nil)
+	for i := range jc.Sources {
+		jc.Sources[i].URL = expandPlaceholders(jc.Sources[i].URL, values, nil)
+	}
+
+	if jc.Source != "" && !placeholderPattern.MatchString(jc.Source) {
+		values["repo"] = path.Base(NormalizeRepositoryURL(jc.Source))
+	}
+	for i := range jc.Targets {
+		jc.Targets[i].URL = expandPlaceholders(jc.Targets[i].URL, values, jc.laterPlaceholders(jc.Targets[i].URL))
+	}
+}
+
+// laterPlaceholders returns the placeholders of a target URL that are
+// expanded after the configuration is loaded
+func (jc *JobConfig) laterPlaceholders(url string) []string {
+	switch {
+	case jc.Discovers():
+		return []string{"name", "path"}
+	case IsBundleURL(url):
+		return []string{"job", "date"}
+	}
+	return nil
+}
+
+func expandPlaceholders(url string, values map[string]string, keep []string) string {
+	return placeholderPattern.ReplaceAllStringFunc(url, func(placeholder string) string {
+		name := placeholder[1 : len(placeholder)-1]
+		if value, ok := values[name]; ok && !slices.Contains(keep, name) {
+			return value
+		}
+		return placeholder
+	})
+}
+
+// validatePlaceholders rejects vars redefining a built-in placeholder and
+// source or target URLs left with a placeholder expandURLs could not resolve
+func validatePlaceholders(jobName string, jobConfig *JobConfig) error {
+	for key := range jobConfig.Vars {
+		if slices.Contains(builtinPlaceholders, key) {
+			return fmt.Errorf("vars cannot define the built-in placeholder {%s} for job '%s'", key, jobName)
+		}
+		if !placeholderPattern.MatchString("{" + key + "}") {
+			return fmt.Errorf("vars key '%s' is no valid placeholder name, use letters, digits, _ and -, for job '%s'", key, jobName)
+		}
+	}
+
+	unresolved := func(url string, keep []string) error {
+		for _, match := range placeholderPattern.FindAllStringSubmatch(url, -1) {
+			name := match[1]
+			switch {
+			case slices.Contains(keep, name):
+			case name == "repo":
+				return fmt.Errorf("unresolved placeholder {repo} in %s, it needs a source without placeholders, for job '%s'", url, jobName)
+			default:
+				return fmt.Errorf("unresolved placeholder {%s} in %s, define it in vars, for job '%s'", name, url, jobName)
+			}
+		}
+		return nil
+	}
+	if err := unresolved(jobConfig.Source, nil); err != nil {
+		return err
+	}
+	for _, source := range jobConfig.Sources {
+		if err := unresolved(source.URL, nil); err != nil {
+			return err
+		}
+	}
+	for _, target := range jobConfig.Targets {
+		if err := unresolved(target.URL, jobConfig.laterPlaceholders(target.URL)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package common
+
+import (
+	"os"
+)
+
+// styled reports whether console output may use ANSI colors and emoji. It is
+// off when stdout is not a terminal, such as under journald or in a container
+// log, and when disabled with NO_COLOR or logging no_color.
+var styled = stdoutIsTerminal() && os.Getenv("NO_COLOR") == ""
+
+// Styled reports whether output is decorated with colors and emoji
+func Styled() bool {
+	return styled
+}
+
+// Emoji returns the symbol followed by a space when output is styled and
+// nothing otherwise, for log messages that lead with an emoji
+func Emoji(symbol string) string {
+	if !styled {
+		return ""
+	}
+	return symbol + " "
+}
+
+func applyNoColor(config *LoggingConfig) {
+	if config.NoColor {
+		styled = false
+	}
+}
+
+func stdoutIsTerminal() bool {
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
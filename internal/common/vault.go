@@ -0,0 +1,106 @@
+package common
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Vault auth methods
+const (
+	VaultAuthToken      = "token"
+	VaultAuthKubernetes = "kubernetes"
+)
+
+// DefaultVaultCacheTTL is how long a secret read from Vault is used before it
+// is read again, when its lease does not say
+const DefaultVaultCacheTTL = 5 * time.Minute
+
+// defaultVaultJWTPath is where Kubernetes mounts the service account token
+const defaultVaultJWTPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// VaultConfig is the [vault] table, the HashiCorp Vault server jobs read
+// git_token_vault_path from. Its values are comparable, so a configuration
+// identifies the client that serves it.
+type VaultConfig struct {
+	Address       string        `toml:"address"`     // Server URL, VAULT_ADDR when empty
+	AuthMethod    string        `toml:"auth_method"` // token (default) or kubernetes
+	Token         string        `toml:"token"`       // Token of the token auth method, VAULT_TOKEN when empty
+	TokenEnv      string        `toml:"token_env"`
+	Role          string        `toml:"role"`       // Role of the kubernetes auth method
+	AuthMount     string        `toml:"auth_mount"` // Mount path of the kubernetes auth method
+	JWTPath       string        `toml:"jwt_path"`   // Service account token the kubernetes auth method logs in with
+	Namespace     string        `toml:"namespace"`  // Vault Enterprise namespace
+	CacheTTL      time.Duration `toml:"cache_ttl"`  // How long a secret without a lease is used before it is read again
+	CABundlePath  string        `toml:"ca_bundle_path"`
+	TLSSkipVerify bool          `toml:"tls_skip_verify"`
+}
+
+func parseVaultConfig(vaultMap map[string]interface{}) *VaultConfig {
+	return &VaultConfig{
+		Address:       strings.TrimSuffix(getString(vaultMap, "address", ""), "/"),
+		AuthMethod:    getString(vaultMap, "auth_method", VaultAuthToken),
+		Token:         getString(vaultMap, "token", ""),
+		TokenEnv:      getString(vaultMap, "token_env", ""),
+		Role:          getString(vaultMap, "role", ""),
+		AuthMount:     strings.Trim(getString(vaultMap, "auth_mount", VaultAuthKubernetes), "/"),
+		JWTPath:       getString(vaultMap, "jwt_path", defaultVaultJWTPath),
+		Namespace:     getString(vaultMap, "namespace", ""),
+		CacheTTL:      getDuration(vaultMap, "cache_ttl", DefaultVaultCacheTTL),
+		CABundlePath:  getString(vaultMap, "ca_bundle_path", ""),
+		TLSSkipVerify: getBool(vaultMap, "tls_skip_verify", false),
+	}
+}
+
+// applyEnvOverrides fills the address and token from the environment the way
+// the vault CLI does
+func (v *VaultConfig) applyEnvOverrides() {
+	if v.Address == "" {
+		v.Address = strings.TrimSuffix(os.Getenv("VAULT_ADDR"), "/")
+	}
+	switch {
+	case v.TokenEnv != "":
+		v.Token = os.Getenv(v.TokenEnv)
+	case v.Token == "":
+		v.Token = os.Getenv("VAULT_TOKEN")
+	}
+}
+
+func (v *VaultConfig) validate() error {
+	if v == nil {
+		return nil
+	}
+	if !strings.HasPrefix(v.Address, "https://") && !strings.HasPrefix(v.Address, "http://") {
+		return fmt.Errorf("address must be an http or https URL, set address or VAULT_ADDR")
+	}
+	if v.CacheTTL <= 0 {
+		return fmt.Errorf("cache_ttl must be a positive duration such as \"5m\"")
+	}
+	switch v.AuthMethod {
+	case VaultAuthToken:
+		if v.TokenEnv != "" && v.Token == "" {
+			return fmt.Errorf("token_env %s is not set", v.TokenEnv)
+		}
+		if v.Token == "" {
+			return fmt.Errorf("the token auth method needs token, token_env or VAULT_TOKEN")
+		}
+	case VaultAuthKubernetes:
+		if v.Role == "" {
+			return fmt.Errorf("the kubernetes auth method needs role")
+		}
+	default:
+		return fmt.Errorf("auth_method '%s' is not supported, use token or kubernetes", v.AuthMethod)
+	}
+	return nil
+}
+
+// VaultSecretRef splits a Vault secret reference such as
+// secret/data/gitsync#token into the path and the field, token when not given
+func VaultSecretRef(ref string) (secretPath, field string) {
+	secretPath, field, found := strings.Cut(ref, "#")
+	if !found || field == "" {
+		field = "token"
+	}
+	return strings.Trim(secretPath, "/"), field
+}
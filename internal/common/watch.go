@@ -0,0 +1,85 @@
+package common
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// ConfigWatchDebounce is how long the configuration file has to stay unchanged
+// before a change is reported, so editors and partial writes cause one reload
+const ConfigWatchDebounce = 2 * time.Second
+
+// WatchConfig reports changes to the content of the file at path on the
+// returned channel until ctx is done. The directory is watched rather than
+// the file, so replacing the file by rename and Kubernetes ConfigMap updates,
+// which swap a symlink, are seen as well. Events that leave the content as it
+// was are ignored.
+func WatchConfig(ctx context.Context, path string) (<-chan struct{}, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file watcher: %w", err)
+	}
+
+	dir := filepath.Dir(path)
+	if err := watcher.Add(dir); err != nil {
+		watcher.Close()
+		return nil, fmt.Errorf("failed to watch %s: %w", dir, err)
+	}
+
+	changed := make(chan struct{}, 1)
+	lastHash, _ := fileHash(path)
+
+	go func() {
+		defer watcher.Close()
+
+		logger := GetLogger()
+		timer := time.NewTimer(ConfigWatchDebounce)
+		timer.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case _, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				timer.Reset(ConfigWatchDebounce)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logger.Warn().Str("config", path).Err(err).Msg("Configuration file watcher error")
+			case <-timer.C:
+				// A missing file is an update in progress, the next event retries
+				hash, err := fileHash(path)
+				if err != nil || hash == lastHash {
+					continue
+				}
+				lastHash = hash
+
+				select {
+				case changed <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+
+	return changed, nil
+}
+
+func fileHash(path string) ([sha256.Size]byte, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	return sha256.Sum256(content), nil
+}
@@ -0,0 +1,11 @@
+package common
+
+import "strings"
+
+// WikiURL returns the URL of the wiki repository belonging to a repository.
+// GitHub, GitLab, Gitea and Forgejo all keep the wiki of <repo>.git in
+// <repo>.wiki.git next to it, which also holds for local paths.
+func WikiURL(url string) string {
+	url = strings.TrimSuffix(strings.TrimRight(url, `/\`), ".git")
+	return url + ".wiki.git"
+}
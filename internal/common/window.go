@@ -0,0 +1,102 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SyncWindow restricts scheduled runs of a job to a daily time range. A range
+// whose end is before its start crosses midnight, such as 20:00 to 06:00, and
+// belongs to the day it starts on.
+type SyncWindow struct {
+	Start string   `toml:"start"` // HH:MM
+	End   string   `toml:"end"`   // HH:MM, exclusive
+	Days  []string `toml:"days"`  // mon..sun, every day when empty
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "sunday": time.Sunday,
+	"mon": time.Monday, "monday": time.Monday,
+	"tue": time.Tuesday, "tuesday": time.Tuesday,
+	"wed": time.Wednesday, "wednesday": time.Wednesday,
+	"thu": time.Thursday, "thursday": time.Thursday,
+	"fri": time.Friday, "friday": time.Friday,
+	"sat": time.Saturday, "saturday": time.Saturday,
+}
+
+func parseSyncWindow(windowMap map[string]interface{}) *SyncWindow {
+	return &SyncWindow{
+		Start: getString(windowMap, "start", ""),
+		End:   getString(windowMap, "end", ""),
+		Days:  getStringSlice(windowMap, "days"),
+	}
+}
+
+func (w *SyncWindow) validate() error {
+	start, err := parseClock(w.Start)
+	if err != nil {
+		return fmt.Errorf("start: %w", err)
+	}
+	end, err := parseClock(w.End)
+	if err != nil {
+		return fmt.Errorf("end: %w", err)
+	}
+	if start == end {
+		return fmt.Errorf("start and end are both %s, the window is empty", w.Start)
+	}
+	for _, day := range w.Days {
+		if _, ok := weekdays[strings.ToLower(day)]; !ok {
+			return fmt.Errorf("unknown day '%s', use mon, tue, wed, thu, fri, sat or sun", day)
+		}
+	}
+	return nil
+}
+
+// Contains reports whether t falls inside the window, in the location of t
+func (w *SyncWindow) Contains(t time.Time) bool {
+	start, _ := parseClock(w.Start)
+	end, _ := parseClock(w.End)
+	minute := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+
+	switch {
+	case start < end:
+		if minute < start || minute >= end {
+			return false
+		}
+	case minute >= start:
+	case minute < end:
+		// The early hours of a window that opened the day before
+		day = (day + 6) % 7
+	default:
+		return false
+	}
+
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, name := range w.Days {
+		if weekdays[strings.ToLower(name)] == day {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *SyncWindow) String() string {
+	days := "daily"
+	if len(w.Days) > 0 {
+		days = strings.Join(w.Days, ",")
+	}
+	return fmt.Sprintf("%s-%s %s", w.Start, w.End, days)
+}
+
+// parseClock returns the minutes since midnight of an HH:MM time
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time '%s', use HH:MM", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
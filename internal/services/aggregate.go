@@ -0,0 +1,241 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/ternarybob/gitsync/internal/common"
+)
+
+// aggregatedBranch is where a branch of an aggregation job comes from
+type aggregatedBranch struct {
+	source string // Name of the [[job.source]]
+	ref    string // Remote-tracking ref of the source branch
+}
+
+// upstream returns the ref a branch is checked out from: the source branch of
+// an aggregation job, else the branch of origin
+func (s *Syncer) upstream(branch string) string {
+	if aggregated, ok := s.aggregated[branch]; ok {
+		return aggregated.ref
+	}
+	return "origin/" + branch
+}
+
+// aggregateDir is where an aggregation job keeps the repository every source
+// is fetched into, each as its own remote
+func (s *Syncer) aggregateDir() string {
+	return filepath.Join(s.tempDir, "aggregate")
+}
+
+// sourceRemote names the remote of an aggregation source
+func sourceRemote(source common.SourceConfig) string {
+	return "source-" + source.Name
+}
+
+// syncSources runs an aggregation job. The sources are fetched in parallel,
+// then every selected branch is pushed to the targets as <prefix><branch>. A
+// source that cannot be fetched fails on every target, the other sources are
+// still synced.
+func (s *Syncer) syncSources(ctx context.Context, result *SyncResult) error {
+	repoDir := s.aggregateDir()
+	exists, err := dirExists(repoDir)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		cmd := s.git(ctx, "init", "--quiet", repoDir)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to create repository: %w\n%s", err, output)
+		}
+	}
+
+	fetchErrs := s.fetchSources(ctx, repoDir)
+
+	s.aggregated = make(map[string]aggregatedBranch)
+	var branchesToSync []string
+	for _, source := range s.jobConfig.Sources {
+		err := fetchErrs[source.Name]
+		var selected []string
+		if err == nil {
+			selected, err = s.sourceBranches(ctx, repoDir, source)
+		}
+		if err != nil {
+			for _, target := range s.targets() {
+				s.record(result, SyncEntry{Source: source.Name, Target: target.URL, err: err})
+			}
+			continue
+		}
+
+		for _, branch := range selected {
+			name := source.Prefix + branch
+			if s.filter.Branch != "" && s.filter.Branch != name {
+				continue
+			}
+			s.aggregated[name] = aggregatedBranch{source: source.Name, ref: "refs/remotes/" + sourceRemote(source) + "/" + branch}
+			branchesToSync = append(branchesToSync, name)
+		}
+		s.logger.Info().Str("job", s.jobName).Str("source", source.Name).Strs("branches", selected).Msg("Found branches of source to sync")
+	}
+
+	if len(branchesToSync) == 0 {
+		s.logger.Warn().Str("job", s.jobName).Msg("No branches to sync")
+		return nil
+	}
+	result.Matched = len(branchesToSync)
+
+	if err := s.runPreSyncHook(ctx, repoDir, branchesToSync, nil); err != nil {
+		return err
+	}
+	return s.syncBranches(ctx, repoDir, branchesToSync, result)
+}
+
+// fetchSources fetches every source into its remote at once and returns the
+// error of each source that failed, keyed by its name
+func (s *Syncer) fetchSources(ctx context.Context, repoDir string) map[string]error {
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		errs = make(map[string]error)
+	)
+	for _, source := range s.jobConfig.Sources {
+		wg.Add(1)
+		go func(source common.SourceConfig) {
+			defer wg.Done()
+			if err := s.fetchSource(ctx, repoDir, source); err != nil {
+				s.logger.Error().Str("job", s.jobName).Str("source", source.Name).Err(err).Msg("Failed to fetch source")
+				mu.Lock()
+				errs[source.Name] = err
+				mu.Unlock()
+			}
+		}(source)
+	}
+	wg.Wait()
+	return errs
+}
+
+// fetchSource points the remote of a source at its URL and fetches its
+// branches. Fetches of other sources run alongside in the same repository, so
+// none writes FETCH_HEAD or starts an automatic gc.
+func (s *Syncer) fetchSource(ctx context.Context, repoDir string, source common.SourceConfig) (err error) {
+	ctx, span := tracer.Start(ctx, "git fetch", trace.WithAttributes(
+		attribute.String("gitsync.source", source.Name),
+		attribute.String("gitsync.source_host", common.RepositoryHost(source.URL)),
+	))
+	defer func() { endSpan(span, err) }()
+
+	remote := sourceRemote(source)
+	cmd := s.git(ctx, "remote", "get-url", remote)
+	cmd.Dir = repoDir
+	output, err := cmd.Output()
+	switch {
+	case err != nil:
+		cmd = s.git(ctx, "remote", "add", remote, source.URL)
+	case strings.TrimSpace(string(output)) != source.URL:
+		cmd = s.git(ctx, "remote", "set-url", remote, source.URL)
+	default:
+		cmd = nil
+	}
+	if cmd != nil {
+		cmd.Dir = repoDir
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to configure remote: %w\n%s", err, s.redact(string(output)))
+		}
+	}
+
+	if err := s.setupSourceAuth(source); err != nil {
+		return err
+	}
+
+	phaseCtx, cancel := s.phaseContext(ctx, phaseFetch)
+	defer cancel()
+
+	args := append([]string{"-c", "fetch.writeFetchHead=false", "-c", "gc.auto=0", "fetch", remote, "--prune"}, s.progressArg()...)
+	cmd = s.gitSource(phaseCtx, source, args...)
+	cmd.Dir = repoDir
+	if output, err := s.runStreamed(cmd, "fetch"); err != nil {
+		return fmt.Errorf("failed to fetch: %w\n%s", s.phaseError(ctx, phaseCtx, phaseFetch, source.URL, err), output)
+	}
+	return nil
+}
+
+// sourceBranches returns the fetched branches of a source matching its
+// patterns, or its default branch when it has none
+func (s *Syncer) sourceBranches(ctx context.Context, repoDir string, source common.SourceConfig) ([]string, error) {
+	remote := sourceRemote(source)
+
+	if len(source.Branches) == 0 {
+		phaseCtx, cancel := s.phaseContext(ctx, phaseFetch)
+		defer cancel()
+
+		cmd := s.gitSource(phaseCtx, source, "ls-remote", "--symref", remote, "HEAD")
+		cmd.Dir = repoDir
+		output, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve default branch: %w", s.phaseError(ctx, phaseCtx, phaseFetch, source.URL, err))
+		}
+		branch, err := symrefBranch(output)
+		if err != nil {
+			return nil, err
+		}
+		return []string{branch}, nil
+	}
+
+	cmd := s.git(ctx, "for-each-ref", "--format=%(refname)", "refs/remotes/"+remote+"/")
+	cmd.Dir = repoDir
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list branches: %w", err)
+	}
+
+	var branches []string
+	for _, ref := range strings.Fields(string(output)) {
+		branch := strings.TrimPrefix(ref, "refs/remotes/"+remote+"/")
+		if branch != "HEAD" && source.ShouldSyncBranch(branch) {
+			branches = append(branches, branch)
+		}
+	}
+	sort.Strings(branches)
+	return branches, nil
+}
+
+// gitSource builds a git command that talks to an aggregation source, with
+// its own credentials when it has any and the job-level ones otherwise
+func (s *Syncer) gitSource(ctx context.Context, source common.SourceConfig, args ...string) *exec.Cmd {
+	s.waitRateLimit(ctx, source.URL, args)
+	cmd := s.gitCommand(ctx, nil, args)
+	if source.GitToken != "" && source.GitUsername != "" {
+		cmd.Env = append(cmd.Env, "GIT_ASKPASS="+s.sourceAskPass(source))
+	}
+	if source.SSHKeyPath != "" {
+		cmd.Env = append(cmd.Env, sshCommand(source.SSHKeyPath))
+	}
+	s.limitBandwidth(cmd, source.URL, args)
+	return cmd
+}
+
+// sourceAskPass is the askpass script answering with the token of a source
+func (s *Syncer) sourceAskPass(source common.SourceConfig) string {
+	return filepath.Join(s.tempDir, "git-askpass-"+source.Name+".sh")
+}
+
+// setupSourceAuth writes the askpass script of a source with its own token
+func (s *Syncer) setupSourceAuth(source common.SourceConfig) error {
+	if source.GitToken == "" || source.GitUsername == "" {
+		return nil
+	}
+	content := fmt.Sprintf("#!/bin/sh\necho '%s'", source.GitToken)
+	if err := os.WriteFile(s.sourceAskPass(source), []byte(content), 0700); err != nil {
+		return fmt.Errorf("failed to create askpass script: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package services
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ternarybob/gitsync/internal/common"
+	"github.com/ternarybob/gitsync/internal/store"
+)
+
+// defaultAPIHistoryLimit is how many transactions GET /api/jobs/{name} returns
+// when no limit is given
+const defaultAPIHistoryLimit = 20
+
+// jobInfo is the API view of a configured job
+type jobInfo struct {
+	Name         string               `json:"name"`
+	Description  string               `json:"description,omitempty"`
+	Enabled      bool                 `json:"enabled"`
+	Schedule     string               `json:"schedule"`
+	Source       string               `json:"source"`
+	Targets      int                  `json:"targets"`
+	Running      bool                 `json:"running"`
+	SkippedRuns  int                  `json:"skipped_runs"`
+	Failures     int                  `json:"consecutive_failures"`
+	Tripped      bool                 `json:"tripped"`
+	TrippedUntil *time.Time           `json:"tripped_until,omitempty"`
+	TrippedLeft  string               `json:"tripped_remaining,omitempty"`
+	Timezone     string               `json:"timezone,omitempty"`
+	NextRun      *time.Time           `json:"next_run,omitempty"`
+	NextRunUTC   *time.Time           `json:"next_run_utc,omitempty"`
+	PrevRun      *time.Time           `json:"prev_run,omitempty"`
+	Progress     *GitProgress         `json:"progress,omitempty"`
+	Transactions []*store.Transaction `json:"transactions,omitempty"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// registerAPI adds the job API routes, behind bearer authentication when an
+// api_token is configured
+func (s *Server) registerAPI(mux *http.ServeMux) {
+	mux.Handle("GET /api/jobs", s.authenticate(s.handleListJobs))
+	mux.Handle("GET /api/jobs/{name}", s.authenticate(s.handleGetJob))
+	mux.Handle("POST /api/jobs/{name}/run", s.authenticate(s.handleRunJob))
+	mux.Handle("GET /api/runs/{id}", s.authenticate(s.handleGetRun))
+}
+
+func (s *Server) authenticate(next http.HandlerFunc) http.Handler {
+	token := s.config.Server.APIToken
+	if token == "" {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gitsync"`)
+			writeJSON(w, http.StatusUnauthorized, apiError{Error: "missing or invalid bearer token"})
+			return
+		}
+		next(w, r)
+	})
+}
+
+func (s *Server) handleListJobs(w http.ResponseWriter, r *http.Request) {
+	cfg := s.scheduler.Config()
+	jobs := make([]jobInfo, 0, len(cfg.Jobs.Names))
+	for _, name := range cfg.Jobs.Names {
+		if info, exists := s.jobInfo(name); exists {
+			jobs = append(jobs, info)
+		}
+	}
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+func (s *Server) handleGetJob(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	info, exists := s.jobInfo(name)
+	if !exists {
+		writeJSON(w, http.StatusNotFound, apiError{Error: "job not found: " + name})
+		return
+	}
+
+	if s.store != nil {
+		limit := defaultAPIHistoryLimit
+		if value := r.URL.Query().Get("limit"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 0 {
+				writeJSON(w, http.StatusBadRequest, apiError{Error: "limit must be a non-negative number"})
+				return
+			}
+			limit = parsed
+		}
+
+		transactions, err := s.store.GetTransactionsByJob(name, limit)
+		if err != nil {
+			common.GetLogger().Warn().Str("job", name).Err(err).Msg("Failed to read job history for API")
+			writeJSON(w, http.StatusInternalServerError, apiError{Error: "failed to read job history"})
+			return
+		}
+		info.Transactions = transactions
+	}
+
+	writeJSON(w, http.StatusOK, info)
+}
+
+func (s *Server) handleRunJob(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if _, exists := s.scheduler.Config().GetJobConfig(name); !exists {
+		writeJSON(w, http.StatusNotFound, apiError{Error: "job not found: " + name})
+		return
+	}
+
+	force := false
+	if value := r.URL.Query().Get("force"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, apiError{Error: "force must be true or false"})
+			return
+		}
+		force = parsed
+	}
+
+	run, err := s.scheduler.StartJob(name, force)
+	if errors.Is(err, ErrJobRunning) || errors.Is(err, ErrJobDisabled) {
+		writeJSON(w, http.StatusConflict, apiError{Error: err.Error()})
+		return
+	}
+	if errors.Is(err, ErrSchedulerStopping) {
+		writeJSON(w, http.StatusServiceUnavailable, apiError{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, apiError{Error: err.Error()})
+		return
+	}
+
+	common.GetLogger().Info().Str("job", name).Str("run_id", run.ID).Str("remote_addr", r.RemoteAddr).Str("force", strconv.FormatBool(force)).Msg("Job run triggered through API")
+
+	w.Header().Set("Location", "/api/runs/"+run.ID)
+	writeJSON(w, http.StatusAccepted, run)
+}
+
+func (s *Server) handleGetRun(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	run, exists := s.scheduler.GetRun(id)
+	if !exists {
+		writeJSON(w, http.StatusNotFound, apiError{Error: "run not found: " + id})
+		return
+	}
+	writeJSON(w, http.StatusOK, run)
+}
+
+// jobInfo describes a configured job with its schedule when it is scheduled
+func (s *Server) jobInfo(name string) (jobInfo, bool) {
+	cfg := s.scheduler.Config()
+	jobConfig, exists := cfg.GetJobConfig(name)
+	if !exists {
+		return jobInfo{}, false
+	}
+
+	info := jobInfo{
+		Name:        name,
+		Description: jobConfig.Description,
+		Enabled:     jobConfig.Enabled,
+		Schedule:    cfg.JobSchedule(name),
+		Source:      jobConfig.SourceLabel(),
+		Targets:     len(jobConfig.Targets),
+		Running:     s.scheduler.IsRunning(name),
+	}
+
+	if status, err := s.scheduler.GetJobStatus(name); err == nil {
+		info.Timezone, _ = status["timezone"].(string)
+		if next, ok := status["next_run"].(time.Time); ok && !next.IsZero() {
+			info.NextRun = &next
+		}
+		if next, ok := status["next_run_utc"].(time.Time); ok && !next.IsZero() {
+			info.NextRunUTC = &next
+		}
+		if prev, ok := status["prev_run"].(time.Time); ok && !prev.IsZero() {
+			info.PrevRun = &prev
+		}
+		info.SkippedRuns, _ = status["skipped_runs"].(int)
+		info.Failures, _ = status["consecutive_failures"].(int)
+		info.Tripped, _ = status["tripped"].(bool)
+		if until, ok := status["tripped_until"].(time.Time); ok {
+			info.TrippedUntil = &until
+		}
+		if remaining, ok := status["tripped_remaining"].(time.Duration); ok {
+			info.TrippedLeft = remaining.String()
+		}
+		if progress, ok := status["progress"].(GitProgress); ok {
+			info.Progress = &progress
+		}
+	}
+
+	return info, true
+}
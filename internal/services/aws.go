@@ -0,0 +1,513 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ternarybob/gitsync/internal/common"
+)
+
+const (
+	// awsTimeout bounds one request to an AWS API
+	awsTimeout = 15 * time.Second
+	// awsMetadataTimeout bounds one request to the ECS or EC2 metadata
+	// endpoints, which do not answer off AWS
+	awsMetadataTimeout = 2 * time.Second
+	// awsCredentialsMargin is how long before they expire temporary
+	// credentials are fetched again
+	awsCredentialsMargin = 5 * time.Minute
+)
+
+// awsCredentials are the keys requests are signed with, Expires is zero for
+// long-lived keys
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expires         time.Time
+}
+
+// aws holds the credentials of the default chain and the secrets read with
+// them, shared by the jobs. Nothing read is written to disk.
+var aws = struct {
+	sync.Mutex
+	client      *http.Client
+	credentials *awsCredentials
+	secrets     map[string]cachedSecret
+}{
+	client:  &http.Client{Timeout: awsTimeout},
+	secrets: make(map[string]cachedSecret),
+}
+
+// readAWSSecret returns the SecretString of a Secrets Manager secret, or a key
+// of it holding JSON when the reference ends in #key
+func readAWSSecret(ctx context.Context, config *common.AWSConfig, ref string) (string, error) {
+	secretID, key := common.AWSSecretRef(ref)
+	value, err := awsCachedCall(ctx, config, "secretsmanager", secretID, func(credentials *awsCredentials, region string) (string, error) {
+		var resp struct {
+			SecretString string `json:"SecretString"`
+		}
+		request := map[string]interface{}{"SecretId": secretID}
+		if err := awsCall(ctx, credentials, "secretsmanager", region, "secretsmanager.GetSecretValue", request, &resp); err != nil {
+			return "", err
+		}
+		if resp.SecretString == "" {
+			return "", fmt.Errorf("secret has no SecretString, binary secrets are not supported")
+		}
+		return resp.SecretString, nil
+	})
+	if err != nil || key == "" {
+		return value, err
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(value), &fields); err != nil {
+		return "", fmt.Errorf("secret is not a JSON object to read key %s from", key)
+	}
+	field, ok := fields[key].(string)
+	if !ok || field == "" {
+		return "", fmt.Errorf("secret has no key %s", key)
+	}
+	return field, nil
+}
+
+// readAWSParameter returns the decrypted value of a Parameter Store parameter
+func readAWSParameter(ctx context.Context, config *common.AWSConfig, name string) (string, error) {
+	return awsCachedCall(ctx, config, "ssm", name, func(credentials *awsCredentials, region string) (string, error) {
+		var resp struct {
+			Parameter struct {
+				Value string `json:"Value"`
+			} `json:"Parameter"`
+		}
+		request := map[string]interface{}{"Name": name, "WithDecryption": true}
+		if err := awsCall(ctx, credentials, "ssm", region, "AmazonSSM.GetParameter", request, &resp); err != nil {
+			return "", err
+		}
+		if resp.Parameter.Value == "" {
+			return "", fmt.Errorf("parameter is empty")
+		}
+		return resp.Parameter.Value, nil
+	})
+}
+
+// awsCachedCall returns the value read by read, from the cache until the
+// refresh interval passes
+func awsCachedCall(ctx context.Context, config *common.AWSConfig, service, id string, read func(*awsCredentials, string) (string, error)) (string, error) {
+	region := config.RegionOf(id)
+	cacheKey := service + "/" + region + "/" + id
+
+	aws.Lock()
+	defer aws.Unlock()
+	if cached, ok := aws.secrets[cacheKey]; ok && time.Now().Before(cached.expires) {
+		return cached.value, nil
+	}
+
+	credentials, err := awsDefaultCredentials(ctx, region)
+	if err != nil {
+		return "", err
+	}
+	value, err := read(credentials, region)
+	if err != nil {
+		return "", err
+	}
+	aws.secrets[cacheKey] = cachedSecret{value: value, expires: time.Now().Add(config.RefreshInterval)}
+	return value, nil
+}
+
+// awsDefaultCredentials returns the credentials of the default chain, the
+// first of: AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, web identity with
+// AWS_WEB_IDENTITY_TOKEN_FILE and AWS_ROLE_ARN, the shared credentials file,
+// the ECS task role and the EC2 instance role. Temporary credentials are
+// kept until shortly before they expire. The caller holds the lock.
+func awsDefaultCredentials(ctx context.Context, region string) (*awsCredentials, error) {
+	if c := aws.credentials; c != nil && (c.Expires.IsZero() || time.Until(c.Expires) > awsCredentialsMargin) {
+		return c, nil
+	}
+
+	var credentials *awsCredentials
+	var err error
+	switch {
+	case os.Getenv("AWS_ACCESS_KEY_ID") != "" && os.Getenv("AWS_SECRET_ACCESS_KEY") != "":
+		credentials = &awsCredentials{
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}
+	case os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE") != "" && os.Getenv("AWS_ROLE_ARN") != "":
+		credentials, err = awsWebIdentityCredentials(ctx, region)
+	default:
+		if credentials, err = awsSharedCredentials(); credentials == nil && err == nil {
+			credentials, err = awsContainerCredentials(ctx)
+		}
+		if credentials == nil && err == nil {
+			credentials, err = awsInstanceCredentials(ctx)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if credentials == nil {
+		return nil, fmt.Errorf("no AWS credentials found in the environment, web identity, shared credentials file, ECS or EC2 metadata")
+	}
+	aws.credentials = credentials
+	return credentials, nil
+}
+
+// awsWebIdentityCredentials assumes AWS_ROLE_ARN with the token Kubernetes
+// mounts for IAM roles for service accounts
+func awsWebIdentityCredentials(ctx context.Context, region string) (*awsCredentials, error) {
+	token, err := os.ReadFile(os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read web identity token: %w", err)
+	}
+	sessionName := os.Getenv("AWS_ROLE_SESSION_NAME")
+	if sessionName == "" {
+		sessionName = "gitsync"
+	}
+	query := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {os.Getenv("AWS_ROLE_ARN")},
+		"RoleSessionName":  {sessionName},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, awsEndpoint("sts", region), strings.NewReader(query.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := aws.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to assume role with web identity: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		excerpt, _ := io.ReadAll(io.LimitReader(resp.Body, maxExcerpt))
+		return nil, fmt.Errorf("failed to assume role with web identity: unexpected response status %s: %s", resp.Status, strings.TrimSpace(string(excerpt)))
+	}
+	var result struct {
+		Credentials struct {
+			AccessKeyID     string    `xml:"AccessKeyId"`
+			SecretAccessKey string    `xml:"SecretAccessKey"`
+			SessionToken    string    `xml:"SessionToken"`
+			Expiration      time.Time `xml:"Expiration"`
+		} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode web identity credentials: %w", err)
+	}
+	return &awsCredentials{
+		AccessKeyID:     result.Credentials.AccessKeyID,
+		SecretAccessKey: result.Credentials.SecretAccessKey,
+		SessionToken:    result.Credentials.SessionToken,
+		Expires:         result.Credentials.Expiration,
+	}, nil
+}
+
+// awsSharedCredentials reads the keys of AWS_PROFILE, or the default
+// profile, from the shared credentials file. It returns nil without the file
+// or the profile.
+func awsSharedCredentials() (*awsCredentials, error) {
+	path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, nil
+		}
+		path = filepath.Join(home, ".aws", "credentials")
+	}
+	profile := os.Getenv("AWS_PROFILE")
+	if profile == "" {
+		profile = "default"
+	}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read shared credentials file: %w", err)
+	}
+	defer file.Close()
+
+	credentials := &awsCredentials{}
+	section := ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		key, value, found := strings.Cut(line, "=")
+		if !found || section != profile {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "aws_access_key_id":
+			credentials.AccessKeyID = strings.TrimSpace(value)
+		case "aws_secret_access_key":
+			credentials.SecretAccessKey = strings.TrimSpace(value)
+		case "aws_session_token":
+			credentials.SessionToken = strings.TrimSpace(value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read shared credentials file: %w", err)
+	}
+	if credentials.AccessKeyID == "" || credentials.SecretAccessKey == "" {
+		return nil, nil
+	}
+	return credentials, nil
+}
+
+// awsMetadataCredentials holds the credentials the ECS and EC2 metadata
+// endpoints answer with
+type awsMetadataCredentials struct {
+	AccessKeyID     string    `json:"AccessKeyId"`
+	SecretAccessKey string    `json:"SecretAccessKey"`
+	Token           string    `json:"Token"`
+	Expiration      time.Time `json:"Expiration"`
+}
+
+// awsContainerCredentials fetches the credentials of the ECS task role. It
+// returns nil outside ECS.
+func awsContainerCredentials(ctx context.Context) (*awsCredentials, error) {
+	endpoint := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+	if relative := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); relative != "" {
+		endpoint = "http://169.254.170.2" + relative
+	}
+	if endpoint == "" {
+		return nil, nil
+	}
+
+	header := http.Header{}
+	authorization := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+	if tokenFile := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"); tokenFile != "" {
+		token, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read container authorization token: %w", err)
+		}
+		authorization = strings.TrimSpace(string(token))
+	}
+	if authorization != "" {
+		header.Set("Authorization", authorization)
+	}
+
+	var metadata awsMetadataCredentials
+	if err := awsMetadataGet(ctx, endpoint, header, &metadata); err != nil {
+		return nil, fmt.Errorf("failed to fetch ECS task credentials: %w", err)
+	}
+	return metadata.credentials(), nil
+}
+
+// awsInstanceCredentials fetches the credentials of the EC2 instance role
+// with IMDSv2. It returns nil off EC2 or with AWS_EC2_METADATA_DISABLED.
+func awsInstanceCredentials(ctx context.Context) (*awsCredentials, error) {
+	if strings.EqualFold(os.Getenv("AWS_EC2_METADATA_DISABLED"), "true") {
+		return nil, nil
+	}
+	const imds = "http://169.254.169.254/latest"
+
+	client := &http.Client{Timeout: awsMetadataTimeout}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, imds+"/api/token", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "21600")
+	resp, err := client.Do(req)
+	if err != nil {
+		// Not on EC2
+		return nil, nil
+	}
+	token, _ := io.ReadAll(io.LimitReader(resp.Body, maxExcerpt))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil
+	}
+
+	header := http.Header{}
+	header.Set("X-aws-ec2-metadata-token", string(token))
+	var role string
+	if err := awsMetadataGet(ctx, imds+"/meta-data/iam/security-credentials/", header, &role); err != nil {
+		return nil, fmt.Errorf("failed to find the EC2 instance role: %w", err)
+	}
+	role = strings.TrimSpace(strings.SplitN(role, "\n", 2)[0])
+	if role == "" {
+		return nil, nil
+	}
+	var metadata awsMetadataCredentials
+	if err := awsMetadataGet(ctx, imds+"/meta-data/iam/security-credentials/"+role, header, &metadata); err != nil {
+		return nil, fmt.Errorf("failed to fetch EC2 instance role credentials: %w", err)
+	}
+	return metadata.credentials(), nil
+}
+
+// awsMetadataGet reads a metadata endpoint into out, a string pointer takes
+// the body as it is and anything else is decoded as JSON
+func awsMetadataGet(ctx context.Context, endpoint string, header http.Header, out interface{}) error {
+	client := &http.Client{Timeout: awsMetadataTimeout}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header = header
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+	if text, ok := out.(*string); ok {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxExcerpt))
+		*text = string(body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (m awsMetadataCredentials) credentials() *awsCredentials {
+	return &awsCredentials{
+		AccessKeyID:     m.AccessKeyID,
+		SecretAccessKey: m.SecretAccessKey,
+		SessionToken:    m.Token,
+		Expires:         m.Expiration,
+	}
+}
+
+// awsEndpoint returns the endpoint of a service in a region. The AWS_ENDPOINT_URL
+// variables of the AWS SDKs override it, for VPC endpoints.
+func awsEndpoint(service, region string) string {
+	variable := map[string]string{
+		"secretsmanager": "AWS_ENDPOINT_URL_SECRETS_MANAGER",
+		"ssm":            "AWS_ENDPOINT_URL_SSM",
+		"sts":            "AWS_ENDPOINT_URL_STS",
+	}[service]
+	for _, name := range []string{variable, "AWS_ENDPOINT_URL"} {
+		if endpoint := os.Getenv(name); endpoint != "" {
+			return strings.TrimSuffix(endpoint, "/") + "/"
+		}
+	}
+	domain := "amazonaws.com"
+	if strings.HasPrefix(region, "cn-") {
+		domain = "amazonaws.com.cn"
+	}
+	return "https://" + service + "." + region + "." + domain + "/"
+}
+
+// awsCall sends a JSON protocol request, such as secretsmanager.GetSecretValue,
+// signed with Signature Version 4, and decodes the response into out. An
+// error carries the exception type and message AWS gave.
+func awsCall(ctx context.Context, credentials *awsCredentials, service, region, target string, request, out interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, awsEndpoint(service, region), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", target)
+	signAWSRequest(req, body, credentials, service, region, time.Now().UTC())
+
+	resp, err := aws.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var failure struct {
+			Type         string `json:"__type"`
+			Message      string `json:"message"`
+			MessageUpper string `json:"Message"`
+		}
+		excerpt, _ := io.ReadAll(io.LimitReader(resp.Body, maxExcerpt))
+		if json.Unmarshal(excerpt, &failure) == nil && failure.Type != "" {
+			// Types may be qualified, such as com.amazon.coral.service#UnrecognizedClientException
+			exception := failure.Type[strings.LastIndex(failure.Type, "#")+1:]
+			return fmt.Errorf("unexpected response status %s: %s: %s", resp.Status, exception, failure.Message+failure.MessageUpper)
+		}
+		return fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// signAWSRequest adds the Signature Version 4 headers to a request whose
+// signed headers are its Host, Content-Type and X-Amz-* headers
+func signAWSRequest(req *http.Request, body []byte, credentials *awsCredentials, service, region string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if credentials.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", credentials.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "content-type" || strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	payloadHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method, path, req.URL.RawQuery, canonicalHeaders.String(), signedHeaders, hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + credentials.SecretAccessKey)
+	for _, part := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		credentials.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,311 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+
+	"github.com/ternarybob/gitsync/internal/common"
+)
+
+// transferCommands are the git commands max_bandwidth applies to
+var transferCommands = map[string]bool{"clone": true, "fetch": true, "push": true}
+
+// limitBandwidth routes a clone, fetch or push over http(s) through the
+// throttling proxy of the run when the job sets max_bandwidth, and logs the
+// cap. git has no transfer limit of its own, ssh remotes are not limited.
+func (s *Syncer) limitBandwidth(cmd *exec.Cmd, remote string, args []string) {
+	limit := s.jobConfig.MaxBandwidth
+	command := gitSubcommand(args)
+	if limit <= 0 || !transferCommands[command] || common.IsLocalRepository(remote) || common.IsBundleURL(remote) {
+		return
+	}
+	remote = common.RedactURLCredentials(remote)
+
+	lower := strings.ToLower(remote)
+	if !strings.HasPrefix(lower, "http://") && !strings.HasPrefix(lower, "https://") {
+		s.logger.Warn().Str("job", s.jobName).Str("remote", remote).Str("command", command).Msg("Bandwidth not limited, max_bandwidth applies to http(s) remotes only")
+		return
+	}
+
+	proxy, err := s.bandwidthProxy(cmd.Env)
+	if err != nil {
+		s.logger.Warn().Str("job", s.jobName).Str("remote", remote).Str("command", command).Err(err).Msg("Failed to start bandwidth limiting")
+		return
+	}
+
+	// The -c option has to come before the command, git takes http.proxy over
+	// the proxy variables, which the throttling proxy follows instead
+	cmd.Args = slices.Insert(cmd.Args, 1, "-c", "http.proxy=http://"+proxy.listener.Addr().String())
+	cmd.Env = slices.DeleteFunc(cmd.Env, func(entry string) bool {
+		name, _, _ := strings.Cut(entry, "=")
+		return strings.EqualFold(name, "no_proxy")
+	})
+
+	s.logger.Info().Str("job", s.jobName).Str("remote", remote).Str("command", command).Str("limit", limit.String()).
+		Msg("Bandwidth limited")
+}
+
+// bandwidthProxy returns the throttling proxy of the run forwarding to the
+// upstream proxies of env, starting it on first use. All proxies of a run
+// share one limiter, so the cap holds for the job as a whole.
+func (s *Syncer) bandwidthProxy(env []string) (*bandwidthProxy, error) {
+	upstream := httpproxy.Config{
+		HTTPProxy:  envValue(env, "http_proxy", "HTTP_PROXY"),
+		HTTPSProxy: envValue(env, "https_proxy", "HTTPS_PROXY"),
+		NoProxy:    envValue(env, "no_proxy", "NO_PROXY"),
+	}
+	key := upstream.HTTPProxy + "\n" + upstream.HTTPSProxy + "\n" + upstream.NoProxy
+
+	s.throttleMu.Lock()
+	defer s.throttleMu.Unlock()
+	if proxy, ok := s.throttles[key]; ok {
+		return proxy, nil
+	}
+	if s.throttleLimiter == nil {
+		s.throttleLimiter = &byteLimiter{rate: s.jobConfig.MaxBandwidth}
+	}
+	proxy, err := startBandwidthProxy(s.throttleLimiter, upstream.ProxyFunc())
+	if err != nil {
+		return nil, err
+	}
+	if s.throttles == nil {
+		s.throttles = make(map[string]*bandwidthProxy)
+	}
+	s.throttles[key] = proxy
+	return proxy, nil
+}
+
+// closeBandwidthProxies stops the throttling proxies once the run is over
+func (s *Syncer) closeBandwidthProxies() {
+	s.throttleMu.Lock()
+	defer s.throttleMu.Unlock()
+	for _, proxy := range s.throttles {
+		proxy.close()
+	}
+	s.throttles = nil
+	s.throttleLimiter = nil
+}
+
+// envValue returns the last value of the first of names set in env
+func envValue(env []string, names ...string) string {
+	for _, name := range names {
+		for i := len(env) - 1; i >= 0; i-- {
+			if value, ok := strings.CutPrefix(env[i], name+"="); ok {
+				return value
+			}
+		}
+	}
+	return ""
+}
+
+// byteLimiter paces the bytes of all connections of a run to its rate, each
+// chunk starting once the ones before it had their time
+type byteLimiter struct {
+	mu   sync.Mutex
+	rate common.Bandwidth
+	next time.Time // When the next chunk may start
+}
+
+// chunk is the most bytes moved at once, a tenth of a second at the rate
+// within bounds, so a slow cap is not spent in bursts
+func (l *byteLimiter) chunk() int {
+	return min(max(int(l.rate/10), 512), 32*1024)
+}
+
+// wait blocks until n bytes may be moved
+func (l *byteLimiter) wait(n int) {
+	l.mu.Lock()
+	now := time.Now()
+	start := l.next
+	if start.Before(now) {
+		start = now
+	}
+	l.next = start.Add(time.Duration(float64(n) / float64(l.rate) * float64(time.Second)))
+	l.mu.Unlock()
+
+	time.Sleep(start.Sub(now))
+}
+
+// throttledConn is a connection to a remote or upstream proxy whose reads
+// and writes go through a limiter
+type throttledConn struct {
+	net.Conn
+	limiter *byteLimiter
+}
+
+func (c *throttledConn) Read(p []byte) (int, error) {
+	if chunk := c.limiter.chunk(); len(p) > chunk {
+		p = p[:chunk]
+	}
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.limiter.wait(n)
+	}
+	return n, err
+}
+
+func (c *throttledConn) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		end := min(written+c.limiter.chunk(), len(p))
+		c.limiter.wait(end - written)
+		n, err := c.Conn.Write(p[written:end])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// bandwidthProxy is a local HTTP proxy git talks to its http(s) remotes
+// through, tunnelling https and forwarding http over throttled connections
+type bandwidthProxy struct {
+	listener  net.Listener
+	server    *http.Server
+	limiter   *byteLimiter
+	upstream  func(*url.URL) (*url.URL, error)
+	transport *http.Transport
+}
+
+// startBandwidthProxy listens on a loopback port and serves until close
+func startBandwidthProxy(limiter *byteLimiter, upstream func(*url.URL) (*url.URL, error)) (*bandwidthProxy, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	p := &bandwidthProxy{listener: listener, limiter: limiter, upstream: upstream}
+	p.transport = &http.Transport{
+		Proxy:       func(r *http.Request) (*url.URL, error) { return upstream(r.URL) },
+		DialContext: p.dial,
+	}
+	p.server = &http.Server{Handler: p, ReadHeaderTimeout: time.Minute}
+	go p.server.Serve(listener)
+	return p, nil
+}
+
+func (p *bandwidthProxy) close() {
+	p.server.Close()
+	p.transport.CloseIdleConnections()
+}
+
+// dial opens a throttled connection
+func (p *bandwidthProxy) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return &throttledConn{Conn: conn, limiter: p.limiter}, nil
+}
+
+func (p *bandwidthProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		p.tunnel(w, r)
+		return
+	}
+
+	out := r.Clone(r.Context())
+	out.RequestURI = ""
+	out.Header.Del("Proxy-Connection")
+	out.Header.Del("Proxy-Authorization")
+	resp, err := p.transport.RoundTrip(out)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	for name, values := range resp.Header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// tunnel connects git to the host of a CONNECT request, directly or through
+// the upstream proxy, and copies the encrypted stream both ways
+func (p *bandwidthProxy) tunnel(w http.ResponseWriter, r *http.Request) {
+	remote, err := p.dialTunnel(r.Context(), r.Host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		remote.Close()
+		http.Error(w, "connection cannot be tunnelled", http.StatusInternalServerError)
+		return
+	}
+	client, buffered, err := hijacker.Hijack()
+	if err != nil {
+		remote.Close()
+		return
+	}
+	defer client.Close()
+	defer remote.Close()
+
+	if _, err := client.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n")); err != nil {
+		return
+	}
+	go func() {
+		io.Copy(remote, buffered)
+		remote.Close()
+	}()
+	io.Copy(client, remote)
+}
+
+// dialTunnel opens a throttled connection to addr, asking the upstream proxy
+// for it when there is one
+func (p *bandwidthProxy) dialTunnel(ctx context.Context, addr string) (net.Conn, error) {
+	proxyURL, err := p.upstream(&url.URL{Scheme: "https", Host: addr})
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL == nil {
+		return p.dial(ctx, "tcp", addr)
+	}
+
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		proxyAddr = net.JoinHostPort(proxyURL.Hostname(), "80")
+	}
+	conn, err := p.dial(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	request := "CONNECT " + addr + " HTTP/1.1\r\nHost: " + addr + "\r\n"
+	if user := proxyURL.User; user != nil {
+		password, _ := user.Password()
+		request += "Proxy-Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(user.Username()+":"+password)) + "\r\n"
+	}
+	if _, err := conn.Write([]byte(request + "\r\n")); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s refused to connect to %s: %s", proxyURL.Host, addr, resp.Status)
+	}
+	return conn, nil
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ternarybob/gitsync/internal/common"
+)
+
+// throttledGet downloads url through a throttling proxy limited to rate and
+// returns how long it took
+func throttledGet(t *testing.T, client *http.Client, rate common.Bandwidth, target string) time.Duration {
+	t.Helper()
+	direct := func(*url.URL) (*url.URL, error) { return nil, nil }
+	proxy, err := startBandwidthProxy(&byteLimiter{rate: rate}, direct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.close()
+
+	proxyURL, _ := url.Parse("http://" + proxy.listener.Addr().String())
+	transport := client.Transport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(proxyURL)
+	client = &http.Client{Transport: transport}
+
+	start := time.Now()
+	resp, err := client.Get(target)
+	if err != nil {
+		t.Fatalf("GET %s: %v", target, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(body) != 64*1024 {
+		t.Fatalf("got %d bytes, want 64KiB", len(body))
+	}
+	return time.Since(start)
+}
+
+func TestBandwidthProxyLimitsTransfers(t *testing.T) {
+	payload := strings.Repeat("x", 64*1024)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, payload) })
+
+	// 64KiB at 256KiB/s takes a quarter of a second, forwarded and tunnelled
+	plain := httptest.NewServer(handler)
+	defer plain.Close()
+	if elapsed := throttledGet(t, plain.Client(), 256*1024, plain.URL); elapsed < 200*time.Millisecond {
+		t.Errorf("http transfer took %s, want at least 200ms", elapsed)
+	}
+
+	tls := httptest.NewTLSServer(handler)
+	defer tls.Close()
+	if elapsed := throttledGet(t, tls.Client(), 256*1024, tls.URL); elapsed < 200*time.Millisecond {
+		t.Errorf("https transfer took %s, want at least 200ms", elapsed)
+	}
+}
+
+func TestLimitBandwidth(t *testing.T) {
+	logs := captureLogs(t)
+	s := &Syncer{jobName: "test", jobConfig: &common.JobConfig{MaxBandwidth: 1000 * 1000}, logger: common.GetLogger()}
+	defer s.closeBandwidthProxies()
+
+	tests := []struct {
+		remote string
+		args   []string
+		proxy  bool
+	}{
+		{"https://github.com/example/repo.git", []string{"clone", "--mirror"}, true},
+		{"http://git.example.com/repo.git", []string{"push", "target"}, true},
+		{"https://github.com/example/repo.git", []string{"ls-remote", "origin"}, false},
+		{"git@github.com:example/repo.git", []string{"fetch", "origin"}, false},
+		{"/srv/git/repo.git", []string{"push", "target"}, false},
+	}
+	for _, test := range tests {
+		cmd := exec.Command("git", test.args...)
+		cmd.Env = []string{"HOME=/tmp", "no_proxy=example.com"}
+		s.limitBandwidth(cmd, test.remote, test.args)
+
+		proxied := len(cmd.Args) > 2 && cmd.Args[1] == "-c" && strings.HasPrefix(cmd.Args[2], "http.proxy=http://127.0.0.1:")
+		if proxied != test.proxy {
+			t.Errorf("%s of %s: args = %q, want proxied %t", test.args[0], test.remote, cmd.Args, test.proxy)
+		}
+		if proxied && slices.Contains(cmd.Env, "no_proxy=example.com") {
+			t.Errorf("%s of %s: no_proxy kept, git would bypass the throttling proxy", test.args[0], test.remote)
+		}
+	}
+
+	// Commands of one run share the proxy
+	if len(s.throttles) != 1 {
+		t.Errorf("%d proxies started, want one", len(s.throttles))
+	}
+
+	// The cap is logged with the command it applies to
+	event, ok := logs.find("Bandwidth limited")
+	if !ok {
+		t.Fatal("no Bandwidth limited line logged")
+	}
+	if event.Fields["command"] != "clone" || event.Fields["limit"] != common.Bandwidth(1000*1000).String() {
+		t.Errorf("Bandwidth limited fields = %v, want the clone at 1 MB/s", event.Fields)
+	}
+	if event, ok := logs.find("Bandwidth not limited, max_bandwidth applies to http(s) remotes only"); !ok || event.Fields["command"] != "fetch" {
+		t.Errorf("ssh fetch logged %v, %t, want a warning naming the fetch", event.Fields, ok)
+	}
+}
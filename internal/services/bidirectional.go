@@ -0,0 +1,199 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/ternarybob/gitsync/internal/common"
+)
+
+// syncPair runs a bidirectional job. The target is fetched next to the
+// source, then every selected branch is fast-forwarded on whichever side is
+// behind. A branch both sides have commits on is diverged and pushed neither
+// way, nothing is ever forced.
+func (s *Syncer) syncPair(ctx context.Context, repoDir string, result *SyncResult) error {
+	targets := s.targets()
+	if len(targets) == 0 {
+		return nil
+	}
+	target := targets[0]
+
+	targetName, err := s.ensureRemote(ctx, repoDir, target.URL)
+	if err != nil {
+		return err
+	}
+	if err := s.fetchPeer(ctx, repoDir, target, targetName); err != nil {
+		return fmt.Errorf("failed to fetch target: %w", err)
+	}
+
+	branchesToSync, err := s.getBranchesToSync(ctx, repoDir)
+	if err != nil {
+		return fmt.Errorf("failed to get branches to sync: %w", err)
+	}
+	// Branches created on the target are synced back when the patterns select them
+	if len(s.jobConfig.Branches) > 0 && s.filter.Branch == "" {
+		peerBranches, err := s.peerBranches(ctx, repoDir, targetName)
+		if err != nil {
+			return err
+		}
+		for _, branch := range peerBranches {
+			if s.jobConfig.ShouldSyncBranch(branch) && !slices.Contains(branchesToSync, branch) {
+				branchesToSync = append(branchesToSync, branch)
+			}
+		}
+	}
+
+	if len(branchesToSync) == 0 {
+		s.logger.Warn().Str("job", s.jobName).Msg("No branches to sync")
+		return nil
+	}
+	result.Matched = len(branchesToSync)
+	s.logger.Info().Str("job", s.jobName).Strs("branches", branchesToSync).Msg("Found branches to sync both ways")
+
+	if err := s.runPreSyncHook(ctx, repoDir, branchesToSync, nil); err != nil {
+		return err
+	}
+
+	for i, branch := range branchesToSync {
+		if ctx.Err() != nil {
+			s.logger.Error().Str("job", s.jobName).Strs("branches_not_reached", branchesToSync[i:]).Err(ctx.Err()).Msg("Job aborted before all branches were synced")
+			return fmt.Errorf("job aborted with %d branches not synced: %w", len(branchesToSync)-i, ctx.Err())
+		}
+		s.syncPairBranch(ctx, repoDir, target, targetName, branch, result)
+	}
+	return nil
+}
+
+// fetchPeer fetches every branch of the target into its remote-tracking refs
+func (s *Syncer) fetchPeer(ctx context.Context, repoDir string, target common.TargetConfig, targetName string) (err error) {
+	ctx, span := tracer.Start(ctx, "git fetch", trace.WithAttributes(attribute.String("gitsync.target_host", common.RepositoryHost(target.URL))))
+	defer func() { endSpan(span, err) }()
+
+	phaseCtx, cancel := s.phaseContext(ctx, phaseFetch)
+	defer cancel()
+
+	cmd := s.gitTarget(phaseCtx, target, "fetch", targetName, "--prune")
+	cmd.Dir = repoDir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w\n%s", s.phaseError(ctx, phaseCtx, phaseFetch, target.URL, err), s.redact(string(output)))
+	}
+	return nil
+}
+
+// peerBranches returns the branches fetched from the target
+func (s *Syncer) peerBranches(ctx context.Context, repoDir, targetName string) ([]string, error) {
+	prefix := "refs/remotes/" + targetName + "/"
+	cmd := s.git(ctx, "for-each-ref", "--format=%(refname)", prefix)
+	cmd.Dir = repoDir
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list target branches: %w", err)
+	}
+
+	var branches []string
+	for _, ref := range strings.Fields(string(output)) {
+		if branch := strings.TrimPrefix(ref, prefix); branch != "HEAD" {
+			branches = append(branches, branch)
+		}
+	}
+	sort.Strings(branches)
+	return branches, nil
+}
+
+// syncPairBranch compares the tips of a branch on both sides and pushes the
+// newer one to the side that is behind, recording one entry
+func (s *Syncer) syncPairBranch(ctx context.Context, repoDir string, target common.TargetConfig, targetName, branch string, result *SyncResult) {
+	sourceTip, err := s.resolveRef(ctx, repoDir, "refs/remotes/origin/"+branch)
+	var targetTip string
+	if err == nil {
+		targetTip, err = s.resolveRef(ctx, repoDir, "refs/remotes/"+targetName+"/"+branch)
+	}
+	if err != nil {
+		s.record(result, SyncEntry{Branch: branch, Target: target.URL, err: err})
+		return
+	}
+
+	switch {
+	case sourceTip == targetTip:
+		s.record(result, SyncEntry{Branch: branch, Target: target.URL, Status: StatusSkipped, OldCommit: targetTip, NewCommit: sourceTip})
+	case targetTip == "" || s.isAncestor(ctx, repoDir, targetTip, sourceTip):
+		s.fastForward(ctx, repoDir, target, targetName, branch, targetTip, sourceTip, result)
+	case sourceTip == "" || s.isAncestor(ctx, repoDir, sourceTip, targetTip):
+		// The source has no target settings, it is pushed to with the job-level ones
+		s.fastForward(ctx, repoDir, common.TargetConfig{URL: s.jobConfig.Source}, "origin", branch, sourceTip, targetTip, result)
+	default:
+		targetOnly, sourceOnly := s.countDivergence(ctx, repoDir, targetTip, sourceTip)
+		s.record(result, SyncEntry{
+			Branch:    branch,
+			Target:    target.URL,
+			Status:    StatusDiverged,
+			OldCommit: targetTip,
+			NewCommit: sourceTip,
+			err: fmt.Errorf("branch %s diverged: source at %s has %d commits the target lacks, target at %s has %d commits the source lacks, merge them on one side",
+				branch, shortHash(sourceTip), sourceOnly, shortHash(targetTip), targetOnly),
+		})
+	}
+}
+
+// fastForward pushes commit to the branch of one side of the pair, fetched as
+// remote, whose branch is at oldCommit or does not exist yet
+func (s *Syncer) fastForward(ctx context.Context, repoDir string, side common.TargetConfig, remote, branch, oldCommit, commit string, result *SyncResult) {
+	url := side.URL
+	entry := SyncEntry{Branch: branch, Target: url, OldCommit: oldCommit, NewCommit: commit}
+	if oldCommit == "" {
+		entry.CommitsPushed = s.countCommits(ctx, repoDir, commit)
+	} else {
+		entry.CommitsPushed = s.countCommits(ctx, repoDir, oldCommit+".."+commit)
+	}
+
+	startTime := time.Now()
+	s.logger.Info().Str("job", s.jobName).Str("branch", branch).Str("target", url).Str("commit", commit).Msg("Fast-forwarding side that is behind")
+	entry.tx = s.beginTransaction(entry)
+
+	pushCtx, span := tracer.Start(ctx, "git push", trace.WithAttributes(
+		attribute.String("gitsync.branch", branch),
+		attribute.String("gitsync.target_host", common.RepositoryHost(url)),
+	))
+	phaseCtx, cancel := s.phaseContext(pushCtx, phasePush)
+	defer cancel()
+
+	// Never forced, a side that moved since the fetch rejects the push
+	cmd := s.gitTarget(phaseCtx, side, "push", "--progress", remote, commit+":refs/heads/"+branch)
+	cmd.Dir = repoDir
+	output, err := s.runStreamed(cmd, "push")
+	entry.Duration = time.Since(startTime)
+	if err != nil {
+		entry.err = fmt.Errorf("failed to push: %w\n%s", s.phaseError(pushCtx, phaseCtx, phasePush, url, err), output)
+	} else {
+		entry.Status = StatusPushed
+		entry.Objects, entry.Bytes = parsePushTransfer(string(output))
+	}
+	s.record(result, entry)
+	span.SetAttributes(entryAttributes(&entry)...)
+	endSpan(span, entry.err)
+}
+
+// resolveRef returns the commit of a ref, empty when the ref does not exist
+func (s *Syncer) resolveRef(ctx context.Context, repoDir, ref string) (string, error) {
+	cmd := s.git(ctx, "for-each-ref", "--format=%(objectname)", ref)
+	cmd.Dir = repoDir
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", ref, err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// isAncestor reports whether ancestor is reachable from commit
+func (s *Syncer) isAncestor(ctx context.Context, repoDir, ancestor, commit string) bool {
+	cmd := s.git(ctx, "merge-base", "--is-ancestor", ancestor, commit)
+	cmd.Dir = repoDir
+	return cmd.Run() == nil
+}
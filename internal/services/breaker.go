@@ -0,0 +1,82 @@
+package services
+
+import "time"
+
+// breakerState counts the consecutive failures of a job. Once they reach
+// max_consecutive_failures the breaker trips and scheduled runs are skipped
+// until failure_cooldown has passed.
+type breakerState struct {
+	failures     int
+	trippedUntil time.Time
+	skipLogged   bool // The skip of the current cooldown has been logged
+}
+
+// recordOutcome updates the breaker of a job after any run. A success closes
+// it, a failure after the cooldown trips it again right away.
+func (s *Scheduler) recordOutcome(jobName string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := s.breaker[jobName]
+	if state == nil {
+		state = &breakerState{}
+		s.breaker[jobName] = state
+	}
+
+	logger := s.logger
+	if err == nil {
+		if !state.trippedUntil.IsZero() {
+			logger.Info().Str("job", jobName).Msg("Job succeeded, circuit breaker reset")
+		}
+		*state = breakerState{}
+		return
+	}
+
+	state.failures++
+	limit := s.Config().Jobs.MaxConsecutiveFailures
+	if limit <= 0 || state.failures < limit || time.Now().Before(state.trippedUntil) {
+		return
+	}
+
+	state.trippedUntil = time.Now().Add(s.Config().Jobs.FailureCooldown)
+	state.skipLogged = false
+	logger.Error().Str("job", jobName).Int("consecutive_failures", state.failures).Str("until", state.trippedUntil.Format(time.RFC3339)).
+		Msg("Circuit breaker tripped, pausing scheduled runs of job")
+}
+
+// breakerOpen reports whether scheduled runs of a job are paused, logging the
+// first skip of each cooldown
+func (s *Scheduler) breakerOpen(jobName string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := s.breaker[jobName]
+	if state == nil || !time.Now().Before(state.trippedUntil) {
+		return false
+	}
+
+	if !state.skipLogged {
+		state.skipLogged = true
+		s.logger.Warn().Str("job", jobName).Int("consecutive_failures", state.failures).Str("until", state.trippedUntil.Format(time.RFC3339)).
+			Msg("Circuit breaker open, skipping scheduled runs until the cooldown ends")
+	}
+	return true
+}
+
+// addBreakerStatus adds the breaker state of a job to its status. s.mu must
+// be held.
+func (s *Scheduler) addBreakerStatus(jobName string, status map[string]interface{}) {
+	state := s.breaker[jobName]
+	if state == nil {
+		state = &breakerState{}
+	}
+
+	remaining := time.Until(state.trippedUntil)
+	status["consecutive_failures"] = state.failures
+	status["tripped"] = remaining > 0
+	if remaining > 0 {
+		status["tripped_until"] = state.trippedUntil
+		// Rounded up, a tripped job never reports 0s left
+		status["tripped_remaining"] = (remaining + time.Second - 1).Truncate(time.Second)
+	}
+}
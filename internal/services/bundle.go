@@ -0,0 +1,319 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// bundlePath expands the {job} and {date} placeholders of a bundle:// target
+func (s *Syncer) bundlePath(target string) string {
+	path := strings.TrimPrefix(target, "bundle://")
+	path = strings.NewReplacer(
+		"{job}", s.jobName,
+		"{date}", time.Now().UTC().Format("20060102-150405"),
+	).Replace(path)
+	return filepath.FromSlash(path)
+}
+
+// bundleBasisFile is where the tips of the last bundle written for a target are recorded
+func (s *Syncer) bundleBasisFile(target string) string {
+	path := filepath.Join(s.tempDir, "bundle-basis-"+uniqueName(target))
+	if err := migrateLegacyPath(filepath.Join(s.tempDir, "bundle-basis-"+sanitizeName(target)), path); err != nil {
+		s.logger.Debug().Str("job", s.jobName).Str("target", target).Err(err).Msg("Failed to migrate bundle basis")
+	}
+	return path
+}
+
+// writeBundle exports the synced branches (and tags when enabled) to a bundle
+// file, reporting a skip when an incremental bundle would be empty
+func (s *Syncer) writeBundle(ctx context.Context, repoDir, target string, branches []string) (bool, error) {
+	path := s.bundlePath(target)
+
+	refs := make([]string, 0, len(branches))
+	for _, branch := range branches {
+		refs = append(refs, "refs/heads/"+branch)
+	}
+	if s.jobConfig.SyncTags {
+		refs = append(refs, "--tags")
+	}
+
+	// Write next to the destination and rename so readers never see a partial bundle
+	tmpPath := path + ".tmp"
+
+	args := append([]string{"bundle", "create", tmpPath}, refs...)
+
+	var basis []string
+	if s.jobConfig.IncrementalBundle {
+		basis = s.readBundleBasis(ctx, repoDir, target)
+		for _, commit := range basis {
+			args = append(args, "^"+commit)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return false, fmt.Errorf("failed to create bundle directory: %w", err)
+	}
+
+	cmd := s.git(ctx, args...)
+	cmd.Dir = repoDir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(tmpPath)
+		if len(basis) > 0 && strings.Contains(string(output), "empty bundle") {
+			return true, nil
+		}
+		return false, fmt.Errorf("git bundle create failed: %w\n%s", err, output)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return false, fmt.Errorf("failed to move bundle into place: %w", err)
+	}
+
+	if s.jobConfig.IncrementalBundle {
+		if err := s.writeBundleBasis(ctx, repoDir, target, refs); err != nil {
+			s.logger.Warn().Str("job", s.jobName).Str("target", target).Err(err).Msg("Failed to record bundle basis, next bundle will be complete")
+		}
+	}
+
+	mode := "full"
+	if len(basis) > 0 {
+		mode = "incremental"
+	}
+
+	var size int64
+	if info, err := os.Stat(path); err == nil {
+		size = info.Size()
+	}
+
+	s.logger.Info().Str("job", s.jobName).Str("target", target).Str("bundle", path).Int64("bytes", size).Int("refs", len(branches)).Str("mode", mode).Msg("Successfully wrote bundle")
+	return false, nil
+}
+
+// readBundleBasis returns the recorded tips of the previous bundle that still exist locally
+func (s *Syncer) readBundleBasis(ctx context.Context, repoDir, target string) []string {
+	data, err := os.ReadFile(s.bundleBasisFile(target))
+	if err != nil {
+		return nil
+	}
+
+	var basis []string
+	for _, commit := range strings.Fields(string(data)) {
+		cmd := s.git(ctx, "cat-file", "-e", commit+"^{commit}")
+		cmd.Dir = repoDir
+		if cmd.Run() == nil {
+			basis = append(basis, commit)
+		}
+	}
+	return basis
+}
+
+// writeBundleBasis records the current tips of the bundled refs for the next incremental bundle
+func (s *Syncer) writeBundleBasis(ctx context.Context, repoDir, target string, refs []string) error {
+	args := []string{"rev-parse"}
+	for _, ref := range refs {
+		if ref != "--tags" {
+			args = append(args, ref)
+		}
+	}
+
+	cmd := s.git(ctx, args...)
+	cmd.Dir = repoDir
+	output, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("failed to resolve bundled refs: %w", err)
+	}
+
+	return os.WriteFile(s.bundleBasisFile(target), output, 0644)
+}
+
+// sourceBundles returns the bundle files of a bundle:// source in the order
+// they must be applied; a directory yields every *.bundle file sorted by name
+func (s *Syncer) sourceBundles() ([]string, error) {
+	path := filepath.FromSlash(strings.TrimPrefix(s.jobConfig.Source, "bundle://"))
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("source bundle %s is not accessible: %w", path, err)
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(path, "*.bundle"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list source bundles in %s: %w", path, err)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("source bundle directory %s contains no .bundle files", path)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// bundleState captures the identity of each source bundle for change detection
+func bundleState(files []string, previous map[string]string) (map[string]string, error) {
+	state := make(map[string]string, len(files))
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, fmt.Errorf("source bundle %s is not accessible: %w", file, err)
+		}
+		stamp := fmt.Sprintf("%d %d", info.Size(), info.ModTime().UnixNano())
+
+		// Only hash when size or mtime moved, a touched but identical file still counts as unchanged
+		if prev, ok := previous[file]; ok && strings.HasPrefix(prev, stamp+" ") {
+			state[file] = prev
+			continue
+		}
+
+		sum, err := fileChecksum(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to checksum source bundle %s: %w", file, err)
+		}
+		state[file] = stamp + " " + sum
+	}
+	return state, nil
+}
+
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (s *Syncer) bundleStateFile() string {
+	return filepath.Join(s.tempDir, "source-bundle-state")
+}
+
+func (s *Syncer) readBundleState() map[string]string {
+	state := make(map[string]string)
+	data, err := os.ReadFile(s.bundleStateFile())
+	if err != nil {
+		return state
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if file, stamp, ok := strings.Cut(line, "\t"); ok {
+			state[file] = stamp
+		}
+	}
+	return state
+}
+
+func (s *Syncer) writeBundleState(state map[string]string) error {
+	var b strings.Builder
+	for file, stamp := range state {
+		b.WriteString(file + "\t" + stamp + "\n")
+	}
+	return os.WriteFile(s.bundleStateFile(), []byte(b.String()), 0644)
+}
+
+// sameBundleState reports whether two bundle states refer to identical content
+func sameBundleState(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for file, stamp := range a {
+		prev, ok := b[file]
+		if !ok || prev[strings.LastIndex(prev, " ")+1:] != stamp[strings.LastIndex(stamp, " ")+1:] {
+			return false
+		}
+	}
+	return true
+}
+
+// fetchBundleSource unbundles a bundle:// source into the cached repository
+// and reports whether anything changed since the previous run
+func (s *Syncer) fetchBundleSource(ctx context.Context, repoDir string, exists bool) (bool, error) {
+	files, err := s.sourceBundles()
+	if err != nil {
+		return false, err
+	}
+
+	previous := s.readBundleState()
+	state, err := bundleState(files, previous)
+	if err != nil {
+		return false, err
+	}
+	if exists && sameBundleState(state, previous) {
+		return false, nil
+	}
+
+	if !exists {
+		s.logger.Debug().Str("job", s.jobName).Msg("Initializing repository for bundle source")
+
+		cmd := s.git(ctx, "init", "--quiet", repoDir)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return false, fmt.Errorf("failed to initialize repository for bundle source: %w\n%s", err, output)
+		}
+	}
+
+	for _, file := range files {
+		cmd := s.git(ctx, "bundle", "verify", "--quiet", file)
+		cmd.Dir = repoDir
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return false, fmt.Errorf("source bundle %s is corrupt or missing prerequisite commits: %s", file, strings.TrimSpace(string(output)))
+		}
+
+		args := []string{"fetch", "--quiet"}
+		if len(files) == 1 {
+			// A single bundle is a complete snapshot, so refs missing from it were deleted
+			args = append(args, "--prune")
+		}
+		args = append(args, file, "+refs/heads/*:refs/remotes/origin/*")
+		if s.jobConfig.SyncTags {
+			args = append(args, "+refs/tags/*:refs/tags/*")
+		}
+
+		cmd = s.git(ctx, args...)
+		cmd.Dir = repoDir
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return false, fmt.Errorf("failed to fetch from source bundle %s: %w\n%s", file, err, output)
+		}
+
+		s.logger.Debug().Str("job", s.jobName).Str("bundle", file).Msg("Fetched source bundle")
+	}
+
+	// Point origin at the newest bundle so default branch detection can read its HEAD
+	latest := files[len(files)-1]
+	cmd := s.git(ctx, "remote", "add", "origin", latest)
+	cmd.Dir = repoDir
+	if err := cmd.Run(); err != nil {
+		cmd = s.git(ctx, "remote", "set-url", "origin", latest)
+		cmd.Dir = repoDir
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return false, fmt.Errorf("failed to set bundle source remote: %w\n%s", err, output)
+		}
+	}
+
+	// Recorded once the run completes so a failed run retries the same bundles
+	s.bundleState = state
+
+	return true, nil
+}
+
+// commitBundleState records the source bundles consumed by a completed run
+func (s *Syncer) commitBundleState() {
+	if s.bundleState == nil {
+		return
+	}
+	if err := s.writeBundleState(s.bundleState); err != nil {
+		s.logger.Warn().Str("job", s.jobName).Err(err).Msg("Failed to record source bundle state")
+	}
+	s.bundleState = nil
+}
@@ -0,0 +1,363 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ternarybob/gitsync/internal/common"
+)
+
+// CacheManager keeps the cached clones under the gitsync work directory in check
+type CacheManager struct {
+	config atomic.Pointer[common.Config] // Swapped by SetConfig
+	mu     sync.Mutex
+	active map[string]int
+	held   map[string][]string // Directories each running job acquired
+}
+
+type cacheEntry struct {
+	name    string
+	path    string
+	size    int64
+	lastUse time.Time
+}
+
+func NewCacheManager(cfg *common.Config) *CacheManager {
+	c := &CacheManager{
+		active: make(map[string]int),
+		held:   make(map[string][]string),
+	}
+	c.config.Store(cfg)
+	return c
+}
+
+// SetConfig makes the manager follow a reloaded configuration, so the caches
+// of added jobs are kept and a changed max_cache_size applies
+func (c *CacheManager) SetConfig(cfg *common.Config) {
+	c.config.Store(cfg)
+}
+
+// CacheRoot returns the directory holding the cached clones of every job
+func CacheRoot() string {
+	return filepath.Join(os.TempDir(), "gitsync")
+}
+
+// jobCacheDir returns the cache directory used by a single job
+func jobCacheDir(jobName string) string {
+	return filepath.Join(CacheRoot(), jobDirName(jobName))
+}
+
+// jobDirName turns a job name into a single safe path element. Names made of
+// portable characters are used as is so existing cache directories still resolve;
+// anything else is replaced and a hash of the original name keeps it unique.
+func jobDirName(jobName string) string {
+	safe := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.' {
+			return r
+		}
+		return '-'
+	}, jobName)
+
+	if safe == jobName && jobName != "." && jobName != ".." {
+		return jobName
+	}
+
+	sum := sha256.Sum256([]byte(jobName))
+	return strings.Trim(safe, ".-") + "-" + hex.EncodeToString(sum[:4])
+}
+
+// cacheKey returns the name of a job's directory inside the cache root
+func cacheKey(jobName string) string {
+	return filepath.Base(jobCacheDir(jobName))
+}
+
+// cacheDirs returns the cache directories a job uses: its own and the shared
+// mirror of its source when it sets shared_cache
+func (c *CacheManager) cacheDirs(jobName string) []string {
+	dirs := []string{jobCacheDir(jobName)}
+	if jobConfig, exists := c.config.Load().GetJobConfig(jobName); exists && jobConfig.SharedCache {
+		dirs = append(dirs, sourceCacheDir(jobConfig.Source))
+	}
+	return dirs
+}
+
+// Acquire marks a job's cache directories as in use so they are never evicted
+// mid-run
+func (c *CacheManager) Acquire(jobName string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	dirs := c.cacheDirs(jobName)
+	c.held[jobName] = dirs
+	for _, dir := range dirs {
+		c.active[filepath.Base(dir)]++
+
+		if err := os.Chtimes(dir, now, now); err != nil && !os.IsNotExist(err) {
+			common.GetLogger().Debug().Str("job", jobName).Err(err).Msg("Failed to update cache access time")
+		}
+	}
+}
+
+// Release marks the cache directories Acquire marked for a job as idle again,
+// which a reload in between may have changed for the job
+func (c *CacheManager) Release(jobName string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	dirs, ok := c.held[jobName]
+	if !ok {
+		dirs = c.cacheDirs(jobName)
+	}
+	delete(c.held, jobName)
+	for _, dir := range dirs {
+		key := filepath.Base(dir)
+		if c.active[key] <= 1 {
+			delete(c.active, key)
+			continue
+		}
+		c.active[key]--
+	}
+}
+
+// Maintain removes orphaned job directories and, when max_cache_size is set,
+// garbage collects the job's repository and evicts least recently used caches.
+// An evicted shared source mirror takes the clones of the jobs using it along.
+func (c *CacheManager) Maintain(ctx context.Context, jobName string) {
+	c.RemoveOrphans()
+
+	limit := int64(c.config.Load().Service.MaxCacheSize) * 1024 * 1024
+	if limit <= 0 {
+		return
+	}
+
+	logger := common.GetLogger()
+
+	c.gcJob(ctx, jobName)
+	c.gcSource(ctx, jobName)
+
+	entries, total := c.scan()
+	if total <= limit {
+		logger.Debug().Int64("cache_bytes", total).Int64("limit_bytes", limit).Msg("Cache size within limit")
+		return
+	}
+
+	logger.Warn().Int64("cache_bytes", total).Int64("limit_bytes", limit).Msg("Cache size over limit, evicting least recently used repositories")
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].lastUse.Before(entries[j].lastUse)
+	})
+
+	var reclaimed int64
+	for _, entry := range entries {
+		if total <= limit {
+			break
+		}
+		if c.isActive(entry.name) {
+			continue
+		}
+
+		if err := os.RemoveAll(entry.path); err != nil {
+			logger.Error().Str("job", entry.name).Str("path", entry.path).Err(err).Msg("Failed to evict cached repository")
+			continue
+		}
+
+		total -= entry.size
+		reclaimed += entry.size
+		logger.Info().Str("job", entry.name).Str("path", entry.path).Int64("reclaimed_bytes", entry.size).Msg("Evicted cached repository")
+
+		// The clones of the jobs sharing an evicted mirror borrowed their
+		// objects from it, they are cloned again on their next run. None of
+		// the jobs is running, a running one keeps the mirror active.
+		for _, path := range c.derivedClones(entry.name) {
+			size := dirSize(path)
+			if err := os.RemoveAll(path); err != nil {
+				logger.Error().Str("path", path).Err(err).Msg("Failed to evict cached repository")
+				continue
+			}
+			total -= size
+			reclaimed += size
+			logger.Info().Str("path", path).Int64("reclaimed_bytes", size).Msg("Evicted clone of evicted shared source cache")
+		}
+	}
+
+	logger.Info().Int64("reclaimed_bytes", reclaimed).Int64("cache_bytes", total).Msg("Cache eviction completed")
+}
+
+// RemoveOrphans deletes cache directories of jobs that are no longer
+// configured, and shared source mirrors no configured job uses
+func (c *CacheManager) RemoveOrphans() {
+	logger := common.GetLogger()
+
+	dirEntries, err := os.ReadDir(CacheRoot())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warn().Str("path", CacheRoot()).Err(err).Msg("Failed to read cache directory")
+		}
+		return
+	}
+
+	cfg := c.config.Load()
+	configured := make(map[string]bool)
+	for _, jobName := range cfg.Jobs.Names {
+		configured[cacheKey(jobName)] = true
+	}
+	for key := range sharedSources(cfg) {
+		configured[key] = true
+	}
+
+	var reclaimed int64
+	for _, dirEntry := range dirEntries {
+		if !dirEntry.IsDir() || configured[dirEntry.Name()] || c.isActive(dirEntry.Name()) {
+			continue
+		}
+
+		path := filepath.Join(CacheRoot(), dirEntry.Name())
+		size := dirSize(path)
+		if err := os.RemoveAll(path); err != nil {
+			logger.Error().Str("path", path).Err(err).Msg("Failed to remove orphaned cache directory")
+			continue
+		}
+
+		reclaimed += size
+		logger.Info().Str("path", path).Int64("reclaimed_bytes", size).Msg("Removed cache directory for unconfigured job")
+	}
+
+	if reclaimed > 0 {
+		logger.Info().Int64("reclaimed_bytes", reclaimed).Msg("Orphaned cache cleanup completed")
+	}
+}
+
+func (c *CacheManager) isActive(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.active[key] > 0
+}
+
+// derivedClones returns the cached clones of the configured jobs sharing the
+// mirror of a source cache key, nil for any other key
+func (c *CacheManager) derivedClones(key string) []string {
+	cfg := c.config.Load()
+	var paths []string
+	for _, jobName := range sharedSources(cfg)[key] {
+		jobConfig, _ := cfg.GetJobConfig(jobName)
+		paths = append(paths, filepath.Join(jobCacheDir(jobName), uniqueName(jobConfig.Source)))
+	}
+	return paths
+}
+
+// gcSource runs git gc on the shared mirror of a job's source under the
+// mirror's lock. Unreachable objects are kept, the clones of other jobs may
+// still use them.
+func (c *CacheManager) gcSource(ctx context.Context, jobName string) {
+	jobConfig, exists := c.config.Load().GetJobConfig(jobName)
+	if !exists || !jobConfig.SharedCache {
+		return
+	}
+	path := sourceCacheDir(jobConfig.Source)
+	if ok, _ := dirExists(path); !ok {
+		return
+	}
+
+	unlock, err := lockSource(ctx, filepath.Base(path))
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	logger := common.GetLogger()
+	before := dirSize(path)
+	cmd := exec.CommandContext(ctx, "git", "gc", "--prune=never", "--quiet")
+	cmd.Dir = path
+	if output, err := cmd.CombinedOutput(); err != nil {
+		logger.Warn().Str("job", jobName).Str("path", path).Err(err).Str("output", string(output)).Msg("git gc failed")
+		return
+	}
+	logger.Debug().Str("job", jobName).Str("path", path).Int64("reclaimed_bytes", before-dirSize(path)).Msg("Garbage collected shared source cache")
+}
+
+// gcJob runs git gc on every repository cached for the job
+func (c *CacheManager) gcJob(ctx context.Context, jobName string) {
+	logger := common.GetLogger()
+
+	repoDirs, err := os.ReadDir(jobCacheDir(jobName))
+	if err != nil {
+		return
+	}
+
+	for _, repoDir := range repoDirs {
+		if !repoDir.IsDir() {
+			continue
+		}
+
+		path := filepath.Join(jobCacheDir(jobName), repoDir.Name())
+		before := dirSize(path)
+
+		cmd := exec.CommandContext(ctx, "git", "gc", "--prune=now", "--quiet")
+		cmd.Dir = path
+		if output, err := cmd.CombinedOutput(); err != nil {
+			logger.Warn().Str("job", jobName).Str("path", path).Err(err).Str("output", string(output)).Msg("git gc failed")
+			continue
+		}
+
+		logger.Debug().Str("job", jobName).Str("path", path).Int64("reclaimed_bytes", before-dirSize(path)).Msg("Garbage collected cached repository")
+	}
+}
+
+// scan returns the size and last use of every job directory in the cache
+func (c *CacheManager) scan() ([]cacheEntry, int64) {
+	dirEntries, err := os.ReadDir(CacheRoot())
+	if err != nil {
+		return nil, 0
+	}
+
+	var entries []cacheEntry
+	var total int64
+	for _, dirEntry := range dirEntries {
+		if !dirEntry.IsDir() {
+			continue
+		}
+		info, err := dirEntry.Info()
+		if err != nil {
+			continue
+		}
+
+		path := filepath.Join(CacheRoot(), dirEntry.Name())
+		entry := cacheEntry{
+			name:    dirEntry.Name(),
+			path:    path,
+			size:    dirSize(path),
+			lastUse: info.ModTime(),
+		}
+		total += entry.size
+		entries = append(entries, entry)
+	}
+
+	return entries, total
+}
+
+func dirSize(path string) int64 {
+	var size int64
+	filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
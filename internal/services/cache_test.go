@@ -0,0 +1,115 @@
+package services
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ternarybob/gitsync/internal/common"
+)
+
+// writeCacheFile creates dir holding a file of size bytes
+func writeCacheFile(t *testing.T, dir string, size int) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "pack"), make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCacheEvictsSharedSourceWithClones(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	source := "https://git.example.com/org/monorepo.git"
+	cfg := &common.Config{
+		Service: common.ServiceConfig{MaxCacheSize: 1},
+		Jobs:    common.JobsConfig{Names: []string{"first", "second"}},
+		JobDefs: map[string]*common.JobConfig{
+			"first":  {Source: source, SharedCache: true},
+			"second": {Source: source, SharedCache: true},
+		},
+	}
+	cache := NewCacheManager(cfg)
+
+	mirror := sourceCacheDir(source)
+	writeCacheFile(t, mirror, 2*1024*1024)
+	clones := []string{
+		filepath.Join(jobCacheDir("first"), uniqueName(source)),
+		filepath.Join(jobCacheDir("second"), uniqueName(source)),
+	}
+	for _, clone := range clones {
+		writeCacheFile(t, clone, 1024)
+	}
+
+	// A running job keeps the mirror it shares
+	cache.Acquire("first")
+	cache.Maintain(context.Background(), "second")
+	if _, err := os.Stat(mirror); err != nil {
+		t.Fatalf("mirror evicted while a job using it runs: %v", err)
+	}
+	cache.Release("first")
+
+	cache.Maintain(context.Background(), "second")
+	if _, err := os.Stat(mirror); !os.IsNotExist(err) {
+		t.Errorf("mirror kept over the cache limit: %v", err)
+	}
+	for _, clone := range clones {
+		if _, err := os.Stat(clone); !os.IsNotExist(err) {
+			t.Errorf("clone %s kept after its mirror was evicted: %v", clone, err)
+		}
+	}
+}
+
+func TestCacheRemoveOrphansKeepsSharedSources(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	source := "https://git.example.com/org/monorepo.git"
+	cfg := &common.Config{
+		Jobs:    common.JobsConfig{Names: []string{"first"}},
+		JobDefs: map[string]*common.JobConfig{"first": {Source: source, SharedCache: true}},
+	}
+	used := sourceCacheDir(source)
+	unused := sourceCacheDir("https://git.example.com/org/retired.git")
+	writeCacheFile(t, used, 1)
+	writeCacheFile(t, unused, 1)
+
+	NewCacheManager(cfg).RemoveOrphans()
+	if _, err := os.Stat(used); err != nil {
+		t.Errorf("mirror of a configured job removed: %v", err)
+	}
+	if _, err := os.Stat(unused); !os.IsNotExist(err) {
+		t.Errorf("mirror no job uses kept: %v", err)
+	}
+}
+
+func TestReloadUpdatesCacheConfig(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	job := func() *common.JobConfig {
+		return &common.JobConfig{Enabled: true, Schedule: "@every 1h", Source: "https://git.example.com/org/repo.git"}
+	}
+	cfg := &common.Config{
+		Jobs:    common.JobsConfig{Names: []string{"old"}},
+		JobDefs: map[string]*common.JobConfig{"old": job()},
+	}
+	scheduler := NewScheduler(cfg, nil)
+
+	reloaded := &common.Config{
+		Service: common.ServiceConfig{MaxCacheSize: 512},
+		Jobs:    common.JobsConfig{Names: []string{"old", "new"}},
+		JobDefs: map[string]*common.JobConfig{"old": job(), "new": job()},
+	}
+	if _, err := scheduler.Reload(reloaded); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+
+	// The cache of the added job is no orphan
+	writeCacheFile(t, jobCacheDir("new"), 1024)
+	scheduler.cache.RemoveOrphans()
+	if _, err := os.Stat(jobCacheDir("new")); err != nil {
+		t.Errorf("cache of the added job removed as an orphan: %v", err)
+	}
+	if got := scheduler.cache.config.Load().Service.MaxCacheSize; got != 512 {
+		t.Errorf("max_cache_size = %d, want the reloaded 512", got)
+	}
+}
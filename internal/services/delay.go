@@ -0,0 +1,57 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// applySyncDelay holds back the commits of every branch committed within
+// sync_delay. The origin ref of a branch is moved to its newest first-parent
+// commit older than the delay, which the branch is then checked out at, until
+// the next fetch restores it. Branches without such a commit are left out of
+// the returned branches.
+func (s *Syncer) applySyncDelay(ctx context.Context, repoDir string, branches []string) ([]string, error) {
+	cutoff := time.Now().Add(-s.jobConfig.SyncDelay)
+	before := "--before=@" + strconv.FormatInt(cutoff.Unix(), 10)
+
+	var delayed []string
+	for _, branch := range branches {
+		ref := "refs/remotes/origin/" + branch
+
+		cmd := s.git(ctx, "rev-parse", ref)
+		cmd.Dir = repoDir
+		output, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve branch %s: %w", branch, err)
+		}
+		tip := strings.TrimSpace(string(output))
+
+		cmd = s.git(ctx, "rev-list", "-1", "--first-parent", before, ref)
+		cmd.Dir = repoDir
+		output, err = cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("failed to find the commits of branch %s outside sync_delay: %w", branch, err)
+		}
+		commit := strings.TrimSpace(string(output))
+
+		switch {
+		case commit == "":
+			s.logger.Info().Str("job", s.jobName).Str("branch", branch).Dur("sync_delay", s.jobConfig.SyncDelay).Msg("Every commit of branch is within sync_delay, skipping branch")
+			continue
+		case commit != tip:
+			cmd = s.git(ctx, "update-ref", ref, commit)
+			cmd.Dir = repoDir
+			if output, err := cmd.CombinedOutput(); err != nil {
+				return nil, fmt.Errorf("failed to hold back branch %s: %w\n%s", branch, err, output)
+			}
+			s.logger.Info().Str("job", s.jobName).Str("branch", branch).Str("tip", tip).Str("commit", commit).
+				Int("held_back", s.countCommits(ctx, repoDir, commit+".."+tip)).Dur("sync_delay", s.jobConfig.SyncDelay).
+				Msg("Holding back commits within sync_delay, syncing an older commit")
+		}
+		delayed = append(delayed, branch)
+	}
+	return delayed, nil
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ternarybob/gitsync/internal/common"
+)
+
+// errNoTickRun finishes the tick of a job that did not run on it
+var errNoTickRun = errors.New("no scheduled run on this tick")
+
+// tickOutcome is how the scheduled run of a job on one tick ended, awaited
+// by the jobs depending on it
+type tickOutcome struct {
+	tick     time.Time
+	done     chan struct{}
+	err      error
+	finished bool
+}
+
+// tickOutcomeLocked returns the outcome of a job's run on tick, creating it
+// when the run has not begun yet. It returns nil when the job has moved on to
+// a later tick. s.mu must be held.
+func (s *Scheduler) tickOutcomeLocked(jobName string, tick time.Time) *tickOutcome {
+	outcome := s.ticks[jobName]
+	switch {
+	case outcome == nil || outcome.tick.Before(tick):
+		if outcome != nil {
+			// Whoever still waits for an earlier tick would wait forever
+			finishTickLocked(outcome, errNoTickRun)
+		}
+		outcome = &tickOutcome{tick: tick, done: make(chan struct{})}
+		s.ticks[jobName] = outcome
+	case outcome.tick.After(tick):
+		return nil
+	}
+	return outcome
+}
+
+// finishTick records how the scheduled run of a job on tick ended, nil for a
+// successful sync, releasing the jobs depending on it
+func (s *Scheduler) finishTick(jobName string, tick time.Time, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if outcome := s.tickOutcomeLocked(jobName, tick); outcome != nil {
+		finishTickLocked(outcome, err)
+	}
+}
+
+func finishTickLocked(outcome *tickOutcome, err error) {
+	if outcome.finished {
+		return
+	}
+	outcome.err = err
+	outcome.finished = true
+	close(outcome.done)
+}
+
+// awaitDependencies waits for the dependencies of a job to finish their runs
+// on tick, returning why the job has to skip it when one did not succeed
+func (s *Scheduler) awaitDependencies(jobConfig *common.JobConfig, tick time.Time) error {
+	for _, dependency := range jobConfig.DependsOn {
+		s.mu.Lock()
+		_, scheduled := s.jobs[dependency]
+		outcome := s.tickOutcomeLocked(dependency, tick)
+		s.mu.Unlock()
+
+		if !scheduled {
+			return fmt.Errorf("dependency %s is not scheduled", dependency)
+		}
+		if outcome == nil {
+			return fmt.Errorf("dependency %s: %w", dependency, errNoTickRun)
+		}
+
+		select {
+		case <-outcome.done:
+		case <-s.drain.Done():
+			return ErrSchedulerStopping
+		}
+		if outcome.err != nil {
+			return fmt.Errorf("dependency %s did not succeed: %w", dependency, outcome.err)
+		}
+	}
+	return nil
+}
+
+// scheduledTick returns the tick that started the current scheduled run of a job
+func (s *Scheduler) scheduledTick(jobName string) time.Time {
+	s.mu.RLock()
+	entryID, exists := s.jobs[jobName]
+	s.mu.RUnlock()
+	if !exists {
+		return time.Time{}
+	}
+	return s.cron.Entry(entryID).Prev
+}
@@ -0,0 +1,96 @@
+package services
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ternarybob/gitsync/internal/common"
+)
+
+// dependentTickError waits for a scheduled run of job to finish and returns
+// its outcome
+func dependentTickError(t *testing.T, s *Scheduler, job string, after time.Time) error {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		s.mu.Lock()
+		outcome := s.ticks[job]
+		var finished bool
+		var err error
+		if outcome != nil && outcome.tick.After(after) {
+			finished, err = outcome.finished, outcome.err
+		}
+		s.mu.Unlock()
+		if finished {
+			return err
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("no scheduled run of %s finished", job)
+	return nil
+}
+
+func TestDependsOnEvery(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	missing := filepath.Join(t.TempDir(), "missing")
+	config := func(description string) *common.Config {
+		return &common.Config{
+			Jobs: common.JobsConfig{Names: []string{"upstream", "downstream"}},
+			JobDefs: map[string]*common.JobConfig{
+				"upstream":   {Enabled: true, Every: 2 * time.Second, Source: missing, Targets: []common.TargetConfig{{URL: missing + "-upstream.git"}}},
+				"downstream": {Enabled: true, Every: 2 * time.Second, Description: description, Source: missing, Targets: []common.TargetConfig{{URL: missing + "-downstream.git"}}, DependsOn: []string{"upstream"}},
+			},
+		}
+	}
+	s := NewScheduler(config("first"), nil)
+	s.SetLogger(common.GetLogger())
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop(time.Second)
+
+	// The upstream source does not exist, so the downstream job sees its failure on the shared tick
+	check := func(after time.Time) {
+		t.Helper()
+		err := dependentTickError(t, s, "downstream", after)
+		if errors.Is(err, errNoTickRun) || err == nil || !strings.Contains(err.Error(), "did not succeed") {
+			t.Fatalf("downstream tick = %v, want the failure of upstream on the same tick", err)
+		}
+	}
+	check(time.Time{})
+
+	// A dependent rescheduled an odd number of seconds into the interval
+	// keeps sharing the ticks of its dependency
+	s.mu.Lock()
+	epoch := s.epoch
+	s.mu.Unlock()
+	time.Sleep(time.Until(epoch.Add(3*time.Second + 200*time.Millisecond)))
+	reloaded := time.Now()
+	if _, err := s.Reload(config("second")); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	check(reloaded)
+}
+
+func TestIntervalSchedule(t *testing.T) {
+	epoch := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	schedule := intervalSchedule{epoch: epoch, every: time.Hour}
+
+	tests := []struct {
+		t    time.Time
+		want time.Time
+	}{
+		{epoch.Add(-time.Minute), epoch.Add(time.Hour)},
+		{epoch, epoch.Add(time.Hour)},
+		{epoch.Add(90 * time.Minute), epoch.Add(2 * time.Hour)},
+		{epoch.Add(2 * time.Hour), epoch.Add(3 * time.Hour)},
+	}
+	for _, tt := range tests {
+		if got := schedule.Next(tt.t); !got.Equal(tt.want) {
+			t.Errorf("Next(%s) = %s, want %s", tt.t.Format(time.TimeOnly), got.Format(time.TimeOnly), tt.want.Format(time.TimeOnly))
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ternarybob/gitsync/internal/common"
+)
+
+// discoveryTimeout bounds one request to a provider API
+const discoveryTimeout = 30 * time.Second
+
+// A rate limited request to a provider API is sent again up to
+// rateLimitRetries times, after as long as the provider asks but at most
+// maxRateLimitWait, so a refresh never holds up the service for long
+const (
+	rateLimitRetries = 3
+	maxRateLimitWait = time.Minute
+)
+
+// Discovery lists the repositories of discovery jobs and keeps the last
+// successful listing of each, so a provider API that fails for a while never
+// unschedules the jobs discovered before
+type Discovery struct {
+	client *http.Client
+
+	mu       sync.Mutex
+	repos    map[string][]common.DiscoveredRepo
+	listed   map[string]time.Time
+	settings map[string]common.DiscoverConfig // Settings of the last listing, a change lists again
+}
+
+// NewDiscovery creates a discovery that has listed nothing yet
+func NewDiscovery() *Discovery {
+	return &Discovery{
+		client:   &http.Client{Timeout: discoveryTimeout},
+		repos:    make(map[string][]common.DiscoveredRepo),
+		listed:   make(map[string]time.Time),
+		settings: make(map[string]common.DiscoverConfig),
+	}
+}
+
+// Refresh lists the repositories of the discovery jobs of cfg whose refresh
+// interval passed or whose discover settings changed, or of all of them with
+// force. It reports whether any job's repositories changed, and returns the
+// error of every listing that failed keyed by the job.
+func (d *Discovery) Refresh(ctx context.Context, cfg *common.Config, force bool) (bool, map[string]error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	changed := false
+	errs := make(map[string]error)
+	now := time.Now()
+	for _, jobName := range cfg.Jobs.Names {
+		jobConfig, exists := cfg.GetJobConfig(jobName)
+		if !exists || !jobConfig.Discovers() {
+			continue
+		}
+		settings := *jobConfig.Discover
+		due := force || now.Sub(d.listed[jobName]) >= settings.Refresh || !reflect.DeepEqual(d.settings[jobName], settings)
+		if !due {
+			continue
+		}
+
+		d.listed[jobName] = now
+		repos, err := listRepositories(ctx, d.client, jobConfig)
+		if err != nil {
+			errs[jobName] = err
+			continue
+		}
+		d.settings[jobName] = settings
+		if !reflect.DeepEqual(d.repos[jobName], repos) {
+			d.repos[jobName] = repos
+			changed = true
+		}
+	}
+	return changed, errs
+}
+
+// Config returns cfg with a job for every repository its discovery jobs
+// listed last
+func (d *Discovery) Config(cfg *common.Config) *common.Config {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return cfg.WithDiscovered(d.repos)
+}
+
+// listRepositories lists the repositories a discovery job selects, sorted by
+// name
+func listRepositories(ctx context.Context, client *http.Client, jobConfig *common.JobConfig) ([]common.DiscoveredRepo, error) {
+	var repos []common.DiscoveredRepo
+	var err error
+	switch jobConfig.Discover.Provider {
+	case common.ProviderGitHub:
+		repos, err = listGitHubRepos(ctx, client, jobConfig)
+	case common.ProviderGitLab:
+		repos, err = listGitLabProjects(ctx, client, jobConfig)
+	default:
+		err = fmt.Errorf("discover provider %s is not supported", jobConfig.Discover.Provider)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	selected := make([]common.DiscoveredRepo, 0, len(repos))
+	for _, repo := range repos {
+		if jobConfig.Discover.Selects(repo) {
+			selected = append(selected, repo)
+		}
+	}
+	sort.Slice(selected, func(i, j int) bool { return selected[i].Path < selected[j].Path })
+	return selected, nil
+}
+
+// getAPI sends an authenticated GET to a provider API and returns the
+// response, which the caller closes. A rate limited request is sent again
+// after backing off. A status other than 200 is an error carrying the start
+// of the body, which says why.
+func getAPI(ctx context.Context, client *http.Client, url string, header http.Header) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header = header
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusOK {
+			return resp, nil
+		}
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxExcerpt))
+		resp.Body.Close()
+		err = fmt.Errorf("unexpected response status %s: %s", resp.Status, strings.TrimSpace(string(body)))
+
+		wait, limited := rateLimitWait(resp, attempt)
+		if !limited {
+			return nil, err
+		}
+		if attempt == rateLimitRetries {
+			return nil, fmt.Errorf("rate limited after %d retries: %w", rateLimitRetries, err)
+		}
+		common.GetLogger().Warn().Str("url", url).Str("wait", wait.String()).Msg("Provider API rate limit reached, backing off")
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// rateLimitWait reports whether a response is a rate limit and how long to
+// wait before sending the request again: the Retry-After or the reset time
+// of GitHub and GitLab, or doubling from a second when neither is given
+func rateLimitWait(resp *http.Response, attempt int) (time.Duration, bool) {
+	remaining := resp.Header.Get("X-RateLimit-Remaining")
+	if remaining == "" {
+		remaining = resp.Header.Get("RateLimit-Remaining")
+	}
+	retryAfter := resp.Header.Get("Retry-After")
+	limited := resp.StatusCode == http.StatusTooManyRequests ||
+		(resp.StatusCode == http.StatusForbidden && (remaining == "0" || retryAfter != ""))
+	if !limited {
+		return 0, false
+	}
+
+	wait := time.Second << attempt
+	if seconds, err := strconv.Atoi(retryAfter); err == nil {
+		wait = time.Duration(seconds) * time.Second
+	} else {
+		reset := resp.Header.Get("X-RateLimit-Reset")
+		if reset == "" {
+			reset = resp.Header.Get("RateLimit-Reset")
+		}
+		if epoch, err := strconv.ParseInt(reset, 10, 64); err == nil {
+			wait = time.Until(time.Unix(epoch, 0))
+		}
+	}
+	return min(max(wait, time.Second), maxRateLimitWait), true
+}
+
+// nextLink returns the rel="next" URL of an RFC 8288 Link header, empty on
+// the last page
+func nextLink(header string) string {
+	for _, link := range strings.Split(header, ",") {
+		target, params, found := strings.Cut(link, ";")
+		if !found {
+			continue
+		}
+		for _, param := range strings.Split(params, ";") {
+			if strings.TrimSpace(param) == `rel="next"` {
+				return strings.Trim(strings.TrimSpace(target), "<>")
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ternarybob/gitsync/internal/common"
+)
+
+// RunFilter narrows a run started by hand to one branch and one target, so a
+// single problematic branch can be synced without the rest of the job
+type RunFilter struct {
+	Branch string // Only sync this branch, empty for every matched branch
+	Target string // Only push to targets with this URL or host, empty for all
+	// Let Branch be a source branch the job's branch patterns do not select
+	AnyBranch bool
+}
+
+type runFilterKey struct{}
+
+// withRunFilter attaches a filter to the context of a job run
+func withRunFilter(ctx context.Context, filter RunFilter) context.Context {
+	return context.WithValue(ctx, runFilterKey{}, filter)
+}
+
+// runFilterFrom returns the filter of the context, the zero filter when none
+// is attached
+func runFilterFrom(ctx context.Context) RunFilter {
+	filter, _ := ctx.Value(runFilterKey{}).(RunFilter)
+	return filter
+}
+
+// matchesTarget reports whether a target URL passes the target filter
+func (f RunFilter) matchesTarget(url string) bool {
+	return f.Target == "" || url == f.Target || common.SameRepository(url, f.Target) ||
+		common.RepositoryHost(url) == f.Target
+}
+
+// check rejects a filter that cannot select anything in the job
+func (f RunFilter) check(jobName string, jobConfig *common.JobConfig) error {
+	if f.Branch != "" && len(jobConfig.Refspecs) > 0 {
+		return fmt.Errorf("job %s syncs refspecs, a branch cannot be selected", jobName)
+	}
+	if f.Target == "" {
+		return nil
+	}
+	for _, target := range jobConfig.Targets {
+		if f.matchesTarget(target.URL) {
+			return nil
+		}
+	}
+	return fmt.Errorf("no target of job %s matches %s", jobName, f.Target)
+}
+
+// targets returns the targets of the job the current run pushes to
+func (s *Syncer) targets() []common.TargetConfig {
+	if s.filter.Target == "" {
+		return s.jobConfig.Targets
+	}
+
+	var targets []common.TargetConfig
+	for _, target := range s.jobConfig.Targets {
+		if s.filter.matchesTarget(target.URL) {
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+// filteredBranch returns the filtered branch as the only branch of a run. It
+// has to be selected by the job's branch patterns, or be the source default
+// branch for jobs without patterns, unless the filter allows any branch.
+// max_branch_age does not apply to a branch asked for by name.
+func (s *Syncer) filteredBranch(ctx context.Context, repoDir string, remoteBranches []string) ([]string, error) {
+	branch := s.filter.Branch
+
+	found := false
+	for _, remoteBranch := range remoteBranches {
+		found = found || remoteBranch == branch
+	}
+	if !found {
+		return nil, fmt.Errorf("branch %s not found in source", branch)
+	}
+	if s.filter.AnyBranch {
+		return []string{branch}, nil
+	}
+
+	selected := s.jobConfig.ShouldSyncBranch(branch)
+	if len(s.jobConfig.Branches) == 0 {
+		defaultBranch, err := s.getDefaultBranch(ctx, repoDir)
+		if err != nil {
+			return nil, err
+		}
+		selected = branch == defaultBranch
+	}
+	if !selected {
+		return nil, fmt.Errorf("branch %s is not selected by the branches of job %s, force the run to sync it anyway", branch, s.jobName)
+	}
+	return []string{branch}, nil
+}
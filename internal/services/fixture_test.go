@@ -0,0 +1,186 @@
+package services
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/phuslu/log"
+	"github.com/ternarybob/arbor"
+	"github.com/ternarybob/arbor/models"
+	"github.com/ternarybob/arbor/writers"
+
+	"github.com/ternarybob/gitsync/internal/common"
+)
+
+// fixture is a working repository on disk that tests commit to and sync
+// from, with the git identity and cache root of the test
+type fixture struct {
+	t      *testing.T
+	root   string
+	source string
+}
+
+// newFixture creates the source repository with one commit on main
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	root := t.TempDir()
+	t.Setenv("TMPDIR", filepath.Join(root, "tmp"))
+	t.Setenv("GIT_AUTHOR_NAME", "Test")
+	t.Setenv("GIT_AUTHOR_EMAIL", "test@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "Test")
+	t.Setenv("GIT_COMMITTER_EMAIL", "test@example.com")
+	t.Setenv("GIT_CONFIG_GLOBAL", filepath.Join(root, "gitconfig"))
+	if err := os.MkdirAll(filepath.Join(root, "tmp"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fixture{t: t, root: root, source: filepath.Join(root, "source")}
+	f.git(root, "init", "-q", "-b", "main", f.source)
+	f.commit("main", "initial")
+	return f
+}
+
+// git runs git in dir and returns its trimmed output
+func (f *fixture) git(dir string, args ...string) string {
+	f.t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		f.t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, output)
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// commit adds a commit to branch of the source, creating the branch from the
+// current one when it does not exist, and returns its hash
+func (f *fixture) commit(branch, message string) string {
+	f.t.Helper()
+	if f.git(f.source, "branch", "--list", branch) == "" {
+		f.git(f.source, "checkout", "-q", "-b", branch)
+	} else {
+		f.git(f.source, "checkout", "-q", branch)
+	}
+	name := strings.ReplaceAll(message, " ", "-")
+	if err := os.WriteFile(filepath.Join(f.source, name), []byte(message+"\n"), 0644); err != nil {
+		f.t.Fatal(err)
+	}
+	f.git(f.source, "add", name)
+	f.git(f.source, "commit", "-q", "-m", message)
+	return f.git(f.source, "rev-parse", "HEAD")
+}
+
+// path returns a path below the root of the fixture
+func (f *fixture) path(name string) string {
+	return filepath.Join(f.root, name)
+}
+
+// config loads a configuration holding one job named "fixture" with the
+// given lines
+func (f *fixture) config(lines ...string) *common.Config {
+	f.t.Helper()
+	config := "[jobs]\nnames = [\"fixture\"]\nevery = \"1h\"\n\n[fixture]\n" + strings.Join(lines, "\n") + "\n"
+	path := filepath.Join(f.root, "gitsync.toml")
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		f.t.Fatal(err)
+	}
+	cfg, err := common.Load(path)
+	if err != nil {
+		f.t.Fatalf("Load: %v", err)
+	}
+	return cfg
+}
+
+// syncer returns the syncer of the job configured by config
+func (f *fixture) syncer(lines ...string) *Syncer {
+	f.t.Helper()
+	jobConfig, _ := f.config(lines...).GetJobConfig("fixture")
+	syncer, err := NewSyncer("fixture", jobConfig, nil)
+	if err != nil {
+		f.t.Fatalf("NewSyncer: %v", err)
+	}
+	return syncer
+}
+
+// holdPushes makes a bare repository hold every push it receives until
+// release is called, refusing it then. started reports whether a push is held.
+func (f *fixture) holdPushes(bare string) (started func() bool, release func()) {
+	f.t.Helper()
+	startedFile, releaseFile := f.path("push-started"), f.path("push-released")
+	hook := "#!/bin/sh\ntouch " + quote(startedFile) + "\nwhile [ ! -e " + quote(releaseFile) + " ]; do sleep 0.05; done\nexit 1\n"
+	if err := os.WriteFile(filepath.Join(bare, "hooks", "pre-receive"), []byte(hook), 0755); err != nil {
+		f.t.Fatal(err)
+	}
+	release = func() { os.WriteFile(releaseFile, nil, 0644) }
+	f.t.Cleanup(release)
+	started = func() bool {
+		_, err := os.Stat(startedFile)
+		return err == nil
+	}
+	return started, release
+}
+
+// refs returns the branches and tags of a repository by name
+func (f *fixture) refs(dir string) map[string]string {
+	f.t.Helper()
+	refs := make(map[string]string)
+	output := f.git(dir, "for-each-ref", "--format=%(refname) %(objectname)", "refs/heads", "refs/tags")
+	for _, line := range strings.Split(output, "\n") {
+		if name, hash, ok := strings.Cut(line, " "); ok {
+			refs[name] = hash
+		}
+	}
+	return refs
+}
+
+// quote formats a path as a TOML string
+func quote(path string) string {
+	return `"` + filepath.ToSlash(path) + `"`
+}
+
+// logCapture keeps the log events of a test
+type logCapture struct {
+	mu     sync.Mutex
+	events []models.LogEvent
+}
+
+func (c *logCapture) WithLevel(log.Level) writers.IWriter { return c }
+
+func (c *logCapture) Write(data []byte) (int, error) {
+	var event models.LogEvent
+	if err := json.Unmarshal(data, &event); err == nil {
+		c.mu.Lock()
+		c.events = append(c.events, event)
+		c.mu.Unlock()
+	}
+	return len(data), nil
+}
+
+// find returns the first event with message
+func (c *logCapture) find(message string) (models.LogEvent, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, event := range c.events {
+		if event.Message == message {
+			return event, true
+		}
+	}
+	return models.LogEvent{}, false
+}
+
+// captureLogs records the events logged for the rest of the test
+func captureLogs(t *testing.T) *logCapture {
+	t.Helper()
+	c := &logCapture{}
+	arbor.RegisterWriter("test", c)
+	t.Cleanup(func() { arbor.UnregisterWriter("test") })
+	return c
+}
@@ -0,0 +1,255 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/ternarybob/gitsync/internal/common"
+)
+
+// Phases of a sync that carry their own timeout
+const (
+	phaseClone = "clone"
+	phaseFetch = "fetch"
+	phasePush  = "push"
+)
+
+// phaseTimeout returns the configured timeout of a phase, zero means none
+func (s *Syncer) phaseTimeout(phase string) time.Duration {
+	switch phase {
+	case phaseClone:
+		return s.jobConfig.CloneTimeout
+	case phaseFetch:
+		return s.jobConfig.FetchTimeout
+	case phasePush:
+		return s.jobConfig.PushTimeout
+	}
+	return 0
+}
+
+// phaseContext bounds a single git invocation by the timeout of its phase
+func (s *Syncer) phaseContext(ctx context.Context, phase string) (context.Context, context.CancelFunc) {
+	if timeout := s.phaseTimeout(phase); timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// phaseError names the phase and remote when a git invocation was stopped by
+// a deadline instead of failing on its own
+func (s *Syncer) phaseError(ctx, phaseCtx context.Context, phase, remote string, err error) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("%s of %s interrupted by job timeout: %w", phase, remote, ctx.Err())
+	}
+	if phaseCtx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%s of %s timed out after %s", phase, remote, s.phaseTimeout(phase))
+	}
+	return err
+}
+
+// git builds a git command that talks to the source with the job-level
+// credentials, after waiting for the rate limit of the source host, within
+// the job's max_bandwidth
+func (s *Syncer) git(ctx context.Context, args ...string) *exec.Cmd {
+	s.waitRateLimit(ctx, s.jobConfig.Source, args)
+	cmd := s.gitCommand(ctx, nil, args)
+	s.limitBandwidth(cmd, s.jobConfig.Source, args)
+	return cmd
+}
+
+// gitTarget builds a git command that talks to a target, using the target's
+// own SSH key when one is configured, after waiting for the rate limit of the
+// target host, within the job's max_bandwidth
+func (s *Syncer) gitTarget(ctx context.Context, target common.TargetConfig, args ...string) *exec.Cmd {
+	s.waitRateLimit(ctx, target.URL, args)
+	cmd := s.gitCommand(ctx, &target, args)
+	s.limitBandwidth(cmd, target.URL, args)
+	return cmd
+}
+
+// gitCommand builds a git command with the settings of the job, and of target
+// when it is not nil
+func (s *Syncer) gitCommand(ctx context.Context, target *common.TargetConfig, args []string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "git", append(s.gitConfigArgs(target), args...)...)
+	cmd.Env = s.gitEnv(target)
+	return cmd
+}
+
+// gitConfigArgs returns the "-c" options scoped to a single git command, so TLS
+// settings never leak into the global git configuration
+func (s *Syncer) gitConfigArgs(target *common.TargetConfig) []string {
+	var args []string
+
+	caBundle := s.jobConfig.CABundlePath
+	if target != nil && target.CABundlePath != "" {
+		caBundle = target.CABundlePath
+	}
+
+	if caBundle != "" {
+		args = append(args, "-c", "http.sslCAInfo="+caBundle)
+	}
+	if s.jobConfig.SkipsTLSVerify(target) {
+		args = append(args, "-c", "http.sslVerify=false")
+	}
+
+	return args
+}
+
+// askPassTokenVar hands the job's token to its askpass script
+const askPassTokenVar = "GITSYNC_GIT_TOKEN"
+
+// gitEnv composes the environment of a single git command. A nil target
+// selects the credentials used for the source.
+func (s *Syncer) gitEnv(target *common.TargetConfig) []string {
+	env := os.Environ()
+
+	if s.askPass != "" {
+		env = append(env, "GIT_ASKPASS="+s.askPass, askPassTokenVar+"="+s.gitToken)
+	}
+
+	keyPath := s.jobConfig.SSHKeyPath
+	if target != nil && target.SSHKeyPath != "" {
+		keyPath = target.SSHKeyPath
+	}
+	if keyPath != "" {
+		env = append(env, sshCommand(keyPath))
+	}
+
+	// Proxy settings: target overrides job, job overrides the process environment
+	proxies := []struct {
+		names       []string
+		job, target string
+	}{
+		{[]string{"http_proxy", "HTTP_PROXY"}, s.jobConfig.HTTPProxy, ""},
+		{[]string{"https_proxy", "HTTPS_PROXY"}, s.jobConfig.HTTPSProxy, ""},
+		{[]string{"no_proxy", "NO_PROXY"}, s.jobConfig.NoProxy, ""},
+	}
+	if target != nil {
+		proxies[0].target = target.HTTPProxy
+		proxies[1].target = target.HTTPSProxy
+		proxies[2].target = target.NoProxy
+	}
+	for _, proxy := range proxies {
+		value := proxy.job
+		if proxy.target != "" {
+			value = proxy.target
+		}
+		if value == "" {
+			continue
+		}
+		for _, name := range proxy.names {
+			env = append(env, name+"="+value)
+		}
+	}
+
+	return env
+}
+
+// sshCommand returns the GIT_SSH_COMMAND setting that authenticates with a key.
+// git runs the command through a shell, so the path is quoted to survive
+// spaces, quotes and, on Windows, backslashes.
+func sshCommand(keyPath string) string {
+	return fmt.Sprintf("GIT_SSH_COMMAND=ssh -i %s -o IdentitiesOnly=yes -o StrictHostKeyChecking=no", shellQuote(keyPath))
+}
+
+// shellQuote quotes a word for a POSIX shell, ending and reopening the single
+// quotes around each single quote of the word
+func shellQuote(word string) string {
+	return "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+}
+
+// gitProgressInterval is how often progress lines of one git command are
+// logged, lines without a percentage are always logged
+const gitProgressInterval = 2 * time.Second
+
+// runStreamed runs a git command and returns its combined output like
+// CombinedOutput, logging the output lines at debug level while the command
+// runs, so a hung clone can be told from a slow one, and reporting its
+// progress while it lasts
+func (s *Syncer) runStreamed(cmd *exec.Cmd, operation string) ([]byte, error) {
+	w := &gitOutputWriter{syncer: s, operation: operation, lastReport: time.Now()}
+	cmd.Stdout = w
+	cmd.Stderr = w
+	err := cmd.Run()
+	w.flush()
+	s.setProgress(nil)
+	return []byte(s.redact(w.output.String())), err
+}
+
+// progressArg returns --progress when the job asks for git progress lines.
+// git only prints them to a terminal unless asked.
+func (s *Syncer) progressArg() []string {
+	if s.jobConfig.GitProgress {
+		return []string{"--progress"}
+	}
+	return nil
+}
+
+// redact removes credentials from git output
+func (s *Syncer) redact(text string) string {
+	text = common.RedactURLCredentials(text)
+	if s.gitToken != "" {
+		text = strings.ReplaceAll(text, s.gitToken, "***")
+	}
+	for _, source := range s.jobConfig.Sources {
+		if source.GitToken != "" {
+			text = strings.ReplaceAll(text, source.GitToken, "***")
+		}
+	}
+	return text
+}
+
+// gitOutputWriter collects the output of a git command and logs it line by
+// line. git rewrites progress lines in place with carriage returns, each
+// rewrite counts as a line.
+type gitOutputWriter struct {
+	syncer       *Syncer
+	operation    string
+	output       bytes.Buffer
+	pending      []byte
+	lastProgress time.Time
+	lastReport   time.Time // When progress was last logged at info level, the start of the command before
+}
+
+func (w *gitOutputWriter) Write(data []byte) (int, error) {
+	w.output.Write(data)
+	w.pending = append(w.pending, data...)
+	for {
+		end := bytes.IndexAny(w.pending, "\r\n")
+		if end < 0 {
+			break
+		}
+		w.logLine(string(w.pending[:end]), false)
+		w.pending = w.pending[end+1:]
+	}
+	return len(data), nil
+}
+
+// flush logs the last line when the output did not end with a newline
+func (w *gitOutputWriter) flush() {
+	if len(w.pending) > 0 {
+		w.logLine(string(w.pending), true)
+		w.pending = nil
+	}
+}
+
+func (w *gitOutputWriter) logLine(line string, last bool) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+	w.reportProgress(line)
+	// Percentage lines are rate limited, the final one of a step ends in "done."
+	if strings.Contains(line, "%") && !strings.HasSuffix(line, "done.") && !last {
+		if time.Since(w.lastProgress) < gitProgressInterval {
+			return
+		}
+		w.lastProgress = time.Now()
+	}
+	w.syncer.logger.Debug().Str("job", w.syncer.jobName).Str("git", w.operation).Msg(w.syncer.redact(line))
+}
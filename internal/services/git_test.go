@@ -0,0 +1,122 @@
+package services
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/ternarybob/gitsync/internal/common"
+)
+
+func TestGitEnvProxyPrecedence(t *testing.T) {
+	t.Setenv("https_proxy", "http://process.example.com:3128")
+	t.Setenv("HTTPS_PROXY", "http://process.example.com:3128")
+	t.Setenv("no_proxy", "process.example.com")
+	t.Setenv("NO_PROXY", "process.example.com")
+	t.Setenv("http_proxy", "")
+	t.Setenv("HTTP_PROXY", "")
+
+	tests := []struct {
+		name   string
+		job    common.JobConfig
+		target *common.TargetConfig
+		want   map[string]string
+	}{
+		{
+			name: "process environment",
+			job:  common.JobConfig{},
+			want: map[string]string{"https_proxy": "http://process.example.com:3128", "NO_PROXY": "process.example.com", "http_proxy": ""},
+		},
+		{
+			name: "job overrides the process environment",
+			job:  common.JobConfig{HTTPSProxy: "http://job.example.com:3128", HTTPProxy: "http://job.example.com:8080"},
+			want: map[string]string{"https_proxy": "http://job.example.com:3128", "HTTPS_PROXY": "http://job.example.com:3128", "HTTP_PROXY": "http://job.example.com:8080", "no_proxy": "process.example.com"},
+		},
+		{
+			name:   "target overrides the job",
+			job:    common.JobConfig{HTTPSProxy: "http://job.example.com:3128", NoProxy: "job.example.com"},
+			target: &common.TargetConfig{HTTPSProxy: "http://target.example.com:3128"},
+			want:   map[string]string{"https_proxy": "http://target.example.com:3128", "HTTPS_PROXY": "http://target.example.com:3128", "no_proxy": "job.example.com", "NO_PROXY": "job.example.com"},
+		},
+		{
+			name:   "target without proxies keeps the job's",
+			job:    common.JobConfig{HTTPSProxy: "http://job.example.com:3128"},
+			target: &common.TargetConfig{},
+			want:   map[string]string{"https_proxy": "http://job.example.com:3128"},
+		},
+		{
+			name:   "target over the process environment",
+			job:    common.JobConfig{},
+			target: &common.TargetConfig{NoProxy: "target.example.com"},
+			want:   map[string]string{"https_proxy": "http://process.example.com:3128", "no_proxy": "target.example.com", "NO_PROXY": "target.example.com"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Syncer{jobName: "test", jobConfig: &tt.job}
+			env := s.gitEnv(tt.target)
+			for name, want := range tt.want {
+				if got := envValue(env, name); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSSHCommandQuotesKeyPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/etc/gitsync/keys/deploy", `'/etc/gitsync/keys/deploy'`},
+		{"/home/me/my keys/id_ed25519", `'/home/me/my keys/id_ed25519'`},
+		{"/keys/bob's key", `'/keys/bob'\''s key'`},
+		{`C:\Users\me\.ssh\id_rsa`, `'C:\Users\me\.ssh\id_rsa'`},
+		{"/keys/$HOME;`rm`", "'/keys/$HOME;`rm`'"},
+	}
+	for _, tt := range tests {
+		want := "GIT_SSH_COMMAND=ssh -i " + tt.want + " -o IdentitiesOnly=yes -o StrictHostKeyChecking=no"
+		if got := sshCommand(tt.path); got != want {
+			t.Errorf("sshCommand(%q) = %s, want %s", tt.path, got, want)
+		}
+	}
+
+	// A shell reads each quoted path back as the one word it was
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no shell to check the quoting with")
+	}
+	for _, tt := range tests {
+		output, err := exec.Command(sh, "-c", `printf '%s|' `+shellQuote(tt.path)).Output()
+		if err != nil {
+			t.Fatalf("sh: %v", err)
+		}
+		if got := strings.TrimSuffix(string(output), "|"); got != tt.path {
+			t.Errorf("shell read %q back as %q", tt.path, got)
+		}
+	}
+}
+
+func TestGitConfigArgsTLS(t *testing.T) {
+	yes, no := true, false
+	tests := []struct {
+		name   string
+		job    common.JobConfig
+		target *common.TargetConfig
+		want   string
+	}{
+		{"source follows the job", common.JobConfig{TLSSkipVerify: true, CABundlePath: "/ca/job.pem"}, nil, "-c http.sslCAInfo=/ca/job.pem -c http.sslVerify=false"},
+		{"target inherits the job", common.JobConfig{TLSSkipVerify: true}, &common.TargetConfig{}, "-c http.sslVerify=false"},
+		{"target verifies when the job skips", common.JobConfig{TLSSkipVerify: true}, &common.TargetConfig{TLSSkipVerify: &no}, ""},
+		{"target skips when the job verifies", common.JobConfig{}, &common.TargetConfig{TLSSkipVerify: &yes}, "-c http.sslVerify=false"},
+		{"target CA bundle overrides the job's", common.JobConfig{CABundlePath: "/ca/job.pem"}, &common.TargetConfig{CABundlePath: "/ca/target.pem"}, "-c http.sslCAInfo=/ca/target.pem"},
+	}
+	for _, tt := range tests {
+		s := &Syncer{jobName: "test", jobConfig: &tt.job}
+		if got := strings.Join(s.gitConfigArgs(tt.target), " "); got != tt.want {
+			t.Errorf("%s: gitConfigArgs = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ternarybob/gitsync/internal/common"
+)
+
+// giteaTimeout bounds one request to a Gitea or Forgejo API
+const giteaTimeout = 30 * time.Second
+
+// giteaRepo holds the fields of a Gitea repository provisioning reads
+type giteaRepo struct {
+	DefaultBranch string `json:"default_branch"`
+	Description   string `json:"description"`
+	Empty         bool   `json:"empty"`
+}
+
+// giteaClient calls the API of a Gitea or Forgejo instance about one target
+// repository
+type giteaClient struct {
+	client *http.Client
+	api    string
+	owner  string
+	repo   string
+	token  string
+}
+
+// newGiteaClient creates a client for the repository of a target, trusting
+// the CA bundle and TLS settings git pushes to it with
+func (s *Syncer) newGiteaClient(target common.TargetConfig) (*giteaClient, error) {
+	api, owner, repo, err := target.GiteaRepository()
+	if err != nil {
+		return nil, err
+	}
+
+	caBundle := s.jobConfig.CABundlePath
+	if target.CABundlePath != "" {
+		caBundle = target.CABundlePath
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	skipVerify := s.jobConfig.SkipsTLSVerify(&target)
+	if caBundle != "" || skipVerify {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: skipVerify}
+		if caBundle != "" {
+			pem, err := os.ReadFile(caBundle)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates in CA bundle %s", caBundle)
+			}
+			transport.TLSClientConfig.RootCAs = pool
+		}
+	}
+
+	return &giteaClient{
+		client: &http.Client{Timeout: giteaTimeout, Transport: transport},
+		api:    api,
+		owner:  owner,
+		repo:   repo,
+		token:  s.gitToken,
+	}, nil
+}
+
+// call sends a request to the API, encoding body and decoding the response
+// into out when given. It returns the response status, a status of 300 or
+// more is also an error carrying the start of the body.
+func (g *giteaClient) call(ctx context.Context, method, path string, body, out interface{}) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, g.api+path, reader)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "token "+g.token)
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		excerpt, _ := io.ReadAll(io.LimitReader(resp.Body, maxExcerpt))
+		return resp.StatusCode, fmt.Errorf("%s %s: unexpected response status %s: %s", method, path, resp.Status, strings.TrimSpace(string(excerpt)))
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp.StatusCode, fmt.Errorf("failed to decode response of %s %s: %w", method, path, err)
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+func (g *giteaClient) repoPath() string {
+	return "/repos/" + url.PathEscape(g.owner) + "/" + url.PathEscape(g.repo)
+}
+
+// getRepo returns the repository, nil when it does not exist
+func (g *giteaClient) getRepo(ctx context.Context) (*giteaRepo, error) {
+	var repo giteaRepo
+	status, err := g.call(ctx, http.MethodGet, g.repoPath(), nil, &repo)
+	if status == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &repo, nil
+}
+
+// createRepo creates the repository as a private one, in the organization
+// named by the owner or else for the user the token belongs to, which has to
+// be the owner
+func (g *giteaClient) createRepo(ctx context.Context, description, defaultBranch string) error {
+	options := map[string]interface{}{"name": g.repo, "private": true}
+	if description != "" {
+		options["description"] = description
+	}
+	if defaultBranch != "" {
+		options["default_branch"] = defaultBranch
+	}
+
+	status, err := g.call(ctx, http.MethodGet, "/orgs/"+url.PathEscape(g.owner), nil, nil)
+	switch {
+	case err == nil:
+		_, err = g.call(ctx, http.MethodPost, "/orgs/"+url.PathEscape(g.owner)+"/repos", options, nil)
+		return err
+	case status != http.StatusNotFound:
+		return err
+	}
+
+	var user struct {
+		Login string `json:"login"`
+	}
+	if _, err := g.call(ctx, http.MethodGet, "/user", nil, &user); err != nil {
+		return err
+	}
+	if !strings.EqualFold(user.Login, g.owner) {
+		return fmt.Errorf("owner %s is neither an organization nor the user %s the token belongs to", g.owner, user.Login)
+	}
+	_, err = g.call(ctx, http.MethodPost, "/user/repos", options, nil)
+	return err
+}
+
+// mirrorDescription describes a target repository as a mirror of the source
+func (s *Syncer) mirrorDescription() string {
+	return "Mirror of " + common.RedactURLCredentials(s.jobConfig.SourceLabel()) + ", synced by gitsync"
+}
+
+// provisionTargets creates the missing repositories of targets with
+// create_repo before anything is pushed. A failure is logged and left to the
+// pushes to that target, which fail on the missing repository.
+func (s *Syncer) provisionTargets(ctx context.Context) {
+	s.created = nil
+	for _, target := range s.targets() {
+		if !target.CreateRepo {
+			continue
+		}
+		if err := s.provisionTarget(ctx, target); err != nil {
+			s.logger.Error().Str("job", s.jobName).Str("target", target.URL).Err(err).Msg("Failed to create target repository")
+		}
+	}
+}
+
+func (s *Syncer) provisionTarget(ctx context.Context, target common.TargetConfig) error {
+	client, err := s.newGiteaClient(target)
+	if err != nil {
+		return err
+	}
+	repo, err := client.getRepo(ctx)
+	if err != nil || repo != nil {
+		return err
+	}
+
+	description := ""
+	if target.MarkMirror {
+		description = s.mirrorDescription()
+	}
+	if err := client.createRepo(ctx, description, target.DefaultBranch); err != nil {
+		return err
+	}
+	s.created = append(s.created, target.URL)
+	s.logger.Info().Str("job", s.jobName).Str("target", target.URL).Msg("Created missing target repository")
+	return nil
+}
+
+// configureTargets gives the repositories of targets their default_branch
+// and mirror description once the branches are pushed, changing only what
+// differs. A failure is logged, the pushes already happened.
+func (s *Syncer) configureTargets(ctx context.Context) {
+	for _, target := range s.targets() {
+		if target.DefaultBranch == "" && !target.MarkMirror {
+			continue
+		}
+		if err := s.configureTarget(ctx, target); err != nil {
+			s.logger.Warn().Str("job", s.jobName).Str("target", target.URL).Err(err).Msg("Failed to configure target repository")
+		}
+	}
+}
+
+func (s *Syncer) configureTarget(ctx context.Context, target common.TargetConfig) error {
+	client, err := s.newGiteaClient(target)
+	if err != nil {
+		return err
+	}
+	repo, err := client.getRepo(ctx)
+	if err != nil {
+		return err
+	}
+	// A repository nothing was pushed to has no branch to make the default
+	if repo == nil || repo.Empty {
+		return nil
+	}
+
+	options := make(map[string]interface{})
+	if target.DefaultBranch != "" && repo.DefaultBranch != target.DefaultBranch {
+		options["default_branch"] = target.DefaultBranch
+	}
+	if description := s.mirrorDescription(); target.MarkMirror && repo.Description != description {
+		options["description"] = description
+	}
+	if len(options) == 0 {
+		return nil
+	}
+	if _, err := client.call(ctx, http.MethodPatch, client.repoPath(), options, nil); err != nil {
+		return err
+	}
+	s.logger.Info().Str("job", s.jobName).Str("target", target.URL).Msg("Updated target repository settings")
+	return nil
+}
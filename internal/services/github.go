@@ -0,0 +1,69 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/ternarybob/gitsync/internal/common"
+)
+
+// gitHubAPI is the API root of github.com
+const gitHubAPI = "https://api.github.com"
+
+// gitHubRepo holds the fields of a GitHub repository discovery reads
+type gitHubRepo struct {
+	Name       string `json:"name"`
+	CloneURL   string `json:"clone_url"`
+	SSHURL     string `json:"ssh_url"`
+	Visibility string `json:"visibility"`
+	Archived   bool   `json:"archived"`
+	Disabled   bool   `json:"disabled"`
+}
+
+// listGitHubRepos lists the repositories of a GitHub organization. Archived
+// and disabled repositories are left out, they no longer change.
+func listGitHubRepos(ctx context.Context, client *http.Client, jobConfig *common.JobConfig) ([]common.DiscoveredRepo, error) {
+	discover := jobConfig.Discover
+	apiURL := discover.APIURL
+	if apiURL == "" {
+		apiURL = gitHubAPI
+	}
+
+	header := http.Header{}
+	header.Set("Accept", "application/vnd.github+json")
+	header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if token := jobConfig.APIToken(); token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	}
+
+	var repos []common.DiscoveredRepo
+	next := fmt.Sprintf("%s/orgs/%s/repos?type=all&per_page=100", apiURL, url.PathEscape(discover.Org))
+	for next != "" {
+		resp, err := getAPI(ctx, client, next, header)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list repositories of GitHub org %s: %w", discover.Org, err)
+		}
+		var page []gitHubRepo
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode repositories of GitHub org %s: %w", discover.Org, err)
+		}
+
+		for _, repo := range page {
+			if repo.Archived || repo.Disabled {
+				continue
+			}
+			cloneURL := repo.CloneURL
+			if discover.Protocol == "ssh" {
+				cloneURL = repo.SSHURL
+			}
+			repos = append(repos, common.NewDiscoveredRepo(repo.Name, cloneURL, repo.Visibility))
+		}
+		next = nextLink(resp.Header.Get("Link"))
+	}
+	return repos, nil
+}
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ternarybob/gitsync/internal/common"
+)
+
+// gitLabAPI is the API root of gitlab.com
+const gitLabAPI = "https://gitlab.com/api/v4"
+
+// gitLabProject holds the fields of a GitLab project discovery reads
+type gitLabProject struct {
+	PathWithNamespace string `json:"path_with_namespace"`
+	HTTPURL           string `json:"http_url_to_repo"`
+	SSHURL            string `json:"ssh_url_to_repo"`
+	Visibility        string `json:"visibility"`
+	Archived          bool   `json:"archived"`
+}
+
+// listGitLabProjects lists the projects of a GitLab group, and of its
+// subgroups at any depth unless subgroups is off. Each is named by its path
+// below the group. Archived projects and projects shared into the group from
+// elsewhere are left out.
+func listGitLabProjects(ctx context.Context, client *http.Client, jobConfig *common.JobConfig) ([]common.DiscoveredRepo, error) {
+	discover := jobConfig.Discover
+	apiURL := discover.APIURL
+	if apiURL == "" {
+		apiURL = gitLabAPI
+	}
+
+	header := http.Header{}
+	header.Set("Accept", "application/json")
+	if token := jobConfig.APIToken(); token != "" {
+		header.Set("PRIVATE-TOKEN", token)
+	}
+
+	prefix := strings.ToLower(discover.Group) + "/"
+	var repos []common.DiscoveredRepo
+	next := fmt.Sprintf("%s/groups/%s/projects?include_subgroups=%t&with_shared=false&archived=false&order_by=path&sort=asc&per_page=100",
+		apiURL, url.PathEscape(discover.Group), discover.Subgroups)
+	for next != "" {
+		resp, err := getAPI(ctx, client, next, header)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list projects of GitLab group %s: %w", discover.Group, err)
+		}
+		var page []gitLabProject
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode projects of GitLab group %s: %w", discover.Group, err)
+		}
+
+		for _, project := range page {
+			// Group paths are case-insensitive on GitLab
+			if project.Archived || !strings.HasPrefix(strings.ToLower(project.PathWithNamespace), prefix) {
+				continue
+			}
+			cloneURL := project.HTTPURL
+			if discover.Protocol == "ssh" {
+				cloneURL = project.SSHURL
+			}
+			repos = append(repos, common.NewDiscoveredRepo(project.PathWithNamespace[len(prefix):], cloneURL, project.Visibility))
+		}
+		next = nextLink(resp.Header.Get("Link"))
+	}
+	return repos, nil
+}
@@ -0,0 +1,77 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ternarybob/gitsync/internal/common"
+)
+
+// heartbeatTimeout keeps a slow monitoring endpoint from holding up the job
+const heartbeatTimeout = 5 * time.Second
+
+// maxHeartbeatBody bounds the failure description sent with a fail ping
+const maxHeartbeatBody = 1000
+
+var heartbeatClient = &http.Client{Timeout: heartbeatTimeout}
+
+// sendHeartbeat pings the job's heartbeat_url after a successful run, or
+// <url>/fail with a description of the failure. Errors are only logged.
+func sendHeartbeat(jobName string, jobConfig *common.JobConfig, result *SyncResult, err error) {
+	if jobConfig.HeartbeatURL == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), heartbeatTimeout)
+	defer cancel()
+
+	var req *http.Request
+	var reqErr error
+	if err == nil {
+		req, reqErr = http.NewRequestWithContext(ctx, http.MethodGet, jobConfig.HeartbeatURL, nil)
+	} else {
+		// The failure description goes in a POST body, which monitors such as
+		// Healthchecks.io keep with the ping
+		url := strings.TrimRight(jobConfig.HeartbeatURL, "/") + "/fail"
+		req, reqErr = http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(heartbeatFailure(result, err)))
+		if reqErr == nil {
+			req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+		}
+	}
+	if reqErr != nil {
+		common.GetLogger().Warn().Str("job", jobName).Err(reqErr).Msg("Failed to build heartbeat request")
+		return
+	}
+
+	resp, reqErr := heartbeatClient.Do(req)
+	if reqErr == nil {
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			reqErr = fmt.Errorf("unexpected response status %s", resp.Status)
+		}
+	}
+	if reqErr != nil {
+		common.GetLogger().Warn().Str("job", jobName).Err(reqErr).Msg("Heartbeat ping failed")
+		return
+	}
+
+	common.GetLogger().Debug().Str("job", jobName).Str("method", req.Method).Msg("Heartbeat ping sent")
+}
+
+// heartbeatFailure summarizes a failed run for a fail ping
+func heartbeatFailure(result *SyncResult, err error) string {
+	var body strings.Builder
+	if result != nil {
+		fmt.Fprintf(&body, "%d pushed, %d skipped, %d failed\n", result.Count(StatusPushed), result.Count(StatusSkipped), result.Count(StatusFailed))
+		for _, entry := range result.Failed() {
+			fmt.Fprintf(&body, "%s: %s\n", entry.name(), firstLine(entry.Error))
+		}
+	}
+	if result == nil || len(result.Failed()) == 0 {
+		body.WriteString(firstLine(err.Error()) + "\n")
+	}
+	return truncate(body.String(), maxHeartbeatBody)
+}
@@ -0,0 +1,94 @@
+package services
+
+import (
+	"slices"
+	"time"
+
+	"github.com/ternarybob/gitsync/internal/store"
+)
+
+// loadLastSynced returns the commits each branch and target were last synced
+// at, or nil when pushes must always be verified against the target
+func (s *Syncer) loadLastSynced() map[store.SyncKey]string {
+	if s.store == nil || s.jobConfig.VerifyPush {
+		return nil
+	}
+
+	commits, err := s.store.GetLastSyncedCommits(s.jobName)
+	if err != nil {
+		s.logger.Warn().Str("job", s.jobName).Err(err).Msg("Failed to read sync history, comparing with targets instead")
+		return nil
+	}
+	// A repository created this run has none of the commits recorded for it
+	for key := range commits {
+		if slices.Contains(s.created, key.Target) {
+			delete(commits, key)
+		}
+	}
+	return commits
+}
+
+// beginTransaction persists a running transaction for a push about to start.
+// It returns nil when no store is configured.
+func (s *Syncer) beginTransaction(entry SyncEntry) *store.Transaction {
+	if s.store == nil {
+		return nil
+	}
+
+	t := &store.Transaction{
+		JobName:    s.jobName,
+		RunID:      s.runID,
+		Branch:     entry.Branch,
+		Ref:        entry.Ref,
+		Target:     entry.Target,
+		Status:     store.StatusRunning,
+		CommitHash: entry.NewCommit,
+		StartTime:  time.Now(),
+	}
+	if err := s.store.SaveTransaction(t); err != nil {
+		s.logger.Warn().Str("job", s.jobName).Str("target", entry.Target).Err(err).Msg("Failed to record transaction")
+	}
+	return t
+}
+
+// finishTransaction moves the entry's transaction to its final status, creating
+// it first for outcomes that never went through beginTransaction
+func (s *Syncer) finishTransaction(entry SyncEntry) {
+	if s.store == nil {
+		return
+	}
+
+	t := entry.tx
+	if t == nil {
+		t = &store.Transaction{
+			JobName:   s.jobName,
+			RunID:     s.runID,
+			Branch:    entry.Branch,
+			Ref:       entry.Ref,
+			Target:    entry.Target,
+			StartTime: time.Now().Add(-entry.Duration),
+		}
+	}
+
+	switch entry.Status {
+	case StatusPushed:
+		t.Status = store.StatusSuccess
+	case StatusSkipped:
+		t.Status = store.StatusSkipped
+	case StatusDiverged:
+		t.Status = store.StatusDiverged
+	case StatusBlocked:
+		t.Status = store.StatusBlocked
+	default:
+		t.Status = store.StatusFailed
+	}
+	t.CommitHash = entry.NewCommit
+	t.OldCommit = entry.OldCommit
+	t.EndTime = time.Now()
+	t.Duration = t.EndTime.Sub(t.StartTime)
+	t.Error = entry.Error
+
+	if err := s.store.SaveTransaction(t); err != nil {
+		s.logger.Warn().Str("job", s.jobName).Str("target", entry.Target).Err(err).Msg("Failed to record transaction")
+	}
+}
@@ -0,0 +1,146 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// hookWaitDelay bounds how long a finished or cancelled hook may hold its
+// output open through child processes
+const hookWaitDelay = 5 * time.Second
+
+// runPreSyncHook runs pre_sync_command before anything is pushed, with the
+// selected branches or refs in the environment. An error aborts the job.
+func (s *Syncer) runPreSyncHook(ctx context.Context, repoDir string, branches, refs []string) error {
+	if s.jobConfig.PreSyncCommand == "" {
+		return nil
+	}
+
+	env := append(s.hookEnv(repoDir),
+		"GITSYNC_BRANCHES="+strings.Join(branches, " "),
+		"GITSYNC_REFS="+strings.Join(refs, " "),
+	)
+
+	if _, err := s.runHook(ctx, "pre_sync", s.jobConfig.PreSyncCommand, repoDir, env); err != nil {
+		return fmt.Errorf("pre_sync_command failed, nothing was pushed: %w", err)
+	}
+	return nil
+}
+
+// runPrePushHook runs pre_push_command for a range of commits of a branch
+// about to be pushed. An error, carrying the command's output, blocks the
+// branch.
+func (s *Syncer) runPrePushHook(ctx context.Context, push PendingPush) error {
+	if s.jobConfig.PrePushCommand == "" {
+		return nil
+	}
+
+	env := append(s.hookEnv(push.RepoDir),
+		"GITSYNC_BRANCH="+push.Branch,
+		"GITSYNC_OLD_COMMIT="+push.OldCommit,
+		"GITSYNC_NEW_COMMIT="+push.NewCommit,
+		"GITSYNC_RANGE="+push.Range(),
+		"GITSYNC_WIKI="+strconv.FormatBool(push.Wiki),
+	)
+
+	output, err := s.runHook(ctx, "pre_push", s.jobConfig.PrePushCommand, push.RepoDir, env)
+	if err != nil {
+		return fmt.Errorf("pre_push_command blocked %s: %w\n%s", push.Range(), err, bytes.TrimSpace(output))
+	}
+	return nil
+}
+
+// runPostSyncHook runs post_sync_command once the job finished, with its
+// outcome in the environment. Failures are only logged.
+func (s *Syncer) runPostSyncHook(ctx context.Context, result *SyncResult, syncErr error) {
+	if s.jobConfig.PostSyncCommand == "" {
+		return
+	}
+
+	status := "success"
+	if syncErr != nil {
+		status = "failed"
+	}
+
+	var failedTargets []string
+	seen := make(map[string]bool)
+	for _, entry := range result.Failed() {
+		if !seen[entry.Target] {
+			seen[entry.Target] = true
+			failedTargets = append(failedTargets, entry.Target)
+		}
+	}
+
+	repoDir := s.repoDir()
+	env := append(s.hookEnv(repoDir),
+		"GITSYNC_STATUS="+status,
+		"GITSYNC_PUSHED="+strconv.Itoa(result.Count(StatusPushed)),
+		"GITSYNC_SKIPPED="+strconv.Itoa(result.Count(StatusSkipped)),
+		"GITSYNC_FAILED="+strconv.Itoa(result.Count(StatusFailed)),
+		"GITSYNC_FAILED_TARGETS="+strings.Join(failedTargets, " "),
+		"GITSYNC_ERROR="+result.Error,
+	)
+
+	dir := repoDir
+	if exists, _ := dirExists(dir); !exists {
+		dir = s.tempDir
+	}
+
+	if _, err := s.runHook(ctx, "post_sync", s.jobConfig.PostSyncCommand, dir, env); err != nil {
+		s.logger.Error().Str("job", s.jobName).Err(err).Msg("post_sync_command failed")
+	}
+}
+
+// hookEnv returns the environment shared by all hooks
+func (s *Syncer) hookEnv(repoDir string) []string {
+	return append(os.Environ(),
+		"GITSYNC_JOB="+s.jobName,
+		"GITSYNC_SOURCE="+s.jobConfig.SourceLabel(),
+		"GITSYNC_REPO_DIR="+repoDir,
+	)
+}
+
+// runHook runs a command through the platform shell in dir, logging each line
+// it prints, and returns its output. The job context bounds it, so the job
+// timeout also stops hooks.
+func (s *Syncer) runHook(ctx context.Context, hook, command, dir string, env []string) ([]byte, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	cmd.Dir = dir
+	cmd.Env = env
+	cmd.WaitDelay = hookWaitDelay
+
+	s.logger.Info().Str("job", s.jobName).Str("hook", hook).Str("command", command).Msg("Running hook")
+
+	startTime := time.Now()
+	output, err := cmd.CombinedOutput()
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			s.logger.Info().Str("job", s.jobName).Str("hook", hook).Msg(line)
+		}
+	}
+
+	if ctx.Err() != nil {
+		return output, fmt.Errorf("%s hook interrupted by job timeout: %w", hook, ctx.Err())
+	}
+	if err != nil {
+		return output, err
+	}
+
+	s.logger.Info().Str("job", s.jobName).Str("hook", hook).Float64("duration", time.Since(startTime).Seconds()).Msg("Hook completed")
+	return output, nil
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Prometheus metrics, registered with the default registry and served on /metrics.
+// Target labels carry the configured target URL.
+var (
+	syncAttempts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gitsync_sync_attempts_total",
+		Help: "Branch, ref, tag and bundle syncs attempted per job and target.",
+	}, []string{"job", "target"})
+
+	syncSuccesses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gitsync_sync_success_total",
+		Help: "Syncs per job and target that were pushed or needed no push.",
+	}, []string{"job", "target"})
+
+	syncFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gitsync_sync_failures_total",
+		Help: "Syncs per job and target that failed.",
+	}, []string{"job", "target"})
+
+	branchesPushed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gitsync_branches_pushed_total",
+		Help: "Branches pushed per job and target.",
+	}, []string{"job", "target"})
+
+	branchesSkipped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gitsync_branches_skipped_total",
+		Help: "Branches skipped because the target was already up to date, per job and target.",
+	}, []string{"job", "target"})
+
+	syncDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gitsync_sync_duration_seconds",
+		Help:    "Duration of job runs.",
+		Buckets: []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800, 3600},
+	}, []string{"job"})
+
+	skippedRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gitsync_skipped_runs_total",
+		Help: "Scheduled runs skipped because the previous run of the job was still in progress.",
+	}, []string{"job"})
+
+	lastSuccess = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gitsync_last_success_timestamp_seconds",
+		Help: "Unix time of the last job run that completed without failures.",
+	}, []string{"job"})
+
+	credentialsBackendFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gitsync_credentials_backend_failures_total",
+		Help: "Job runs that failed because their credentials could not be read from Vault.",
+	}, []string{"job"})
+)
+
+// observeEntry counts one recorded push
+func observeEntry(jobName string, entry *SyncEntry) {
+	syncAttempts.WithLabelValues(jobName, entry.Target).Inc()
+	if entry.failed() {
+		syncFailures.WithLabelValues(jobName, entry.Target).Inc()
+		return
+	}
+	syncSuccesses.WithLabelValues(jobName, entry.Target).Inc()
+
+	if entry.Branch == "" {
+		return
+	}
+	switch entry.Status {
+	case StatusPushed:
+		branchesPushed.WithLabelValues(jobName, entry.Target).Inc()
+	case StatusSkipped:
+		branchesSkipped.WithLabelValues(jobName, entry.Target).Inc()
+	}
+}
+
+// observeJob records the duration of a job run and, when it succeeded, its completion time
+func observeJob(jobName string, duration time.Duration, err error) {
+	syncDuration.WithLabelValues(jobName).Observe(duration.Seconds())
+	if err == nil {
+		lastSuccess.WithLabelValues(jobName).SetToCurrentTime()
+	}
+	if errors.Is(err, ErrCredentialsBackend) {
+		credentialsBackendFailures.WithLabelValues(jobName).Inc()
+	}
+}
@@ -0,0 +1,280 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ternarybob/gitsync/internal/common"
+)
+
+// notifyTimeout bounds the delivery of one notification to one destination
+const notifyTimeout = 10 * time.Second
+
+// maxNotifyError bounds the error carried by a notification, maxExcerpt the
+// part of each error shown in notification text
+const (
+	maxNotifyError = 2000
+	maxExcerpt     = 500
+)
+
+// Kinds of notification sent after a job run
+const (
+	NotifyFailure  = "failure"
+	NotifyRecovery = "recovery"
+)
+
+// Notification announces a failed job run, or the first successful run after failures
+type Notification struct {
+	Kind       string        `json:"event"`
+	Job        string        `json:"job"`
+	RunID      string        `json:"run_id,omitempty"`
+	Source     string        `json:"source"`
+	Time       time.Time     `json:"time"`
+	Duration   time.Duration `json:"duration_ns"`
+	Error      string        `json:"error,omitempty"`
+	Failed     []SyncEntry   `json:"failed,omitempty"`
+	Suppressed int           `json:"suppressed,omitempty"` // Failures not announced because of min_interval
+}
+
+// Notifier delivers notifications to one destination
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, n *Notification) error
+}
+
+// NotificationManager decides which job runs are announced and hands them to
+// every configured notifier. Delivery failures are only logged.
+type NotificationManager struct {
+	notifiers   []Notifier
+	minInterval time.Duration
+
+	mu   sync.Mutex
+	jobs map[string]*jobNotifyState
+}
+
+type jobNotifyState struct {
+	failing    bool
+	lastSent   time.Time
+	suppressed int
+}
+
+// NewNotificationManager creates the notifiers configured in cfg, it returns
+// nil when none are
+func NewNotificationManager(cfg *common.Config) *NotificationManager {
+	client := &http.Client{Timeout: notifyTimeout}
+
+	var notifiers []Notifier
+	for _, url := range cfg.Notifications.SlackURLs {
+		notifiers = append(notifiers, &slackNotifier{url: url, client: client})
+	}
+	for _, url := range cfg.Notifications.WebhookURLs {
+		notifiers = append(notifiers, &webhookNotifier{url: url, client: client})
+	}
+	if cfg.Notifications.Email != nil {
+		notifiers = append(notifiers, &emailNotifier{config: cfg.Notifications.Email})
+	}
+	if len(notifiers) == 0 {
+		return nil
+	}
+
+	return &NotificationManager{
+		notifiers:   notifiers,
+		minInterval: cfg.Notifications.MinInterval,
+		jobs:        make(map[string]*jobNotifyState),
+	}
+}
+
+// adopt carries the failure state of each job over from the manager of the
+// previous configuration, so a reload neither repeats a failure notification
+// within min_interval nor loses a pending recovery. It returns m.
+func (m *NotificationManager) adopt(old *NotificationManager) *NotificationManager {
+	if m == nil || old == nil {
+		return m
+	}
+	old.mu.Lock()
+	defer old.mu.Unlock()
+	for jobName, state := range old.jobs {
+		copied := *state
+		m.jobs[jobName] = &copied
+	}
+	return m
+}
+
+// JobFinished announces a failed run, at most once per min_interval per job,
+// and the first successful run after a failure
+func (m *NotificationManager) JobFinished(jobName string, result *SyncResult, err error) {
+	if m == nil {
+		return
+	}
+
+	n := m.prepare(jobName, result, err)
+	if n == nil {
+		return
+	}
+
+	logger := common.GetLogger()
+	for _, notifier := range m.notifiers {
+		ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+		if err := notifier.Notify(ctx, n); err != nil {
+			logger.Warn().Str("job", jobName).Str("notifier", notifier.Name()).Str("event", n.Kind).Err(err).Msg("Failed to deliver notification")
+		} else {
+			logger.Debug().Str("job", jobName).Str("notifier", notifier.Name()).Str("event", n.Kind).Msg("Notification delivered")
+		}
+		cancel()
+	}
+}
+
+// prepare updates the job's failure state and returns the notification to send, if any
+func (m *NotificationManager) prepare(jobName string, result *SyncResult, err error) *Notification {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state, exists := m.jobs[jobName]
+	if !exists {
+		state = &jobNotifyState{}
+		m.jobs[jobName] = state
+	}
+
+	now := time.Now()
+	n := &Notification{Job: jobName, Time: now}
+	if result != nil {
+		n.Source = result.Source
+		n.RunID = result.RunID
+		n.Duration = result.Duration
+		n.Failed = result.Failed()
+	}
+
+	if err == nil {
+		if !state.failing {
+			return nil
+		}
+		*state = jobNotifyState{}
+		n.Kind = NotifyRecovery
+		return n
+	}
+
+	state.failing = true
+	if !state.lastSent.IsZero() && now.Sub(state.lastSent) < m.minInterval {
+		state.suppressed++
+		return nil
+	}
+
+	n.Kind = NotifyFailure
+	n.Error = truncate(err.Error(), maxNotifyError)
+	n.Suppressed = state.suppressed
+	state.lastSent = now
+	state.suppressed = 0
+	return n
+}
+
+// slackNotifier posts to a Slack incoming webhook
+type slackNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func (n *slackNotifier) Name() string {
+	return "slack"
+}
+
+func (n *slackNotifier) Notify(ctx context.Context, notification *Notification) error {
+	return postJSON(ctx, n.client, n.url, map[string]string{"text": notificationText(notification, true)})
+}
+
+// webhookNotifier posts the notification as JSON to any URL
+type webhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func (n *webhookNotifier) Name() string {
+	return "webhook"
+}
+
+func (n *webhookNotifier) Notify(ctx context.Context, notification *Notification) error {
+	return postJSON(ctx, n.client, n.url, notification)
+}
+
+// notificationText renders a notification as plain text, or as Slack mrkdwn
+// with bold headings and code blocks for error excerpts
+func notificationText(n *Notification, markdown bool) string {
+	var text strings.Builder
+
+	heading := fmt.Sprintf("gitsync job %s failed", n.Job)
+	if n.Kind == NotifyRecovery {
+		heading = fmt.Sprintf("gitsync job %s recovered", n.Job)
+	}
+	if markdown {
+		heading = "*" + heading + "*"
+	}
+	fmt.Fprintf(&text, "%s\nSource: %s\nDuration: %s\n", heading, n.Source, n.Duration.Round(time.Millisecond))
+	if n.RunID != "" {
+		fmt.Fprintf(&text, "Run: %s\n", n.RunID)
+	}
+
+	if n.Kind == NotifyRecovery {
+		return text.String()
+	}
+
+	if n.Suppressed > 0 {
+		fmt.Fprintf(&text, "Failed %d more times since the last notification\n", n.Suppressed)
+	}
+
+	excerpt := func(err string) {
+		err = truncate(strings.TrimSpace(err), maxExcerpt)
+		if markdown {
+			fmt.Fprintf(&text, "```%s```\n", err)
+		} else {
+			fmt.Fprintf(&text, "    %s\n", strings.ReplaceAll(err, "\n", "\n    "))
+		}
+	}
+
+	if len(n.Failed) == 0 {
+		text.WriteString("Error:\n")
+		excerpt(n.Error)
+		return text.String()
+	}
+
+	for _, entry := range n.Failed {
+		fmt.Fprintf(&text, "Failed %s:\n", entry.name())
+		excerpt(entry.Error)
+	}
+	return text.String()
+}
+
+func postJSON(ctx context.Context, client *http.Client, url string, body interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to encode notification: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+	return nil
+}
+
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	return s[:max] + "…"
+}
@@ -0,0 +1,102 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ternarybob/gitsync/internal/common"
+	"github.com/ternarybob/gitsync/internal/store"
+)
+
+// PushValidator decides whether a branch may be pushed, such as a secret
+// scanner of a program embedding gitsync. It is asked for every range of
+// commits a branch is about to push, before any target receives the branch.
+type PushValidator interface {
+	// ValidatePush returns an error to block the branch on every target, the
+	// error is recorded as the reason
+	ValidatePush(ctx context.Context, push PendingPush) error
+}
+
+// PendingPush is a range of commits of a branch about to be pushed
+type PendingPush struct {
+	Job       string
+	RepoDir   string // The cached repository, with the branch checked out
+	Branch    string
+	OldCommit string // The commit a target has, empty when the branch is new to it
+	NewCommit string
+	Wiki      bool // A branch of the wiki repository, with sync_wiki
+}
+
+// Range returns the commits about to be pushed as a revision range, old..new,
+// or the new commit alone, meaning its whole history, for a new branch
+func (p PendingPush) Range() string {
+	if p.OldCommit == "" {
+		return p.NewCommit
+	}
+	return p.OldCommit + ".." + p.NewCommit
+}
+
+// SetValidator makes the syncer ask v before pushing a branch, after
+// pre_push_command passed
+func (s *Syncer) SetValidator(v PushValidator) {
+	s.validator = v
+}
+
+// validatePush runs pre_push_command and the validator for every range the
+// targets are about to receive of the pending branch, whose OldCommit is
+// filled in per range. Targets on the same commit share a range, so most
+// branches are checked once. An error blocks the branch.
+func (s *Syncer) validatePush(ctx context.Context, targets []common.TargetConfig, pending PendingPush) error {
+	if s.jobConfig.PrePushCommand == "" && s.validator == nil {
+		return nil
+	}
+
+	for _, oldCommit := range s.targetCommits(ctx, pending.RepoDir, targets, pending.Branch, pending.NewCommit) {
+		push := pending
+		push.OldCommit = oldCommit
+		if err := s.runPrePushHook(ctx, push); err != nil {
+			return err
+		}
+		if s.validator == nil {
+			continue
+		}
+		if err := s.validator.ValidatePush(ctx, push); err != nil {
+			return fmt.Errorf("push validator blocked %s: %w", push.Range(), err)
+		}
+	}
+	return nil
+}
+
+// targetCommits returns the distinct commits the git targets have of a
+// branch, empty for targets without it, leaving out targets already on
+// commit. A target that cannot be asked counts as having nothing, so the
+// whole branch is checked rather than too little.
+func (s *Syncer) targetCommits(ctx context.Context, repoDir string, targets []common.TargetConfig, branch, commit string) []string {
+	var commits []string
+	seen := make(map[string]bool)
+	for _, target := range targets {
+		if common.IsBundleURL(target.URL) {
+			continue
+		}
+		if s.lastSynced[store.SyncKey{Branch: branch, Target: target.URL}] == commit {
+			continue
+		}
+
+		targetName, err := s.ensureRemote(ctx, repoDir, target.URL)
+		var remoteCommit string
+		if err == nil {
+			remoteCommit, err = s.getRemoteCommitHash(ctx, repoDir, target, targetName, branch)
+		}
+		if err != nil && !errors.Is(err, errRemoteBranchMissing) {
+			s.logger.Debug().Str("job", s.jobName).Str("branch", branch).Str("target", target.URL).Err(err).Msg("Could not get remote commit hash, validating the whole branch")
+		}
+
+		if remoteCommit == commit || seen[remoteCommit] {
+			continue
+		}
+		seen[remoteCommit] = true
+		commits = append(commits, remoteCommit)
+	}
+	return commits
+}
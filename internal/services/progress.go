@@ -0,0 +1,76 @@
+package services
+
+import (
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// GitProgress is the latest progress line of a clone, fetch or push of a
+// running job, such as "Receiving objects:  45% (450/1000)"
+type GitProgress struct {
+	Operation string    `json:"operation"` // clone, fetch or push
+	Phase     string    `json:"phase"`     // The step git is in, such as "Receiving objects"
+	Percent   int       `json:"percent"`
+	Done      int64     `json:"done"`
+	Total     int64     `json:"total"`
+	Updated   time.Time `json:"updated"`
+}
+
+// progressPattern matches the progress lines of git and of the remote side,
+// capturing the step, percentage and counts
+var progressPattern = regexp.MustCompile(`^(?:remote:\s*)?([A-Za-z][A-Za-z ]*?):\s+(\d+)% \((\d+)/(\d+)\)`)
+
+// parseProgress reads a progress line of git
+func parseProgress(line string) (GitProgress, bool) {
+	match := progressPattern.FindStringSubmatch(line)
+	if match == nil {
+		return GitProgress{}, false
+	}
+	percent, _ := strconv.Atoi(match[2])
+	done, _ := strconv.ParseInt(match[3], 10, 64)
+	total, _ := strconv.ParseInt(match[4], 10, 64)
+	return GitProgress{Phase: match[1], Percent: percent, Done: done, Total: total}, true
+}
+
+// Progress returns the latest progress of the running job, false when none
+// of its git commands is reporting any
+func (s *Syncer) Progress() (GitProgress, bool) {
+	s.progressMu.Lock()
+	defer s.progressMu.Unlock()
+	if s.progress == nil {
+		return GitProgress{}, false
+	}
+	return *s.progress, true
+}
+
+func (s *Syncer) setProgress(progress *GitProgress) {
+	s.progressMu.Lock()
+	s.progress = progress
+	s.progressMu.Unlock()
+}
+
+// reportProgress records a progress line of the command when the job sets
+// git_progress, and logs it at info level at most once per
+// progress_interval, so long transfers are not silent
+func (w *gitOutputWriter) reportProgress(line string) {
+	s := w.syncer
+	if !s.jobConfig.GitProgress {
+		return
+	}
+	progress, ok := parseProgress(line)
+	if !ok {
+		return
+	}
+	progress.Operation = w.operation
+	progress.Updated = time.Now()
+	s.setProgress(&progress)
+
+	if progress.Updated.Sub(w.lastReport) < s.jobConfig.ProgressInterval {
+		return
+	}
+	w.lastReport = progress.Updated
+	s.logger.Info().Str("job", s.jobName).Str("operation", progress.Operation).Str("phase", progress.Phase).
+		Int("percent", progress.Percent).Int64("done", progress.Done).Int64("total", progress.Total).
+		Msg("Git progress")
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ternarybob/gitsync/internal/common"
+)
+
+func TestParseProgress(t *testing.T) {
+	tests := []struct {
+		line string
+		want GitProgress
+		ok   bool
+	}{
+		{"Receiving objects:  45% (450/1000), 1.20 MiB | 512.00 KiB/s", GitProgress{Phase: "Receiving objects", Percent: 45, Done: 450, Total: 1000}, true},
+		{"Resolving deltas: 100% (12/12), done.", GitProgress{Phase: "Resolving deltas", Percent: 100, Done: 12, Total: 12}, true},
+		{"remote: Counting objects:   7% (7/100)", GitProgress{Phase: "Counting objects", Percent: 7, Done: 7, Total: 100}, true},
+		{"Writing objects:  50% (1/2)", GitProgress{Phase: "Writing objects", Percent: 50, Done: 1, Total: 2}, true},
+		{"Cloning into bare repository 'repo'...", GitProgress{}, false},
+		{"remote: Enumerating objects: 5, done.", GitProgress{}, false},
+	}
+	for _, test := range tests {
+		got, ok := parseProgress(test.line)
+		if ok != test.ok || got != test.want {
+			t.Errorf("parseProgress(%q) = %+v, %t, want %+v, %t", test.line, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestReportProgress(t *testing.T) {
+	logs := captureLogs(t)
+	s := &Syncer{jobName: "test", jobConfig: &common.JobConfig{GitProgress: true, ProgressInterval: time.Hour}, logger: common.GetLogger()}
+	w := &gitOutputWriter{syncer: s, operation: "clone"}
+
+	w.Write([]byte("Cloning into 'repo'...\nReceiving objects:  10% (1/10)\rReceiving objects:  20% (2/10)\r"))
+	progress, ok := s.Progress()
+	if !ok || progress.Operation != "clone" || progress.Phase != "Receiving objects" || progress.Percent != 20 || progress.Updated.IsZero() {
+		t.Fatalf("Progress() = %+v, %t, want clone receiving objects at 20%%", progress, ok)
+	}
+
+	// Only the first step is logged within progress_interval
+	event, ok := logs.find("Git progress")
+	if !ok {
+		t.Fatal("no Git progress line logged")
+	}
+	if event.Fields["operation"] != "clone" || event.Fields["phase"] != "Receiving objects" || event.Fields["percent"] != float64(10) || event.Fields["done"] != float64(1) || event.Fields["total"] != float64(10) {
+		t.Errorf("Git progress fields = %v, want clone receiving objects at 10%% (1/10)", event.Fields)
+	}
+
+	// Without git_progress nothing is reported
+	s = &Syncer{jobName: "test", jobConfig: &common.JobConfig{}, logger: common.GetLogger()}
+	w = &gitOutputWriter{syncer: s, operation: "fetch"}
+	w.Write([]byte("Receiving objects:  10% (1/10)\r"))
+	if progress, ok := s.Progress(); ok {
+		t.Errorf("Progress() = %+v without git_progress, want none", progress)
+	}
+}
+
+func TestScheduledRunProgress(t *testing.T) {
+	f := newFixture(t)
+	target := f.path("target.git")
+	f.git(f.root, "init", "-q", "--bare", target)
+	started, release := f.holdPushes(target)
+
+	cfg := f.config(
+		"source = "+quote(f.source),
+		"targets = ["+quote(target)+"]",
+		`every = "1s"`,
+		"git_progress = true",
+	)
+	cfg.Jobs.RunOnStartup = false
+	s := NewScheduler(cfg, nil)
+	s.SetLogger(common.GetLogger())
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop(5 * time.Second)
+	defer release()
+
+	// The scheduled run is held in its push, after writing its objects
+	deadline := time.Now().Add(10 * time.Second)
+	for !started() {
+		if time.Now().After(deadline) {
+			t.Fatal("no scheduled run reached the target")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	var progress interface{}
+	for time.Now().Before(deadline) {
+		status, err := s.GetJobStatus("fixture")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if progress = status["progress"]; progress != nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if p, ok := progress.(GitProgress); !ok || p.Operation != "push" {
+		t.Fatalf("progress of the scheduled run = %+v, want the push", progress)
+	}
+
+	// Once the run ends its progress goes
+	release()
+	for time.Now().Before(deadline) {
+		status, _ := s.GetJobStatus("fixture")
+		if status["progress"] == nil {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Error("progress still reported after the scheduled run ended")
+}
@@ -0,0 +1,232 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/ternarybob/gitsync/internal/common"
+)
+
+// preparedBranch is a branch checked out and validated, ready to be pushed
+type preparedBranch struct {
+	branch  string
+	commit  string
+	err     error // Recorded on every target instead of pushing
+	blocked bool  // Pre-push validation refused the branch
+}
+
+// queuedPush is a branch waiting for the push of its target
+type queuedPush struct {
+	entry SyncEntry
+	stats *pushStats
+	start time.Time
+}
+
+// pushedRef is the outcome of one ref in the porcelain output of git push
+type pushedRef struct {
+	flag    byte   // ' ' fast-forward, '+' forced, '*' new, '=' up to date, '!' rejected
+	summary string // Such as "[rejected] (non-fast-forward)"
+}
+
+// porcelainPattern matches the line git push --porcelain prints for each ref:
+// flag, from:to and summary separated by tabs
+var porcelainPattern = regexp.MustCompile(`^([ +\-*=!])\t([^\t]*):([^\t]+)\t(.*)$`)
+
+// parsePushPorcelain returns the outcome of each ref of a push keyed by the
+// ref on the remote
+func parsePushPorcelain(output string) map[string]pushedRef {
+	refs := make(map[string]pushedRef)
+	for _, line := range strings.FieldsFunc(output, func(r rune) bool { return r == '\n' || r == '\r' }) {
+		if match := porcelainPattern.FindStringSubmatch(line); match != nil {
+			refs[match[3]] = pushedRef{flag: match[1][0], summary: match[4]}
+		}
+	}
+	return refs
+}
+
+// pushBranchesToTarget pushes the prepared branches to a non-bundle target,
+// recording an entry per branch. Branches the target already has are skipped,
+// the others go out in one push, one round trip for the target instead of
+// one per branch. The branches left once ctx is done are returned unrecorded,
+// their push never started.
+func (s *Syncer) pushBranchesToTarget(ctx context.Context, repoDir string, target common.TargetConfig, prepared []preparedBranch, result *SyncResult) []string {
+	var queue []queuedPush
+	var targetName string
+	var notReached []string
+	for _, branch := range prepared {
+		if ctx.Err() != nil && branch.err == nil {
+			notReached = append(notReached, branch.branch)
+			continue
+		}
+
+		entry := SyncEntry{Branch: branch.branch, Source: s.aggregated[branch.branch].source, Target: target.URL, NewCommit: branch.commit, SourceCommit: s.sourceCommits[branch.commit], err: branch.err}
+		if branch.err != nil {
+			if branch.blocked {
+				entry.Status = StatusBlocked
+			}
+			s.record(result, entry)
+			continue
+		}
+
+		startTime := time.Now()
+
+		s.logger.Info().Str("job", s.jobName).Str("branch", branch.branch).Str("target", target.URL).Str("commit", branch.commit).Msg("Starting sync to target")
+		entry.tx = s.beginTransaction(entry)
+
+		name, stats, err := s.planPush(ctx, repoDir, target, branch.branch, branch.commit, s.jobConfig.RequireSignatures)
+		if err != nil || stats.Skipped {
+			entry.Duration = time.Since(startTime)
+			s.recordPush(result, entry, stats, err)
+			continue
+		}
+		targetName = name
+		queue = append(queue, queuedPush{entry: entry, stats: stats, start: startTime})
+	}
+
+	if len(queue) > 0 {
+		s.pushQueue(ctx, repoDir, target, targetName, queue, result)
+	}
+	return notReached
+}
+
+// pushQueue pushes the queued branches to the target in a single git push,
+// atomic when the job sets atomic_push, and reads the outcome of each branch
+// from the porcelain output. A push the target refuses as a whole is retried
+// branch by branch, so the entry of the branch at fault carries the failure.
+func (s *Syncer) pushQueue(ctx context.Context, repoDir string, target common.TargetConfig, targetName string, queue []queuedPush, result *SyncResult) {
+	pushCtx, span := tracer.Start(ctx, "git push", trace.WithAttributes(
+		attribute.Int("gitsync.branches", len(queue)),
+		attribute.String("gitsync.target_host", common.RepositoryHost(target.URL)),
+	))
+
+	// Each branch keeps a span of its own under the push, with its outcome
+	branchCtxs := make([]context.Context, len(queue))
+	branchSpans := make([]trace.Span, len(queue))
+	for i, queued := range queue {
+		branchCtxs[i], branchSpans[i] = tracer.Start(pushCtx, "git push branch", trace.WithAttributes(
+			attribute.String("gitsync.branch", queued.entry.Branch),
+			attribute.String("gitsync.target_host", common.RepositoryHost(target.URL)),
+		))
+	}
+
+	args := []string{"push", "--progress", "--porcelain"}
+	if s.jobConfig.AtomicPush {
+		args = append(args, "--atomic")
+	}
+	args = append(args, targetName)
+	for _, queued := range queue {
+		// override forces each refspec with a +, --force would apply to all of them
+		spec := fmt.Sprintf("refs/heads/%s:refs/heads/%s", queued.entry.Branch, queued.entry.Branch)
+		if s.jobConfig.Override {
+			spec = "+" + spec
+		}
+		args = append(args, spec)
+	}
+
+	phaseCtx, cancel := s.phaseContext(pushCtx, phasePush)
+	cmd := s.gitTarget(phaseCtx, target, args...)
+	cmd.Dir = repoDir
+	output, err := s.runStreamed(cmd, "push")
+	if err != nil {
+		err = fmt.Errorf("failed to push: %w\n%s", s.phaseError(pushCtx, phaseCtx, phasePush, target.URL, err), output)
+	}
+	cancel()
+
+	refs := parsePushPorcelain(string(output))
+	if err != nil && len(queue) > 1 && pushCtx.Err() == nil && s.batchRefused(queue, refs) {
+		s.logger.Warn().Str("job", s.jobName).Str("target", target.URL).Int("branches", len(queue)).Err(err).Msg("Target refused the push of all branches, pushing them one by one")
+		for i, queued := range queue {
+			err := s.pushSingleBranch(branchCtxs[i], repoDir, target, targetName, queued.entry.Branch, queued.stats)
+			queued.entry.Duration = time.Since(queued.start)
+			endPushSpan(branchSpans[i], s.recordPush(result, queued.entry, queued.stats, err), err)
+		}
+		span.SetAttributes(attribute.Bool("gitsync.batch_refused", true))
+		endSpan(span, nil)
+		return
+	}
+
+	// The transfer is shared by all branches, the first one pushed carries it
+	objects, bytes := parsePushTransfer(string(output))
+	var failed int
+	for i, queued := range queue {
+		ref, reported := refs["refs/heads/"+queued.entry.Branch]
+		var refErr error
+		switch {
+		case reported && ref.flag == '!':
+			refErr = fmt.Errorf("failed to push: target rejected %s %s\n%s", queued.entry.Branch, ref.summary, output)
+		case !reported && err != nil:
+			refErr = err
+		case reported && ref.flag == '=':
+			queued.stats.Skipped = true
+		default:
+			queued.stats.Objects, queued.stats.Bytes = objects, bytes
+			objects, bytes = 0, 0
+		}
+		if refErr != nil {
+			failed++
+		}
+		queued.entry.Duration = time.Since(queued.start)
+		endPushSpan(branchSpans[i], s.recordPush(result, queued.entry, queued.stats, refErr), refErr)
+	}
+
+	span.SetAttributes(attribute.Int("gitsync.branches_failed", failed))
+	endSpan(span, err)
+}
+
+// batchRefused reports whether a failed push left no outcome to attribute:
+// the remote rejected every branch for the same reason, or an atomic push got
+// no porcelain line for a branch, which a server without --atomic refuses
+// before any ref. A plain push failing that early, say on authentication,
+// would fail branch by branch as well.
+func (s *Syncer) batchRefused(queue []queuedPush, refs map[string]pushedRef) bool {
+	var summary string
+	for i, queued := range queue {
+		ref, ok := refs["refs/heads/"+queued.entry.Branch]
+		if !ok {
+			return s.jobConfig.AtomicPush
+		}
+		if ref.flag != '!' || !strings.HasPrefix(ref.summary, "[remote rejected]") || (i > 0 && ref.summary != summary) {
+			return false
+		}
+		summary = ref.summary
+	}
+	return true
+}
+
+// recordPush records the entry of a branch push with its outcome and returns it
+func (s *Syncer) recordPush(result *SyncResult, entry SyncEntry, stats *pushStats, err error) SyncEntry {
+	if err != nil {
+		entry.err = err
+		s.record(result, entry)
+		return entry
+	}
+
+	entry.OldCommit = stats.OldCommit
+	entry.Status = StatusPushed
+	if stats.Skipped {
+		entry.Status = StatusSkipped
+	} else {
+		entry.CommitsPushed = stats.Ahead
+		entry.CommitsOverwritten = stats.Behind
+		entry.Objects = stats.Objects
+		entry.Bytes = stats.Bytes
+	}
+	s.record(result, entry)
+	return entry
+}
+
+// endPushSpan ends the span of one branch of a push with its outcome
+func endPushSpan(span trace.Span, entry SyncEntry, err error) {
+	if err != nil {
+		span.SetAttributes(attribute.String("gitsync.outcome", StatusFailed))
+	} else {
+		span.SetAttributes(entryAttributes(&entry)...)
+	}
+	endSpan(span, err)
+}
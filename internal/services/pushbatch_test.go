@@ -0,0 +1,126 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/ternarybob/gitsync/internal/common"
+)
+
+func TestParsePushPorcelain(t *testing.T) {
+	output := "Enumerating objects: 3, done.\r" +
+		"Writing objects: 100% (3/3), 207 bytes | 51.00 KiB/s, done.\n" +
+		"To https://example.com/repo.git\n" +
+		"*\trefs/heads/feature:refs/heads/feature\t[new branch]\n" +
+		" \trefs/heads/main:refs/heads/main\t1a2b3c4..5d6e7f8\n" +
+		"+\trefs/heads/rebased:refs/heads/rebased\t1a2b3c4...5d6e7f8 (forced update)\n" +
+		"=\trefs/heads/stable:refs/heads/stable\t[up to date]\n" +
+		"!\trefs/heads/dev:refs/heads/dev\t[rejected] (non-fast-forward)\n" +
+		"Done\n"
+
+	want := map[string]pushedRef{
+		"refs/heads/feature": {flag: '*', summary: "[new branch]"},
+		"refs/heads/main":    {flag: ' ', summary: "1a2b3c4..5d6e7f8"},
+		"refs/heads/rebased": {flag: '+', summary: "1a2b3c4...5d6e7f8 (forced update)"},
+		"refs/heads/stable":  {flag: '=', summary: "[up to date]"},
+		"refs/heads/dev":     {flag: '!', summary: "[rejected] (non-fast-forward)"},
+	}
+	got := parsePushPorcelain(output)
+	if len(got) != len(want) {
+		t.Fatalf("parsePushPorcelain = %v, want %v", got, want)
+	}
+	for ref, outcome := range want {
+		if got[ref] != outcome {
+			t.Errorf("%s = %+v, want %+v", ref, got[ref], outcome)
+		}
+	}
+}
+
+func TestBatchRefused(t *testing.T) {
+	queue := []queuedPush{{entry: SyncEntry{Branch: "main"}}, {entry: SyncEntry{Branch: "dev"}}}
+	declined := pushedRef{flag: '!', summary: "[remote rejected] (pre-receive hook declined)"}
+
+	tests := []struct {
+		name   string
+		atomic bool
+		refs   map[string]pushedRef
+		want   bool
+	}{
+		{"every branch declined alike", false, map[string]pushedRef{"refs/heads/main": declined, "refs/heads/dev": declined}, true},
+		{"one branch rejected", false, map[string]pushedRef{"refs/heads/main": {flag: '*', summary: "[new branch]"}, "refs/heads/dev": {flag: '!', summary: "[rejected] (non-fast-forward)"}}, false},
+		{"different reasons", false, map[string]pushedRef{"refs/heads/main": declined, "refs/heads/dev": {flag: '!', summary: "[remote rejected] (protected branch)"}}, false},
+		{"atomic push failed", true, map[string]pushedRef{"refs/heads/main": {flag: '!', summary: "[rejected] (atomic push failed)"}, "refs/heads/dev": {flag: '!', summary: "[rejected] (non-fast-forward)"}}, false},
+		{"no outcome of an atomic push", true, map[string]pushedRef{}, true},
+		{"no outcome of a plain push", false, map[string]pushedRef{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Syncer{jobConfig: &common.JobConfig{AtomicPush: tt.atomic}}
+			if got := s.batchRefused(queue, tt.refs); got != tt.want {
+				t.Errorf("batchRefused = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPushSpanPerBranch(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	f := newFixture(t)
+	f.commit("feature", "feature work")
+	s := f.syncer(
+		"source = "+quote(f.source),
+		"targets = ["+quote(f.path("target.git"))+"]",
+		`branches = ["main", "feature"]`,
+	)
+	if _, err := s.SyncAll(context.Background()); err != nil {
+		t.Fatalf("SyncAll: %v", err)
+	}
+
+	var push sdktrace.ReadOnlySpan
+	branches := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		switch span.Name() {
+		case "git push":
+			push = span
+		case "git push branch":
+			for _, kv := range span.Attributes() {
+				if kv.Key == "gitsync.branch" {
+					branches[kv.Value.AsString()] = span
+				}
+			}
+		}
+	}
+	if push == nil {
+		t.Fatal("no git push span")
+	}
+	if len(branches) != 2 {
+		t.Fatalf("branch spans for %v, want main and feature", branches)
+	}
+	for branch, span := range branches {
+		if span.Parent().SpanID() != push.SpanContext().SpanID() {
+			t.Errorf("span of %s is not a child of the push", branch)
+		}
+		attributes := make(map[attribute.Key]attribute.Value)
+		for _, kv := range span.Attributes() {
+			attributes[kv.Key] = kv.Value
+		}
+		if got := attributes["gitsync.outcome"].AsString(); got != StatusPushed {
+			t.Errorf("%s outcome = %q, want %s", branch, got, StatusPushed)
+		}
+		if _, ok := attributes["gitsync.bytes_pushed"]; !ok {
+			t.Errorf("%s span has no bytes_pushed", branch)
+		}
+		if _, ok := attributes["gitsync.objects_pushed"]; !ok {
+			t.Errorf("%s span has no objects_pushed", branch)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ternarybob/gitsync/internal/common"
+)
+
+// rateLimits holds a limiter per host of [rate_limit], shared by every job
+// of the process so concurrent runs take turns
+var rateLimits = struct {
+	mu       sync.Mutex
+	limiters map[string]*hostLimiter
+}{limiters: make(map[string]*hostLimiter)}
+
+// hostLimiter is a token bucket holding one token, refilled every interval:
+// operations on the host start at least interval apart, in the order they
+// asked
+type hostLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time // When the next operation may start
+}
+
+// limiterFor returns the limiter of host, taking on a changed interval
+func limiterFor(host string, interval time.Duration) *hostLimiter {
+	rateLimits.mu.Lock()
+	defer rateLimits.mu.Unlock()
+
+	limiter, ok := rateLimits.limiters[host]
+	if !ok {
+		limiter = &hostLimiter{}
+		rateLimits.limiters[host] = limiter
+	}
+	limiter.mu.Lock()
+	limiter.interval = interval
+	limiter.mu.Unlock()
+	return limiter
+}
+
+// wait blocks until the operation may start and returns how long it waited.
+// A cancelled wait gives its slot back when no later operation queued behind
+// it.
+func (l *hostLimiter) wait(ctx context.Context) (time.Duration, error) {
+	l.mu.Lock()
+	now := time.Now()
+	start := l.next
+	if start.Before(now) {
+		start = now
+	}
+	l.next = start.Add(l.interval)
+	l.mu.Unlock()
+
+	delay := start.Sub(now)
+	if delay <= 0 {
+		return 0, nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return delay, nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		if l.next.Equal(start.Add(l.interval)) {
+			l.next = start
+		}
+		l.mu.Unlock()
+		return time.Since(now), ctx.Err()
+	}
+}
+
+// networkCommands are the git commands that talk to a remote
+var networkCommands = map[string]bool{"clone": true, "fetch": true, "ls-remote": true, "push": true}
+
+// waitRateLimit waits for the rate limit of the host of url before a git
+// command talking to it. A cancelled wait leaves ctx done, so the command
+// fails without running.
+func (s *Syncer) waitRateLimit(ctx context.Context, url string, args []string) {
+	if len(s.jobConfig.RateLimit) == 0 || !networkCommands[gitSubcommand(args)] {
+		return
+	}
+	host := common.RepositoryHost(url)
+	interval := s.jobConfig.RateLimit.Interval(host)
+	if interval <= 0 {
+		return
+	}
+
+	waited, err := limiterFor(host, interval).wait(ctx)
+	if err != nil {
+		s.logger.Debug().Str("job", s.jobName).Str("host", host).Float64("waited", waited.Seconds()).Err(err).Msg("Stopped waiting for rate limit")
+		return
+	}
+	if waited > 0 {
+		s.logger.Debug().Str("job", s.jobName).Str("host", host).Float64("waited", waited.Seconds()).Msg("Waited for rate limit")
+	}
+}
+
+// gitSubcommand returns the command of git arguments, skipping the -c
+// options before it
+func gitSubcommand(args []string) string {
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "-c":
+			i++
+		case !strings.HasPrefix(args[i], "-"):
+			return args[i]
+		}
+	}
+	return ""
+}
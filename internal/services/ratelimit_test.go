@@ -0,0 +1,67 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHostLimiterSpacesOperations(t *testing.T) {
+	limiter := limiterFor("spacing.example.com", 50*time.Millisecond)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := limiter.wait(context.Background()); err != nil {
+			t.Fatalf("wait %d: %v", i, err)
+		}
+	}
+	// The first operation starts at once, the next two one interval apart
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("three operations took %s, want at least 100ms", elapsed)
+	}
+}
+
+func TestHostLimiterCancelledWaitGivesSlotBack(t *testing.T) {
+	limiter := limiterFor("cancel.example.com", time.Hour)
+	if waited, err := limiter.wait(context.Background()); err != nil || waited != 0 {
+		t.Fatalf("first wait = %s, %v, want no wait", waited, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := limiter.wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("wait past the deadline: err = %v, want DeadlineExceeded", err)
+	}
+
+	limiter.mu.Lock()
+	next := limiter.next
+	limiter.mu.Unlock()
+	if until := time.Until(next); until > time.Hour {
+		t.Errorf("next slot in %s, want the cancelled slot given back", until)
+	}
+}
+
+func TestLimiterForTakesChangedInterval(t *testing.T) {
+	limiterFor("reload.example.com", time.Second)
+	if limiter := limiterFor("reload.example.com", 2*time.Second); limiter.interval != 2*time.Second {
+		t.Errorf("interval = %s, want 2s after a reload", limiter.interval)
+	}
+}
+
+func TestGitSubcommand(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"fetch", "origin"}, "fetch"},
+		{[]string{"-c", "fetch.writeFetchHead=false", "-c", "gc.auto=0", "fetch", "src"}, "fetch"},
+		{[]string{"--no-pager", "push", "target"}, "push"},
+		{nil, ""},
+	}
+	for _, test := range tests {
+		if got := gitSubcommand(test.args); got != test.want {
+			t.Errorf("gitSubcommand(%q) = %q, want %q", test.args, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/ternarybob/gitsync/internal/common"
+)
+
+// mirrorRefPrefix is the private namespace fetched refspecs are stored under,
+// keeping them clear of the working branches and remote-tracking refs
+const mirrorRefPrefix = "refs/gitsync/mirror/"
+
+// mirroredRef is a single source ref fetched through a configured refspec
+type mirroredRef struct {
+	local  string
+	dst    string
+	commit string
+	force  bool
+}
+
+// syncRefspecs fetches the configured refspecs from the source and pushes
+// every matched ref verbatim to each target
+func (s *Syncer) syncRefspecs(ctx context.Context, repoDir string, result *SyncResult) error {
+	var refs []mirroredRef
+
+	for _, spec := range s.jobConfig.Refspecs {
+		refspec, err := common.ParseRefspec(spec)
+		if err != nil {
+			return fmt.Errorf("invalid refspec %s: %w", spec, err)
+		}
+
+		fetched, err := s.fetchRefspec(ctx, repoDir, refspec)
+		if err != nil {
+			return err
+		}
+		refs = append(refs, fetched...)
+	}
+
+	if len(refs) == 0 {
+		s.logger.Warn().Str("job", s.jobName).Strs("refspecs", s.jobConfig.Refspecs).Msg("No refs matched the configured refspecs")
+		return nil
+	}
+
+	result.Matched = len(refs)
+	s.logger.Info().Str("job", s.jobName).Int("refs", len(refs)).Msg("Found refs to sync")
+
+	localRefs := make([]string, len(refs))
+	for i, ref := range refs {
+		localRefs[i] = ref.local
+	}
+	if err := s.runPreSyncHook(ctx, repoDir, nil, localRefs); err != nil {
+		return err
+	}
+
+	for _, target := range s.targets() {
+		if common.IsBundleURL(target.URL) {
+			s.logger.Warn().Str("job", s.jobName).Str("target", target.URL).Msg("Bundle targets are not supported with refspecs, skipping")
+			continue
+		}
+
+		s.pushRefsToTarget(ctx, repoDir, target, refs, result)
+	}
+
+	return nil
+}
+
+// fetchRefspec fetches one refspec into the mirror namespace and returns the refs it produced
+func (s *Syncer) fetchRefspec(ctx context.Context, repoDir string, refspec common.Refspec) ([]mirroredRef, error) {
+	localPattern := mirrorRefPrefix + strings.TrimPrefix(refspec.Src, "refs/")
+
+	phaseCtx, cancel := s.phaseContext(ctx, phaseFetch)
+	defer cancel()
+
+	cmd := s.git(phaseCtx, "fetch", "--prune", "origin", fmt.Sprintf("+%s:%s", refspec.Src, localPattern))
+	cmd.Dir = repoDir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("failed to fetch refspec %s: %w\n%s", refspec, s.phaseError(ctx, phaseCtx, phaseFetch, s.jobConfig.Source, err), output)
+	}
+
+	// for-each-ref matches by prefix, so strip the wildcard from the pattern
+	listPattern, _, _ := strings.Cut(localPattern, "*")
+	cmd = s.git(ctx, "for-each-ref", "--format=%(objectname) %(refname)", listPattern)
+	cmd.Dir = repoDir
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list refs for refspec %s: %w", refspec, err)
+	}
+
+	localSpec := common.Refspec{Src: localPattern, Dst: refspec.Dst}
+
+	var refs []mirroredRef
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		commit, local, found := strings.Cut(line, " ")
+		if !found {
+			continue
+		}
+		dst, ok := localSpec.MapRef(local)
+		if !ok {
+			continue
+		}
+		refs = append(refs, mirroredRef{
+			local:  local,
+			dst:    dst,
+			commit: commit,
+			force:  s.jobConfig.Override || refspec.Force,
+		})
+	}
+
+	return refs, nil
+}
+
+// pushRefsToTarget pushes every ref whose hash differs from the target's copy
+func (s *Syncer) pushRefsToTarget(ctx context.Context, repoDir string, target common.TargetConfig, refs []mirroredRef, result *SyncResult) {
+	targetName, err := s.ensureRemote(ctx, repoDir, target.URL)
+	if err != nil {
+		for _, ref := range refs {
+			s.record(result, SyncEntry{Ref: ref.dst, Target: target.URL, NewCommit: ref.commit, err: err})
+		}
+		return
+	}
+
+	remoteRefs, err := s.listRemoteRefs(ctx, repoDir, target, targetName)
+	if err != nil {
+		s.logger.Debug().Str("job", s.jobName).Str("target", target.URL).Err(err).Msg("Could not list target refs, pushing all refs")
+	}
+
+	for _, ref := range refs {
+		entry := SyncEntry{Ref: ref.dst, Target: target.URL, OldCommit: remoteRefs[ref.dst], NewCommit: ref.commit}
+		if remoteRefs[ref.dst] == ref.commit {
+			entry.Status = StatusSkipped
+			s.record(result, entry)
+			continue
+		}
+
+		startTime := time.Now()
+		spec := fmt.Sprintf("%s:%s", ref.local, ref.dst)
+		if ref.force {
+			spec = "+" + spec
+		}
+
+		pushCtx, span := tracer.Start(ctx, "git push", trace.WithAttributes(
+			attribute.String("gitsync.ref", ref.dst),
+			attribute.String("gitsync.target_host", common.RepositoryHost(target.URL)),
+		))
+		phaseCtx, cancel := s.phaseContext(pushCtx, phasePush)
+		cmd := s.gitTarget(phaseCtx, target, "push", targetName, spec)
+		cmd.Dir = repoDir
+		if output, err := cmd.CombinedOutput(); err != nil {
+			entry.err = fmt.Errorf("failed to push: %w\n%s", s.phaseError(pushCtx, phaseCtx, phasePush, target.URL, err), output)
+		}
+		cancel()
+
+		entry.Status = StatusPushed
+		entry.Duration = time.Since(startTime)
+		s.record(result, entry)
+
+		outcome := entry.Status
+		if entry.err != nil {
+			outcome = StatusFailed
+		}
+		span.SetAttributes(attribute.String("gitsync.outcome", outcome), attribute.String("gitsync.commit", ref.commit))
+		endSpan(span, entry.err)
+	}
+}
+
+// listRemoteRefs returns the refs advertised by a remote keyed by ref name
+func (s *Syncer) listRemoteRefs(ctx context.Context, repoDir string, target common.TargetConfig, remoteName string) (map[string]string, error) {
+	phaseCtx, cancel := s.phaseContext(ctx, phaseFetch)
+	defer cancel()
+
+	cmd := s.gitTarget(phaseCtx, target, "ls-remote", remoteName)
+	cmd.Dir = repoDir
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list remote refs: %w", s.phaseError(ctx, phaseCtx, phaseFetch, target.URL, err))
+	}
+
+	refs := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 {
+			refs[fields[1]] = fields[0]
+		}
+	}
+	return refs, nil
+}
@@ -0,0 +1,61 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// applyTagPatterns moves the origin ref of every branch with a
+// sync_to_tag_pattern to the newest matching tag that is an ancestor of the
+// branch, so the branch is pushed at its latest release instead of its tip.
+// Branches without such a tag are left out of the returned branches.
+func (s *Syncer) applyTagPatterns(ctx context.Context, repoDir string, branches []string) ([]string, error) {
+	var tagged []string
+	for _, branch := range branches {
+		tagPattern := s.jobConfig.TagPatternFor(branch)
+		if tagPattern == "" {
+			tagged = append(tagged, branch)
+			continue
+		}
+		ref := "refs/remotes/origin/" + branch
+
+		// Newest by tagger or committer date, the higher version on a tie
+		cmd := s.git(ctx, "for-each-ref", "--merged="+ref, "--sort=-v:refname", "--sort=-creatordate", "--count=1",
+			"--format=%(refname:strip=2)", "refs/tags/"+tagPattern)
+		cmd.Dir = repoDir
+		output, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("failed to find the tags of branch %s: %w", branch, err)
+		}
+		tag := strings.TrimSpace(string(output))
+		if tag == "" {
+			s.logger.Info().Str("job", s.jobName).Str("branch", branch).Str("tag_pattern", tagPattern).Msg("No tag matching sync_to_tag_pattern on branch yet, skipping branch")
+			continue
+		}
+
+		cmd = s.git(ctx, "rev-parse", ref, "refs/tags/"+tag+"^{commit}")
+		cmd.Dir = repoDir
+		output, err = cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve tag %s of branch %s: %w", tag, branch, err)
+		}
+		commits := strings.Fields(string(output))
+		if len(commits) != 2 {
+			return nil, fmt.Errorf("failed to resolve tag %s of branch %s", tag, branch)
+		}
+		tip, commit := commits[0], commits[1]
+
+		if commit != tip {
+			cmd = s.git(ctx, "update-ref", ref, commit)
+			cmd.Dir = repoDir
+			if output, err := cmd.CombinedOutput(); err != nil {
+				return nil, fmt.Errorf("failed to move branch %s to tag %s: %w\n%s", branch, tag, err, output)
+			}
+		}
+		s.logger.Info().Str("job", s.jobName).Str("branch", branch).Str("tag", tag).Str("commit", commit).
+			Int("held_back", s.countCommits(ctx, repoDir, commit+".."+tip)).Msg("Syncing branch at its latest matching tag")
+		tagged = append(tagged, branch)
+	}
+	return tagged, nil
+}
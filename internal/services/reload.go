@@ -0,0 +1,139 @@
+package services
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/ternarybob/gitsync/internal/common"
+)
+
+// ReloadResult lists the jobs a configuration reload touched
+type ReloadResult struct {
+	Added   []string
+	Removed []string
+	Changed []string
+	// Sections whose changes only take effect after a restart
+	RestartRequired []string
+}
+
+// Reload switches the scheduler to a new, already validated configuration.
+// Jobs that are new or enabled get scheduled, removed or disabled jobs are
+// unscheduled and changed jobs are rescheduled with fresh syncers. Runs in
+// progress finish with the configuration they started with. When a syncer
+// cannot be created nothing is changed and the error is returned.
+func (s *Scheduler) Reload(cfg *common.Config) (ReloadResult, error) {
+	old := s.Config()
+
+	var result ReloadResult
+	enabled := make(map[string]bool)
+	syncers := make(map[string]*Syncer)
+
+	for _, jobName := range cfg.GetEnabledJobs() {
+		enabled[jobName] = true
+		jobConfig, _ := cfg.GetJobConfig(jobName)
+
+		s.mu.RLock()
+		_, scheduled := s.jobs[jobName]
+		s.mu.RUnlock()
+
+		switch {
+		case !scheduled:
+			result.Added = append(result.Added, jobName)
+		case jobChanged(old, cfg, jobName):
+			result.Changed = append(result.Changed, jobName)
+		default:
+			continue
+		}
+
+		syncer, err := s.newSyncer(jobName, jobConfig)
+		if err != nil {
+			return ReloadResult{}, fmt.Errorf("job %s: failed to create syncer: %w", jobName, err)
+		}
+		syncers[jobName] = syncer
+	}
+
+	s.mu.Lock()
+	for jobName := range s.jobs {
+		if !enabled[jobName] {
+			result.Removed = append(result.Removed, jobName)
+		}
+	}
+	s.mu.Unlock()
+
+	result.RestartRequired = restartRequired(old, cfg)
+
+	s.config.Store(cfg)
+	s.cache.SetConfig(cfg)
+	if !reflect.DeepEqual(old.Notifications, cfg.Notifications) {
+		s.notify.Store(NewNotificationManager(cfg).adopt(s.notify.Load()))
+	}
+
+	logger := s.logger
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, jobName := range append(append([]string{}, result.Removed...), result.Changed...) {
+		s.cron.Remove(s.jobs[jobName])
+		delete(s.jobs, jobName)
+		delete(s.missed, jobName)
+		if outcome := s.ticks[jobName]; outcome != nil {
+			finishTickLocked(outcome, errNoTickRun)
+			delete(s.ticks, jobName)
+		}
+	}
+
+	for jobName, syncer := range syncers {
+		jobConfig, _ := cfg.GetJobConfig(jobName)
+		spec := cfg.JobCronSpec(jobName)
+		entryID, err := s.addCronJobLocked(cfg, jobName, s.createJobFunc(jobName, jobConfig, syncer))
+		if err != nil {
+			// Validate parsed the schedule already, this is not expected
+			logger.Error().Str("job", jobName).Err(err).Msg("Failed to schedule reloaded job")
+			continue
+		}
+		s.jobs[jobName] = entryID
+		logger.Info().Str("job", jobName).Str("schedule", spec).Msg("Job scheduled successfully")
+	}
+
+	return result, nil
+}
+
+// jobChanged reports whether a job needs rescheduling after a reload
+func jobChanged(old, cfg *common.Config, jobName string) bool {
+	oldJob, _ := old.GetJobConfig(jobName)
+	newJob, _ := cfg.GetJobConfig(jobName)
+	return !reflect.DeepEqual(oldJob, newJob) ||
+		old.JobCronSpec(jobName) != cfg.JobCronSpec(jobName) ||
+		old.JobScheduleJitter(jobName) != cfg.JobScheduleJitter(jobName)
+}
+
+// restartRequired lists the changed sections a reload does not apply
+func restartRequired(old, cfg *common.Config) []string {
+	var sections []string
+
+	// The summary path is looked up per run, the grace period at shutdown, the
+	// cache manager follows the reloaded max_cache_size
+	oldService, newService := old.Service, cfg.Service
+	oldService.SummaryPath, newService.SummaryPath = "", ""
+	oldService.MaxCacheSize, newService.MaxCacheSize = 0, 0
+	oldService.ShutdownGracePeriod, newService.ShutdownGracePeriod = 0, 0
+	if !reflect.DeepEqual(oldService, newService) {
+		sections = append(sections, "service")
+	}
+	if !reflect.DeepEqual(old.Logging, cfg.Logging) {
+		sections = append(sections, "logging")
+	}
+	if !reflect.DeepEqual(old.Store, cfg.Store) {
+		sections = append(sections, "store")
+	}
+	if !reflect.DeepEqual(old.Server, cfg.Server) {
+		sections = append(sections, "server")
+	}
+	if !reflect.DeepEqual(old.Tracing, cfg.Tracing) {
+		sections = append(sections, "tracing")
+	}
+	if old.Jobs.MaxConcurrentJobs != cfg.Jobs.MaxConcurrentJobs {
+		sections = append(sections, "jobs.max_concurrent_jobs")
+	}
+	return sections
+}
@@ -0,0 +1,61 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+
+	"github.com/ternarybob/gitsync/internal/common"
+)
+
+// RemoteCheck is the outcome of reaching one repository of a job
+type RemoteCheck struct {
+	URL string
+	Err error
+}
+
+// CheckRemotes lists the refs of the job's sources and of every target with
+// the credentials the job syncs with, without fetching or pushing anything.
+// Bundle files are not remotes and are left out. git never prompts for
+// credentials, a missing one fails the check instead.
+func (s *Syncer) CheckRemotes(ctx context.Context) ([]RemoteCheck, error) {
+	if err := s.setupGitAuth(ctx); err != nil {
+		return nil, err
+	}
+
+	var checks []RemoteCheck
+	if s.jobConfig.Aggregates() {
+		for _, source := range s.jobConfig.Sources {
+			if err := s.setupSourceAuth(source); err != nil {
+				return nil, err
+			}
+			cmd := s.gitSource(ctx, source, "ls-remote", "--heads", source.URL)
+			checks = append(checks, RemoteCheck{URL: source.URL, Err: lsRemote(cmd)})
+		}
+	} else if !common.IsBundleURL(s.jobConfig.Source) {
+		cmd := s.git(ctx, "ls-remote", "--heads", s.jobConfig.Source)
+		checks = append(checks, RemoteCheck{URL: s.jobConfig.Source, Err: lsRemote(cmd)})
+	}
+	for _, target := range s.jobConfig.Targets {
+		if common.IsBundleURL(target.URL) {
+			continue
+		}
+		cmd := s.gitTarget(ctx, target, "ls-remote", "--heads", target.URL)
+		checks = append(checks, RemoteCheck{URL: target.URL, Err: lsRemote(cmd)})
+	}
+	return checks, nil
+}
+
+func lsRemote(cmd *exec.Cmd) error {
+	cmd.Env = append(cmd.Env, "GIT_TERMINAL_PROMPT=0")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		// The first line tells why, git adds generic advice after it
+		line, _, _ := strings.Cut(strings.TrimSpace(string(output)), "\n")
+		if line == "" {
+			return err
+		}
+		return errors.New(common.RedactURLCredentials(line))
+	}
+	return nil
+}
@@ -0,0 +1,340 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ternarybob/gitsync/internal/common"
+	"github.com/ternarybob/gitsync/internal/store"
+)
+
+// Status of a single push recorded in a SyncResult
+const (
+	StatusPushed  = "pushed"
+	StatusSkipped = "skipped"
+	StatusFailed  = "failed"
+	// A branch of a bidirectional job both sides have commits on, synced neither way
+	StatusDiverged = "diverged"
+	// A branch pre_push_command or a PushValidator refused, pushed to no target
+	StatusBlocked = "blocked"
+)
+
+// ErrorCategoryCredentials is the error category of a run whose credentials
+// backend could not be reached, so monitoring can tell it from failed pushes
+const ErrorCategoryCredentials = "credentials_backend"
+
+// SyncResult describes the outcome of one run of a job
+type SyncResult struct {
+	Job       string        `json:"job"`
+	RunID     string        `json:"run_id"`
+	Source    string        `json:"source"`
+	Matched   int           `json:"matched"` // Branches, or refs of the refspecs, selected for the run
+	StartTime time.Time     `json:"start_time"`
+	Duration  time.Duration `json:"duration_ns"`
+	Entries   []SyncEntry   `json:"entries"`
+	Error     string        `json:"error,omitempty"`
+	// Set when the run failed for a reason other than syncing, such as
+	// ErrorCategoryCredentials
+	ErrorCategory string `json:"error_category,omitempty"`
+}
+
+// SyncEntry is the outcome of pushing one branch or ref to one target. Bundle
+// and tag pushes carry no branch; tag pushes use the ref "refs/tags/*". A
+// diverged entry of a bidirectional job carries the source tip as NewCommit
+// and the target tip as OldCommit.
+type SyncEntry struct {
+	Branch             string        `json:"branch,omitempty"`
+	Ref                string        `json:"ref,omitempty"`
+	Source             string        `json:"source,omitempty"` // The [[job.source]] of an aggregation job the branch came from
+	Target             string        `json:"target"`
+	Status             string        `json:"status"`
+	OldCommit          string        `json:"old_commit,omitempty"`
+	NewCommit          string        `json:"new_commit,omitempty"`
+	SourceCommit       string        `json:"source_commit,omitempty"` // The source commit NewCommit was rewritten from
+	CommitsPushed      int           `json:"commits_pushed,omitempty"`
+	CommitsOverwritten int           `json:"commits_overwritten,omitempty"`
+	Objects            int           `json:"objects,omitempty"`
+	Bytes              int64         `json:"bytes,omitempty"`
+	Duration           time.Duration `json:"duration_ns"`
+	Error              string        `json:"error,omitempty"`
+	Wiki               bool          `json:"wiki,omitempty"` // A push to the wiki repository of the target, with sync_wiki
+
+	err error
+	tx  *store.Transaction
+}
+
+// Succeeded returns the entries that were pushed or needed no push
+func (r *SyncResult) Succeeded() []SyncEntry {
+	var entries []SyncEntry
+	for _, entry := range r.Entries {
+		if !entry.failed() {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// Failed returns the entries whose push failed, or whose branch diverged or
+// was blocked
+func (r *SyncResult) Failed() []SyncEntry {
+	var entries []SyncEntry
+	for _, entry := range r.Entries {
+		if entry.failed() {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// Count returns the number of entries with the given status
+func (r *SyncResult) Count(status string) int {
+	count := 0
+	for _, entry := range r.Entries {
+		if entry.Status == status {
+			count++
+		}
+	}
+	return count
+}
+
+// Summary describes the run in one line, such as "12 branches matched, 9
+// pushed, 2 skipped (no change), 1 failed (gitlab.com: non-fast-forward),
+// total 1m24s". Wiki pushes are counted on their own, such as ", wiki 1
+// pushed, 1 skipped".
+func (r *SyncResult) Summary() string {
+	branches := &SyncResult{Matched: r.Matched, Error: r.Error}
+	wiki := &SyncResult{}
+	for _, entry := range r.Entries {
+		if entry.Wiki {
+			wiki.Entries = append(wiki.Entries, entry)
+		} else {
+			branches.Entries = append(branches.Entries, entry)
+		}
+	}
+
+	unit := "branches"
+	if len(branches.Entries) > 0 && branches.Entries[0].Branch == "" && branches.Entries[0].Ref != "" {
+		unit = "refs"
+	}
+	summary := fmt.Sprintf("%d %s matched, %s", r.Matched, unit, branches.counts())
+	if len(wiki.Entries) > 0 {
+		summary += ", wiki " + wiki.counts()
+	}
+	return summary + fmt.Sprintf(", total %s", r.Duration.Round(time.Second/10))
+}
+
+// counts describes the outcome of the entries for Summary, starting with the
+// number pushed
+func (r *SyncResult) counts() string {
+	summary := fmt.Sprintf("%d pushed", r.Count(StatusPushed))
+	if skipped := r.Count(StatusSkipped); skipped > 0 {
+		summary += fmt.Sprintf(", %d skipped (no change)", skipped)
+	}
+	var failed, diverged, blocked []string
+	for _, entry := range r.Failed() {
+		switch entry.Status {
+		case StatusDiverged:
+			diverged = append(diverged, entry.Branch)
+		case StatusBlocked:
+			// Blocked on every target, the branch is listed once
+			if !slices.Contains(blocked, entry.Branch) {
+				blocked = append(blocked, entry.Branch)
+			}
+		default:
+			failed = append(failed, entry.failureReason())
+		}
+	}
+	if len(failed) > 0 {
+		summary += fmt.Sprintf(", %d failed (%s)", len(failed), strings.Join(failed, "; "))
+	} else if r.Error != "" && len(diverged) == 0 && len(blocked) == 0 {
+		summary += fmt.Sprintf(", run failed (%s)", failureReason(r.Error))
+	}
+	if len(diverged) > 0 {
+		summary += fmt.Sprintf(", %d diverged (%s)", len(diverged), strings.Join(diverged, ", "))
+	}
+	if len(blocked) > 0 {
+		summary += fmt.Sprintf(", %d blocked (%s)", len(blocked), strings.Join(blocked, ", "))
+	}
+	if sources := r.sourceCounts(); sources != "" {
+		summary += ", by source: " + sources
+	}
+	return summary
+}
+
+// sourceCounts counts the entries of every aggregation source, such as
+// "alice 2 pushed 1 skipped, bob 1 failed", empty for other jobs
+func (r *SyncResult) sourceCounts() string {
+	var sources []string
+	counts := make(map[string]map[string]int)
+	for _, entry := range r.Entries {
+		if entry.Source == "" {
+			continue
+		}
+		if counts[entry.Source] == nil {
+			sources = append(sources, entry.Source)
+			counts[entry.Source] = make(map[string]int)
+		}
+		counts[entry.Source][entry.Status]++
+	}
+
+	parts := make([]string, 0, len(sources))
+	for _, source := range sources {
+		part := source
+		for _, status := range []string{StatusPushed, StatusSkipped, StatusFailed} {
+			if count := counts[source][status]; count > 0 {
+				part += fmt.Sprintf(" %d %s", count, status)
+			}
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// FailureReasons returns "target host: reason" for every failed entry
+func (r *SyncResult) FailureReasons() []string {
+	var reasons []string
+	for _, entry := range r.Failed() {
+		reasons = append(reasons, entry.failureReason())
+	}
+	return reasons
+}
+
+// failureReason returns "target host: reason" for a failed entry
+func (e *SyncEntry) failureReason() string {
+	return common.RepositoryHost(e.Target) + ": " + failureReason(e.Error)
+}
+
+// failed reports whether the entry counts as a failure of the run
+func (e *SyncEntry) failed() bool {
+	return e.Status == StatusFailed || e.Status == StatusDiverged || e.Status == StatusBlocked
+}
+
+// WriteTable writes one row per entry with its status, branch or ref, target
+// and the reason of a failure
+func (r *SyncResult) WriteTable(w io.Writer) {
+	for _, entry := range r.Entries {
+		name := entry.Branch
+		if name == "" {
+			name = entry.Ref
+		}
+		if name == "" && entry.Source != "" {
+			name = "source " + entry.Source
+		}
+		if name == "" {
+			name = "-"
+		}
+		line := fmt.Sprintf("  %-8s %-30s %s", entry.Status, name, entry.Target)
+		if entry.Error != "" {
+			line += "  " + failureReason(entry.Error)
+		}
+		fmt.Fprintln(w, line)
+	}
+}
+
+// failureReason picks the telling line of a git error: the reason git gave
+// for a rejected ref, else its fatal or error message, else the first line
+func failureReason(err string) string {
+	lines := strings.Split(strings.TrimSpace(err), "\n")
+	for _, line := range lines {
+		if strings.Contains(line, "[rejected]") || strings.Contains(line, "[remote rejected]") {
+			if open := strings.LastIndex(line, "("); open >= 0 && strings.HasSuffix(line, ")") {
+				return line[open+1 : len(line)-1]
+			}
+		}
+	}
+	for _, line := range lines {
+		if strings.HasPrefix(line, "fatal: ") || strings.HasPrefix(line, "error: ") {
+			return truncate(line, maxReason)
+		}
+	}
+	return truncate(lines[0], maxReason)
+}
+
+// maxReason bounds a failure reason in summaries
+const maxReason = 120
+
+// failures joins the errors of every failed entry, nil when nothing failed
+func (r *SyncResult) failures() error {
+	var errs []error
+	for _, entry := range r.Entries {
+		if entry.failed() {
+			errs = append(errs, fmt.Errorf("%s: %w", entry.name(), entry.err))
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d sync operations failed: %w", len(errs), errors.Join(errs...))
+}
+
+// name identifies the entry in error messages
+func (e *SyncEntry) name() string {
+	switch {
+	case e.Branch != "":
+		return fmt.Sprintf("branch %s to %s", e.Branch, e.Target)
+	case e.Ref != "":
+		return fmt.Sprintf("ref %s to %s", e.Ref, e.Target)
+	case e.Source != "":
+		return fmt.Sprintf("source %s to %s", e.Source, e.Target)
+	}
+	return e.Target
+}
+
+// record adds an entry to the result and logs its outcome
+func (s *Syncer) record(result *SyncResult, entry SyncEntry) {
+	if entry.err != nil {
+		if entry.Status != StatusDiverged && entry.Status != StatusBlocked {
+			entry.Status = StatusFailed
+		}
+		entry.Error = entry.err.Error()
+	}
+	result.Entries = append(result.Entries, entry)
+	s.finishTransaction(entry)
+	observeEntry(s.jobName, &entry)
+
+	event := s.logger.Info()
+	msg := "Successfully synced to target"
+	switch entry.Status {
+	case StatusSkipped:
+		msg = "Skipping push - no changes detected (hashes match)"
+		if entry.Ref != "" {
+			// Refspecs can match thousands of refs, keep unchanged ones out of the info log
+			event = s.logger.Debug()
+		}
+	case StatusFailed:
+		event = s.logger.Error()
+		msg = "Failed to sync to target"
+	case StatusDiverged:
+		event = s.logger.Error().Str("source_commit", entry.NewCommit).Str("target_commit", entry.OldCommit)
+		msg = "Branch diverged, not syncing either way"
+	case StatusBlocked:
+		event = s.logger.Error()
+		msg = "Branch blocked by pre-push validation, not pushed"
+	}
+
+	event = event.Str("job", s.jobName).Str("target", entry.Target)
+	if entry.Wiki {
+		event = event.Str("repository", "wiki")
+	}
+	if entry.Branch != "" {
+		event = event.Str("branch", entry.Branch)
+	}
+	if entry.Ref != "" {
+		event = event.Str("ref", entry.Ref)
+	}
+	if entry.NewCommit != "" && entry.Status != StatusDiverged {
+		event = event.Str("commit", entry.NewCommit)
+	}
+	if entry.Status == StatusPushed {
+		event = event.Int("commits_pushed", entry.CommitsPushed).Int("commits_overwritten", entry.CommitsOverwritten).
+			Int("objects", entry.Objects).Int64("bytes", entry.Bytes)
+	}
+	if entry.err != nil {
+		event = event.Err(entry.err)
+	}
+	event.Float64("duration", entry.Duration.Seconds()).Msg(msg)
+}
@@ -0,0 +1,241 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/ternarybob/gitsync/internal/common"
+)
+
+// originTrailer names the source commit a rewritten commit was made from
+const originTrailer = "Mirrored-From"
+
+// rewriteHistory rewrites the local branches with git filter-branch: the
+// author_replace rules replace authors, add_origin_trailer appends a
+// Mirrored-From trailer naming the source commit. Which commit each source
+// commit became is kept in the job cache, and a run whose branches were all
+// rewritten before with the same rules reuses those commits. A source commit
+// always becomes the same commit, so hashes and trailers are stable across runs.
+func (s *Syncer) rewriteHistory(ctx context.Context, repoDir string) (err error) {
+	envFilter := s.authorFilter()
+	if envFilter == "" && !s.jobConfig.AddOriginTrailer {
+		return nil // No replacements to make
+	}
+	commitFilter := rewriteCommitFilter(s.jobConfig.AddOriginTrailer)
+	origin := common.NormalizeRepositoryURL(s.jobConfig.Source)
+	rules := rewriteRules(envFilter, commitFilter, origin)
+
+	s.logger.Info().Str("job", s.jobName).Int("replacements", len(s.jobConfig.AuthorReplace)).Msg("Rewriting history")
+
+	ctx, span := tracer.Start(ctx, "rewrite history", trace.WithAttributes(
+		attribute.Int("gitsync.replacements", len(s.jobConfig.AuthorReplace)),
+		attribute.Bool("gitsync.origin_trailer", s.jobConfig.AddOriginTrailer),
+	))
+	defer func() { endSpan(span, err) }()
+
+	// Start from the freshly fetched source so the rewrite never builds on a previous run's output
+	if err := s.resetLocalBranches(ctx, repoDir); err != nil {
+		return err
+	}
+
+	rewritten := s.readRewriteMap(rules)
+	reused, err := s.reuseRewrite(ctx, repoDir, rewritten)
+	if err != nil {
+		return err
+	}
+	if reused {
+		s.sourceCommits = invertMap(rewritten)
+		span.SetAttributes(attribute.Bool("gitsync.rewrite_reused", true))
+		s.logger.Info().Str("job", s.jobName).Msg("Branches unchanged since they were rewritten, reusing the rewritten commits")
+		return nil
+	}
+
+	// The commit filter appends "<source> <rewritten>" for every commit
+	mapFile := s.rewriteMapFile() + ".tmp"
+	os.Remove(mapFile)
+	defer os.Remove(mapFile)
+
+	args := []string{"filter-branch", "-f"}
+	if envFilter != "" {
+		args = append(args, "--env-filter", envFilter)
+	}
+	args = append(args, "--commit-filter", commitFilter, "--", "--all")
+	cmd := s.git(ctx, args...)
+	cmd.Dir = repoDir
+	cmd.Env = append(cmd.Env, "GITSYNC_ORIGIN="+origin, "GITSYNC_REWRITE_MAP="+mapFile)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("git filter-branch failed: %w\nOutput: %s", err, string(output))
+	}
+
+	// filter-branch keeps the pre-rewrite refs as backups, they are never pushed
+	if err := s.removeOriginalRefs(ctx, repoDir); err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(mapFile)
+	if err != nil {
+		return fmt.Errorf("failed to read rewritten commits: %w", err)
+	}
+	rewritten = parseRewriteMap(string(data))
+	if err := s.writeRewriteMap(rules, rewritten); err != nil {
+		s.logger.Warn().Str("job", s.jobName).Err(err).Msg("Failed to keep rewritten commits, the next run rewrites again")
+	}
+	s.sourceCommits = invertMap(rewritten)
+
+	s.logger.Info().Str("job", s.jobName).Int("commits", len(rewritten)).Msg("Successfully rewrote history")
+	return nil
+}
+
+// authorFilter returns the filter-branch environment filter applying the
+// author_replace rules, empty when no rule matches anything
+func (s *Syncer) authorFilter() string {
+	var filterScript strings.Builder
+	for _, replacement := range s.jobConfig.AuthorReplace {
+		if replacement.FromEmail != "" {
+			filterScript.WriteString(fmt.Sprintf(`
+if [ "$GIT_AUTHOR_EMAIL" = "%s" ]; then
+    export GIT_AUTHOR_NAME="%s"
+    export GIT_AUTHOR_EMAIL="%s"
+    export GIT_COMMITTER_NAME="%s"
+    export GIT_COMMITTER_EMAIL="%s"
+fi`, replacement.FromEmail, replacement.ToName, replacement.ToEmail, replacement.ToName, replacement.ToEmail))
+		}
+		if replacement.FromName != "" && replacement.FromEmail == "" {
+			filterScript.WriteString(fmt.Sprintf(`
+if [ "$GIT_AUTHOR_NAME" = "%s" ]; then
+    export GIT_AUTHOR_NAME="%s"
+    export GIT_AUTHOR_EMAIL="%s"
+    export GIT_COMMITTER_NAME="%s"
+    export GIT_COMMITTER_EMAIL="%s"
+fi`, replacement.FromName, replacement.ToName, replacement.ToEmail, replacement.ToName, replacement.ToEmail))
+		}
+	}
+	return filterScript.String()
+}
+
+// rewriteCommitFilter returns the filter-branch commit filter, which records
+// the commit every source commit became and with trailer appends
+// "Mirrored-From: <source host>/<repo>@<source commit>" to the message. The
+// trailer options are given so trailer.* settings of the host cannot change
+// the result, and "---" lines in messages are not taken for a patch.
+func rewriteCommitFilter(trailer bool) string {
+	message := "cat"
+	if trailer {
+		message = `git interpret-trailers --no-divider --where end --if-exists add --if-missing add --trailer "` + originTrailer + `: $GITSYNC_ORIGIN@$GIT_COMMIT"`
+	}
+	return `new=$(` + message + ` | git commit-tree "$@") || exit 1
+echo "$GIT_COMMIT $new" >> "$GITSYNC_REWRITE_MAP"
+echo "$new"`
+}
+
+// rewriteRules identifies the filters and origin a rewrite ran with, kept
+// commits are only reused for the same rules
+func rewriteRules(envFilter, commitFilter, origin string) string {
+	sum := sha256.Sum256([]byte(envFilter + "\x00" + commitFilter + "\x00" + origin))
+	return hex.EncodeToString(sum[:])
+}
+
+// rewriteMapFile is where the rewritten commit of every source commit is
+// recorded, after a first line with the rules of the rewrite
+func (s *Syncer) rewriteMapFile() string {
+	return filepath.Join(s.tempDir, "rewrite-map")
+}
+
+// readRewriteMap returns the source to rewritten commits of the last rewrite,
+// empty when it ran with other rules
+func (s *Syncer) readRewriteMap(rules string) map[string]string {
+	data, err := os.ReadFile(s.rewriteMapFile())
+	if err != nil {
+		return map[string]string{}
+	}
+	header, rest, _ := strings.Cut(string(data), "\n")
+	if header != "rules "+rules {
+		return map[string]string{}
+	}
+	return parseRewriteMap(rest)
+}
+
+func (s *Syncer) writeRewriteMap(rules string, rewritten map[string]string) error {
+	var b strings.Builder
+	b.WriteString("rules " + rules + "\n")
+	for source, commit := range rewritten {
+		b.WriteString(source + " " + commit + "\n")
+	}
+	return os.WriteFile(s.rewriteMapFile(), []byte(b.String()), 0644)
+}
+
+func parseRewriteMap(data string) map[string]string {
+	rewritten := make(map[string]string)
+	for _, line := range strings.Split(data, "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 {
+			rewritten[fields[0]] = fields[1]
+		}
+	}
+	return rewritten
+}
+
+// reuseRewrite points the branches and origin refs filter-branch would
+// rewrite at the commits they were rewritten to before. It changes nothing
+// and returns false when any of them was not rewritten yet, or its rewritten
+// commit is gone from the repository.
+func (s *Syncer) reuseRewrite(ctx context.Context, repoDir string, rewritten map[string]string) (bool, error) {
+	if len(rewritten) == 0 {
+		return false, nil
+	}
+
+	cmd := s.git(ctx, "for-each-ref", "--format=%(refname) %(objectname) %(symref)", "refs/heads/", "refs/remotes/origin/")
+	cmd.Dir = repoDir
+	output, err := cmd.Output()
+	if err != nil {
+		return false, fmt.Errorf("failed to list branches: %w", err)
+	}
+
+	updates := make(map[string]string)
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		// origin/HEAD follows the branch it refers to
+		if len(fields) != 2 {
+			continue
+		}
+		commit, ok := rewritten[fields[1]]
+		if !ok {
+			return false, nil
+		}
+		updates[fields[0]] = commit
+	}
+
+	for _, commit := range updates {
+		cmd = s.git(ctx, "cat-file", "-e", commit+"^{commit}")
+		cmd.Dir = repoDir
+		if cmd.Run() != nil {
+			return false, nil
+		}
+	}
+	for ref, commit := range updates {
+		cmd = s.git(ctx, "update-ref", ref, commit)
+		cmd.Dir = repoDir
+		if output, err := cmd.CombinedOutput(); err != nil {
+			// Some refs may be rewritten already, rewriting them again would stack trailers
+			return false, fmt.Errorf("failed to point %s at its rewritten commit: %w\n%s", ref, err, output)
+		}
+	}
+	return true, nil
+}
+
+func invertMap(m map[string]string) map[string]string {
+	inverted := make(map[string]string, len(m))
+	for key, value := range m {
+		inverted[value] = key
+	}
+	return inverted
+}
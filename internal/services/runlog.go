@@ -0,0 +1,24 @@
+package services
+
+import (
+	"github.com/ternarybob/arbor"
+)
+
+// runLogger adds the run ID to every event of a logger, so the lines of runs
+// that interleave in the log can be told apart
+type runLogger struct {
+	arbor.ILogger
+	runID string
+}
+
+func newRunLogger(logger arbor.ILogger, runID string) arbor.ILogger {
+	return &runLogger{ILogger: logger, runID: runID}
+}
+
+func (l *runLogger) Trace() arbor.ILogEvent { return l.ILogger.Trace().Str("run_id", l.runID) }
+func (l *runLogger) Debug() arbor.ILogEvent { return l.ILogger.Debug().Str("run_id", l.runID) }
+func (l *runLogger) Info() arbor.ILogEvent  { return l.ILogger.Info().Str("run_id", l.runID) }
+func (l *runLogger) Warn() arbor.ILogEvent  { return l.ILogger.Warn().Str("run_id", l.runID) }
+func (l *runLogger) Error() arbor.ILogEvent { return l.ILogger.Error().Str("run_id", l.runID) }
+func (l *runLogger) Fatal() arbor.ILogEvent { return l.ILogger.Fatal().Str("run_id", l.runID) }
+func (l *runLogger) Panic() arbor.ILogEvent { return l.ILogger.Panic().Str("run_id", l.runID) }
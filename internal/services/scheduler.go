@@ -2,42 +2,151 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/robfig/cron/v3"
+	"github.com/ternarybob/arbor"
 
 	"github.com/ternarybob/gitsync/internal/common"
+	"github.com/ternarybob/gitsync/internal/store"
 )
 
+// ErrJobRunning is returned when a job is started while a run of it is in progress
+var ErrJobRunning = errors.New("job is already running")
+
+// ErrSchedulerStopping is returned for runs requested once Stop has begun
+var ErrSchedulerStopping = errors.New("scheduler is shutting down")
+
+// ErrJobDisabled is returned when a disabled job is started without force
+var ErrJobDisabled = errors.New("job is disabled")
+
+// maxTrackedRuns bounds the runs started with StartJob that are kept for lookup
+const maxTrackedRuns = 100
+
+// JobRun describes a run started with StartJob
+type JobRun struct {
+	ID        string      `json:"id"`
+	Job       string      `json:"job"`
+	StartTime time.Time   `json:"start_time"`
+	EndTime   time.Time   `json:"end_time,omitempty"`
+	Running   bool        `json:"running"`
+	Result    *SyncResult `json:"result,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
 type Scheduler struct {
-	cron   *cron.Cron
-	jobs   map[string]cron.EntryID
-	config *common.Config
-	mu     sync.RWMutex
-	ctx    context.Context
-	cancel context.CancelFunc
+	cron    *cron.Cron
+	jobs    map[string]cron.EntryID
+	config  atomic.Pointer[common.Config] // Swapped by Reload
+	cache   *CacheManager
+	store   *store.Store
+	notify  atomic.Pointer[NotificationManager] // Swapped by Reload, nil without notifiers
+	mu      sync.RWMutex
+	started bool
+	initial bool // The startup sync is running
+	running map[string]bool
+	skipped map[string]int  // Scheduled runs skipped because the job was still running
+	missed  map[string]bool // Jobs with a skipped run to start once the current one finishes
+	runs    map[string]*JobRun
+	runIDs  []string
+	queued  map[string]*time.Timer
+	slots   chan struct{} // Bounds concurrent runs to max_concurrent_jobs, nil when unlimited
+	breaker map[string]*breakerState
+	ticks   map[string]*tickOutcome // Latest scheduled run of each job, for depends_on
+	syncers map[string]*Syncer      // Syncers of the running jobs, for their progress
+	epoch   time.Time               // Start of the scheduler, interval schedules count from it
+	runWG   sync.WaitGroup
+	logger  arbor.ILogger   // The global logger unless SetLogger changed it
+	valid   PushValidator   // Handed to every syncer, nil for none
+	ctx     context.Context // Cancels running syncs
+	cancel  context.CancelFunc
+	drain   context.Context // Done once Stop begins, no new runs start
+	stopNew context.CancelFunc
 }
 
-func NewScheduler(cfg *common.Config) *Scheduler {
+// NewScheduler creates a scheduler sharing st between all jobs, st may be nil
+func NewScheduler(cfg *common.Config, st *store.Store) *Scheduler {
 	ctx, cancel := context.WithCancel(context.Background())
+	drain, stopNew := context.WithCancel(ctx)
+
+	var slots chan struct{}
+	if cfg.Jobs.MaxConcurrentJobs > 0 {
+		slots = make(chan struct{}, cfg.Jobs.MaxConcurrentJobs)
+	}
 
-	return &Scheduler{
-		cron:   cron.New(cron.WithSeconds()),
-		jobs:   make(map[string]cron.EntryID),
-		config: cfg,
-		ctx:    ctx,
-		cancel: cancel,
+	s := &Scheduler{
+		cron:    cron.New(cron.WithSeconds()),
+		jobs:    make(map[string]cron.EntryID),
+		cache:   NewCacheManager(cfg),
+		store:   st,
+		running: make(map[string]bool),
+		skipped: make(map[string]int),
+		missed:  make(map[string]bool),
+		runs:    make(map[string]*JobRun),
+		queued:  make(map[string]*time.Timer),
+		slots:   slots,
+		breaker: make(map[string]*breakerState),
+		ticks:   make(map[string]*tickOutcome),
+		syncers: make(map[string]*Syncer),
+		logger:  common.GetLogger(),
+		ctx:     ctx,
+		cancel:  cancel,
+		drain:   drain,
+		stopNew: stopNew,
+	}
+	s.config.Store(cfg)
+	s.notify.Store(NewNotificationManager(cfg))
+	return s
+}
+
+// SetLogger makes the scheduler and the jobs it runs log to logger instead of
+// the global logger. It has to be called before Start.
+func (s *Scheduler) SetLogger(logger arbor.ILogger) {
+	s.logger = logger
+}
+
+// SetValidator makes the jobs the scheduler runs ask v before pushing a
+// branch. It has to be called before Start.
+func (s *Scheduler) SetValidator(v PushValidator) {
+	s.valid = v
+}
+
+// newSyncer creates the syncer of a job, logging to the scheduler's logger
+func (s *Scheduler) newSyncer(jobName string, jobConfig *common.JobConfig) (*Syncer, error) {
+	syncer, err := NewSyncer(jobName, jobConfig, s.store)
+	if err != nil {
+		return nil, err
 	}
+	syncer.SetLogger(s.logger)
+	syncer.SetValidator(s.valid)
+	return syncer, nil
+}
+
+// Config returns the configuration currently in effect, which changes when
+// the scheduler is reloaded
+func (s *Scheduler) Config() *common.Config {
+	return s.config.Load()
 }
 
 func (s *Scheduler) Start() error {
-	logger := common.GetLogger()
+	logger := s.logger
 	logger.Info().Msg("Starting scheduler")
 
-	for _, jobName := range s.config.Jobs.Names {
-		jobConfig, exists := s.config.GetJobConfig(jobName)
+	s.cache.RemoveOrphans()
+
+	s.mu.Lock()
+	s.epoch = time.Now().Truncate(time.Second)
+	s.mu.Unlock()
+
+	cfg := s.Config()
+	for _, jobName := range cfg.Jobs.Names {
+		jobConfig, exists := cfg.GetJobConfig(jobName)
 		if !exists {
 			logger.Error().Str("job", jobName).Msg("Job definition not found")
 			continue
@@ -48,32 +157,183 @@ func (s *Scheduler) Start() error {
 			continue
 		}
 
+		// Discovery jobs only create jobs, they have nothing to run
+		if jobConfig.Discovers() {
+			continue
+		}
+
 		if err := s.scheduleJob(jobName, jobConfig); err != nil {
 			logger.Error().Str("job", jobName).Err(err).Msg("Failed to schedule job")
 			continue
 		}
 	}
 
+	if s.store != nil {
+		s.cleanupStore()
+		if _, err := s.cron.AddFunc("@daily", s.cleanupStore); err != nil {
+			logger.Error().Err(err).Msg("Failed to schedule transaction store cleanup")
+		}
+	}
+
+	s.cleanupLogs()
+	if _, err := s.cron.AddFunc("@daily", s.cleanupLogs); err != nil {
+		logger.Error().Err(err).Msg("Failed to schedule log file cleanup")
+	}
+
 	s.cron.Start()
 
+	s.mu.Lock()
+	s.started = true
+	s.mu.Unlock()
+
 	logger.Info().Int("active_jobs", len(s.jobs)).Msg("Scheduler started")
 	return nil
 }
 
-func (s *Scheduler) Stop() {
-	logger := common.GetLogger()
+// Stop stops starting new runs and waits up to grace for the running ones to
+// finish before cancelling them. It returns false when runs had to be
+// cancelled.
+func (s *Scheduler) Stop(grace time.Duration) bool {
+	logger := s.logger
 	logger.Info().Msg("Stopping scheduler")
 
-	s.cancel()
+	// Drain under the lock, so a finishing run cannot start a missed run
+	// once runWG is being waited for
+	s.mu.Lock()
+	s.started = false
+	for jobName, timer := range s.queued {
+		timer.Stop()
+		delete(s.queued, jobName)
+	}
+	s.stopNew()
+	s.mu.Unlock()
+
+	cronCtx := s.cron.Stop()
+	done := make(chan struct{})
+	go func() {
+		<-cronCtx.Done()
+		s.runWG.Wait()
+		close(done)
+	}()
+
+	clean := true
+	select {
+	case <-done:
+	default:
+		logger.Info().Strs("jobs", s.runningJobs()).Dur("grace_period", grace).Msg("Waiting for running jobs to finish")
+		select {
+		case <-done:
+		case <-time.After(grace):
+			clean = false
+			logger.Warn().Strs("jobs", s.runningJobs()).Msg("Shutdown grace period expired, cancelling running jobs")
+		}
+	}
 
-	ctx := s.cron.Stop()
-	<-ctx.Done()
+	s.cancel()
+	<-done
 
 	logger.Info().Msg("Scheduler stopped")
+	return clean
+}
+
+// runningJobs lists the jobs with a run in progress, sorted by name
+func (s *Scheduler) runningJobs() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	jobs := make([]string, 0, len(s.running))
+	for jobName := range s.running {
+		jobs = append(jobs, jobName)
+	}
+	sort.Strings(jobs)
+	return jobs
+}
+
+// addRun counts a run Stop waits for, returning false once Stop has begun
+func (s *Scheduler) addRun() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.drain.Err() != nil {
+		return false
+	}
+	s.runWG.Add(1)
+	return true
+}
+
+// Started reports whether the scheduler is running its cron jobs
+func (s *Scheduler) Started() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.started
+}
+
+// SetInitialSync marks whether the startup sync of the enabled jobs is running
+func (s *Scheduler) SetInitialSync(running bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.initial = running
+}
+
+// InitialSyncRunning reports whether the startup sync is running
+func (s *Scheduler) InitialSyncRunning() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.initial
+}
+
+// cleanupStore drops transactions older than the retention window and then the
+// oldest ones beyond max_transactions
+func (s *Scheduler) cleanupStore() {
+	logger := s.logger
+	sizeBefore := s.store.Size()
+
+	var purged int
+	if days := s.Config().Store.RetentionDays; days > 0 {
+		removed, err := s.store.CleanupOldTransactions(s.ctx, time.Now().AddDate(0, 0, -days))
+		purged += removed
+		if err != nil {
+			logger.Error().Err(err).Int("purged", purged).Msg("Transaction retention cleanup failed")
+			return
+		}
+	}
+
+	if max := s.Config().Store.MaxTransactions; max > 0 {
+		removed, err := s.store.TrimTransactions(s.ctx, max)
+		purged += removed
+		if err != nil {
+			logger.Error().Err(err).Int("purged", purged).Msg("Transaction retention cleanup failed")
+			return
+		}
+	}
+
+	// bbolt reuses freed pages rather than shrinking the file
+	logger.Info().Int("purged", purged).Int64("size_before", sizeBefore).Int64("size_after", s.store.Size()).Msg("Transaction retention cleanup completed")
+}
+
+// cleanupLogs removes rotated log files older than logging max_age
+func (s *Scheduler) cleanupLogs() {
+	logging := s.Config().Logging
+	if logging.MaxAge <= 0 || !logging.WritesFile() {
+		return
+	}
+
+	logger := s.logger
+	logsDir, err := logging.LogDirectory()
+	if err != nil {
+		logger.Error().Err(err).Msg("Log file cleanup failed")
+		return
+	}
+	removed, err := common.CleanupLogs(logsDir, logging.MaxAge)
+	if err != nil {
+		logger.Error().Err(err).Int("removed", removed).Msg("Log file cleanup failed")
+		return
+	}
+	logger.Info().Int("removed", removed).Int("max_age_days", logging.MaxAge).Msg("Log file cleanup completed")
 }
 
 func (s *Scheduler) scheduleJob(jobName string, jobConfig *common.JobConfig) error {
-	logger := common.GetLogger()
+	logger := s.logger
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -82,85 +342,534 @@ func (s *Scheduler) scheduleJob(jobName string, jobConfig *common.JobConfig) err
 		return nil
 	}
 
-	syncer, err := NewSyncer(jobName, jobConfig)
+	syncer, err := s.newSyncer(jobName, jobConfig)
 	if err != nil {
 		return fmt.Errorf("failed to create syncer: %w", err)
 	}
 
 	jobFunc := s.createJobFunc(jobName, jobConfig, syncer)
 
-	entryID, err := s.cron.AddFunc(s.config.Jobs.Schedule, jobFunc)
+	spec := s.Config().JobCronSpec(jobName)
+	entryID, err := s.addCronJobLocked(s.Config(), jobName, jobFunc)
 	if err != nil {
 		return fmt.Errorf("failed to add cron job: %w", err)
 	}
 
 	s.jobs[jobName] = entryID
 
-	logger.Info().Str("job", jobName).Str("schedule", s.config.Jobs.Schedule).Msg("Job scheduled successfully")
+	logger.Info().Str("job", jobName).Str("schedule", spec).Msg("Job scheduled successfully")
 
 	return nil
 }
 
+// addCronJobLocked registers the scheduled runs of a job. Intervals count
+// from the start of the scheduler instead of from when the job was added, so
+// jobs on the same every keep sharing their ticks, which depends_on waits on,
+// also when a reload adds or reschedules one of them. s.mu must be held.
+func (s *Scheduler) addCronJobLocked(cfg *common.Config, jobName string, jobFunc func()) (cron.EntryID, error) {
+	schedule, err := cfg.JobCronSchedule(jobName)
+	if err != nil {
+		return 0, err
+	}
+	if interval, ok := schedule.(cron.ConstantDelaySchedule); ok {
+		epoch := s.epoch
+		if epoch.IsZero() {
+			epoch = time.Now().Truncate(time.Second)
+		}
+		schedule = intervalSchedule{epoch: epoch, every: interval.Delay}
+	}
+	return s.cron.Schedule(schedule, cron.FuncJob(jobFunc)), nil
+}
+
+// intervalSchedule fires every interval counted from epoch
+type intervalSchedule struct {
+	epoch time.Time
+	every time.Duration
+}
+
+// Next returns the first tick after t
+func (i intervalSchedule) Next(t time.Time) time.Time {
+	if t.Before(i.epoch) {
+		return i.epoch.Add(i.every)
+	}
+	return i.epoch.Add((t.Sub(i.epoch)/i.every + 1) * i.every)
+}
+
 func (s *Scheduler) createJobFunc(jobName string, jobConfig *common.JobConfig, syncer *Syncer) func() {
 	return func() {
-		logger := common.GetLogger()
-		logger.Info().Str("job", jobName).Msg("Executing scheduled job")
+		if !s.addRun() {
+			return
+		}
+		defer s.runWG.Done()
+
+		// Jobs depending on this one learn how its run on this tick ended
+		tick := s.scheduledTick(jobName)
+		err := s.runScheduled(jobName, jobConfig, syncer, tick)
+		s.finishTick(jobName, tick, err)
+	}
+}
 
-		ctx := s.ctx
-		if s.config.Jobs.Timeout > 0 {
-			var cancel context.CancelFunc
-			ctx, cancel = context.WithTimeout(s.ctx, s.config.Jobs.Timeout)
-			defer cancel()
+// runScheduled runs a job for a cron tick, returning why it did not sync
+// successfully
+func (s *Scheduler) runScheduled(jobName string, jobConfig *common.JobConfig, syncer *Syncer, tick time.Time) error {
+	// The tick gets its run ID up front, so skipped and delayed ticks can be
+	// told apart in the log as well
+	runID := newRunID()
+	logger := newRunLogger(s.logger, runID)
+
+	if s.breakerOpen(jobName) {
+		return errors.New("circuit breaker open")
+	}
+
+	if !s.inWindow(jobName, jobConfig) {
+		logger.Debug().Str("job", jobName).Str("window", jobConfig.Window.String()).Msg("Outside sync window, skipping scheduled run")
+		return errors.New("outside sync window")
+	}
+
+	if err := s.awaitDependencies(jobConfig, tick); err != nil {
+		if !errors.Is(err, ErrSchedulerStopping) {
+			logger.Warn().Str("job", jobName).Err(err).Msg("Dependency did not run successfully, skipping scheduled run")
+		}
+		return err
+	}
+
+	if delay := s.jitterDelay(jobName); delay > 0 {
+		logger.Info().Str("job", jobName).Dur("jitter", delay).Msg("Delaying scheduled run by jitter")
+		select {
+		case <-time.After(delay):
+		case <-s.drain.Done():
+			return ErrSchedulerStopping
 		}
+	}
+
+	if !s.lockJob(jobName) {
+		s.skipRun(logger, jobName, jobConfig)
+		return fmt.Errorf("%w: %s", ErrJobRunning, jobName)
+	}
+	defer s.unlockJob(jobName)
+
+	if !s.acquireSlot(jobName) {
+		return ErrSchedulerStopping
+	}
+	defer s.releaseSlot()
+
+	logger.Info().Str("job", jobName).Msg("Executing scheduled job")
+
+	ctx := withRunID(s.ctx, runID)
+	if timeout := s.Config().Jobs.Timeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	s.mu.Lock()
+	s.syncers[jobName] = syncer
+	s.mu.Unlock()
+
+	startTime := time.Now()
+
+	s.cache.Acquire(jobName)
+	result, err := syncer.SyncAll(ctx)
+	s.cache.Release(jobName)
+
+	s.mu.Lock()
+	delete(s.syncers, jobName)
+	s.mu.Unlock()
+	observeJob(jobName, time.Since(startTime), err)
+	s.finishRun(jobName, jobConfig, result, err)
+
+	if err != nil {
+		logger.Error().Str("job", jobName).Err(err).Float64("duration", time.Since(startTime).Seconds()).
+			Int("pushed", result.Count(StatusPushed)).Int("skipped", result.Count(StatusSkipped)).Int("failed", result.Count(StatusFailed)).
+			Msg("Job execution failed")
+	} else {
+		logger.Info().Str("job", jobName).Float64("duration", time.Since(startTime).Seconds()).
+			Int("pushed", result.Count(StatusPushed)).Int("skipped", result.Count(StatusSkipped)).
+			Msg("Job execution completed")
+	}
+
+	s.cache.Maintain(s.ctx, jobName)
+	return err
+}
+
+// inWindow reports whether a scheduled run may start now, evaluated in the
+// job's timezone. Runs already in progress are not affected by the window.
+func (s *Scheduler) inWindow(jobName string, jobConfig *common.JobConfig) bool {
+	if jobConfig.Window == nil {
+		return true
+	}
+
+	now := time.Now()
+	if tz := s.Config().JobTimezone(jobName); tz != "" {
+		if loc, err := time.LoadLocation(tz); err == nil {
+			now = now.In(loc)
+		}
+	}
+	return jobConfig.Window.Contains(now)
+}
 
-		startTime := time.Now()
+// jitterDelay returns how long to delay the current scheduled run of a job, at
+// most its schedule_jitter. The delay is stable for a job and tick and always
+// ends before the next tick.
+func (s *Scheduler) jitterDelay(jobName string) time.Duration {
+	jitter := s.Config().JobScheduleJitter(jobName)
+	if jitter <= 0 {
+		return 0
+	}
+
+	s.mu.RLock()
+	entryID, exists := s.jobs[jobName]
+	s.mu.RUnlock()
+	if !exists {
+		return 0
+	}
+
+	// Prev is the tick that started this run
+	entry := s.cron.Entry(entryID)
+	window := jitter
+	if interval := entry.Next.Sub(entry.Prev); interval < window {
+		window = interval
+	}
+	if window <= 0 {
+		return 0
+	}
+
+	hash := fnv.New64a()
+	fmt.Fprintf(hash, "%s@%d", jobName, entry.Prev.UnixNano())
+	return time.Duration(hash.Sum64() % uint64(window))
+}
+
+// lockJob marks a job as running, returning false when a run is already in progress
+func (s *Scheduler) lockJob(jobName string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.running[jobName] {
+		return false
+	}
+	s.running[jobName] = true
+	return true
+}
+
+// unlockJob ends a run. When a scheduled run was missed meanwhile and
+// queue_missed_run is set, the job stays locked and the missed run starts.
+func (s *Scheduler) unlockJob(jobName string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	missed := s.missed[jobName]
+	delete(s.missed, jobName)
+
+	jobConfig, exists := s.Config().GetJobConfig(jobName)
+	if !missed || !exists || s.drain.Err() != nil {
+		delete(s.running, jobName)
+		return
+	}
+
+	run := &JobRun{ID: newRunID(), Job: jobName, StartTime: time.Now(), Running: true}
+	s.trackRunLocked(run)
+	s.logger.Info().Str("job", jobName).Str("run_id", run.ID).Msg("Starting missed scheduled run")
+	s.startRun(jobName, jobConfig, run)
+}
+
+// skipRun counts a scheduled run that found the previous run still active,
+// remembering it when the job queues missed runs
+func (s *Scheduler) skipRun(logger arbor.ILogger, jobName string, jobConfig *common.JobConfig) {
+	s.mu.Lock()
+	s.skipped[jobName]++
+	if jobConfig.QueueMissedRun {
+		s.missed[jobName] = true
+	}
+	s.mu.Unlock()
+
+	skippedRuns.WithLabelValues(jobName).Inc()
+
+	if jobConfig.QueueMissedRun {
+		logger.Warn().Str("job", jobName).Msg("Previous run still active, queueing scheduled run until it finishes")
+		return
+	}
+	logger.Warn().Str("job", jobName).Msg("Previous run still active, skipping scheduled run")
+}
+
+// IsRunning reports whether a run of the job is in progress
+func (s *Scheduler) IsRunning(jobName string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.running[jobName]
+}
+
+// RunJobNow runs a job immediately and returns its result, which is nil only
+// when the job could not be started. Disabled jobs only run with force.
+func (s *Scheduler) RunJobNow(jobName string, force bool) (*SyncResult, error) {
+	return s.RunJobFiltered(jobName, force, RunFilter{})
+}
+
+// RunJobFiltered is RunJobNow limited to the branch and target of filter
+func (s *Scheduler) RunJobFiltered(jobName string, force bool, filter RunFilter) (*SyncResult, error) {
+	return s.RunJobContext(context.Background(), jobName, force, filter)
+}
+
+// RunJobContext is RunJobFiltered, cancelled when ctx is done as well as when
+// the scheduler stops
+func (s *Scheduler) RunJobContext(ctx context.Context, jobName string, force bool, filter RunFilter) (*SyncResult, error) {
+	jobConfig, err := s.startableJob(jobName, force)
+	if err != nil {
+		return nil, err
+	}
+	if err := filter.check(jobName, jobConfig); err != nil {
+		return nil, err
+	}
+
+	if !s.addRun() {
+		return nil, ErrSchedulerStopping
+	}
+	defer s.runWG.Done()
+
+	if !s.lockJob(jobName) {
+		return nil, fmt.Errorf("%w: %s", ErrJobRunning, jobName)
+	}
+	defer s.unlockJob(jobName)
+
+	runCtx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+	stop := context.AfterFunc(ctx, cancel)
+	defer stop()
+	return s.runJob(runCtx, jobName, jobConfig, newRunID(), filter)
+}
+
+// startableJob returns the configuration of a job that may be started by hand
+func (s *Scheduler) startableJob(jobName string, force bool) (*common.JobConfig, error) {
+	jobConfig, exists := s.Config().GetJobConfig(jobName)
+	if !exists {
+		return nil, fmt.Errorf("job not found: %s", jobName)
+	}
+	if jobConfig.Discovers() {
+		return nil, fmt.Errorf("job %s discovers repositories and is not run itself, run one of the jobs named %s-<repository>", jobName, jobName)
+	}
+	if !jobConfig.Enabled && !force {
+		return nil, fmt.Errorf("%w: %s, force the run to start it anyway", ErrJobDisabled, jobName)
+	}
+	return jobConfig, nil
+}
+
+// StartJob runs a job in the background and returns the run as started, which
+// can be looked up with GetRun while and after it runs. Disabled jobs only run
+// with force.
+func (s *Scheduler) StartJob(jobName string, force bool) (JobRun, error) {
+	jobConfig, err := s.startableJob(jobName, force)
+	if err != nil {
+		return JobRun{}, err
+	}
+
+	if !s.lockJob(jobName) {
+		return JobRun{}, fmt.Errorf("%w: %s", ErrJobRunning, jobName)
+	}
+
+	run := &JobRun{ID: newRunID(), Job: jobName, StartTime: time.Now(), Running: true}
+	started := *run
 
-		if err := syncer.SyncAll(ctx); err != nil {
-			logger.Error().Str("job", jobName).Err(err).Float64("duration", time.Since(startTime).Seconds()).Msg("Job execution failed")
+	s.mu.Lock()
+	if s.drain.Err() != nil {
+		delete(s.running, jobName)
+		s.mu.Unlock()
+		return JobRun{}, ErrSchedulerStopping
+	}
+	s.trackRunLocked(run)
+	s.startRun(jobName, jobConfig, run)
+	s.mu.Unlock()
+
+	return started, nil
+}
+
+// startRun runs a locked job in the background, recording the outcome in run.
+// s.mu must be held.
+func (s *Scheduler) startRun(jobName string, jobConfig *common.JobConfig, run *JobRun) {
+	s.runWG.Add(1)
+	go func() {
+		defer s.runWG.Done()
+		defer s.unlockJob(jobName)
+
+		logger := s.logger
+		logger.Info().Str("job", jobName).Str("run_id", run.ID).Msg("Executing triggered job")
+
+		result, err := s.runJob(s.ctx, jobName, jobConfig, run.ID, RunFilter{})
+
+		s.mu.Lock()
+		run.Running = false
+		run.EndTime = time.Now()
+		run.Result = result
+		if err != nil {
+			run.Error = err.Error()
+		}
+		s.mu.Unlock()
+
+		if err != nil {
+			logger.Error().Str("job", jobName).Str("run_id", run.ID).Err(err).Msg("Triggered job failed")
 		} else {
-			logger.Info().Str("job", jobName).Float64("duration", time.Since(startTime).Seconds()).Msg("Job execution completed")
+			logger.Info().Str("job", jobName).Str("run_id", run.ID).Msg("Triggered job completed")
+		}
+	}()
+}
+
+// EnqueueJob starts a job once the webhook debounce has passed, so a burst of
+// pushes causes a single run. It returns false when a run is already queued.
+// A job still running when the debounce passes is queued again.
+func (s *Scheduler) EnqueueJob(jobName string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, queued := s.queued[jobName]; queued || s.drain.Err() != nil {
+		return false
+	}
+
+	s.queued[jobName] = time.AfterFunc(s.Config().Server.WebhookDebounce, func() {
+		s.mu.Lock()
+		delete(s.queued, jobName)
+		s.mu.Unlock()
+
+		if s.drain.Err() != nil {
+			return
 		}
+
+		logger := s.logger
+		run, err := s.StartJob(jobName, false)
+		switch {
+		case errors.Is(err, ErrJobRunning):
+			logger.Debug().Str("job", jobName).Msg("Job still running, queueing triggered run again")
+			s.EnqueueJob(jobName)
+		case errors.Is(err, ErrJobDisabled):
+			logger.Info().Str("job", jobName).Msg("Job was disabled, dropping queued run")
+		case errors.Is(err, ErrSchedulerStopping):
+			logger.Debug().Str("job", jobName).Msg("Scheduler stopping, dropping queued run")
+		case err != nil:
+			logger.Error().Str("job", jobName).Err(err).Msg("Failed to start queued job")
+		default:
+			logger.Debug().Str("job", jobName).Str("run_id", run.ID).Msg("Started queued job")
+		}
+	})
+	return true
+}
+
+// trackRunLocked remembers a run, forgetting the oldest beyond maxTrackedRuns.
+// s.mu must be held.
+func (s *Scheduler) trackRunLocked(run *JobRun) {
+	s.runs[run.ID] = run
+	s.runIDs = append(s.runIDs, run.ID)
+	if len(s.runIDs) > maxTrackedRuns {
+		delete(s.runs, s.runIDs[0])
+		s.runIDs = s.runIDs[1:]
 	}
 }
 
-func (s *Scheduler) RunJobNow(jobName string) error {
-	jobConfig, exists := s.config.GetJobConfig(jobName)
+// GetRun returns a copy of a run started with StartJob
+func (s *Scheduler) GetRun(id string) (JobRun, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	run, exists := s.runs[id]
 	if !exists {
-		return fmt.Errorf("job not found: %s", jobName)
+		return JobRun{}, false
+	}
+	return *run, true
+}
+
+// runJob syncs a job once under the given run ID, cancelled with ctx. The
+// caller holds the job lock.
+func (s *Scheduler) runJob(ctx context.Context, jobName string, jobConfig *common.JobConfig, runID string, filter RunFilter) (*SyncResult, error) {
+	if !s.acquireSlot(jobName) {
+		return nil, fmt.Errorf("scheduler stopped before job %s could start", jobName)
 	}
+	defer s.releaseSlot()
 
-	syncer, err := NewSyncer(jobName, jobConfig)
+	syncer, err := s.newSyncer(jobName, jobConfig)
 	if err != nil {
-		return fmt.Errorf("failed to create syncer: %w", err)
+		err = fmt.Errorf("failed to create syncer: %w", err)
+		s.finishRun(jobName, jobConfig, &SyncResult{Job: jobName, RunID: runID, Source: jobConfig.SourceLabel(), StartTime: time.Now(), Error: err.Error()}, err)
+		return nil, err
 	}
 
-	ctx := context.Background()
-	if s.config.Jobs.Timeout > 0 {
+	// Stop cancels runs started outside the cron schedule too
+	ctx = withRunFilter(withRunID(ctx, runID), filter)
+	if timeout := s.Config().Jobs.Timeout; timeout > 0 {
 		var cancel context.CancelFunc
-		ctx, cancel = context.WithTimeout(ctx, s.config.Jobs.Timeout)
+		ctx, cancel = context.WithTimeout(ctx, timeout)
 		defer cancel()
 	}
 
-	return syncer.SyncAll(ctx)
+	s.mu.Lock()
+	s.syncers[jobName] = syncer
+	s.mu.Unlock()
+
+	startTime := time.Now()
+	s.cache.Acquire(jobName)
+	result, err := syncer.SyncAll(ctx)
+	s.cache.Release(jobName)
+
+	s.mu.Lock()
+	delete(s.syncers, jobName)
+	s.mu.Unlock()
+	observeJob(jobName, time.Since(startTime), err)
+	s.finishRun(jobName, jobConfig, result, err)
+
+	s.cache.Maintain(s.ctx, jobName)
+
+	return result, err
+}
+
+// acquireSlot waits until fewer than max_concurrent_jobs runs are executing.
+// It returns false when the scheduler stops while waiting. Job timeouts start
+// once the slot is acquired.
+func (s *Scheduler) acquireSlot(jobName string) bool {
+	if s.slots == nil {
+		return true
+	}
+
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	s.logger.Info().Str("job", jobName).Int("max_concurrent_jobs", cap(s.slots)).Msg("Concurrency limit reached, waiting for a running job to finish")
+
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	case <-s.drain.Done():
+		return false
+	}
+}
+
+func (s *Scheduler) releaseSlot() {
+	if s.slots != nil {
+		<-s.slots
+	}
+}
+
+// finishRun reports a completed run through notifications, the heartbeat and
+// the summary file
+func (s *Scheduler) finishRun(jobName string, jobConfig *common.JobConfig, result *SyncResult, err error) {
+	s.recordOutcome(jobName, err)
+	s.notify.Load().JobFinished(jobName, result, err)
+	sendHeartbeat(jobName, jobConfig, result, err)
+
+	if path := s.Config().SummaryPath(jobName); path != "" {
+		if err := writeSummary(path, result); err != nil {
+			s.logger.Warn().Str("job", jobName).Str("path", path).Err(err).Msg("Failed to write run summary")
+		}
+	}
 }
 
 func (s *Scheduler) GetJobStatus(jobName string) (map[string]interface{}, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	status := make(map[string]interface{})
-
 	entryID, exists := s.jobs[jobName]
 	if !exists {
 		return nil, fmt.Errorf("job not found: %s", jobName)
 	}
 
-	entry := s.cron.Entry(entryID)
-	status["job_name"] = jobName
-	status["next_run"] = entry.Next
-	status["prev_run"] = entry.Prev
-
-	return status, nil
+	return s.jobStatus(jobName, entryID), nil
 }
 
 func (s *Scheduler) GetAllJobsStatus() []map[string]interface{} {
@@ -170,14 +879,40 @@ func (s *Scheduler) GetAllJobsStatus() []map[string]interface{} {
 	var statuses []map[string]interface{}
 
 	for jobName, entryID := range s.jobs {
-		entry := s.cron.Entry(entryID)
-		status := map[string]interface{}{
-			"job_name": jobName,
-			"next_run": entry.Next,
-			"prev_run": entry.Prev,
-		}
-		statuses = append(statuses, status)
+		statuses = append(statuses, s.jobStatus(jobName, entryID))
 	}
 
 	return statuses
 }
+
+// jobStatus describes a scheduled job. Run times are in the job's timezone,
+// next_run_utc repeats the next run in UTC, progress is the latest progress
+// of a running clone, fetch or push with git_progress. s.mu must be held.
+func (s *Scheduler) jobStatus(jobName string, entryID cron.EntryID) map[string]interface{} {
+	cfg := s.Config()
+	entry := s.cron.Entry(entryID)
+
+	loc := time.Local
+	if tz := cfg.JobTimezone(jobName); tz != "" {
+		if l, err := time.LoadLocation(tz); err == nil {
+			loc = l
+		}
+	}
+
+	status := map[string]interface{}{
+		"job_name":     jobName,
+		"schedule":     cfg.JobSchedule(jobName),
+		"timezone":     loc.String(),
+		"skipped_runs": s.skipped[jobName],
+		"next_run":     entry.Next.In(loc),
+		"next_run_utc": entry.Next.UTC(),
+		"prev_run":     entry.Prev.In(loc),
+	}
+	s.addBreakerStatus(jobName, status)
+	if syncer := s.syncers[jobName]; syncer != nil {
+		if progress, ok := syncer.Progress(); ok {
+			status["progress"] = progress
+		}
+	}
+	return status
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ternarybob/gitsync/internal/common"
+)
+
+// ErrCredentialsBackend marks a run that failed because its credentials could
+// not be read from the secrets backend, rather than because of the sync
+var ErrCredentialsBackend = errors.New("credentials backend unavailable")
+
+// resolveGitToken returns the job's git_token, read from Vault, Secrets
+// Manager or Parameter Store when the job names a secret. Backends cache what
+// they read. An error names the secret and the job, never the value, and wraps
+// ErrCredentialsBackend.
+func resolveGitToken(ctx context.Context, jobName string, jobConfig *common.JobConfig) (string, error) {
+	var token string
+	var err error
+	switch {
+	case jobConfig.GitTokenVaultPath != "":
+		token, err = readVaultSecret(ctx, jobConfig.Vault, jobConfig.GitTokenVaultPath)
+	case jobConfig.GitTokenSecretARN != "":
+		token, err = readAWSSecret(ctx, jobConfig.AWS, jobConfig.GitTokenSecretARN)
+	case jobConfig.GitTokenSSMParam != "":
+		token, err = readAWSParameter(ctx, jobConfig.AWS, jobConfig.GitTokenSSMParam)
+	default:
+		return jobConfig.GitToken, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("%w: %s of job '%s': %v", ErrCredentialsBackend, jobConfig.TokenSecret(), jobName, err)
+	}
+	return token, nil
+}
+
+// ResolveTokenSecrets reads the token of every enabled job that takes it from
+// a secrets backend, filling the caches so runs start with it, and returns the
+// error of each job whose secret cannot be read
+func ResolveTokenSecrets(ctx context.Context, cfg *common.Config) map[string]error {
+	errs := make(map[string]error)
+	for _, jobName := range cfg.GetEnabledJobs() {
+		jobConfig, _ := cfg.GetJobConfig(jobName)
+		if jobConfig.TokenSecret() == "" {
+			continue
+		}
+		if _, err := resolveGitToken(ctx, jobName, jobConfig); err != nil {
+			errs[jobName] = err
+		}
+	}
+	return errs
+}
@@ -0,0 +1,143 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os/exec"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/ternarybob/gitsync/internal/common"
+	"github.com/ternarybob/gitsync/internal/store"
+)
+
+// Server exposes liveness and readiness probes, Prometheus metrics, the job API
+// and webhook receivers over HTTP
+type Server struct {
+	config    *common.Config
+	scheduler *Scheduler
+	store     *store.Store
+	http      *http.Server
+}
+
+type probeResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// NewServer creates the probe server, st may be nil when the store is disabled
+func NewServer(cfg *common.Config, sched *Scheduler, st *store.Store) *Server {
+	s := &Server{
+		config:    cfg,
+		scheduler: sched,
+		store:     st,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", s.handleHealth)
+	mux.HandleFunc("GET /readyz", s.handleReady)
+	mux.Handle("GET /metrics", promhttp.Handler())
+	s.registerAPI(mux)
+	s.registerWebhooks(mux)
+
+	s.http = &http.Server{
+		Addr:              cfg.Server.Listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return s
+}
+
+// Start binds the listen address and serves in the background. Bind errors
+// are returned so startup fails instead of running without probes.
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.http.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.http.Addr, err)
+	}
+
+	logger := common.GetLogger()
+	logger.Info().Str("listen", listener.Addr().String()).Msg("HTTP server started")
+
+	go func() {
+		if err := s.http.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error().Err(err).Msg("HTTP server stopped unexpectedly")
+		}
+	}()
+	return nil
+}
+
+// Stop waits for in-flight requests to finish, up to the context deadline
+func (s *Server) Stop(ctx context.Context) {
+	logger := common.GetLogger()
+	if err := s.http.Shutdown(ctx); err != nil {
+		logger.Warn().Err(err).Msg("HTTP server shutdown incomplete")
+		return
+	}
+	logger.Info().Msg("HTTP server stopped")
+}
+
+// handleHealth reports whether the process can sync at all: the store is open
+// when configured and the git binary can be found
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{"process": "ok"}
+
+	switch {
+	case s.config.Store.Path == "":
+		checks["store"] = "disabled"
+	case s.store == nil:
+		checks["store"] = "not open"
+	default:
+		checks["store"] = "ok"
+	}
+
+	if _, err := exec.LookPath("git"); err != nil {
+		checks["git"] = err.Error()
+	} else {
+		checks["git"] = "ok"
+	}
+
+	writeProbe(w, checks, "process", "store", "git")
+}
+
+// handleReady reports whether the configuration is loaded and jobs are scheduled
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{"config": "ok", "scheduler": "ok"}
+	if s.config == nil {
+		checks["config"] = "not loaded"
+	}
+	switch {
+	case s.scheduler.Started():
+	case s.scheduler.InitialSyncRunning():
+		checks["scheduler"] = "initial sync in progress"
+	default:
+		checks["scheduler"] = "not started"
+	}
+
+	writeProbe(w, checks, "config", "scheduler")
+}
+
+// writeProbe answers 200 when every named check passed and 503 otherwise
+func writeProbe(w http.ResponseWriter, checks map[string]string, names ...string) {
+	response := probeResponse{Status: "ok", Checks: checks}
+	code := http.StatusOK
+	for _, name := range names {
+		if result := checks[name]; result != "ok" && result != "disabled" {
+			response.Status = "unavailable"
+			code = http.StatusServiceUnavailable
+		}
+	}
+
+	writeJSON(w, code, response)
+}
+
+func writeJSON(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
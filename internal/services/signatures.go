@@ -0,0 +1,198 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/ternarybob/gitsync/internal/common"
+	"github.com/ternarybob/gitsync/internal/store"
+)
+
+// maxUnsignedReported bounds the commits named in a signature failure
+const maxUnsignedReported = 10
+
+// verifySignatures checks that every commit of revs, such as "<local>
+// ^<remote>", is signed by a trusted key, and returns an error naming the
+// commits that are not. Signatures already checked are taken from this run or
+// the store, only the rest are verified by git.
+func (s *Syncer) verifySignatures(ctx context.Context, repoDir string, revs ...string) error {
+	cmd := s.git(ctx, append([]string{"rev-list"}, revs...)...)
+	cmd.Dir = repoDir
+	output, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("failed to list commits to verify: %w", err)
+	}
+	commits := strings.Fields(string(output))
+	if len(commits) == 0 {
+		return nil
+	}
+
+	scope, err := s.signatureScope()
+	if err != nil {
+		return err
+	}
+	if s.signatures == nil {
+		s.signatures = make(map[string]store.Signature)
+	}
+
+	unknown := s.unverified(commits)
+	if len(unknown) > 0 && s.store != nil {
+		recorded, err := s.store.GetSignatures(scope, unknown)
+		if err != nil {
+			s.logger.Warn().Str("job", s.jobName).Err(err).Msg("Failed to read recorded signatures, verifying every commit")
+		}
+		for commit, signature := range recorded {
+			s.signatures[commit] = signature
+		}
+		unknown = s.unverified(unknown)
+	}
+
+	if len(unknown) > 0 {
+		verified, err := s.checkSignatures(ctx, repoDir, unknown)
+		if err != nil {
+			return err
+		}
+		// Missing keys or expiry can change, only outcomes that cannot are recorded
+		lasting := make(map[string]store.Signature)
+		for commit, signature := range verified {
+			s.signatures[commit] = signature
+			switch signature.Status {
+			case "G", "U", "B", "N":
+				lasting[commit] = signature
+			}
+		}
+		if s.store != nil && len(lasting) > 0 {
+			if err := s.store.SaveSignatures(scope, lasting); err != nil {
+				s.logger.Warn().Str("job", s.jobName).Err(err).Msg("Failed to record signatures, the next run verifies them again")
+			}
+		}
+	}
+
+	var untrusted []string
+	for _, commit := range commits {
+		if reason := s.untrustedReason(s.signatures[commit]); reason != "" {
+			untrusted = append(untrusted, fmt.Sprintf("%s (%s)", shortHash(commit), reason))
+		}
+	}
+	if len(untrusted) == 0 {
+		return nil
+	}
+	if len(untrusted) > maxUnsignedReported {
+		untrusted = append(untrusted[:maxUnsignedReported], fmt.Sprintf("and %d more", len(untrusted)-maxUnsignedReported))
+	}
+	return fmt.Errorf("%d of %d commits are not signed by a trusted key: %s",
+		len(untrusted), len(commits), strings.Join(untrusted, ", "))
+}
+
+// unverified returns the commits whose signature this run has not seen yet
+func (s *Syncer) unverified(commits []string) []string {
+	var unknown []string
+	for _, commit := range commits {
+		if _, ok := s.signatures[commit]; !ok {
+			unknown = append(unknown, commit)
+		}
+	}
+	return unknown
+}
+
+// checkSignatures asks git for the signature of every commit
+func (s *Syncer) checkSignatures(ctx context.Context, repoDir string, commits []string) (signatures map[string]store.Signature, err error) {
+	s.logger.Info().Str("job", s.jobName).Int("commits", len(commits)).Msg("Verifying commit signatures")
+
+	ctx, span := tracer.Start(ctx, "verify signatures", trace.WithAttributes(
+		attribute.Int("gitsync.commits", len(commits)),
+	))
+	defer func() { endSpan(span, err) }()
+
+	// Without allowed signers git still reports the key of SSH signatures,
+	// with an unknown validity trusted_keys can accept
+	allowedSigners := os.DevNull
+	if s.jobConfig.AllowedSigners != "" {
+		// git runs in the repository, a relative path has to be made absolute
+		allowedSigners, err = filepath.Abs(s.jobConfig.AllowedSigners)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve allowed_signers_file: %w", err)
+		}
+	}
+	cmd := s.git(ctx, "-c", "gpg.ssh.allowedSignersFile="+allowedSigners,
+		"log", "--no-walk=unsorted", "--stdin", "--format=%H %G? %GF %GP")
+	cmd.Dir = repoDir
+	cmd.Stdin = strings.NewReader(strings.Join(commits, "\n") + "\n")
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify commit signatures: %w", err)
+	}
+
+	signatures = make(map[string]store.Signature, len(commits))
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Split(line, " ")
+		if len(fields) != 4 {
+			continue
+		}
+		signatures[fields[0]] = store.Signature{Status: fields[1], Key: fields[2], PrimaryKey: fields[3]}
+	}
+	return signatures, nil
+}
+
+// untrustedReason says why a commit's signature is not trusted, empty when it
+// is. With trusted_keys a good signature, or one by a key of unknown validity,
+// has to be made by one of them or a subkey of one; without, git has to call
+// the signature good.
+func (s *Syncer) untrustedReason(signature store.Signature) string {
+	switch signature.Status {
+	case "G", "U":
+		if len(s.jobConfig.TrustedKeys) > 0 {
+			for _, key := range s.jobConfig.TrustedKeys {
+				key = common.NormalizeKeyFingerprint(key)
+				if key == signature.Key || key == signature.PrimaryKey {
+					return ""
+				}
+			}
+		} else if signature.Status == "G" {
+			return ""
+		}
+		return "signed by untrusted key " + signature.Key
+	case "N", "":
+		return "not signed"
+	case "B":
+		return "bad signature"
+	case "E":
+		return "signature cannot be checked, the key is missing"
+	case "X":
+		return "signature expired"
+	case "Y":
+		return "signed by expired key " + signature.Key
+	case "R":
+		return "signed by revoked key " + signature.Key
+	}
+	return "signature status " + signature.Status
+}
+
+// signatureScope identifies the allowed signers file recorded signatures were
+// verified against, empty when SSH signatures are not verified
+func (s *Syncer) signatureScope() (string, error) {
+	if s.jobConfig.AllowedSigners == "" {
+		return "", nil
+	}
+	data, err := os.ReadFile(s.jobConfig.AllowedSigners)
+	if err != nil {
+		return "", fmt.Errorf("failed to read allowed_signers_file: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func shortHash(commit string) string {
+	if len(commit) > 7 {
+		return commit[:7]
+	}
+	return commit
+}
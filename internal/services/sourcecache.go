@@ -0,0 +1,177 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ternarybob/gitsync/internal/common"
+)
+
+// sourceLocks holds a lock per shared source mirror, so the jobs of the
+// process never fetch into the same mirror at once
+var sourceLocks = struct {
+	mu    sync.Mutex
+	locks map[string]chan struct{}
+}{locks: make(map[string]chan struct{})}
+
+// lockSource takes the lock of a shared mirror, returning the function
+// releasing it, or the error of ctx when it is done first
+func lockSource(ctx context.Context, key string) (func(), error) {
+	sourceLocks.mu.Lock()
+	lock, ok := sourceLocks.locks[key]
+	if !ok {
+		lock = make(chan struct{}, 1)
+		sourceLocks.locks[key] = lock
+	}
+	sourceLocks.mu.Unlock()
+
+	select {
+	case lock <- struct{}{}:
+		return func() { <-lock }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// sourceCacheKey names the shared mirror of a source inside the cache root,
+// by the same collision-proof name job caches use for their clone. The @
+// keeps mirrors apart from job directories, jobDirName never produces it.
+func sourceCacheKey(source string) string {
+	return "@" + uniqueName(source)
+}
+
+// sourceCacheDir returns the shared bare mirror of a source
+func sourceCacheDir(source string) string {
+	return filepath.Join(CacheRoot(), sourceCacheKey(source))
+}
+
+// isDerivedClone reports whether a cached clone borrows its objects from a
+// shared mirror
+func isDerivedClone(repoDir string) bool {
+	_, err := os.Stat(filepath.Join(repoDir, ".git", "objects", "info", "alternates"))
+	return err == nil
+}
+
+// fetchSharedSource brings the job's clone up to date through the shared
+// mirror of the source: the mirror fetches from the source under its lock,
+// the clone then fetches from the mirror, borrowing its objects, so jobs of
+// the same source keep one copy of its history and only their rewrites and
+// checkouts of their own. The mirror stays locked throughout.
+func (s *Syncer) fetchSharedSource(ctx context.Context, repoDir string, exists bool) error {
+	mirrorDir := sourceCacheDir(s.jobConfig.Source)
+	unlock, err := lockSource(ctx, filepath.Base(mirrorDir))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := s.updateSourceMirror(ctx, mirrorDir); err != nil {
+		return fmt.Errorf("failed to update shared source cache: %w", err)
+	}
+
+	if !exists {
+		s.logger.Debug().Str("job", s.jobName).Str("mirror", mirrorDir).Msg("Cloning repository from shared source cache")
+		cmd := s.gitCommand(ctx, nil, []string{"clone", "--shared", mirrorDir, repoDir})
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to clone shared source cache: %w\n%s", err, output)
+		}
+		return nil
+	}
+
+	args := []string{"fetch", "origin", "--prune"}
+	if s.jobConfig.SyncTags || len(s.jobConfig.SyncToTagPattern) > 0 {
+		args = append(args, "--tags", "--prune-tags")
+	}
+	cmd := s.gitCommand(ctx, nil, args)
+	cmd.Dir = repoDir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to fetch from shared source cache: %w\n%s", err, output)
+	}
+	return nil
+}
+
+// updateSourceMirror clones the bare mirror of the source on first use and
+// fetches its branches and tags afterwards. The mirror is never pruned of
+// objects, the clones of jobs may still use them. The caller holds the lock
+// of the mirror.
+func (s *Syncer) updateSourceMirror(ctx context.Context, mirrorDir string) error {
+	exists, err := dirExists(mirrorDir)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return s.cloneSourceMirror(ctx, mirrorDir)
+	}
+
+	phaseCtx, cancel := s.phaseContext(ctx, phaseFetch)
+	defer cancel()
+
+	cmd := s.git(phaseCtx, append([]string{"fetch", "origin", "--prune"}, s.progressArg()...)...)
+	cmd.Dir = mirrorDir
+	if output, err := s.runStreamed(cmd, "fetch"); err != nil {
+		return fmt.Errorf("failed to fetch: %w\n%s", s.phaseError(ctx, phaseCtx, phaseFetch, s.jobConfig.Source, err), output)
+	}
+
+	// The default branch of the source may have changed, the clones of jobs
+	// resolve it through the mirror's HEAD
+	cmd = s.git(phaseCtx, "ls-remote", "--symref", "origin", "HEAD")
+	cmd.Dir = mirrorDir
+	output, err := cmd.Output()
+	if err == nil {
+		var branch string
+		if branch, err = symrefBranch(output); err == nil {
+			cmd = s.git(ctx, "symbolic-ref", "HEAD", "refs/heads/"+branch)
+			cmd.Dir = mirrorDir
+			err = cmd.Run()
+		}
+	}
+	if err != nil {
+		s.logger.Debug().Str("job", s.jobName).Str("mirror", mirrorDir).Err(err).Msg("Could not update the default branch of the shared source cache")
+	}
+	return nil
+}
+
+// cloneSourceMirror creates the bare mirror, following the branches and tags
+// of the source
+func (s *Syncer) cloneSourceMirror(ctx context.Context, mirrorDir string) error {
+	s.logger.Info().Str("job", s.jobName).Str("source", s.jobConfig.Source).Str("mirror", mirrorDir).Msg("Creating shared source cache")
+
+	phaseCtx, cancel := s.phaseContext(ctx, phaseClone)
+	defer cancel()
+
+	cmd := s.git(phaseCtx, append(append([]string{"clone", "--bare"}, s.progressArg()...), s.jobConfig.Source, mirrorDir)...)
+	if output, err := s.runStreamed(cmd, "clone"); err != nil {
+		os.RemoveAll(mirrorDir)
+		return fmt.Errorf("failed to clone: %w\n%s", s.phaseError(ctx, phaseCtx, phaseClone, s.jobConfig.Source, err), output)
+	}
+
+	for _, args := range [][]string{
+		{"config", "remote.origin.fetch", "+refs/heads/*:refs/heads/*"},
+		{"config", "--add", "remote.origin.fetch", "+refs/tags/*:refs/tags/*"},
+		{"config", "gc.auto", "0"},
+	} {
+		cmd := s.gitCommand(ctx, nil, args)
+		cmd.Dir = mirrorDir
+		if output, err := cmd.CombinedOutput(); err != nil {
+			os.RemoveAll(mirrorDir)
+			return fmt.Errorf("failed to configure shared source cache: %w\n%s", err, output)
+		}
+	}
+	return nil
+}
+
+// sharedSources returns the shared mirror keys of the configured jobs with
+// the names of the jobs using each
+func sharedSources(cfg *common.Config) map[string][]string {
+	sources := make(map[string][]string)
+	for _, jobName := range cfg.Jobs.Names {
+		if jobConfig, exists := cfg.GetJobConfig(jobName); exists && jobConfig.SharedCache {
+			key := sourceCacheKey(jobConfig.Source)
+			sources[key] = append(sources[key], jobName)
+		}
+	}
+	return sources
+}
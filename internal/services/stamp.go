@@ -0,0 +1,177 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ternarybob/gitsync/internal/common"
+)
+
+// stampTargets pushes a stamp tag, named by stamp_tag_format, at the commit
+// the stamp branch was synced at to every target this run pushed to. Targets
+// the run found unchanged already carry the stamp of the run that changed them.
+// Stamp tags beyond stamp_tag_keep are then deleted from the target, oldest first.
+func (s *Syncer) stampTargets(ctx context.Context, repoDir string, result *SyncResult) {
+	branch := s.jobConfig.StampTagBranch
+	if branch == "" {
+		defaultBranch, err := s.getDefaultBranch(ctx, repoDir)
+		if err != nil {
+			s.logger.Warn().Str("job", s.jobName).Err(err).Msg("Failed to find the branch to stamp, no stamp tag pushed")
+			return
+		}
+		branch = defaultBranch
+	}
+
+	tag := s.jobConfig.StampTag(s.jobName, s.runID, result.StartTime)
+	var object string
+
+	for _, target := range s.targets() {
+		if common.IsBundleURL(target.URL) {
+			continue
+		}
+
+		var commit string
+		pushed := false
+		for _, entry := range result.Entries {
+			if entry.Target != target.URL {
+				continue
+			}
+			if entry.Branch == branch {
+				commit = entry.NewCommit
+			}
+			pushed = pushed || entry.Status == StatusPushed
+		}
+		if commit == "" {
+			s.logger.Info().Str("job", s.jobName).Str("branch", branch).Str("target", target.URL).Msg("Stamp branch was not synced to target, no stamp tag pushed")
+			continue
+		}
+		if !pushed {
+			s.logger.Debug().Str("job", s.jobName).Str("target", target.URL).Msg("Nothing pushed to target, no stamp tag pushed")
+			continue
+		}
+
+		startTime := time.Now()
+		entry := SyncEntry{Ref: "refs/tags/" + tag, Target: target.URL, Status: StatusPushed, NewCommit: commit}
+		if object == "" || !s.jobConfig.StampTagAnnotated {
+			object, entry.err = s.stampObject(ctx, repoDir, tag, commit)
+		}
+		if entry.err == nil {
+			entry.err = s.pushStampTag(ctx, repoDir, target, tag, object)
+		}
+		entry.Duration = time.Since(startTime)
+		s.record(result, entry)
+
+		if entry.err == nil && s.jobConfig.StampTagKeep > 0 {
+			s.pruneStampTags(ctx, repoDir, target)
+		}
+	}
+}
+
+// stampObject returns what a stamp tag points at, the commit itself or, with
+// stamp_tag_annotated, a new tag object naming the job and run
+func (s *Syncer) stampObject(ctx context.Context, repoDir, tag, commit string) (string, error) {
+	if !s.jobConfig.StampTagAnnotated {
+		return commit, nil
+	}
+
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "localhost"
+	}
+	content := fmt.Sprintf("object %s\ntype commit\ntag %s\ntagger gitsync <gitsync@%s> %d +0000\n\nSynced by gitsync job %s, run %s\n",
+		commit, tag, hostname, time.Now().Unix(), s.jobName, s.runID)
+
+	cmd := s.git(ctx, "mktag")
+	cmd.Dir = repoDir
+	cmd.Stdin = strings.NewReader(content)
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to create stamp tag %s: %w", tag, err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// pushStampTag pushes the tag straight from the object, the job cache never
+// carries stamp tags that later tag syncs would push along. A tag of the same
+// name, from an earlier run when the format repeats, is replaced.
+func (s *Syncer) pushStampTag(ctx context.Context, repoDir string, target common.TargetConfig, tag, object string) error {
+	targetName, err := s.ensureRemote(ctx, repoDir, target.URL)
+	if err != nil {
+		return err
+	}
+
+	phaseCtx, cancel := s.phaseContext(ctx, phasePush)
+	defer cancel()
+
+	cmd := s.gitTarget(phaseCtx, target, "push", targetName, fmt.Sprintf("+%s:refs/tags/%s", object, tag))
+	cmd.Dir = repoDir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to push stamp tag %s: %w\n%s", tag, s.phaseError(ctx, phaseCtx, phasePush, target.URL, err), output)
+	}
+	return nil
+}
+
+// pruneStampTags deletes the stamp tags of the job beyond the newest
+// stamp_tag_keep from a target. Tags not made by stamp_tag_format are never
+// touched, and a failure only costs a warning.
+func (s *Syncer) pruneStampTags(ctx context.Context, repoDir string, target common.TargetConfig) {
+	targetName, err := s.ensureRemote(ctx, repoDir, target.URL)
+	if err != nil {
+		s.logger.Warn().Str("job", s.jobName).Str("target", target.URL).Err(err).Msg("Failed to prune stamp tags")
+		return
+	}
+
+	phaseCtx, cancel := s.phaseContext(ctx, phasePush)
+	defer cancel()
+
+	cmd := s.gitTarget(phaseCtx, target, "ls-remote", "--tags", "--refs", targetName)
+	cmd.Dir = repoDir
+	output, err := cmd.Output()
+	if err != nil {
+		s.logger.Warn().Str("job", s.jobName).Str("target", target.URL).Err(s.phaseError(ctx, phaseCtx, phasePush, target.URL, err)).Msg("Failed to list stamp tags to prune")
+		return
+	}
+
+	type stamp struct{ tag, date string }
+	pattern := s.jobConfig.StampTagPattern(s.jobName)
+	var stamps []stamp
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		tag := strings.TrimPrefix(fields[1], "refs/tags/")
+		if match := pattern.FindStringSubmatch(tag); match != nil {
+			stamps = append(stamps, stamp{tag: tag, date: match[1]})
+		}
+	}
+	if len(stamps) <= s.jobConfig.StampTagKeep {
+		return
+	}
+
+	// Newest first, tags of the same second by name
+	sort.Slice(stamps, func(i, j int) bool {
+		if stamps[i].date != stamps[j].date {
+			return stamps[i].date > stamps[j].date
+		}
+		return stamps[i].tag > stamps[j].tag
+	})
+	args := []string{"push", targetName, "--delete"}
+	var deleted []string
+	for _, old := range stamps[s.jobConfig.StampTagKeep:] {
+		args = append(args, "refs/tags/"+old.tag)
+		deleted = append(deleted, old.tag)
+	}
+
+	cmd = s.gitTarget(phaseCtx, target, args...)
+	cmd.Dir = repoDir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		s.logger.Warn().Str("job", s.jobName).Str("target", target.URL).Err(fmt.Errorf("%w\n%s", s.phaseError(ctx, phaseCtx, phasePush, target.URL, err), output)).Msg("Failed to delete old stamp tags")
+		return
+	}
+	s.logger.Info().Str("job", s.jobName).Str("target", target.URL).Strs("tags", deleted).Int("kept", s.jobConfig.StampTagKeep).Msg("Deleted stamp tags beyond stamp_tag_keep")
+}
@@ -0,0 +1,63 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// runSummary is the JSON document written to summary_path after each run
+type runSummary struct {
+	*SyncResult
+	Status  string    `json:"status"` // success or failed
+	EndTime time.Time `json:"end_time"`
+}
+
+// writeSummary writes the result of a run to path, replacing the previous
+// summary atomically so readers never see a partial file
+func writeSummary(path string, result *SyncResult) error {
+	summary := runSummary{SyncResult: result, Status: "success", EndTime: result.StartTime.Add(result.Duration)}
+	if result.Error != "" {
+		summary.Status = "failed"
+	}
+
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode run summary: %w", err)
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create summary directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary summary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write run summary: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write run summary: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write run summary: %w", err)
+	}
+
+	// CreateTemp uses 0600, summaries are meant to be read by other tools
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("failed to write run summary: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace run summary: %w", err)
+	}
+	return nil
+}
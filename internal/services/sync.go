@@ -2,26 +2,59 @@ package services
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ternarybob/arbor"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
 	"github.com/ternarybob/gitsync/internal/common"
+	"github.com/ternarybob/gitsync/internal/store"
 )
 
 type Syncer struct {
 	jobName   string
 	jobConfig *common.JobConfig
 	tempDir   string
-	logger    arbor.ILogger
+	logger    arbor.ILogger // Adds the run ID while SyncAll runs
+	base      arbor.ILogger // The logger runs log to, the global one unless SetLogger changed it
+	store     *store.Store
+	runID     string
+	filter    RunFilter // Set while SyncAll runs
+
+	askPass       string
+	bundleState   map[string]string
+	lastSynced    map[store.SyncKey]string
+	sourceCommits map[string]string           // Source commit of each rewritten commit, set while SyncAll runs
+	signatures    map[string]store.Signature  // Signatures of commits verified while SyncAll runs
+	aggregated    map[string]aggregatedBranch // Branches of an aggregation job by target name, set while SyncAll runs
+	created       []string                    // Targets whose repository was created while SyncAll runs
+	gitToken      string                      // The job's git_token, or the one read from its secrets backend, set by setupGitAuth
+	validator     PushValidator               // Asked before each branch is pushed, nil for none
+
+	throttleMu      sync.Mutex
+	throttles       map[string]*bandwidthProxy // Throttling proxies by upstream proxies, started while SyncAll runs
+	throttleLimiter *byteLimiter               // Shared by the throttling proxies of a run
+
+	progressMu sync.Mutex
+	progress   *GitProgress // Latest progress of a running git command, nil when none reports any
 }
 
-func NewSyncer(jobName string, jobConfig *common.JobConfig) (*Syncer, error) {
-	tempDir := filepath.Join(os.TempDir(), "gitsync", jobName)
+// NewSyncer creates the syncer of a job, st may be nil when history is disabled
+func NewSyncer(jobName string, jobConfig *common.JobConfig, st *store.Store) (*Syncer, error) {
+	tempDir := jobCacheDir(jobName)
 	if err := os.MkdirAll(tempDir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create temp directory: %w", err)
 	}
@@ -31,35 +64,136 @@ func NewSyncer(jobName string, jobConfig *common.JobConfig) (*Syncer, error) {
 		jobConfig: jobConfig,
 		tempDir:   tempDir,
 		logger:    common.GetLogger(),
+		base:      common.GetLogger(),
+		store:     st,
 	}, nil
 }
 
-func (s *Syncer) SyncAll(ctx context.Context) error {
+// SetLogger makes the syncer log to logger instead of the global logger
+func (s *Syncer) SetLogger(logger arbor.ILogger) {
+	s.logger = logger
+	s.base = logger
+}
+
+// SyncAll runs the job once. The result is always returned, the error is
+// non-nil when the job failed outright or any single push failed. The run ID
+// attached to ctx, or a new one, identifies the run in logs and traces.
+func (s *Syncer) SyncAll(ctx context.Context) (*SyncResult, error) {
 	startTime := time.Now()
 
+	runID := runIDFrom(ctx)
+	if runID == "" {
+		runID = newRunID()
+		ctx = withRunID(ctx, runID)
+	}
+	s.runID = runID
+	s.logger = newRunLogger(s.base, runID)
+	s.filter = runFilterFrom(ctx)
+	defer func() {
+		s.runID = ""
+		s.logger = s.base
+		s.filter = RunFilter{}
+		s.sourceCommits = nil
+		s.signatures = nil
+		s.aggregated = nil
+		s.closeBandwidthProxies()
+	}()
+
+	result := &SyncResult{
+		Job:       s.jobName,
+		RunID:     runID,
+		Source:    s.jobConfig.SourceLabel(),
+		StartTime: startTime,
+	}
+
+	ctx, span := tracer.Start(ctx, "sync job", trace.WithAttributes(
+		attribute.String("gitsync.job", s.jobName),
+		attribute.String("gitsync.source_host", common.RepositoryHost(s.jobConfig.Source)),
+	))
+
 	// Use direct logging functions that work
 	s.logger.Info().Str("job", s.jobName).Msg("=== STARTING SYNC JOB ===")
-	s.logger.Info().Str("job", s.jobName).Str("source", s.jobConfig.Source).Str("start_time", startTime.Format("2006-01-02 15:04:05")).Msg("Job details")
+	s.logger.Info().Str("job", s.jobName).Str("source", s.jobConfig.SourceLabel()).Str("start_time", startTime.Format("2006-01-02 15:04:05")).Msg("Job details")
 
-	if err := s.syncJob(ctx); err != nil {
-		duration := time.Since(startTime)
-		s.logger.Error().Str("job", s.jobName).Dur("duration", duration).Err(err).Msg("=== FAILED SYNC JOB ===")
-		return err
+	err := s.syncJob(ctx, result)
+	// The wiki follows the repository, a run limited to one branch leaves it out
+	if err == nil && s.jobConfig.SyncWiki && s.filter.Branch == "" {
+		s.syncWiki(ctx, result)
+	}
+	err = errors.Join(err, result.failures())
+	if err == nil {
+		s.commitBundleState()
 	}
+	s.bundleState = nil
 
-	duration := time.Since(startTime)
-	s.logger.Info().Str("job", s.jobName).Dur("duration", duration).Msg("=== COMPLETED SYNC JOB ===")
-	return nil
+	if err != nil {
+		result.Error = err.Error()
+		if errors.Is(err, ErrCredentialsBackend) {
+			result.ErrorCategory = ErrorCategoryCredentials
+		}
+	}
+	s.runPostSyncHook(ctx, result, err)
+
+	result.Duration = time.Since(startTime)
+
+	span.SetAttributes(
+		attribute.Int("gitsync.pushed", result.Count(StatusPushed)),
+		attribute.Int("gitsync.skipped", result.Count(StatusSkipped)),
+		attribute.Int("gitsync.failed", result.Count(StatusFailed)),
+	)
+	endSpan(span, err)
+
+	if err != nil {
+		s.logger.Error().Str("job", s.jobName).Dur("duration", result.Duration).Int("matched", result.Matched).
+			Int("pushed", result.Count(StatusPushed)).Int("skipped", result.Count(StatusSkipped)).Int("failed", result.Count(StatusFailed)).
+			Strs("failures", result.FailureReasons()).Err(err).Msg("=== FAILED SYNC JOB ===")
+		return result, err
+	}
+
+	s.logger.Info().Str("job", s.jobName).Dur("duration", result.Duration).Int("matched", result.Matched).
+		Int("pushed", result.Count(StatusPushed)).Int("skipped", result.Count(StatusSkipped)).
+		Msg("=== COMPLETED SYNC JOB ===")
+	return result, nil
 }
 
-func (s *Syncer) syncJob(ctx context.Context) error {
-	s.logger.Info().Str("job", s.jobName).Str("source", s.jobConfig.Source).Msg("Syncing repository")
+// syncJob performs the run, recording every push in result. Only failures that
+// stop the whole run are returned.
+func (s *Syncer) syncJob(ctx context.Context, result *SyncResult) error {
+	s.logger.Info().Str("job", s.jobName).Str("source", s.jobConfig.SourceLabel()).Msg("Syncing repository")
+
+	// The cache directory may have been evicted since the syncer was created
+	if err := os.MkdirAll(s.tempDir, 0755); err != nil {
+		return fmt.Errorf("failed to create temp directory: %w", err)
+	}
+
+	// Set up authentication using job-level credentials, local repositories need none
+	if s.jobConfig.UsesRemoteAuth() {
+		if err := s.setupGitAuth(ctx); err != nil {
+			return fmt.Errorf("failed to setup git auth: %w", err)
+		}
+	}
+
+	// Missing target repositories are created before anything is pushed to them
+	if s.jobConfig.ProvisionsTargets() {
+		s.provisionTargets(ctx)
+		defer s.configureTargets(ctx)
+	}
+
+	if s.jobConfig.Aggregates() {
+		return s.syncSources(ctx, result)
+	}
 
-	repoDir := filepath.Join(s.tempDir, sanitizeName(s.jobConfig.Source))
+	repoDir := s.repoDir()
+	if err := migrateLegacyPath(filepath.Join(s.tempDir, sanitizeName(s.jobConfig.Source)), repoDir); err != nil {
+		return fmt.Errorf("failed to migrate cached repository: %w", err)
+	}
 
-	// Set up authentication using job-level credentials
-	if err := s.setupGitAuth(); err != nil {
-		return fmt.Errorf("failed to setup git auth: %w", err)
+	// A clone made before shared_cache was switched on or off is cloned again
+	// the other way, a derived clone has none of the objects it needs alone
+	if isDerivedClone(repoDir) != s.jobConfig.SharedCache {
+		if err := os.RemoveAll(repoDir); err != nil {
+			return fmt.Errorf("failed to remove cached repository: %w", err)
+		}
 	}
 
 	exists, err := dirExists(repoDir)
@@ -67,7 +201,20 @@ func (s *Syncer) syncJob(ctx context.Context) error {
 		return err
 	}
 
-	if exists {
+	if common.IsBundleURL(s.jobConfig.Source) {
+		changed, err := s.fetchBundleSource(ctx, repoDir, exists)
+		if err != nil {
+			return err
+		}
+		if !changed {
+			s.logger.Info().Str("job", s.jobName).Str("source", s.jobConfig.Source).Msg("Source bundle unchanged since last run, skipping")
+			return nil
+		}
+	} else if s.jobConfig.SharedCache {
+		if err := s.fetchSharedSource(ctx, repoDir, exists); err != nil {
+			return err
+		}
+	} else if exists {
 		if err := s.updateRepository(ctx, repoDir); err != nil {
 			return fmt.Errorf("failed to update repository: %w", err)
 		}
@@ -77,6 +224,15 @@ func (s *Syncer) syncJob(ctx context.Context) error {
 		}
 	}
 
+	if s.jobConfig.Bidirectional {
+		return s.syncPair(ctx, repoDir, result)
+	}
+
+	// Explicit refspecs replace the branch pattern mechanism entirely
+	if len(s.jobConfig.Refspecs) > 0 {
+		return s.syncRefspecs(ctx, repoDir, result)
+	}
+
 	// Get branches to sync
 	branchesToSync, err := s.getBranchesToSync(ctx, repoDir)
 	if err != nil {
@@ -88,26 +244,141 @@ func (s *Syncer) syncJob(ctx context.Context) error {
 		return nil
 	}
 
+	result.Matched = len(branchesToSync)
 	s.logger.Info().Str("job", s.jobName).Str("branches", fmt.Sprintf("%v", branchesToSync)).Msg("Found branches to sync")
 
-	// Rewrite commit history if author replacement is configured
-	if s.jobConfig.RewriteHistory && len(s.jobConfig.AuthorReplace) > 0 {
-		if err := s.rewriteCommitAuthors(ctx, repoDir); err != nil {
-			return fmt.Errorf("failed to rewrite commit authors: %w", err)
+	// Rewrite commit history if author replacement or the origin trailer is configured
+	if s.jobConfig.RewritesHistory() {
+		if err := s.rewriteHistory(ctx, repoDir); err != nil {
+			return fmt.Errorf("failed to rewrite history: %w", err)
+		}
+	}
+
+	// After the rewrite, the held back commits are the rewritten ones
+	if s.jobConfig.SyncDelay > 0 {
+		if branchesToSync, err = s.applySyncDelay(ctx, repoDir, branchesToSync); err != nil {
+			return err
+		}
+		if len(branchesToSync) == 0 {
+			s.logger.Info().Str("job", s.jobName).Msg("No branch has commits older than sync_delay, nothing to sync")
+			return nil
+		}
+	}
+
+	// After the delay, so the tag is the newest one on the held back branch
+	if len(s.jobConfig.SyncToTagPattern) > 0 {
+		if branchesToSync, err = s.applyTagPatterns(ctx, repoDir, branchesToSync); err != nil {
+			return err
+		}
+		if len(branchesToSync) == 0 {
+			s.logger.Info().Str("job", s.jobName).Msg("No branch has a tag matching sync_to_tag_pattern, nothing to sync")
+			return nil
+		}
+	}
+
+	if err := s.runPreSyncHook(ctx, repoDir, branchesToSync, nil); err != nil {
+		return err
+	}
+
+	return s.syncBranches(ctx, repoDir, branchesToSync, result)
+}
+
+// syncBranches pushes the fetched branches to every target, then writes the
+// bundle targets and pushes tags and the stamp tag
+func (s *Syncer) syncBranches(ctx context.Context, repoDir string, branchesToSync []string, result *SyncResult) error {
+	s.lastSynced = s.loadLastSynced()
+
+	// Check out and validate every branch first, failures are recorded on every
+	// target so the other branches are still pushed
+	var blocked int
+	allowed := make([]string, 0, len(branchesToSync))
+	prepared := make([]preparedBranch, 0, len(branchesToSync))
+	for i, branch := range branchesToSync {
+		if ctx.Err() != nil {
+			return s.abortedBranches(ctx, branchesToSync[i:])
 		}
+
+		ready := s.prepareBranch(ctx, repoDir, branch)
+		prepared = append(prepared, ready)
+		if ready.blocked {
+			blocked++
+			continue
+		}
+		allowed = append(allowed, branch)
+	}
+	// Bundles leave out blocked branches, which reach no target
+	branchesToSync = allowed
+
+	// Each target gets its branches in one push, bundles are written once all
+	// are pushed. A branch whose push to any target never started is not reached.
+	var notReached []string
+	listed := make(map[string]bool)
+	for _, target := range s.targets() {
+		if !common.IsBundleURL(target.URL) {
+			for _, branch := range s.pushBranchesToTarget(ctx, repoDir, target, prepared, result) {
+				if !listed[branch] {
+					listed[branch] = true
+					notReached = append(notReached, branch)
+				}
+			}
+		}
+	}
+	if len(notReached) > 0 {
+		return s.abortedBranches(ctx, notReached)
+	}
+
+	// A bundle holds every branch of the job and tags do not belong to one
+	if s.filter.Branch != "" {
+		s.logger.Info().Str("job", s.jobName).Str("branch", s.filter.Branch).Msg("Syncing a single branch, bundles and tags are left out")
+		return nil
 	}
 
-	// Sync each branch to all targets
-	for _, branch := range branchesToSync {
-		if err := s.syncBranchToTargets(ctx, repoDir, branch); err != nil {
-			s.logger.Error().Str("job", s.jobName).Str("branch", branch).Err(err).Msg("Failed to sync branch")
+	for _, target := range s.targets() {
+		if common.IsBundleURL(target.URL) {
+			startTime := time.Now()
+			skipped, err := s.writeBundle(ctx, repoDir, target.URL, branchesToSync)
+			entry := SyncEntry{Target: target.URL, Status: StatusPushed, Duration: time.Since(startTime), err: err}
+			if skipped {
+				entry.Status = StatusSkipped
+			}
+			s.record(result, entry)
 			continue
 		}
+
+		// Tags could carry the commits of a blocked branch
+		if s.jobConfig.SyncTags && blocked > 0 {
+			s.logger.Warn().Str("job", s.jobName).Str("target", target.URL).Int("blocked", blocked).Msg("Branches were blocked, tags not pushed")
+		} else if s.jobConfig.SyncTags {
+			startTime := time.Now()
+			err := s.pushTagsToTarget(ctx, repoDir, target)
+			s.record(result, SyncEntry{Ref: "refs/tags/*", Target: target.URL, Status: StatusPushed, Duration: time.Since(startTime), err: err})
+		}
+	}
+
+	// A stamp marks a complete mirror state, a run with any failure gets none
+	if s.jobConfig.StampTagFormat != "" {
+		if failed := result.Failed(); len(failed) > 0 {
+			s.logger.Info().Str("job", s.jobName).Int("failed", len(failed)).Msg("Sync had failures, no stamp tag pushed")
+		} else {
+			s.stampTargets(ctx, repoDir, result)
+		}
 	}
 
 	return nil
 }
 
+// abortedBranches logs the branches a job ended before reaching and returns
+// the error of the aborted job
+func (s *Syncer) abortedBranches(ctx context.Context, notReached []string) error {
+	s.logger.Error().Str("job", s.jobName).Strs("branches_not_reached", notReached).Err(ctx.Err()).Msg("Job aborted before all branches were synced")
+	return fmt.Errorf("job aborted with %d branches not synced: %w", len(notReached), ctx.Err())
+}
+
+// repoDir is where the source repository of the job is cached
+func (s *Syncer) repoDir() string {
+	return filepath.Join(s.tempDir, uniqueName(s.jobConfig.Source))
+}
+
 func (s *Syncer) getBranchesToSync(ctx context.Context, repoDir string) ([]string, error) {
 	// Fetch all remote branches and filter against configured patterns
 	remoteBranches, err := s.getRemoteBranches(ctx, repoDir)
@@ -115,18 +386,67 @@ func (s *Syncer) getBranchesToSync(ctx context.Context, repoDir string) ([]strin
 		return nil, err
 	}
 
+	if s.filter.Branch != "" {
+		return s.filteredBranch(ctx, repoDir, remoteBranches)
+	}
+
+	// Without configured patterns, follow whatever the source uses as its default branch
+	if len(s.jobConfig.Branches) == 0 {
+		defaultBranch, err := s.getDefaultBranch(ctx, repoDir)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, remoteBranch := range remoteBranches {
+			if remoteBranch == defaultBranch {
+				s.logger.Info().Str("job", s.jobName).Str("branch", defaultBranch).Msg("No branches configured, using detected source default branch")
+				return []string{defaultBranch}, nil
+			}
+		}
+
+		return nil, fmt.Errorf("detected default branch %s not found in source branches", defaultBranch)
+	}
+
 	var matchingBranches []string
+	var staleCount int
 	for _, remoteBranch := range remoteBranches {
-		if s.jobConfig.ShouldSyncBranch(remoteBranch) {
-			matchingBranches = append(matchingBranches, remoteBranch)
+		if !s.jobConfig.ShouldSyncBranch(remoteBranch) {
+			continue
 		}
+
+		if s.jobConfig.MaxBranchAge > 0 && !s.jobConfig.IsExplicitBranch(remoteBranch) {
+			stale, err := s.isStaleBranch(ctx, repoDir, remoteBranch)
+			if err != nil {
+				s.logger.Warn().Str("job", s.jobName).Str("branch", remoteBranch).Err(err).Msg("Could not determine branch age, syncing anyway")
+			} else if stale {
+				staleCount++
+				s.logger.Debug().Str("job", s.jobName).Str("branch", remoteBranch).Msg("Skipping stale branch")
+				continue
+			}
+		}
+
+		matchingBranches = append(matchingBranches, remoteBranch)
+	}
+
+	if staleCount > 0 {
+		s.logger.Info().Str("job", s.jobName).Int("skipped", staleCount).Dur("max_branch_age", s.jobConfig.MaxBranchAge).Msg("Skipped stale branches")
 	}
 
+	// Priority branches first, in configured order, then the rest alphabetically
+	sort.SliceStable(matchingBranches, func(i, j int) bool {
+		rankI := s.jobConfig.BranchPriorityRank(matchingBranches[i])
+		rankJ := s.jobConfig.BranchPriorityRank(matchingBranches[j])
+		if rankI != rankJ {
+			return rankI < rankJ
+		}
+		return matchingBranches[i] < matchingBranches[j]
+	})
+
 	return matchingBranches, nil
 }
 
 func (s *Syncer) getRemoteBranches(ctx context.Context, repoDir string) ([]string, error) {
-	cmd := exec.CommandContext(ctx, "git", "branch", "-r", "--format=%(refname:short)")
+	cmd := s.git(ctx, "branch", "-r", "--format=%(refname:short)")
 	cmd.Dir = repoDir
 	output, err := cmd.Output()
 	if err != nil {
@@ -148,74 +468,132 @@ func (s *Syncer) getRemoteBranches(ctx context.Context, repoDir string) ([]strin
 	return branches, nil
 }
 
-func (s *Syncer) syncBranchToTargets(ctx context.Context, repoDir string, branch string) error {
-	// Checkout the branch
-	if err := s.checkoutBranch(ctx, repoDir, branch); err != nil {
-		return fmt.Errorf("failed to checkout branch %s: %w", branch, err)
+// isStaleBranch reports whether the branch tip was committed longer ago than max_branch_age
+func (s *Syncer) isStaleBranch(ctx context.Context, repoDir, branch string) (bool, error) {
+	cmd := s.git(ctx, "log", "-1", "--format=%ct", fmt.Sprintf("origin/%s", branch))
+	cmd.Dir = repoDir
+	output, err := cmd.Output()
+	if err != nil {
+		return false, fmt.Errorf("failed to get commit date: %w", err)
 	}
 
-	// Get commit hash for this branch
-	commitHash, err := s.getLatestCommit(ctx, repoDir)
+	timestamp, err := strconv.ParseInt(strings.TrimSpace(string(output)), 10, 64)
 	if err != nil {
-		return fmt.Errorf("failed to get commit hash: %w", err)
+		return false, fmt.Errorf("failed to parse commit date: %w", err)
 	}
 
-	// Authentication is already set up at job level, no need to change it
+	return time.Since(time.Unix(timestamp, 0)) > s.jobConfig.MaxBranchAge, nil
+}
 
-	// Sync to each target
-	for _, target := range s.jobConfig.Targets {
-		startTime := time.Now()
+// getDefaultBranch resolves the branch the source HEAD points at
+func (s *Syncer) getDefaultBranch(ctx context.Context, repoDir string) (string, error) {
+	phaseCtx, cancel := s.phaseContext(ctx, phaseFetch)
+	defer cancel()
 
-		s.logger.Info().Str("job", s.jobName).Str("branch", branch).Str("target", target).Str("commit", commitHash).Msg("Starting sync to target")
+	cmd := s.git(phaseCtx, "ls-remote", "--symref", "origin", "HEAD")
+	cmd.Dir = repoDir
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve source default branch: %w", s.phaseError(ctx, phaseCtx, phaseFetch, s.jobConfig.Source, err))
+	}
+	return symrefBranch(output)
+}
 
-		if err := s.pushToTarget(ctx, repoDir, target, branch); err != nil {
-			s.logger.Error().Str("job", s.jobName).Str("branch", branch).Str("target", target).Err(err).Float64("duration", time.Since(startTime).Seconds()).Msg("Failed to sync to target")
+// symrefBranch reads the branch HEAD points at from ls-remote --symref output
+func symrefBranch(output []byte) (string, error) {
+	// Output looks like: "ref: refs/heads/master\tHEAD"
+	for _, line := range strings.Split(string(output), "\n") {
+		if !strings.HasPrefix(line, "ref: ") {
 			continue
 		}
+		fields := strings.Fields(strings.TrimPrefix(line, "ref: "))
+		if len(fields) > 0 && strings.HasPrefix(fields[0], "refs/heads/") {
+			return strings.TrimPrefix(fields[0], "refs/heads/"), nil
+		}
+	}
+
+	return "", fmt.Errorf("source HEAD does not point at a branch")
+}
+
+// prepareBranch checks out a branch and runs pre-push validation on it. A
+// blocked branch reaches none of the targets, validation decides for all of
+// them at once.
+func (s *Syncer) prepareBranch(ctx context.Context, repoDir string, branch string) preparedBranch {
+	prepared := preparedBranch{branch: branch}
 
-		s.logger.Info().Str("job", s.jobName).Str("branch", branch).Str("target", target).Str("commit", commitHash).Float64("duration", time.Since(startTime).Seconds()).Msg("Successfully synced to target")
+	// Checkout the branch and get its commit hash, a failure fails the branch on every target
+	if err := s.checkoutBranch(ctx, repoDir, branch); err != nil {
+		prepared.err = fmt.Errorf("failed to checkout branch %s: %w", branch, err)
+		return prepared
 	}
 
-	return nil
+	prepared.commit, prepared.err = s.getLatestCommit(ctx, repoDir)
+	if prepared.err != nil {
+		return prepared
+	}
+
+	prepared.err = s.validatePush(ctx, s.targets(), PendingPush{Job: s.jobName, RepoDir: repoDir, Branch: branch, NewCommit: prepared.commit})
+	prepared.blocked = prepared.err != nil && ctx.Err() == nil
+	return prepared
 }
 
-func (s *Syncer) cloneRepository(ctx context.Context, repoDir string) error {
+func (s *Syncer) cloneRepository(ctx context.Context, repoDir string) (err error) {
 	s.logger.Debug().Str("job", s.jobName).Msg("Cloning repository")
 
-	cmd := exec.CommandContext(ctx, "git", "clone", s.jobConfig.Source, repoDir)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to clone: %w\n%s", err, output)
+	ctx, span := tracer.Start(ctx, "git clone", trace.WithAttributes(attribute.String("gitsync.source_host", common.RepositoryHost(s.jobConfig.Source))))
+	defer func() { endSpan(span, err) }()
+
+	phaseCtx, cancel := s.phaseContext(ctx, phaseClone)
+	defer cancel()
+
+	cmd := s.git(phaseCtx, append(append([]string{"clone"}, s.progressArg()...), s.jobConfig.Source, repoDir)...)
+	if output, err := s.runStreamed(cmd, "clone"); err != nil {
+		return fmt.Errorf("failed to clone: %w\n%s", s.phaseError(ctx, phaseCtx, phaseClone, s.jobConfig.Source, err), output)
 	}
 
 	return nil
 }
 
-func (s *Syncer) updateRepository(ctx context.Context, repoDir string) error {
+func (s *Syncer) updateRepository(ctx context.Context, repoDir string) (err error) {
 	s.logger.Debug().Str("job", s.jobName).Msg("Updating repository")
 
-	cmd := exec.CommandContext(ctx, "git", "fetch", "origin", "--prune")
+	ctx, span := tracer.Start(ctx, "git fetch", trace.WithAttributes(attribute.String("gitsync.source_host", common.RepositoryHost(s.jobConfig.Source))))
+	defer func() { endSpan(span, err) }()
+
+	args := append([]string{"fetch", "origin", "--prune"}, s.progressArg()...)
+	// Tags are fetched in full when sync_to_tag_pattern looks for the newest one
+	if s.jobConfig.SyncTags || len(s.jobConfig.SyncToTagPattern) > 0 {
+		args = append(args, "--tags", "--prune-tags")
+	}
+
+	phaseCtx, cancel := s.phaseContext(ctx, phaseFetch)
+	defer cancel()
+
+	cmd := s.git(phaseCtx, args...)
 	cmd.Dir = repoDir
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to fetch: %w\n%s", err, output)
+	if output, err := s.runStreamed(cmd, "fetch"); err != nil {
+		return fmt.Errorf("failed to fetch: %w\n%s", s.phaseError(ctx, phaseCtx, phaseFetch, s.jobConfig.Source, err), output)
 	}
 
 	return nil
 }
 
 func (s *Syncer) checkoutBranch(ctx context.Context, repoDir, branch string) error {
+	upstream := s.upstream(branch)
+
 	// Try to checkout local branch first
-	cmd := exec.CommandContext(ctx, "git", "checkout", branch)
+	cmd := s.git(ctx, "checkout", branch)
 	cmd.Dir = repoDir
 	if err := cmd.Run(); err != nil {
 		// If local branch doesn't exist, create it from remote
-		cmd = exec.CommandContext(ctx, "git", "checkout", "-b", branch, fmt.Sprintf("origin/%s", branch))
+		cmd = s.git(ctx, "checkout", "-b", branch, upstream)
 		cmd.Dir = repoDir
 		if output, err := cmd.CombinedOutput(); err != nil {
 			return fmt.Errorf("failed to checkout branch %s: %w\n%s", branch, err, output)
 		}
 	} else {
 		// Reset to match remote
-		cmd = exec.CommandContext(ctx, "git", "reset", "--hard", fmt.Sprintf("origin/%s", branch))
+		cmd = s.git(ctx, "reset", "--hard", upstream)
 		cmd.Dir = repoDir
 		if output, err := cmd.CombinedOutput(); err != nil {
 			return fmt.Errorf("failed to reset branch %s: %w\n%s", branch, err, output)
@@ -225,57 +603,210 @@ func (s *Syncer) checkoutBranch(ctx context.Context, repoDir, branch string) err
 	return nil
 }
 
-func (s *Syncer) pushToTarget(ctx context.Context, repoDir, target, branch string) error {
-	targetName := sanitizeName(target)
+// pushBranch pushes the local branch at localCommit to a target unless the
+// target already has it, verifying the signatures of the pushed commits first
+// when verify is set
+func (s *Syncer) pushBranch(ctx context.Context, repoDir string, target common.TargetConfig, branch, localCommit string, verify bool) (*pushStats, error) {
+	targetName, stats, err := s.planPush(ctx, repoDir, target, branch, localCommit, verify)
+	if err != nil {
+		return nil, err
+	}
+	if stats.Skipped {
+		return stats, nil
+	}
 
-	cmd := exec.CommandContext(ctx, "git", "remote", "get-url", targetName)
+	if err := s.pushSingleBranch(ctx, repoDir, target, targetName, branch, stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// planPush compares the local branch at localCommit with the target and
+// returns the remote of the target with what a push would change, skipped
+// when the target already has the commit. With verify set the signatures of
+// the commits the target is missing are checked.
+func (s *Syncer) planPush(ctx context.Context, repoDir string, target common.TargetConfig, branch, localCommit string, verify bool) (string, *pushStats, error) {
+	stats := &pushStats{}
+
+	// The history already says the target has this commit, no need to ask it
+	if recorded := s.lastSynced[store.SyncKey{Branch: branch, Target: target.URL}]; recorded == localCommit {
+		s.logger.Debug().Str("job", s.jobName).Str("branch", branch).Str("target", target.URL).Str("commit", localCommit).Msg("Commit matches last recorded sync, not contacting target")
+		stats.OldCommit = recorded
+		stats.Skipped = true
+		return "", stats, nil
+	}
+
+	targetName, err := s.ensureRemote(ctx, repoDir, target.URL)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Get remote commit hash from target
+	remoteCommit, err := s.getRemoteCommitHash(ctx, repoDir, target, targetName, branch)
+	if errors.Is(err, errRemoteBranchMissing) {
+		s.logger.Debug().Str("job", s.jobName).Str("branch", branch).Str("target", target.URL).Msg("Branch does not exist on target yet, pushing full history")
+		stats.Ahead = s.countCommits(ctx, repoDir, localCommit)
+	} else if err != nil {
+		s.logger.Debug().Str("job", s.jobName).Str("branch", branch).Str("target", target.URL).Msg("Could not get remote commit hash, proceeding with push")
+		stats.Ahead = s.countCommits(ctx, repoDir, localCommit)
+	} else if localCommit == remoteCommit {
+		// Hashes match, skip push
+		stats.OldCommit = remoteCommit
+		stats.Skipped = true
+		return targetName, stats, nil
+	} else {
+		stats.OldCommit = remoteCommit
+		stats.Behind, stats.Ahead = s.countDivergence(ctx, repoDir, remoteCommit, localCommit)
+	}
+
+	if verify {
+		revs := []string{localCommit}
+		if stats.OldCommit != "" {
+			revs = append(revs, "^"+stats.OldCommit)
+		}
+		if err := s.verifySignatures(ctx, repoDir, revs...); err != nil {
+			return "", nil, err
+		}
+	}
+
+	return targetName, stats, nil
+}
+
+// pushSingleBranch pushes one local branch to the remote of a target,
+// recording what it transferred in stats
+func (s *Syncer) pushSingleBranch(ctx context.Context, repoDir string, target common.TargetConfig, targetName, branch string, stats *pushStats) error {
+	phaseCtx, cancel := s.phaseContext(ctx, phasePush)
+	defer cancel()
+
+	// Use force push if override is enabled, otherwise regular push
+	var cmd *exec.Cmd
+	if s.jobConfig.Override {
+		cmd = s.gitTarget(phaseCtx, target, "push", "--progress", targetName, fmt.Sprintf("%s:%s", branch, branch), "--force")
+	} else {
+		cmd = s.gitTarget(phaseCtx, target, "push", "--progress", targetName, fmt.Sprintf("%s:%s", branch, branch))
+	}
+	cmd.Dir = repoDir
+	output, err := s.runStreamed(cmd, "push")
+	if err != nil {
+		return fmt.Errorf("failed to push: %w\n%s", s.phaseError(ctx, phaseCtx, phasePush, target.URL, err), output)
+	}
+
+	stats.Objects, stats.Bytes = parsePushTransfer(string(output))
+	return nil
+}
+
+// pushTagsToTarget pushes every tag fetched from the source to a target
+func (s *Syncer) pushTagsToTarget(ctx context.Context, repoDir string, target common.TargetConfig) error {
+	targetName, err := s.ensureRemote(ctx, repoDir, target.URL)
+	if err != nil {
+		return err
+	}
+
+	spec := "refs/tags/*:refs/tags/*"
+	if s.jobConfig.Override {
+		spec = "+" + spec
+	}
+
+	phaseCtx, cancel := s.phaseContext(ctx, phasePush)
+	defer cancel()
+
+	cmd := s.gitTarget(phaseCtx, target, "push", targetName, spec)
+	cmd.Dir = repoDir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to push tags: %w\n%s", s.phaseError(ctx, phaseCtx, phasePush, target.URL, err), output)
+	}
+
+	return nil
+}
+
+// ensureRemote adds or updates the git remote pointing at a target and returns its name
+func (s *Syncer) ensureRemote(ctx context.Context, repoDir, target string) (string, error) {
+	targetName := uniqueName(target)
+
+	if common.IsLocalRepository(target) {
+		if err := s.ensureLocalTarget(ctx, target); err != nil {
+			return "", err
+		}
+	}
+
+	if err := s.migrateLegacyRemote(ctx, repoDir, target, targetName); err != nil {
+		return "", err
+	}
+
+	cmd := s.git(ctx, "remote", "get-url", targetName)
 	cmd.Dir = repoDir
 	if _, err := cmd.CombinedOutput(); err != nil {
-		cmd = exec.CommandContext(ctx, "git", "remote", "add", targetName, target)
+		cmd = s.git(ctx, "remote", "add", targetName, target)
 		cmd.Dir = repoDir
 		if output, err := cmd.CombinedOutput(); err != nil {
-			return fmt.Errorf("failed to add remote: %w\n%s", err, output)
+			return "", fmt.Errorf("failed to add remote: %w\n%s", err, output)
 		}
 	} else {
-		cmd = exec.CommandContext(ctx, "git", "remote", "set-url", targetName, target)
+		cmd = s.git(ctx, "remote", "set-url", targetName, target)
 		cmd.Dir = repoDir
 		if output, err := cmd.CombinedOutput(); err != nil {
-			return fmt.Errorf("failed to update remote: %w\n%s", err, output)
+			return "", fmt.Errorf("failed to update remote: %w\n%s", err, output)
 		}
 	}
 
-	// Get current local commit hash
-	localCommit, err := s.getLatestCommit(ctx, repoDir)
-	if err != nil {
-		return fmt.Errorf("failed to get local commit hash: %w", err)
-	}
+	return targetName, nil
+}
 
-	// Get remote commit hash from target
-	remoteCommit, err := s.getRemoteCommitHash(ctx, repoDir, targetName, branch)
+// ensureLocalTarget creates a bare repository for a local target that does not exist yet
+func (s *Syncer) ensureLocalTarget(ctx context.Context, target string) error {
+	path := common.LocalRepositoryPath(target)
+
+	exists, err := dirExists(path)
 	if err != nil {
-		s.logger.Debug().Str("job", s.jobName).Str("branch", branch).Str("target", target).Msg("Could not get remote commit hash, proceeding with push")
-	} else if localCommit == remoteCommit {
-		// Hashes match, skip push
-		s.logger.Info().Str("job", s.jobName).Str("branch", branch).Str("target", target).Str("commit", localCommit).Msg("Skipping push - no changes detected (hashes match)")
+		return fmt.Errorf("failed to check local target %s: %w", path, err)
+	}
+	if exists {
 		return nil
 	}
 
-	// Use force push if override is enabled, otherwise regular push
-	if s.jobConfig.Override {
-		cmd = exec.CommandContext(ctx, "git", "push", targetName, fmt.Sprintf("%s:%s", branch, branch), "--force")
-	} else {
-		cmd = exec.CommandContext(ctx, "git", "push", targetName, fmt.Sprintf("%s:%s", branch, branch))
-	}
-	cmd.Dir = repoDir
+	s.logger.Info().Str("job", s.jobName).Str("target", target).Msg("Creating bare repository for local target")
+
+	cmd := s.git(ctx, "init", "--bare", path)
 	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to push: %w\n%s", err, output)
+		return fmt.Errorf("failed to create local target %s: %w\n%s", path, err, output)
 	}
 
 	return nil
 }
 
+// countCommits returns the number of commits reachable from rev
+func (s *Syncer) countCommits(ctx context.Context, repoDir, rev string) int {
+	cmd := s.git(ctx, "rev-list", "--count", rev)
+	cmd.Dir = repoDir
+	output, err := cmd.Output()
+	if err != nil {
+		return 0
+	}
+	count, _ := strconv.Atoi(strings.TrimSpace(string(output)))
+	return count
+}
+
+// countDivergence returns how many commits only the target has (behind) and
+// how many only the local branch has (ahead)
+func (s *Syncer) countDivergence(ctx context.Context, repoDir, remoteCommit, localCommit string) (int, int) {
+	cmd := s.git(ctx, "rev-list", "--left-right", "--count", fmt.Sprintf("%s...%s", remoteCommit, localCommit))
+	cmd.Dir = repoDir
+	output, err := cmd.Output()
+	if err != nil {
+		return 0, 0
+	}
+
+	fields := strings.Fields(string(output))
+	if len(fields) != 2 {
+		return 0, 0
+	}
+	behind, _ := strconv.Atoi(fields[0])
+	ahead, _ := strconv.Atoi(fields[1])
+	return behind, ahead
+}
+
 func (s *Syncer) getLatestCommit(ctx context.Context, repoDir string) (string, error) {
-	cmd := exec.CommandContext(ctx, "git", "rev-parse", "HEAD")
+	cmd := s.git(ctx, "rev-parse", "HEAD")
 	cmd.Dir = repoDir
 	output, err := cmd.Output()
 	if err != nil {
@@ -284,38 +815,67 @@ func (s *Syncer) getLatestCommit(ctx context.Context, repoDir string) (string, e
 	return strings.TrimSpace(string(output)), nil
 }
 
-func (s *Syncer) getRemoteCommitHash(ctx context.Context, repoDir, remoteName, branch string) (string, error) {
+// errRemoteBranchMissing reports a branch the target does not have yet, which
+// includes every branch of a freshly created empty repository
+var errRemoteBranchMissing = errors.New("branch does not exist on target")
+
+func (s *Syncer) getRemoteCommitHash(ctx context.Context, repoDir string, target common.TargetConfig, remoteName, branch string) (string, error) {
+	phaseCtx, cancel := s.phaseContext(ctx, phaseFetch)
+	defer cancel()
+
+	// Ask the target first so a missing branch or empty repository never reaches fetch
+	cmd := s.gitTarget(phaseCtx, target, "ls-remote", "--heads", remoteName, "refs/heads/"+branch)
+	cmd.Dir = repoDir
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to list remote %s: %w", remoteName, s.phaseError(ctx, phaseCtx, phaseFetch, target.URL, err))
+	}
+	if strings.TrimSpace(string(output)) == "" {
+		return "", errRemoteBranchMissing
+	}
+
 	// Fetch the remote to ensure we have the latest refs
-	cmd := exec.CommandContext(ctx, "git", "fetch", remoteName, branch)
+	cmd = s.gitTarget(phaseCtx, target, "fetch", remoteName, branch)
 	cmd.Dir = repoDir
-	if _, err := cmd.CombinedOutput(); err != nil {
-		return "", fmt.Errorf("failed to fetch remote %s: %w", remoteName, err)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		if strings.Contains(string(output), "couldn't find remote ref") {
+			return "", errRemoteBranchMissing
+		}
+		return "", fmt.Errorf("failed to fetch remote %s: %w", remoteName, s.phaseError(ctx, phaseCtx, phaseFetch, target.URL, err))
 	}
 
 	// Get the commit hash of the remote branch
-	cmd = exec.CommandContext(ctx, "git", "rev-parse", fmt.Sprintf("%s/%s", remoteName, branch))
+	cmd = s.git(ctx, "rev-parse", fmt.Sprintf("%s/%s", remoteName, branch))
 	cmd.Dir = repoDir
-	output, err := cmd.Output()
+	output, err = cmd.Output()
 	if err != nil {
 		return "", fmt.Errorf("failed to get remote commit hash: %w", err)
 	}
 	return strings.TrimSpace(string(output)), nil
 }
 
-func (s *Syncer) setupGitAuth() error {
-	if s.jobConfig.GitToken != "" && s.jobConfig.GitUsername != "" {
+// setupGitAuth resolves the job's token, reading it from its secrets
+// backend, and writes the askpass script handing it to git
+func (s *Syncer) setupGitAuth(ctx context.Context) error {
+	token, err := resolveGitToken(ctx, s.jobName, s.jobConfig)
+	if err != nil {
+		return err
+	}
+	s.gitToken = token
+
+	if s.gitToken != "" && s.jobConfig.GitUsername != "" {
+		// The script echoes the token from the environment of each git
+		// command, so the token is never written to disk
 		gitAskPass := filepath.Join(s.tempDir, "git-askpass.sh")
-		content := fmt.Sprintf("#!/bin/sh\necho '%s'", s.jobConfig.GitToken)
+		content := "#!/bin/sh\necho \"$" + askPassTokenVar + "\"\n"
 
-		if err := os.WriteFile(gitAskPass, []byte(content), 0755); err != nil {
+		if err := os.WriteFile(gitAskPass, []byte(content), 0700); err != nil {
 			return fmt.Errorf("failed to create askpass script: %w", err)
 		}
 
-		os.Setenv("GIT_ASKPASS", gitAskPass)
-	}
-
-	if s.jobConfig.SSHKeyPath != "" {
-		os.Setenv("GIT_SSH_COMMAND", fmt.Sprintf("ssh -i %s -o StrictHostKeyChecking=no", s.jobConfig.SSHKeyPath))
+		// Passed to each git command rather than the process environment so
+		// concurrent jobs never see each other's credentials
+		s.askPass = gitAskPass
 	}
 
 	return nil
@@ -336,52 +896,158 @@ func dirExists(path string) (bool, error) {
 	return false, err
 }
 
-// rewriteCommitAuthors rewrites commit history to replace author information
-func (s *Syncer) rewriteCommitAuthors(ctx context.Context, repoDir string) error {
-	s.logger.Info().Str("job", s.jobName).Int("replacements", len(s.jobConfig.AuthorReplace)).Msg("Rewriting commit authors")
+// resetLocalBranches points every local branch back at its origin counterpart
+// and deletes local branches the source no longer has, leaving HEAD detached
+func (s *Syncer) resetLocalBranches(ctx context.Context, repoDir string) error {
+	if err := s.removeOriginalRefs(ctx, repoDir); err != nil {
+		return err
+	}
+
+	cmd := s.git(ctx, "checkout", "--quiet", "--detach")
+	cmd.Dir = repoDir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to detach HEAD: %w\n%s", err, output)
+	}
+
+	cmd = s.git(ctx, "for-each-ref", "--format=%(refname:strip=2)", "refs/heads/")
+	cmd.Dir = repoDir
+	output, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("failed to list local branches: %w", err)
+	}
 
-	// Build the environment filter script for git filter-branch
-	var filterScript strings.Builder
-	for _, replacement := range s.jobConfig.AuthorReplace {
-		if replacement.FromEmail != "" {
-			filterScript.WriteString(fmt.Sprintf(`
-if [ "$GIT_AUTHOR_EMAIL" = "%s" ]; then
-    export GIT_AUTHOR_NAME="%s"
-    export GIT_AUTHOR_EMAIL="%s"
-    export GIT_COMMITTER_NAME="%s"
-    export GIT_COMMITTER_EMAIL="%s"
-fi`, replacement.FromEmail, replacement.ToName, replacement.ToEmail, replacement.ToName, replacement.ToEmail))
+	for _, branch := range strings.Fields(string(output)) {
+		cmd = s.git(ctx, "rev-parse", "--verify", "--quiet", "refs/remotes/origin/"+branch)
+		cmd.Dir = repoDir
+		if originCommit, err := cmd.Output(); err == nil {
+			cmd = s.git(ctx, "update-ref", "refs/heads/"+branch, strings.TrimSpace(string(originCommit)))
+		} else {
+			cmd = s.git(ctx, "update-ref", "-d", "refs/heads/"+branch)
 		}
-		if replacement.FromName != "" && replacement.FromEmail == "" {
-			filterScript.WriteString(fmt.Sprintf(`
-if [ "$GIT_AUTHOR_NAME" = "%s" ]; then
-    export GIT_AUTHOR_NAME="%s"
-    export GIT_AUTHOR_EMAIL="%s"
-    export GIT_COMMITTER_NAME="%s"
-    export GIT_COMMITTER_EMAIL="%s"
-fi`, replacement.FromName, replacement.ToName, replacement.ToEmail, replacement.ToName, replacement.ToEmail))
+		cmd.Dir = repoDir
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to reset local branch %s: %w\n%s", branch, err, output)
 		}
 	}
 
-	if filterScript.Len() == 0 {
-		return nil // No replacements to make
-	}
+	return nil
+}
 
-	// Execute git filter-branch with the environment filter
-	cmd := exec.CommandContext(ctx, "git", "filter-branch", "-f", "--env-filter", filterScript.String(), "--", "--all")
+// removeOriginalRefs deletes the refs/original/ backups left by filter-branch
+func (s *Syncer) removeOriginalRefs(ctx context.Context, repoDir string) error {
+	cmd := s.git(ctx, "for-each-ref", "--format=%(refname)", "refs/original/")
 	cmd.Dir = repoDir
+	output, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("failed to list filter-branch backups: %w", err)
+	}
 
-	output, err := cmd.CombinedOutput()
+	for _, ref := range strings.Fields(string(output)) {
+		cmd = s.git(ctx, "update-ref", "-d", ref)
+		cmd.Dir = repoDir
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to delete %s: %w\n%s", ref, err, output)
+		}
+	}
+
+	return nil
+}
+
+// pushStats describes what a single branch push to a target transferred
+type pushStats struct {
+	Skipped   bool
+	OldCommit string
+	Ahead     int
+	Behind    int
+	Objects   int
+	Bytes     int64
+}
+
+var writingObjectsPattern = regexp.MustCompile(`Writing objects: 100% \((\d+)/\d+\), ([\d.]+) (bytes|KiB|MiB|GiB)`)
+
+// parsePushTransfer extracts the object count and transfer size from git push progress output
+func parsePushTransfer(output string) (int, int64) {
+	matches := writingObjectsPattern.FindAllStringSubmatch(output, -1)
+	if len(matches) == 0 {
+		return 0, 0
+	}
+	last := matches[len(matches)-1]
+
+	objects, _ := strconv.Atoi(last[1])
+	size, _ := strconv.ParseFloat(last[2], 64)
+	switch last[3] {
+	case "KiB":
+		size *= 1024
+	case "MiB":
+		size *= 1024 * 1024
+	case "GiB":
+		size *= 1024 * 1024 * 1024
+	}
+
+	return objects, int64(size)
+}
+
+// migrateLegacyRemote moves a remote created under the old, collision-prone
+// name to its unique name. A legacy remote pointing elsewhere belonged to a
+// colliding target and is dropped, its owner re-adds it under its own name.
+func (s *Syncer) migrateLegacyRemote(ctx context.Context, repoDir, target, targetName string) error {
+	legacyName := sanitizeName(target)
+
+	cmd := s.git(ctx, "remote", "get-url", legacyName)
+	cmd.Dir = repoDir
+	output, err := cmd.Output()
 	if err != nil {
-		return fmt.Errorf("git filter-branch failed: %w\nOutput: %s", err, string(output))
+		return nil
 	}
 
-	s.logger.Info().Str("job", s.jobName).Msg("Successfully rewrote commit authors")
+	cmd = s.git(ctx, "remote", "get-url", targetName)
+	cmd.Dir = repoDir
+	_, missing := cmd.Output()
 
+	if strings.TrimSpace(string(output)) == target && missing != nil {
+		cmd = s.git(ctx, "remote", "rename", legacyName, targetName)
+	} else {
+		cmd = s.git(ctx, "remote", "remove", legacyName)
+	}
+	cmd.Dir = repoDir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to migrate remote %s: %w\n%s", legacyName, err, output)
+	}
+
+	s.logger.Debug().Str("job", s.jobName).Str("target", target).Str("remote", targetName).Msg("Migrated legacy remote name")
 	return nil
 }
 
+// migrateLegacyPath renames a cache entry created under its old name, unless
+// the new one already exists
+func migrateLegacyPath(legacy, path string) error {
+	if legacy == path {
+		return nil
+	}
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if _, err := os.Stat(legacy); err != nil {
+		return nil
+	}
+	return os.Rename(legacy, path)
+}
+
+// uniqueName extends sanitizeName with a short hash of the full name, so URLs
+// that sanitize to the same string still get distinct remotes and directories
+func uniqueName(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return sanitizeName(name) + "-" + hex.EncodeToString(sum[:4])
+}
+
 func sanitizeName(name string) string {
+	if common.IsLocalRepository(name) {
+		path := strings.TrimPrefix(name, "file://")
+		path = strings.TrimSuffix(strings.TrimRight(path, `/\`), ".git")
+		path = strings.Trim(strings.NewReplacer("/", "-", `\`, "-", ":", "-", ".", "-").Replace(path), "-")
+		return "local-" + path
+	}
+
 	name = strings.ReplaceAll(name, "https://", "")
 	name = strings.ReplaceAll(name, "http://", "")
 	name = strings.ReplaceAll(name, "git@", "")
@@ -0,0 +1,369 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyncAllMirrorsBranches(t *testing.T) {
+	f := newFixture(t)
+	f.commit("feature/login", "login form")
+	f.commit("feature/search", "search box")
+	f.commit("experiment", "wild idea")
+	target := f.path("target.git")
+
+	s := f.syncer(
+		"source = "+quote(f.source),
+		"targets = ["+quote(target)+"]",
+		`branches = ["main", "feature/*"]`,
+	)
+	result, err := s.SyncAll(context.Background())
+	if err != nil {
+		t.Fatalf("SyncAll: %v", err)
+	}
+	if pushed := result.Count(StatusPushed); pushed != 3 {
+		t.Errorf("pushed %d entries, want main and both features: %+v", pushed, result.Entries)
+	}
+
+	source, got := f.refs(f.source), f.refs(target)
+	for _, ref := range []string{"refs/heads/main", "refs/heads/feature/login", "refs/heads/feature/search"} {
+		if got[ref] != source[ref] {
+			t.Errorf("target %s = %q, want %q", ref, got[ref], source[ref])
+		}
+	}
+	if _, ok := got["refs/heads/experiment"]; ok {
+		t.Error("target has experiment, which matches no pattern")
+	}
+
+	// A new commit moves only its branch
+	moved := f.commit("feature/login", "login validation")
+	if _, err := s.SyncAll(context.Background()); err != nil {
+		t.Fatalf("second SyncAll: %v", err)
+	}
+	after := f.refs(target)
+	if after["refs/heads/feature/login"] != moved {
+		t.Errorf("target feature/login = %q, want %q", after["refs/heads/feature/login"], moved)
+	}
+	if after["refs/heads/main"] != got["refs/heads/main"] || after["refs/heads/feature/search"] != got["refs/heads/feature/search"] {
+		t.Errorf("unchanged branches moved: before %v, after %v", got, after)
+	}
+}
+
+func TestSyncAllRejectsDivergedTargetWithoutOverride(t *testing.T) {
+	f := newFixture(t)
+	target := f.path("target.git")
+	f.git(f.root, "init", "-q", "--bare", target)
+	unrelated := f.git(f.source, "commit-tree", "HEAD^{tree}", "-m", "unrelated")
+	f.git(f.source, "push", "-q", target, unrelated+":refs/heads/main")
+
+	s := f.syncer("source = "+quote(f.source), "targets = ["+quote(target)+"]")
+	result, err := s.SyncAll(context.Background())
+	if err == nil || len(result.Failed()) != 1 {
+		t.Fatalf("SyncAll = %v with entries %+v, want the push of main rejected", err, result.Entries)
+	}
+	if got := f.refs(target)["refs/heads/main"]; got != unrelated {
+		t.Errorf("target main = %q, want it left at %q", got, unrelated)
+	}
+
+	s = f.syncer("source = "+quote(f.source), "targets = ["+quote(target)+"]", "override = true")
+	if _, err := s.SyncAll(context.Background()); err != nil {
+		t.Fatalf("SyncAll with override: %v", err)
+	}
+	if got, want := f.refs(target)["refs/heads/main"], f.refs(f.source)["refs/heads/main"]; got != want {
+		t.Errorf("target main = %q after override, want %q", got, want)
+	}
+}
+
+func TestSyncAllLocalRoundTrip(t *testing.T) {
+	f := newFixture(t)
+	f.commit("develop", "work in progress")
+
+	// A bare source as an internal git server would serve it
+	source := f.path("upstream.git")
+	f.git(f.root, "init", "-q", "--bare", source)
+	f.git(f.source, "push", "-q", source, "main", "develop")
+
+	// One target created by the syncer, one existing bare repository addressed by a file:// URL
+	created := f.path("mirrors/created.git")
+	existing := f.path("existing.git")
+	f.git(f.root, "init", "-q", "--bare", existing)
+	existingURL := "file://" + filepath.ToSlash(existing)
+	if !strings.HasPrefix(existingURL, "file:///") {
+		existingURL = "file:///" + strings.TrimPrefix(existingURL, "file://")
+	}
+
+	s := f.syncer(
+		"source = "+quote(source),
+		"targets = ["+quote(created)+", "+quote(existingURL)+"]",
+		`branches = ["*"]`,
+	)
+	result, err := s.SyncAll(context.Background())
+	if err != nil {
+		t.Fatalf("SyncAll: %v", err)
+	}
+	if pushed := result.Count(StatusPushed); pushed != 4 {
+		t.Errorf("pushed %d entries, want both branches to both targets: %+v", pushed, result.Entries)
+	}
+
+	want := f.refs(source)
+	for _, target := range []string{created, existing} {
+		got := f.refs(target)
+		for _, ref := range []string{"refs/heads/main", "refs/heads/develop"} {
+			if got[ref] != want[ref] {
+				t.Errorf("%s %s = %q, want %q", target, ref, got[ref], want[ref])
+			}
+		}
+	}
+	if bare := f.git(created, "rev-parse", "--is-bare-repository"); bare != "true" {
+		t.Errorf("created target is bare = %s, want true", bare)
+	}
+}
+
+func TestPushBranchToEmptyTarget(t *testing.T) {
+	f := newFixture(t)
+	second := f.commit("main", "second")
+	target := f.path("empty.git")
+	f.git(f.root, "init", "-q", "--bare", target)
+
+	s := f.syncer("source = "+quote(f.source), "targets = ["+quote(target)+"]")
+	jobTarget := s.jobConfig.Targets[0]
+	ctx := context.Background()
+
+	remote, err := s.ensureRemote(ctx, f.source, target)
+	if err != nil {
+		t.Fatalf("ensureRemote: %v", err)
+	}
+	if _, err := s.getRemoteCommitHash(ctx, f.source, jobTarget, remote, "main"); !errors.Is(err, errRemoteBranchMissing) {
+		t.Fatalf("getRemoteCommitHash on an empty target = %v, want errRemoteBranchMissing", err)
+	}
+
+	stats, err := s.pushBranch(ctx, f.source, jobTarget, "main", second, false)
+	if err != nil {
+		t.Fatalf("pushBranch: %v", err)
+	}
+	if stats.Skipped || stats.OldCommit != "" || stats.Ahead != 2 || stats.Behind != 0 {
+		t.Errorf("stats = %+v, want both commits pushed over nothing", stats)
+	}
+	if got := f.refs(target)["refs/heads/main"]; got != second {
+		t.Errorf("target main = %q, want %q", got, second)
+	}
+
+	hash, err := s.getRemoteCommitHash(ctx, f.source, jobTarget, remote, "main")
+	if err != nil || hash != second {
+		t.Errorf("getRemoteCommitHash after the push = %q, %v, want %q", hash, err, second)
+	}
+	if stats, err = s.pushBranch(ctx, f.source, jobTarget, "main", second, false); err != nil || !stats.Skipped {
+		t.Errorf("second pushBranch = %+v, %v, want it skipped", stats, err)
+	}
+}
+
+func TestSyncAllRewriteTwiceIsNoOp(t *testing.T) {
+	f := newFixture(t)
+	// filter-branch otherwise waits ten seconds after its deprecation warning
+	t.Setenv("FILTER_BRANCH_SQUELCH_WARNING", "1")
+	f.commit("main", "second")
+	f.commit("feature/login", "login form")
+	target := f.path("target.git")
+
+	s := f.syncer(
+		"source = "+quote(f.source),
+		"targets = ["+quote(target)+"]",
+		`branches = ["main", "feature/*"]`,
+		"rewrite_history = true",
+		"override = true",
+		"",
+		"[[fixture.author_replace]]",
+		`from_email = "test@example.com"`,
+		`to_email = "mirror@example.com"`,
+		`to_name = "Mirror"`,
+	)
+	result, err := s.SyncAll(context.Background())
+	if err != nil {
+		t.Fatalf("SyncAll: %v", err)
+	}
+	if pushed := result.Count(StatusPushed); pushed != 2 {
+		t.Fatalf("pushed %d entries, want main and feature/login: %+v", pushed, result.Entries)
+	}
+	before := f.refs(target)
+	if author := f.git(target, "log", "--format=%an <%ae>", "-1", "main"); author != "Mirror <mirror@example.com>" {
+		t.Errorf("target main author = %q, want the replacement", author)
+	}
+	if before["refs/heads/main"] == f.refs(f.source)["refs/heads/main"] {
+		t.Error("target main has the source commit, want it rewritten")
+	}
+
+	result, err = s.SyncAll(context.Background())
+	if err != nil {
+		t.Fatalf("second SyncAll: %v", err)
+	}
+	if len(result.Entries) != 2 || result.Count(StatusSkipped) != len(result.Entries) {
+		t.Errorf("second run entries = %+v, want both skipped", result.Entries)
+	}
+	after := f.refs(target)
+	if len(after) != len(before) {
+		t.Errorf("target refs = %v after the second run, want %v", after, before)
+	}
+	for ref, hash := range before {
+		if after[ref] != hash {
+			t.Errorf("target %s moved from %q to %q on the second run", ref, hash, after[ref])
+		}
+	}
+
+	for _, dir := range []string{s.repoDir(), target} {
+		if refs := f.git(dir, "for-each-ref", "refs/original/"); refs != "" {
+			t.Errorf("%s keeps filter-branch backups:\n%s", dir, refs)
+		}
+	}
+}
+
+func TestBranchPriorityOrder(t *testing.T) {
+	f := newFixture(t)
+	for _, branch := range []string{"alpha", "zeta", "release/2", "release/1", "hotfix"} {
+		f.git(f.source, "branch", branch, "main")
+	}
+	target := f.path("target.git")
+
+	s := f.syncer(
+		"source = "+quote(f.source),
+		"targets = ["+quote(target)+"]",
+		`branches = ["*"]`,
+		`branch_priority = ["release/*", "zeta", "main"]`,
+	)
+	want := []string{"release/1", "release/2", "zeta", "main", "alpha", "hotfix"}
+
+	result, err := s.SyncAll(context.Background())
+	if err != nil {
+		t.Fatalf("SyncAll: %v", err)
+	}
+	var pushed []string
+	for _, entry := range result.Entries {
+		pushed = append(pushed, entry.Branch)
+	}
+	if strings.Join(pushed, ",") != strings.Join(want, ",") {
+		t.Errorf("entries in order %v, want %v", pushed, want)
+	}
+
+	branches, err := s.getBranchesToSync(context.Background(), s.repoDir())
+	if err != nil {
+		t.Fatalf("getBranchesToSync: %v", err)
+	}
+	if strings.Join(branches, ",") != strings.Join(want, ",") {
+		t.Errorf("getBranchesToSync = %v, want %v", branches, want)
+	}
+
+	// A job aborted before its first push reaches none of them
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = s.syncBranches(ctx, s.repoDir(), branches, &SyncResult{})
+	if err == nil || !strings.Contains(err.Error(), "6 branches not synced") {
+		t.Errorf("syncBranches after cancel = %v, want all 6 branches not synced", err)
+	}
+}
+
+// cancelOnBranch cancels the run once asked to validate its branch
+type cancelOnBranch struct {
+	branch string
+	cancel context.CancelFunc
+}
+
+func (v cancelOnBranch) ValidatePush(ctx context.Context, push PendingPush) error {
+	if push.Branch == v.branch {
+		v.cancel()
+	}
+	return nil
+}
+
+func TestSyncAbortReportsBranchesNotReached(t *testing.T) {
+	notReached := func(t *testing.T, logs *logCapture) []string {
+		t.Helper()
+		event, ok := logs.find("Job aborted before all branches were synced")
+		if !ok {
+			t.Fatal("abort not logged")
+		}
+		var branches []string
+		list, _ := event.Fields["branches_not_reached"].([]interface{})
+		for _, branch := range list {
+			branches = append(branches, branch.(string))
+		}
+		return branches
+	}
+
+	t.Run("while preparing", func(t *testing.T) {
+		f := newFixture(t)
+		for _, branch := range []string{"alpha", "zeta", "hotfix"} {
+			f.git(f.source, "branch", branch, "main")
+		}
+		s := f.syncer(
+			"source = "+quote(f.source),
+			"targets = ["+quote(f.path("target.git"))+"]",
+			`branches = ["*"]`,
+			`branch_priority = ["zeta"]`,
+		)
+		logs := captureLogs(t)
+
+		// Branches go zeta, alpha, hotfix, main: the run ends after alpha is prepared
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		s.SetValidator(cancelOnBranch{branch: "alpha", cancel: cancel})
+		result, err := s.SyncAll(ctx)
+		if err == nil || !strings.Contains(err.Error(), "job aborted with 2 branches not synced") {
+			t.Fatalf("SyncAll = %v, want 2 branches not synced", err)
+		}
+		if got := strings.Join(notReached(t, logs), ","); got != "hotfix,main" {
+			t.Errorf("branches_not_reached = %s, want hotfix,main", got)
+		}
+		if len(result.Entries) != 0 {
+			t.Errorf("entries = %+v, want none pushed", result.Entries)
+		}
+	})
+
+	t.Run("while pushing", func(t *testing.T) {
+		f := newFixture(t)
+		for _, branch := range []string{"alpha", "zeta"} {
+			f.git(f.source, "branch", branch, "main")
+		}
+		first, second := f.path("first.git"), f.path("second.git")
+		f.git(f.root, "init", "-q", "--bare", first)
+
+		// The first target holds its push until the run is cancelled, then refuses it
+		started, release := f.holdPushes(first)
+
+		s := f.syncer(
+			"source = "+quote(f.source),
+			"targets = ["+quote(first)+", "+quote(second)+"]",
+			`branches = ["*"]`,
+		)
+		logs := captureLogs(t)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			for !started() {
+				time.Sleep(20 * time.Millisecond)
+			}
+			cancel()
+			release()
+		}()
+
+		result, err := s.SyncAll(ctx)
+		if err == nil || !strings.Contains(err.Error(), "job aborted with 3 branches not synced") {
+			t.Fatalf("SyncAll = %v, want 3 branches not synced", err)
+		}
+		if got := strings.Join(notReached(t, logs), ","); got != "alpha,main,zeta" {
+			t.Errorf("branches_not_reached = %s, want alpha,main,zeta", got)
+		}
+		// The push to the first target ran and failed, the second got none
+		for _, entry := range result.Entries {
+			if entry.Target != first || entry.Status != StatusFailed {
+				t.Errorf("entry %+v, want only failed pushes to the first target", entry)
+			}
+		}
+		if len(result.Entries) != 3 {
+			t.Errorf("%d entries, want the 3 failed pushes to the first target", len(result.Entries))
+		}
+	})
+}
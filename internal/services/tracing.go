@@ -0,0 +1,130 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/ternarybob/gitsync/internal/common"
+)
+
+// tracer creates the spans of job runs. Until InitTracing installs a provider
+// it is the global no-op tracer.
+var tracer = otel.Tracer("github.com/ternarybob/gitsync")
+
+// InitTracing installs an OTLP/HTTP exporting tracer provider when tracing is
+// configured. The returned function flushes and stops it, it is a no-op when
+// tracing is disabled.
+func InitTracing(ctx context.Context, cfg *common.Config) (func(context.Context) error, error) {
+	if cfg.Tracing.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	opts := []otlptracehttp.Option{}
+	if strings.Contains(cfg.Tracing.Endpoint, "://") {
+		opts = append(opts, otlptracehttp.WithEndpointURL(cfg.Tracing.Endpoint))
+	} else {
+		opts = append(opts, otlptracehttp.WithEndpoint(cfg.Tracing.Endpoint))
+	}
+	if cfg.Tracing.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+
+	res := resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(cfg.Service.Name),
+		semconv.ServiceVersion(common.GetVersion()),
+		semconv.DeploymentEnvironment(cfg.Service.Environment),
+	)
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.Tracing.SampleRatio))),
+		sdktrace.WithIDGenerator(runIDGenerator{}),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+type runIDKey struct{}
+
+// newRunID returns a random run ID, formatted like a trace ID
+func newRunID() string {
+	var id trace.TraceID
+	rand.Read(id[:])
+	return id.String()
+}
+
+// withRunID attaches a run ID to the context of a job run
+func withRunID(ctx context.Context, runID string) context.Context {
+	return context.WithValue(ctx, runIDKey{}, runID)
+}
+
+// runIDFrom returns the run ID of the context, empty when none is attached
+func runIDFrom(ctx context.Context) string {
+	runID, _ := ctx.Value(runIDKey{}).(string)
+	return runID
+}
+
+// runIDGenerator uses the run ID of the context as trace ID, so the run_id in
+// logs finds the run's trace
+type runIDGenerator struct{}
+
+func (runIDGenerator) NewIDs(ctx context.Context) (trace.TraceID, trace.SpanID) {
+	traceID, err := trace.TraceIDFromHex(runIDFrom(ctx))
+	if err != nil {
+		rand.Read(traceID[:])
+	}
+	return traceID, newSpanID()
+}
+
+func (runIDGenerator) NewSpanID(ctx context.Context, traceID trace.TraceID) trace.SpanID {
+	return newSpanID()
+}
+
+func newSpanID() trace.SpanID {
+	var id trace.SpanID
+	rand.Read(id[:])
+	return id
+}
+
+// endSpan records the outcome of a span and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, firstLine(err.Error()))
+	}
+	span.End()
+}
+
+// entryAttributes describes the outcome of a recorded push
+func entryAttributes(entry *SyncEntry) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("gitsync.outcome", entry.Status),
+		attribute.String("gitsync.commit", entry.NewCommit),
+		attribute.Int("gitsync.commits_pushed", entry.CommitsPushed),
+		attribute.Int("gitsync.commits_overwritten", entry.CommitsOverwritten),
+		attribute.Int("gitsync.objects_pushed", entry.Objects),
+		attribute.Int64("gitsync.bytes_pushed", entry.Bytes),
+	}
+}
+
+func firstLine(s string) string {
+	return strings.SplitN(s, "\n", 2)[0]
+}
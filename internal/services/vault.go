@@ -0,0 +1,260 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ternarybob/gitsync/internal/common"
+)
+
+// vaultTimeout bounds one request to Vault
+const vaultTimeout = 15 * time.Second
+
+// vaults holds a client per [vault] configuration, shared by the jobs so
+// the login and the secrets they read are cached across runs. Nothing read
+// from Vault is written to disk.
+var vaults = struct {
+	sync.Mutex
+	clients map[common.VaultConfig]*vaultClient
+}{clients: make(map[common.VaultConfig]*vaultClient)}
+
+// vaultClient reads secrets with a token it renews, or logs in again for,
+// once half its TTL has passed
+type vaultClient struct {
+	config common.VaultConfig
+	client *http.Client
+
+	mu          sync.Mutex
+	token       string
+	tokenIssued time.Time
+	tokenTTL    time.Duration // Zero for a token that does not expire
+	renewable   bool
+	secrets     map[string]cachedSecret
+}
+
+type cachedSecret struct {
+	value   string
+	expires time.Time
+}
+
+// vaultResponse holds the fields of Vault responses the client reads
+type vaultResponse struct {
+	LeaseDuration int                    `json:"lease_duration"`
+	Data          map[string]interface{} `json:"data"`
+	Auth          *struct {
+		ClientToken   string `json:"client_token"`
+		LeaseDuration int    `json:"lease_duration"`
+		Renewable     bool   `json:"renewable"`
+	} `json:"auth"`
+}
+
+// readVaultSecret returns the field of a Vault secret, referenced as
+// path#field
+func readVaultSecret(ctx context.Context, config *common.VaultConfig, ref string) (string, error) {
+	client, err := vaultFor(config)
+	if err != nil {
+		return "", err
+	}
+	return client.secret(ctx, ref)
+}
+
+func vaultFor(config *common.VaultConfig) (*vaultClient, error) {
+	vaults.Lock()
+	defer vaults.Unlock()
+	if client, ok := vaults.clients[*config]; ok {
+		return client, nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if config.CABundlePath != "" || config.TLSSkipVerify {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: config.TLSSkipVerify}
+		if config.CABundlePath != "" {
+			pem, err := os.ReadFile(config.CABundlePath)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates in CA bundle %s", config.CABundlePath)
+			}
+			transport.TLSClientConfig.RootCAs = pool
+		}
+	}
+
+	client := &vaultClient{
+		config:  *config,
+		client:  &http.Client{Timeout: vaultTimeout, Transport: transport},
+		secrets: make(map[string]cachedSecret),
+	}
+	vaults.clients[*config] = client
+	return client, nil
+}
+
+// secret returns a field of a secret, from the cache while its TTL lasts. A
+// KV version 2 secret has its fields under data.data, version 1 under data.
+func (v *vaultClient) secret(ctx context.Context, ref string) (string, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if cached, ok := v.secrets[ref]; ok && time.Now().Before(cached.expires) {
+		return cached.value, nil
+	}
+
+	secretPath, field := common.VaultSecretRef(ref)
+	var resp vaultResponse
+	status, err := v.authorizedCall(ctx, http.MethodGet, "/v1/"+secretPath, nil, &resp)
+	// A token revoked before its TTL ran out is replaced once
+	if status == http.StatusForbidden && v.config.AuthMethod == common.VaultAuthKubernetes {
+		v.token = ""
+		status, err = v.authorizedCall(ctx, http.MethodGet, "/v1/"+secretPath, nil, &resp)
+	}
+	if status == http.StatusNotFound {
+		return "", fmt.Errorf("secret %s does not exist", secretPath)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	fields := resp.Data
+	if nested, ok := fields["data"].(map[string]interface{}); ok {
+		fields = nested
+	}
+	value, ok := fields[field].(string)
+	if !ok || value == "" {
+		return "", fmt.Errorf("secret %s has no field %s", secretPath, field)
+	}
+
+	ttl := v.config.CacheTTL
+	if lease := time.Duration(resp.LeaseDuration) * time.Second; lease > 0 && lease < ttl {
+		ttl = lease
+	}
+	v.secrets[ref] = cachedSecret{value: value, expires: time.Now().Add(ttl)}
+	return value, nil
+}
+
+// authorizedCall sends a request with a token that is logged in, or renewed,
+// first when needed
+func (v *vaultClient) authorizedCall(ctx context.Context, method, path string, body, out interface{}) (int, error) {
+	if err := v.ensureToken(ctx); err != nil {
+		return 0, err
+	}
+	return v.call(ctx, method, path, body, out)
+}
+
+// ensureToken logs in with the kubernetes auth method when there is no token
+// or half its TTL passed, and renews a renewable token of the token auth
+// method once half its TTL passed
+func (v *vaultClient) ensureToken(ctx context.Context) error {
+	halfway := v.tokenTTL > 0 && time.Since(v.tokenIssued) > v.tokenTTL/2
+
+	switch v.config.AuthMethod {
+	case common.VaultAuthKubernetes:
+		if v.token != "" && !halfway {
+			return nil
+		}
+		jwt, err := os.ReadFile(v.config.JWTPath)
+		if err != nil {
+			return fmt.Errorf("failed to read service account token: %w", err)
+		}
+		login := map[string]string{"role": v.config.Role, "jwt": strings.TrimSpace(string(jwt))}
+		v.token = ""
+		return v.authenticate(ctx, "/v1/auth/"+v.config.AuthMount+"/login", login, "log in")
+
+	default:
+		if v.token == "" {
+			v.token = v.config.Token
+			return v.authenticate(ctx, "/v1/auth/token/lookup-self", nil, "look up token")
+		}
+		if halfway && v.renewable {
+			return v.authenticate(ctx, "/v1/auth/token/renew-self", map[string]string{}, "renew token")
+		}
+		return nil
+	}
+}
+
+// authenticate calls an auth endpoint and keeps the token it returns with
+// its TTL. lookup-self answers with data instead of auth.
+func (v *vaultClient) authenticate(ctx context.Context, path string, body interface{}, action string) error {
+	method := http.MethodPost
+	if body == nil {
+		method = http.MethodGet
+	}
+	var resp vaultResponse
+	if _, err := v.call(ctx, method, path, body, &resp); err != nil {
+		if v.config.AuthMethod == common.VaultAuthToken {
+			v.token = ""
+		}
+		return fmt.Errorf("failed to %s: %w", action, err)
+	}
+
+	v.tokenIssued = time.Now()
+	switch {
+	case resp.Auth != nil:
+		if resp.Auth.ClientToken != "" {
+			v.token = resp.Auth.ClientToken
+		}
+		v.tokenTTL = time.Duration(resp.Auth.LeaseDuration) * time.Second
+		v.renewable = resp.Auth.Renewable
+	case resp.Data != nil:
+		ttl, _ := resp.Data["ttl"].(float64)
+		v.tokenTTL = time.Duration(ttl) * time.Second
+		v.renewable, _ = resp.Data["renewable"].(bool)
+	}
+	return nil
+}
+
+// call sends a request to Vault with the current token. It returns the
+// response status, a status of 300 or more is also an error carrying the
+// errors Vault gave.
+func (v *vaultClient) call(ctx context.Context, method, path string, body, out interface{}) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, v.config.Address+path, reader)
+	if err != nil {
+		return 0, err
+	}
+	if v.token != "" {
+		req.Header.Set("X-Vault-Token", v.token)
+	}
+	if v.config.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.config.Namespace)
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var failure struct {
+			Errors []string `json:"errors"`
+		}
+		excerpt, _ := io.ReadAll(io.LimitReader(resp.Body, maxExcerpt))
+		if json.Unmarshal(excerpt, &failure) == nil && len(failure.Errors) > 0 {
+			return resp.StatusCode, fmt.Errorf("unexpected response status %s: %s", resp.Status, strings.Join(failure.Errors, "; "))
+		}
+		return resp.StatusCode, fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp.StatusCode, fmt.Errorf("failed to decode response: %w", err)
+		}
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,190 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/ternarybob/gitsync/internal/common"
+)
+
+// maxWebhookBody bounds the payload read from a webhook request
+const maxWebhookBody = 10 << 20
+
+type webhookResponse struct {
+	Status string   `json:"status"`
+	Jobs   []string `json:"jobs,omitempty"`
+}
+
+// githubPushEvent holds the parts of a GitHub push payload used to find jobs
+type githubPushEvent struct {
+	Ref        string `json:"ref"`
+	Repository struct {
+		FullName string `json:"full_name"`
+		CloneURL string `json:"clone_url"`
+		SSHURL   string `json:"ssh_url"`
+		GitURL   string `json:"git_url"`
+	} `json:"repository"`
+}
+
+// gitlabPushEvent holds the parts of a GitLab push or tag push payload used to find jobs
+type gitlabPushEvent struct {
+	ObjectKind string `json:"object_kind"`
+	Ref        string `json:"ref"`
+	Project    struct {
+		PathWithNamespace string `json:"path_with_namespace"`
+		GitHTTPURL        string `json:"git_http_url"`
+		GitSSHURL         string `json:"git_ssh_url"`
+	} `json:"project"`
+}
+
+// registerWebhooks adds the webhook routes whose secrets are configured
+func (s *Server) registerWebhooks(mux *http.ServeMux) {
+	if s.config.Server.GitHubSecret != "" {
+		mux.HandleFunc("POST /webhooks/github", s.handleGitHubWebhook)
+	}
+	if s.config.Server.GitLabSecret != "" {
+		mux.HandleFunc("POST /webhooks/gitlab", s.handleGitLabWebhook)
+	}
+}
+
+func (s *Server) handleGitHubWebhook(w http.ResponseWriter, r *http.Request) {
+	logger := common.GetLogger()
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBody))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "failed to read request body"})
+		return
+	}
+
+	if !validGitHubSignature(s.config.Server.GitHubSecret, r.Header.Get("X-Hub-Signature-256"), body) {
+		logger.Warn().Str("remote_addr", r.RemoteAddr).Msg("Rejected GitHub webhook with invalid signature")
+		writeJSON(w, http.StatusUnauthorized, apiError{Error: "invalid signature"})
+		return
+	}
+
+	switch event := r.Header.Get("X-GitHub-Event"); event {
+	case "ping":
+		writeJSON(w, http.StatusOK, webhookResponse{Status: "pong"})
+		return
+	case "push":
+	default:
+		logger.Debug().Str("event", event).Msg("Ignoring GitHub webhook event")
+		writeJSON(w, http.StatusAccepted, webhookResponse{Status: "ignored"})
+		return
+	}
+
+	var event githubPushEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "invalid push payload"})
+		return
+	}
+
+	jobs := s.triggerJobsForRepository(false, event.Repository.CloneURL, event.Repository.SSHURL, event.Repository.GitURL)
+	if len(jobs) == 0 {
+		logger.Info().Str("repository", event.Repository.FullName).Str("ref", event.Ref).Msg("GitHub push for a repository no enabled job syncs, ignoring")
+		writeJSON(w, http.StatusAccepted, webhookResponse{Status: "no matching job"})
+		return
+	}
+
+	logger.Info().Str("repository", event.Repository.FullName).Str("ref", event.Ref).Strs("jobs", jobs).Msg("GitHub push received, queueing jobs")
+	writeJSON(w, http.StatusAccepted, webhookResponse{Status: "queued", Jobs: jobs})
+}
+
+func (s *Server) handleGitLabWebhook(w http.ResponseWriter, r *http.Request) {
+	logger := common.GetLogger()
+
+	token := r.Header.Get("X-Gitlab-Token")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.config.Server.GitLabSecret)) != 1 {
+		logger.Warn().Str("remote_addr", r.RemoteAddr).Msg("Rejected GitLab webhook with invalid token")
+		writeJSON(w, http.StatusUnauthorized, apiError{Error: "invalid token"})
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBody))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "failed to read request body"})
+		return
+	}
+
+	var event gitlabPushEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "invalid push payload"})
+		return
+	}
+
+	if event.ObjectKind != "push" && event.ObjectKind != "tag_push" {
+		logger.Debug().Str("event", event.ObjectKind).Msg("Ignoring GitLab webhook event")
+		writeJSON(w, http.StatusAccepted, webhookResponse{Status: "ignored"})
+		return
+	}
+
+	// Tag pushes only concern jobs that push tags
+	tagPush := event.ObjectKind == "tag_push"
+	jobs := s.triggerJobsForRepository(tagPush, event.Project.GitHTTPURL, event.Project.GitSSHURL)
+	if len(jobs) == 0 {
+		logger.Info().Str("repository", event.Project.PathWithNamespace).Str("ref", event.Ref).Str("event", event.ObjectKind).Msg("GitLab push for a repository no enabled job syncs, ignoring")
+		writeJSON(w, http.StatusAccepted, webhookResponse{Status: "no matching job"})
+		return
+	}
+
+	logger.Info().Str("repository", event.Project.PathWithNamespace).Str("ref", event.Ref).Strs("jobs", jobs).Msg("GitLab push received, queueing jobs")
+	writeJSON(w, http.StatusAccepted, webhookResponse{Status: "queued", Jobs: jobs})
+}
+
+// validGitHubSignature checks an X-Hub-Signature-256 header ("sha256=<hex>")
+// against the HMAC of the body
+func validGitHubSignature(secret, header string, body []byte) bool {
+	signature, ok := strings.CutPrefix(header, "sha256=")
+	if !ok {
+		return false
+	}
+	provided, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(provided, mac.Sum(nil))
+}
+
+// triggerJobsForRepository queues every enabled job whose source is one of the
+// given URLs and returns their names. With tagsOnly, jobs that do not push tags
+// are left alone.
+func (s *Server) triggerJobsForRepository(tagsOnly bool, urls ...string) []string {
+	// Jobs come from the scheduler, which sees configuration reloads
+	cfg := s.scheduler.Config()
+	var jobs []string
+	for _, jobName := range cfg.GetEnabledJobs() {
+		jobConfig, _ := cfg.GetJobConfig(jobName)
+		if tagsOnly && !jobConfig.SyncsTags() {
+			continue
+		}
+		if pushedToSource(jobConfig, urls) {
+			if !s.scheduler.EnqueueJob(jobName) {
+				common.GetLogger().Debug().Str("job", jobName).Msg("Run already queued, coalescing webhook")
+			}
+			jobs = append(jobs, jobName)
+		}
+	}
+	return jobs
+}
+
+// pushedToSource reports whether any of the URLs of a pushed repository is a
+// source of the job
+func pushedToSource(jobConfig *common.JobConfig, urls []string) bool {
+	for _, url := range urls {
+		for _, source := range jobConfig.SourceURLs() {
+			if url != "" && common.SameRepository(url, source) {
+				return true
+			}
+		}
+	}
+	return false
+}
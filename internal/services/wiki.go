@@ -0,0 +1,145 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ternarybob/gitsync/internal/common"
+)
+
+// syncWiki mirrors the branches of the source's wiki repository to the wikis
+// of the git targets, recording entries with Wiki set. Pushes go through the
+// same validation and history as branches. A source without a wiki is
+// skipped.
+func (s *Syncer) syncWiki(ctx context.Context, result *SyncResult) {
+	wikiURL := common.WikiURL(s.jobConfig.Source)
+	wikiDir := filepath.Join(s.tempDir, uniqueName(wikiURL))
+
+	var targets []common.TargetConfig
+	for _, target := range s.targets() {
+		if common.IsBundleURL(target.URL) {
+			continue
+		}
+		target.URL = common.WikiURL(target.URL)
+		targets = append(targets, target)
+	}
+	if len(targets) == 0 {
+		return
+	}
+
+	output, err := s.fetchWiki(ctx, wikiURL, wikiDir)
+	if err != nil {
+		if isMissingRepository(string(output)) {
+			s.logger.Debug().Str("job", s.jobName).Str("wiki", wikiURL).Msg("Source has no wiki, skipping")
+			return
+		}
+		for _, target := range targets {
+			s.record(result, SyncEntry{Target: target.URL, Wiki: true, err: fmt.Errorf("failed to fetch wiki: %w\n%s", err, output)})
+		}
+		return
+	}
+
+	cmd := s.git(ctx, "for-each-ref", "--format=%(objectname) %(refname:short)", "refs/heads/")
+	cmd.Dir = wikiDir
+	refs, err := cmd.Output()
+	if err != nil {
+		for _, target := range targets {
+			s.record(result, SyncEntry{Target: target.URL, Wiki: true, err: fmt.Errorf("failed to list wiki branches: %w", err)})
+		}
+		return
+	}
+	if len(strings.TrimSpace(string(refs))) == 0 {
+		s.logger.Debug().Str("job", s.jobName).Str("wiki", wikiURL).Msg("Source wiki has no pages, skipping")
+		return
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(string(refs)), "\n") {
+		commit, branch, _ := strings.Cut(line, " ")
+		s.syncWikiBranch(ctx, wikiDir, targets, branch, commit, result)
+	}
+}
+
+// syncWikiBranch pushes one wiki branch to the wikis of the targets
+func (s *Syncer) syncWikiBranch(ctx context.Context, wikiDir string, targets []common.TargetConfig, branch, commit string, result *SyncResult) {
+	err := s.validatePush(ctx, targets, PendingPush{Job: s.jobName, RepoDir: wikiDir, Branch: branch, NewCommit: commit, Wiki: true})
+	blocked := err != nil && ctx.Err() == nil
+
+	for _, target := range targets {
+		entry := SyncEntry{Branch: branch, Target: target.URL, NewCommit: commit, Wiki: true, err: err}
+		if err != nil {
+			if blocked {
+				entry.Status = StatusBlocked
+			}
+			s.record(result, entry)
+			continue
+		}
+
+		startTime := time.Now()
+		entry.tx = s.beginTransaction(entry)
+		stats, pushErr := s.pushBranch(ctx, wikiDir, target, branch, commit, false)
+		entry.Duration = time.Since(startTime)
+		if pushErr != nil {
+			entry.err = pushErr
+			s.record(result, entry)
+			continue
+		}
+
+		entry.OldCommit = stats.OldCommit
+		entry.Status = StatusPushed
+		if stats.Skipped {
+			entry.Status = StatusSkipped
+		} else {
+			entry.CommitsPushed = stats.Ahead
+			entry.CommitsOverwritten = stats.Behind
+			entry.Objects = stats.Objects
+			entry.Bytes = stats.Bytes
+		}
+		s.record(result, entry)
+	}
+}
+
+// fetchWiki clones the wiki repository bare on the first run and fetches it
+// afterwards, its local branches following those of the source wiki
+func (s *Syncer) fetchWiki(ctx context.Context, wikiURL, wikiDir string) ([]byte, error) {
+	phaseCtx, cancel := s.phaseContext(ctx, phaseFetch)
+	defer cancel()
+
+	exists, err := dirExists(wikiDir)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		cmd := s.git(phaseCtx, "clone", "--bare", wikiURL, wikiDir)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return output, s.phaseError(ctx, phaseCtx, phaseFetch, wikiURL, err)
+		}
+		// A bare clone fetches nothing by default
+		cmd = s.git(ctx, "config", "remote.origin.fetch", "+refs/heads/*:refs/heads/*")
+		cmd.Dir = wikiDir
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return output, err
+		}
+	}
+
+	cmd := s.git(phaseCtx, "fetch", "--prune", "origin")
+	cmd.Dir = wikiDir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return output, s.phaseError(ctx, phaseCtx, phaseFetch, wikiURL, err)
+	}
+	return nil, nil
+}
+
+// isMissingRepository reports whether git output says the remote repository
+// does not exist, the way hosts answer for a wiki that was never created
+func isMissingRepository(output string) bool {
+	output = strings.ToLower(output)
+	for _, message := range []string{"not found", "could not be found", "does not appear to be a git repository", "does not exist"} {
+		if strings.Contains(output, message) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Signature is what git reported for a commit's signature: Status is the %G?
+// letter, such as G for a good signature or N for none, Key and PrimaryKey
+// the fingerprints of the signing key and its primary key
+type Signature struct {
+	Status     string `json:"status"`
+	Key        string `json:"key,omitempty"`
+	PrimaryKey string `json:"primary_key,omitempty"`
+}
+
+// signatureKey keys a commit's signature by the scope it was verified in, the
+// same commit verifies differently against another allowed signers file
+func signatureKey(scope, commit string) []byte {
+	return []byte(scope + "\x00" + commit)
+}
+
+// GetSignatures returns the recorded signatures of the commits verified in
+// scope before, commits never verified are left out
+func (s *Store) GetSignatures(scope string, commits []string) (map[string]Signature, error) {
+	signatures := make(map[string]Signature)
+
+	err := s.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket(s.signatures)
+		for _, commit := range commits {
+			v := b.Get(signatureKey(scope, commit))
+			if v == nil {
+				continue
+			}
+			var signature Signature
+			if err := json.Unmarshal(v, &signature); err != nil {
+				return fmt.Errorf("failed to decode signature of %s: %w", commit, err)
+			}
+			signatures[commit] = signature
+		}
+		return nil
+	})
+
+	return signatures, err
+}
+
+// SaveSignatures records the signatures of commits verified in scope
+func (s *Store) SaveSignatures(scope string, signatures map[string]Signature) error {
+	return s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(s.signatures)
+		for commit, signature := range signatures {
+			data, err := json.Marshal(signature)
+			if err != nil {
+				return fmt.Errorf("failed to encode signature of %s: %w", commit, err)
+			}
+			if err := b.Put(signatureKey(scope, commit), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,435 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Transaction statuses
+const (
+	StatusRunning  = "running"
+	StatusSuccess  = "success"
+	StatusFailed   = "failed"
+	StatusSkipped  = "skipped"
+	StatusDiverged = "diverged"
+	StatusBlocked  = "blocked"
+)
+
+// Transaction is the persisted record of one branch or ref pushed to one target
+type Transaction struct {
+	ID         string        `json:"id"`
+	JobName    string        `json:"job_name"`
+	RunID      string        `json:"run_id,omitempty"` // The job run that made the push
+	Branch     string        `json:"branch,omitempty"`
+	Ref        string        `json:"ref,omitempty"`
+	Target     string        `json:"target"`
+	Status     string        `json:"status"`
+	CommitHash string        `json:"commit_hash,omitempty"`
+	OldCommit  string        `json:"old_commit,omitempty"`
+	StartTime  time.Time     `json:"start_time"`
+	EndTime    time.Time     `json:"end_time,omitempty"`
+	Duration   time.Duration `json:"duration_ns"`
+	Error      string        `json:"error,omitempty"`
+}
+
+// lockTimeout is how long an operation waits for another process, such as an
+// export running next to the service, to release the database file
+const lockTimeout = 30 * time.Second
+
+// Store persists sync transactions in a bbolt database. A Store is safe for
+// concurrent use by multiple jobs. The file is only held open, and locked,
+// while operations are in flight, so other gitsync processes can read it
+// while the service is idle between writes.
+type Store struct {
+	path       string
+	bucket     []byte
+	index      []byte
+	signatures []byte
+
+	mu    sync.Mutex
+	db    *bolt.DB
+	users int
+}
+
+// Open opens or creates the database at path
+func Open(path, bucketName string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create store directory: %w", err)
+	}
+
+	s := &Store{
+		path:       path,
+		bucket:     []byte(bucketName),
+		index:      []byte(bucketName + "_by_job"),
+		signatures: []byte(bucketName + "_signatures"),
+	}
+
+	if err := s.update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(s.bucket)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(s.signatures); err != nil {
+			return err
+		}
+		if tx.Bucket(s.index) != nil {
+			return nil
+		}
+
+		// Stores written before the job index existed are backfilled once
+		index, err := tx.CreateBucket(s.index)
+		if err != nil {
+			return err
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var t Transaction
+			if err := json.Unmarshal(v, &t); err != nil {
+				return fmt.Errorf("failed to decode transaction %s: %w", k, err)
+			}
+			return index.Put(indexKey(&t), k)
+		})
+	}); err != nil {
+		return nil, fmt.Errorf("failed to prepare store %s: %w", path, err)
+	}
+
+	return s, nil
+}
+
+// acquire opens the database file unless another operation already has it open
+func (s *Store) acquire() (*bolt.DB, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.db == nil {
+		db, err := bolt.Open(s.path, 0600, &bolt.Options{Timeout: lockTimeout})
+		if err != nil {
+			return nil, fmt.Errorf("failed to open store %s: %w", s.path, err)
+		}
+		s.db = db
+	}
+	s.users++
+	return s.db, nil
+}
+
+// release closes the database file once the last operation is done with it
+func (s *Store) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.users--
+	if s.users == 0 && s.db != nil {
+		s.db.Close()
+		s.db = nil
+	}
+}
+
+func (s *Store) view(fn func(*bolt.Tx) error) error {
+	db, err := s.acquire()
+	if err != nil {
+		return err
+	}
+	defer s.release()
+	return db.View(fn)
+}
+
+func (s *Store) update(fn func(*bolt.Tx) error) error {
+	db, err := s.acquire()
+	if err != nil {
+		return err
+	}
+	defer s.release()
+	return db.Update(fn)
+}
+
+// indexKey orders a job's transactions newest first: job name, a zero byte,
+// the inverted start time and the ID to keep keys unique
+func indexKey(t *Transaction) []byte {
+	key := make([]byte, 0, len(t.JobName)+1+8+len(t.ID))
+	key = append(key, t.JobName...)
+	key = append(key, 0)
+	key = binary.BigEndian.AppendUint64(key, math.MaxUint64-uint64(t.StartTime.UnixNano()))
+	return append(key, t.ID...)
+}
+
+// jobPrefix is the index key prefix shared by all transactions of a job
+func jobPrefix(jobName string) []byte {
+	return append([]byte(jobName), 0)
+}
+
+// walkJob calls fn for each transaction of a job, newest first, until fn
+// returns false
+func (s *Store) walkJob(tx *bolt.Tx, jobName string, fn func(*Transaction) bool) error {
+	b := tx.Bucket(s.bucket)
+	prefix := jobPrefix(jobName)
+
+	c := tx.Bucket(s.index).Cursor()
+	for k, id := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, id = c.Next() {
+		v := b.Get(id)
+		if v == nil {
+			continue
+		}
+		var t Transaction
+		if err := json.Unmarshal(v, &t); err != nil {
+			return fmt.Errorf("failed to decode transaction %s: %w", id, err)
+		}
+		if !fn(&t) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// Close releases the database file if an operation still holds it
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.db == nil {
+		return nil
+	}
+	err := s.db.Close()
+	s.db = nil
+	return err
+}
+
+// Path returns the database file location
+func (s *Store) Path() string {
+	return s.path
+}
+
+// SaveTransaction inserts or updates a transaction, assigning an ID to new ones.
+// A new transaction never replaces an existing record.
+func (s *Store) SaveTransaction(t *Transaction) error {
+	isNew := t.ID == ""
+
+	return s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(s.bucket)
+		index := tx.Bucket(s.index)
+
+		if isNew {
+			id, err := generateID()
+			if err != nil {
+				return err
+			}
+			if b.Get([]byte(id)) != nil {
+				return fmt.Errorf("transaction id %s already exists", id)
+			}
+			t.ID = id
+		} else if v := b.Get([]byte(t.ID)); v != nil {
+			// Drop the index entry of the previous version, its start time may differ
+			var previous Transaction
+			if err := json.Unmarshal(v, &previous); err == nil {
+				if err := index.Delete(indexKey(&previous)); err != nil {
+					return err
+				}
+			}
+		}
+
+		data, err := json.Marshal(t)
+		if err != nil {
+			return fmt.Errorf("failed to encode transaction: %w", err)
+		}
+		if err := b.Put([]byte(t.ID), data); err != nil {
+			return err
+		}
+		return index.Put(indexKey(t), []byte(t.ID))
+	})
+}
+
+// GetTransactionsByJob returns the most recent transactions of a job, newest
+// first. A limit of zero returns all of them.
+func (s *Store) GetTransactionsByJob(jobName string, limit int) ([]*Transaction, error) {
+	var transactions []*Transaction
+
+	err := s.view(func(tx *bolt.Tx) error {
+		return s.walkJob(tx, jobName, func(t *Transaction) bool {
+			transactions = append(transactions, t)
+			return limit <= 0 || len(transactions) < limit
+		})
+	})
+
+	return transactions, err
+}
+
+// GetLastSuccessfulSync returns the newest successful transaction of a job, or
+// nil when the job never synced successfully
+func (s *Store) GetLastSuccessfulSync(jobName string) (*Transaction, error) {
+	var last *Transaction
+
+	err := s.view(func(tx *bolt.Tx) error {
+		return s.walkJob(tx, jobName, func(t *Transaction) bool {
+			if t.Status == StatusSuccess {
+				last = t
+				return false
+			}
+			return true
+		})
+	})
+
+	return last, err
+}
+
+// ForEachTransaction calls fn for every transaction started in [from, to),
+// oldest first, inside a single read transaction so the export is a consistent
+// snapshot. Zero times leave that end of the range open.
+func (s *Store) ForEachTransaction(from, to time.Time, fn func(*Transaction) error) error {
+	return s.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(s.bucket).ForEach(func(k, v []byte) error {
+			var t Transaction
+			if err := json.Unmarshal(v, &t); err != nil {
+				return fmt.Errorf("failed to decode transaction %s: %w", k, err)
+			}
+			if !from.IsZero() && t.StartTime.Before(from) {
+				return nil
+			}
+			if !to.IsZero() && !t.StartTime.Before(to) {
+				return nil
+			}
+			return fn(&t)
+		})
+	})
+}
+
+// SyncKey identifies a branch pushed to a target
+type SyncKey struct {
+	Branch string
+	Target string
+}
+
+// GetLastSyncedCommits returns, per branch and target of a job, the commit the
+// newest successful or skipped transaction left the target at
+func (s *Store) GetLastSyncedCommits(jobName string) (map[SyncKey]string, error) {
+	commits := make(map[SyncKey]string)
+
+	err := s.view(func(tx *bolt.Tx) error {
+		return s.walkJob(tx, jobName, func(t *Transaction) bool {
+			if t.Branch == "" || t.CommitHash == "" {
+				return true
+			}
+			if t.Status != StatusSuccess && t.Status != StatusSkipped {
+				return true
+			}
+			key := SyncKey{Branch: t.Branch, Target: t.Target}
+			if _, seen := commits[key]; !seen {
+				commits[key] = t.CommitHash
+			}
+			return true
+		})
+	})
+
+	return commits, err
+}
+
+// deleteBatchSize bounds the keys removed per write transaction, so cleanup
+// never holds the database lock for long and can stop between batches
+const deleteBatchSize = 1000
+
+// CleanupOldTransactions deletes transactions started before the cutoff and
+// returns how many were removed. It stops early when ctx is cancelled.
+func (s *Store) CleanupOldTransactions(ctx context.Context, cutoff time.Time) (int, error) {
+	var expired [][]byte
+
+	err := s.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(s.bucket).ForEach(func(k, v []byte) error {
+			var t Transaction
+			if err := json.Unmarshal(v, &t); err != nil {
+				return fmt.Errorf("failed to decode transaction %s: %w", k, err)
+			}
+			if t.StartTime.Before(cutoff) {
+				expired = append(expired, append([]byte(nil), k...))
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return s.deleteKeys(ctx, expired)
+}
+
+// TrimTransactions deletes the oldest transactions until at most max remain
+// and returns how many were removed. It stops early when ctx is cancelled.
+func (s *Store) TrimTransactions(ctx context.Context, max int) (int, error) {
+	var oldest [][]byte
+
+	err := s.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket(s.bucket)
+		excess := b.Stats().KeyN - max
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil && len(oldest) < excess; k, _ = c.Next() {
+			oldest = append(oldest, append([]byte(nil), k...))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return s.deleteKeys(ctx, oldest)
+}
+
+// Size returns the database file size in bytes
+func (s *Store) Size() int64 {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// deleteKeys removes keys in batches and returns how many were deleted
+func (s *Store) deleteKeys(ctx context.Context, keys [][]byte) (int, error) {
+	removed := 0
+	for start := 0; start < len(keys); start += deleteBatchSize {
+		if err := ctx.Err(); err != nil {
+			return removed, err
+		}
+
+		batch := keys[start:min(start+deleteBatchSize, len(keys))]
+		err := s.update(func(tx *bolt.Tx) error {
+			b := tx.Bucket(s.bucket)
+			index := tx.Bucket(s.index)
+			for _, k := range batch {
+				var t Transaction
+				if v := b.Get(k); v != nil && json.Unmarshal(v, &t) == nil {
+					if err := index.Delete(indexKey(&t)); err != nil {
+						return err
+					}
+				}
+				if err := b.Delete(k); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return removed, err
+		}
+		removed += len(batch)
+	}
+	return removed, nil
+}
+
+// generateID returns a UUIDv7: 48 bits of millisecond time followed by
+// crypto/rand bits, with ordering kept monotonic within the process. The
+// <bucket>_by_job index keys end with the ID, which keeps them unique when
+// two transactions of a job start at the same time.
+func generateID() (string, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate transaction id: %w", err)
+	}
+	return id.String(), nil
+}
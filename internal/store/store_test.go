@@ -0,0 +1,154 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestGenerateIDUniqueAndOrdered(t *testing.T) {
+	const workers, perWorker = 10, 1000
+
+	ids := make([][]string, workers)
+	errs := make(chan error, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				id, err := generateID()
+				if err != nil {
+					errs <- err
+					return
+				}
+				ids[w] = append(ids[w], id)
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	seen := make(map[string]bool, workers*perWorker)
+	for w, generated := range ids {
+		for i, id := range generated {
+			if seen[id] {
+				t.Fatalf("id %s generated twice", id)
+			}
+			seen[id] = true
+			// Each goroutine sees the IDs it asks for in increasing order
+			if i > 0 && id <= generated[i-1] {
+				t.Fatalf("worker %d: id %s after %s, want increasing", w, id, generated[i-1])
+			}
+		}
+	}
+	if len(seen) != workers*perWorker {
+		t.Errorf("generated %d unique ids, want %d", len(seen), workers*perWorker)
+	}
+}
+
+// benchmarkStore returns a store of 20 busy jobs with 1000 transactions each,
+// interleaved, and a rare job whose 50 transactions are the oldest
+func benchmarkStore(b *testing.B) *Store {
+	b.Helper()
+	s, err := Open(filepath.Join(b.TempDir(), "gitsync.db"), "transactions")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { s.Close() })
+
+	start := time.Now().Add(-24 * time.Hour)
+	var jobs []string
+	for i := 0; i < 50; i++ {
+		jobs = append(jobs, "rare")
+	}
+	for i := 0; i < 1000; i++ {
+		for j := 0; j < 20; j++ {
+			jobs = append(jobs, fmt.Sprintf("busy-%02d", j))
+		}
+	}
+
+	err = s.update(func(tx *bolt.Tx) error {
+		bucket, index := tx.Bucket(s.bucket), tx.Bucket(s.index)
+		for i, job := range jobs {
+			id, err := generateID()
+			if err != nil {
+				return err
+			}
+			t := &Transaction{ID: id, JobName: job, Branch: "main", Target: "https://example.com/repo.git", Status: StatusSuccess, CommitHash: id, StartTime: start.Add(time.Duration(i) * time.Second)}
+			data, err := json.Marshal(t)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(id), data); err != nil {
+				return err
+			}
+			if err := index.Put(indexKey(t), []byte(id)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	return s
+}
+
+// scanTransactionsByJob is the lookup without the job index: every
+// transaction is decoded, newest first, until limit of the job are found
+func (s *Store) scanTransactionsByJob(jobName string, limit int) ([]*Transaction, error) {
+	var transactions []*Transaction
+	err := s.view(func(tx *bolt.Tx) error {
+		c := tx.Bucket(s.bucket).Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			var t Transaction
+			if err := json.Unmarshal(v, &t); err != nil {
+				return fmt.Errorf("failed to decode transaction %s: %w", k, err)
+			}
+			if t.JobName != jobName {
+				continue
+			}
+			transactions = append(transactions, &t)
+			if limit > 0 && len(transactions) >= limit {
+				break
+			}
+		}
+		return nil
+	})
+	return transactions, err
+}
+
+func BenchmarkGetTransactionsByJob(b *testing.B) {
+	s := benchmarkStore(b)
+	for _, job := range []string{"busy-07", "rare"} {
+		b.Run(job, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if transactions, err := s.GetTransactionsByJob(job, 50); err != nil || len(transactions) != 50 {
+					b.Fatalf("GetTransactionsByJob = %d, %v, want 50", len(transactions), err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkScanTransactionsByJob(b *testing.B) {
+	s := benchmarkStore(b)
+	for _, job := range []string{"busy-07", "rare"} {
+		b.Run(job, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if transactions, err := s.scanTransactionsByJob(job, 50); err != nil || len(transactions) != 50 {
+					b.Fatalf("scanTransactionsByJob = %d, %v, want 50", len(transactions), err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package gitsync_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/ternarybob/gitsync/pkg/gitsync"
+)
+
+func ExampleSync() {
+	cfg, err := gitsync.LoadConfig("gitsync.toml")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+	result, err := gitsync.Sync(ctx, cfg, "main-sync")
+	if result != nil {
+		for _, entry := range result.Entries {
+			fmt.Println(entry.Branch, entry.Target, entry.Status)
+		}
+	}
+	if errors.Is(err, gitsync.ErrCredentialsBackend) {
+		log.Fatal("secrets backend unavailable: ", err)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func ExampleScheduler_Run() {
+	cfg, err := gitsync.LoadConfig("gitsync.toml")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if err := gitsync.NewScheduler(cfg).Run(ctx); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,194 @@
+// Package gitsync runs gitsync jobs from Go programs, without the binary.
+//
+// A configuration is loaded from a gitsync.toml file, environment variables
+// applied, the same way the binary loads it. Sync runs one job once and
+// returns a structured Result; a Scheduler runs the enabled jobs on their
+// schedules until its context is done.
+//
+// The package never configures the global logger or changes the process
+// environment: runs log to the logger given with WithLogger, or else to
+// gitsync's default console logger. Clones are kept under the gitsync
+// directory of os.TempDir, shared with the binary, and no transaction history
+// is recorded.
+//
+// The package follows semantic versioning with the module: within a major
+// version, exported identifiers and the fields of the types below are only
+// ever added.
+package gitsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ternarybob/arbor"
+
+	"github.com/ternarybob/gitsync/internal/common"
+	"github.com/ternarybob/gitsync/internal/services"
+)
+
+type (
+	// Config is a loaded configuration
+	Config = common.Config
+	// JobConfig is the configuration of one job
+	JobConfig = common.JobConfig
+	// TargetConfig is a target of a job with its own settings
+	TargetConfig = common.TargetConfig
+	// Result is the outcome of one run of a job
+	Result = services.SyncResult
+	// Entry is the outcome of pushing one branch or ref to one target
+	Entry = services.SyncEntry
+	// Validator decides whether a branch may be pushed, see WithValidator
+	Validator = services.PushValidator
+	// PendingPush is a range of commits of a branch about to be pushed
+	PendingPush = services.PendingPush
+)
+
+// Status of an Entry
+const (
+	StatusPushed   = services.StatusPushed
+	StatusSkipped  = services.StatusSkipped
+	StatusFailed   = services.StatusFailed
+	StatusDiverged = services.StatusDiverged
+	StatusBlocked  = services.StatusBlocked
+)
+
+var (
+	// ErrJobNotFound is returned for a job the configuration does not define
+	ErrJobNotFound = errors.New("job not found")
+	// ErrJobRunning is returned by Scheduler.RunJob while the job runs
+	ErrJobRunning = services.ErrJobRunning
+	// ErrCredentialsBackend is wrapped by the error of a run whose token
+	// could not be read from Vault, Secrets Manager or Parameter Store
+	ErrCredentialsBackend = services.ErrCredentialsBackend
+	// ErrForcedShutdown is returned by Scheduler.Run when jobs still running
+	// after the shutdown grace period were cancelled
+	ErrForcedShutdown = errors.New("shutdown grace period expired, running jobs were cancelled")
+)
+
+// Version returns the version of gitsync
+func Version() string {
+	return common.GetVersion()
+}
+
+// LoadConfig loads and validates a configuration file
+func LoadConfig(path string) (*Config, error) {
+	return common.Load(path)
+}
+
+// Option changes how jobs run
+type Option func(*options)
+
+type options struct {
+	logger    arbor.ILogger
+	validator Validator
+}
+
+// WithLogger makes runs log to logger
+func WithLogger(logger arbor.ILogger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithValidator makes runs ask v for every range of commits a branch is about
+// to push, after the job's pre_push_command passed. An error blocks the branch
+// on every target, recorded with StatusBlocked.
+func WithValidator(v Validator) Option {
+	return func(o *options) {
+		o.validator = v
+	}
+}
+
+func newOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// Sync runs a job of cfg once, disabled or not. The result is returned
+// whenever the job started; the error is non-nil when the job failed outright
+// or any push failed. Cancelling ctx stops the run.
+func Sync(ctx context.Context, cfg *Config, jobName string, opts ...Option) (*Result, error) {
+	syncer, err := NewSyncer(cfg, jobName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return syncer.Sync(ctx)
+}
+
+// Syncer runs one job. A Syncer keeps the clone of its job between runs, and
+// runs one at a time: Sync must not be called concurrently.
+type Syncer struct {
+	syncer *services.Syncer
+}
+
+// NewSyncer creates the syncer of a job of cfg
+func NewSyncer(cfg *Config, jobName string, opts ...Option) (*Syncer, error) {
+	jobConfig, exists := cfg.GetJobConfig(jobName)
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrJobNotFound, jobName)
+	}
+	syncer, err := services.NewSyncer(jobName, jobConfig, nil)
+	if err != nil {
+		return nil, err
+	}
+	o := newOptions(opts)
+	if o.logger != nil {
+		syncer.SetLogger(o.logger)
+	}
+	syncer.SetValidator(o.validator)
+	return &Syncer{syncer: syncer}, nil
+}
+
+// Sync runs the job once, see Sync
+func (s *Syncer) Sync(ctx context.Context) (*Result, error) {
+	return s.syncer.SyncAll(ctx)
+}
+
+// Scheduler runs the enabled jobs of a configuration on their schedules
+type Scheduler struct {
+	scheduler *services.Scheduler
+}
+
+// NewScheduler creates a scheduler of the jobs of cfg
+func NewScheduler(cfg *Config, opts ...Option) *Scheduler {
+	scheduler := services.NewScheduler(cfg, nil)
+	o := newOptions(opts)
+	if o.logger != nil {
+		scheduler.SetLogger(o.logger)
+	}
+	scheduler.SetValidator(o.validator)
+	return &Scheduler{scheduler: scheduler}
+}
+
+// Run schedules the enabled jobs and blocks until ctx is done. Running jobs
+// then get the shutdown_grace_period of the configuration to finish before
+// they are cancelled and ErrForcedShutdown is returned. A scheduler runs once.
+func (s *Scheduler) Run(ctx context.Context) error {
+	if err := s.scheduler.Start(); err != nil {
+		return err
+	}
+	<-ctx.Done()
+	if !s.scheduler.Stop(s.scheduler.Config().Service.ShutdownGracePeriod) {
+		return ErrForcedShutdown
+	}
+	return nil
+}
+
+// RunJob runs a job now, outside its schedule, and returns its result. A
+// disabled job only runs with force. It fails with ErrJobRunning while a run
+// of the job is in progress. Cancelling ctx, or the scheduler stopping,
+// stops the run.
+func (s *Scheduler) RunJob(ctx context.Context, jobName string, force bool) (*Result, error) {
+	return s.scheduler.RunJobContext(ctx, jobName, force, services.RunFilter{})
+}
+
+// Reload applies a new configuration to the scheduled jobs: new jobs are
+// scheduled, removed ones unscheduled and changed ones rescheduled
+func (s *Scheduler) Reload(cfg *Config) error {
+	_, err := s.scheduler.Reload(cfg)
+	return err
+}
@@ -0,0 +1,396 @@
+package gitsync_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ternarybob/gitsync/pkg/gitsync"
+)
+
+// runGit runs git in dir and returns its trimmed output
+func runGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, output)
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// setup creates a source repository with one commit on main and a config
+// syncing it to a local target, with extra lines added to the job, and
+// returns the config, target and commit
+func setup(t *testing.T, extra ...string) (*gitsync.Config, string, string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	root := t.TempDir()
+	t.Setenv("TMPDIR", filepath.Join(root, "tmp"))
+	t.Setenv("GIT_AUTHOR_NAME", "Test")
+	t.Setenv("GIT_AUTHOR_EMAIL", "test@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "Test")
+	t.Setenv("GIT_COMMITTER_EMAIL", "test@example.com")
+
+	source := filepath.Join(root, "source")
+	runGit(t, root, "init", "-q", "-b", "main", source)
+	if err := os.WriteFile(filepath.Join(source, "README"), []byte("hello\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, source, "add", "README")
+	runGit(t, source, "commit", "-q", "-m", "initial")
+	commit := runGit(t, source, "rev-parse", "HEAD")
+
+	target := filepath.Join(root, "target.git")
+	configPath := filepath.Join(root, "gitsync.toml")
+	config := `[jobs]
+names = ["mirror"]
+every = "1h"
+
+[mirror]
+source = "` + filepath.ToSlash(source) + `"
+targets = ["` + filepath.ToSlash(target) + `"]
+branches = ["main"]
+` + strings.Join(extra, "\n") + "\n"
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := gitsync.LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	return cfg, target, commit
+}
+
+func TestSync(t *testing.T) {
+	cfg, target, commit := setup(t)
+
+	result, err := gitsync.Sync(context.Background(), cfg, "mirror")
+	if err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if len(result.Entries) != 1 || result.Entries[0].Status != gitsync.StatusPushed {
+		t.Fatalf("entries = %+v, want one pushed", result.Entries)
+	}
+	if got := runGit(t, target, "rev-parse", "main"); got != commit {
+		t.Errorf("target main = %s, want %s", got, commit)
+	}
+}
+
+func TestSyncJobNotFound(t *testing.T) {
+	cfg, _, _ := setup(t)
+
+	if _, err := gitsync.Sync(context.Background(), cfg, "missing"); !errors.Is(err, gitsync.ErrJobNotFound) {
+		t.Errorf("Sync of a missing job: err = %v, want ErrJobNotFound", err)
+	}
+}
+
+func TestSchedulerRunJob(t *testing.T) {
+	cfg, target, commit := setup(t)
+
+	scheduler := gitsync.NewScheduler(cfg)
+	result, err := scheduler.RunJob(context.Background(), "mirror", false)
+	if err != nil {
+		t.Fatalf("RunJob: %v", err)
+	}
+	if result.Count(gitsync.StatusPushed) != 1 {
+		t.Errorf("entries = %+v, want one pushed", result.Entries)
+	}
+	if got := runGit(t, target, "rev-parse", "main"); got != commit {
+		t.Errorf("target main = %s, want %s", got, commit)
+	}
+}
+
+// recorder records the pushes it is asked about and answers with err
+type recorder struct {
+	err    error
+	pushes []gitsync.PendingPush
+}
+
+func (r *recorder) ValidatePush(_ context.Context, push gitsync.PendingPush) error {
+	r.pushes = append(r.pushes, push)
+	return r.err
+}
+
+func TestSyncValidatorBlocks(t *testing.T) {
+	cfg, target, commit := setup(t)
+
+	validator := &recorder{err: errors.New("secret found")}
+	result, err := gitsync.Sync(context.Background(), cfg, "mirror", gitsync.WithValidator(validator))
+	if err == nil {
+		t.Fatal("Sync succeeded with a blocked branch")
+	}
+	if len(result.Entries) != 1 || result.Entries[0].Status != gitsync.StatusBlocked {
+		t.Fatalf("entries = %+v, want one blocked", result.Entries)
+	}
+	if !strings.Contains(result.Entries[0].Error, "secret found") {
+		t.Errorf("error = %q, want the validator's reason", result.Entries[0].Error)
+	}
+	if len(validator.pushes) != 1 {
+		t.Fatalf("validator asked %d times, want once", len(validator.pushes))
+	}
+	if push := validator.pushes[0]; push.Branch != "main" || push.OldCommit != "" || push.NewCommit != commit || push.Range() != commit {
+		t.Errorf("push = %+v, want main new to the target at %s", push, commit)
+	}
+	if _, err := exec.Command("git", "--git-dir", target, "rev-parse", "--verify", "main").Output(); err == nil {
+		t.Error("blocked branch reached the target")
+	}
+}
+
+func TestSyncValidatorRange(t *testing.T) {
+	cfg, target, oldCommit := setup(t)
+	if _, err := gitsync.Sync(context.Background(), cfg, "mirror"); err != nil {
+		t.Fatalf("first Sync: %v", err)
+	}
+
+	jobConfig, _ := cfg.GetJobConfig("mirror")
+	source := jobConfig.Source
+	runGit(t, source, "commit", "-q", "--allow-empty", "-m", "second")
+	newCommit := runGit(t, source, "rev-parse", "HEAD")
+
+	validator := &recorder{}
+	if _, err := gitsync.Sync(context.Background(), cfg, "mirror", gitsync.WithValidator(validator)); err != nil {
+		t.Fatalf("second Sync: %v", err)
+	}
+	if len(validator.pushes) != 1 || validator.pushes[0].Range() != oldCommit+".."+newCommit {
+		t.Fatalf("pushes = %+v, want the range %s..%s", validator.pushes, oldCommit, newCommit)
+	}
+	if got := runGit(t, target, "rev-parse", "main"); got != newCommit {
+		t.Errorf("target main = %s, want %s", got, newCommit)
+	}
+
+	// Nothing to push, nothing to validate
+	validator.pushes = nil
+	if _, err := gitsync.Sync(context.Background(), cfg, "mirror", gitsync.WithValidator(validator)); err != nil {
+		t.Fatalf("third Sync: %v", err)
+	}
+	if len(validator.pushes) != 0 {
+		t.Errorf("pushes = %+v, want none for an unchanged branch", validator.pushes)
+	}
+}
+
+func TestSyncPrePushCommand(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not installed")
+	}
+	cfg, target, commit := setup(t, `pre_push_command = "test \"$GITSYNC_BRANCH $GITSYNC_RANGE\" = \"main $GITSYNC_NEW_COMMIT\""`)
+
+	if _, err := gitsync.Sync(context.Background(), cfg, "mirror"); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if got := runGit(t, target, "rev-parse", "main"); got != commit {
+		t.Errorf("target main = %s, want %s", got, commit)
+	}
+
+	cfg, target, _ = setup(t, `pre_push_command = "echo leak in $GITSYNC_RANGE; exit 1"`)
+	result, err := gitsync.Sync(context.Background(), cfg, "mirror")
+	if err == nil || result.Count(gitsync.StatusBlocked) != 1 {
+		t.Fatalf("Sync: err = %v, entries = %+v, want one blocked", err, result.Entries)
+	}
+	if !strings.Contains(result.Entries[0].Error, "leak in") {
+		t.Errorf("error = %q, want the command's output", result.Entries[0].Error)
+	}
+	if _, err := exec.Command("git", "--git-dir", target, "rev-parse", "--verify", "main").Output(); err == nil {
+		t.Error("blocked branch reached the target")
+	}
+}
+
+func TestSyncWiki(t *testing.T) {
+	cfg, target, commit := setup(t, "sync_wiki = true")
+	jobConfig, _ := cfg.GetJobConfig("mirror")
+
+	// Without a wiki on the source only the repository is synced
+	result, err := gitsync.Sync(context.Background(), cfg, "mirror")
+	if err != nil {
+		t.Fatalf("Sync without a wiki: %v", err)
+	}
+	if len(result.Entries) != 1 || result.Entries[0].Wiki {
+		t.Fatalf("entries = %+v, want only the branch", result.Entries)
+	}
+
+	wiki := jobConfig.Source + ".wiki.git"
+	runGit(t, filepath.Dir(wiki), "init", "-q", "-b", "master", wiki)
+	runGit(t, wiki, "commit", "-q", "--allow-empty", "-m", "Home")
+	wikiCommit := runGit(t, wiki, "rev-parse", "HEAD")
+
+	result, err = gitsync.Sync(context.Background(), cfg, "mirror")
+	if err != nil {
+		t.Fatalf("Sync with a wiki: %v", err)
+	}
+	var wikiEntries []gitsync.Entry
+	for _, entry := range result.Entries {
+		if entry.Wiki {
+			wikiEntries = append(wikiEntries, entry)
+		}
+	}
+	targetWiki := strings.TrimSuffix(target, ".git") + ".wiki.git"
+	if len(wikiEntries) != 1 || wikiEntries[0].Status != gitsync.StatusPushed || wikiEntries[0].Branch != "master" || wikiEntries[0].Target != targetWiki {
+		t.Fatalf("wiki entries = %+v, want master pushed to %s", wikiEntries, targetWiki)
+	}
+	if !strings.Contains(result.Summary(), ", wiki 1 pushed") {
+		t.Errorf("summary = %q, want the wiki counted on its own", result.Summary())
+	}
+	if got := runGit(t, targetWiki, "rev-parse", "master"); got != wikiCommit {
+		t.Errorf("target wiki master = %s, want %s", got, wikiCommit)
+	}
+	if got := runGit(t, target, "rev-parse", "main"); got != commit {
+		t.Errorf("target main = %s, want %s", got, commit)
+	}
+}
+
+func TestSyncSharedCache(t *testing.T) {
+	cfg, _, commit := setup(t)
+	jobConfig, _ := cfg.GetJobConfig("mirror")
+	source := jobConfig.Source
+	root := filepath.Dir(source)
+
+	configPath := filepath.Join(root, "shared.toml")
+	config := `[jobs]
+names = ["first", "second"]
+every = "1h"
+
+[first]
+source = "` + filepath.ToSlash(source) + `"
+targets = ["` + filepath.ToSlash(filepath.Join(root, "first.git")) + `"]
+shared_cache = true
+
+[second]
+source = "` + filepath.ToSlash(source) + `"
+targets = ["` + filepath.ToSlash(filepath.Join(root, "second.git")) + `"]
+shared_cache = true
+`
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := gitsync.LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+
+	for _, job := range []string{"first", "second"} {
+		if _, err := gitsync.Sync(context.Background(), cfg, job); err != nil {
+			t.Fatalf("Sync %s: %v", job, err)
+		}
+		if got := runGit(t, root, "--git-dir", job+".git", "rev-parse", "main"); got != commit {
+			t.Errorf("%s target main = %s, want %s", job, got, commit)
+		}
+	}
+
+	// One mirror of the source, the clones of both jobs borrow its objects
+	cacheRoot := filepath.Join(os.Getenv("TMPDIR"), "gitsync")
+	mirrors, _ := filepath.Glob(filepath.Join(cacheRoot, "@*"))
+	if len(mirrors) != 1 {
+		t.Fatalf("mirrors = %v, want one shared by both jobs", mirrors)
+	}
+	clones, _ := filepath.Glob(filepath.Join(cacheRoot, "*", "*", ".git", "objects", "info", "alternates"))
+	if len(clones) != 2 {
+		t.Errorf("derived clones = %v, want one per job", clones)
+	}
+
+	runGit(t, source, "commit", "-q", "--allow-empty", "-m", "second")
+	newCommit := runGit(t, source, "rev-parse", "HEAD")
+	if _, err := gitsync.Sync(context.Background(), cfg, "second"); err != nil {
+		t.Fatalf("second Sync: %v", err)
+	}
+	if got := runGit(t, root, "--git-dir", "second.git", "rev-parse", "main"); got != newCommit {
+		t.Errorf("second target main = %s, want %s through the mirror", got, newCommit)
+	}
+	if got := runGit(t, mirrors[0], "rev-parse", "main"); got != newCommit {
+		t.Errorf("mirror main = %s, want %s", got, newCommit)
+	}
+}
+
+// setupBranches adds dev and feature branches to the source of setup and
+// syncs all three, with dev on the target holding an unrelated commit so a
+// push without override is rejected
+func setupBranches(t *testing.T, extra ...string) (*gitsync.Config, string) {
+	t.Helper()
+	cfg, target, _ := setup(t, extra...)
+	jobConfig, _ := cfg.GetJobConfig("mirror")
+	jobConfig.Branches = []string{"main", "dev", "feature"}
+	source := jobConfig.Source
+
+	runGit(t, source, "branch", "dev")
+	runGit(t, source, "branch", "feature")
+	runGit(t, filepath.Dir(target), "init", "-q", "--bare", target)
+	unrelated := runGit(t, source, "commit-tree", "HEAD^{tree}", "-m", "unrelated")
+	runGit(t, source, "push", "-q", target, unrelated+":refs/heads/dev")
+	return cfg, target
+}
+
+// statuses returns the status of each branch entry of a result
+func statuses(result *gitsync.Result) map[string]string {
+	statuses := make(map[string]string)
+	for _, entry := range result.Entries {
+		statuses[entry.Branch] = entry.Status
+	}
+	return statuses
+}
+
+func TestSyncBatchedPush(t *testing.T) {
+	cfg, target := setupBranches(t)
+
+	result, err := gitsync.Sync(context.Background(), cfg, "mirror")
+	if err == nil {
+		t.Fatal("Sync succeeded with a rejected branch")
+	}
+	want := map[string]string{"main": gitsync.StatusPushed, "dev": gitsync.StatusFailed, "feature": gitsync.StatusPushed}
+	if got := statuses(result); len(result.Entries) != 3 || got["main"] != want["main"] || got["dev"] != want["dev"] || got["feature"] != want["feature"] {
+		t.Fatalf("statuses = %v, want %v", got, want)
+	}
+	for _, entry := range result.Entries {
+		if entry.Branch == "dev" && !strings.Contains(entry.Error, "non-fast-forward") {
+			t.Errorf("dev error = %q, want the rejection of the ref", entry.Error)
+		}
+	}
+	if got := runGit(t, target, "branch", "--list", "main", "feature"); !strings.Contains(got, "main") || !strings.Contains(got, "feature") {
+		t.Errorf("target branches = %q, want main and feature", got)
+	}
+}
+
+func TestSyncAtomicPush(t *testing.T) {
+	cfg, target := setupBranches(t, "atomic_push = true")
+
+	result, err := gitsync.Sync(context.Background(), cfg, "mirror")
+	if err == nil {
+		t.Fatal("Sync succeeded with a rejected branch")
+	}
+	if len(result.Entries) != 3 {
+		t.Fatalf("entries = %+v, want one per branch", result.Entries)
+	}
+	for _, entry := range result.Entries {
+		if entry.Status != gitsync.StatusFailed {
+			t.Errorf("%s status = %s, want failed with the atomic push", entry.Branch, entry.Status)
+		}
+	}
+	if got := runGit(t, target, "branch", "--list", "main", "feature"); got != "" {
+		t.Errorf("target branches = %q, want none pushed", got)
+	}
+}
+
+func TestSyncBatchRefusedFallsBack(t *testing.T) {
+	cfg, target := setupBranches(t, "override = true")
+
+	// The hook declines the whole push when it carries dev
+	hook := "#!/bin/sh\nif grep -q refs/heads/dev; then echo dev is frozen; exit 1; fi\n"
+	if err := os.WriteFile(filepath.Join(target, "hooks", "pre-receive"), []byte(hook), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := gitsync.Sync(context.Background(), cfg, "mirror")
+	if err == nil {
+		t.Fatal("Sync succeeded with a rejected branch")
+	}
+	want := map[string]string{"main": gitsync.StatusPushed, "dev": gitsync.StatusFailed, "feature": gitsync.StatusPushed}
+	if got := statuses(result); got["main"] != want["main"] || got["dev"] != want["dev"] || got["feature"] != want["feature"] {
+		t.Errorf("statuses = %v, want %v", got, want)
+	}
+}